@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"strconv"
 	"time"
 
@@ -380,6 +381,10 @@ func adminUtils(context *router.Context) {
 				}
 			case "users":
 				Session.Navigate("/usersonline")
+			case "reorder":
+				count := 0
+				rpcClient.Call("ReorderRPC.Check", Session.Channel, &count)
+				retval = fmt.Sprintf("%d new reorder suggestions", count)
 			default:
 				print("ERROR - unknown utility", url)
 				return
@@ -19,6 +19,7 @@ type GlobalSessionData struct {
 	AppFn         map[string]router.Handler
 	Subscriptions map[string]MessageFunction
 	ID            map[string]int
+	Route         string
 }
 
 var Session GlobalSessionData
@@ -26,6 +27,7 @@ var Session GlobalSessionData
 func (s *GlobalSessionData) Navigate(url string) {
 	// On navigate, clear out any subscriptions on events
 	s.Subscriptions = make(map[string]MessageFunction)
+	s.Route = url
 	s.Router.Navigate(url)
 	go rpcClient.Call("LoginRPC.Nav", shared.Nav{
 		Channel: s.Channel,
@@ -33,8 +35,19 @@ func (s *GlobalSessionData) Navigate(url string) {
 	}, &url)
 }
 
-func (s *GlobalSessionData) Subscribe(msg string, fn MessageFunction) {
-	s.Subscriptions[msg] = fn
+// Subscribe to a topic, such as "task", "task:1234" or "site:4/machine".
+// The server only sends messages that match, and the callback is keyed
+// on the message name
+func (s *GlobalSessionData) Subscribe(topic string, fn MessageFunction) {
+	s.Subscriptions[shared.TopicName(topic)] = fn
+	go func() {
+		done := false
+		rpcClient.Call("LoginRPC.Subscribe", shared.SubscribeRequest{
+			Channel: s.Channel,
+			Topic:   topic,
+			Route:   s.Route,
+		}, &done)
+	}()
 }
 
 func (s *GlobalSessionData) Reload(context *router.Context) {
@@ -48,4 +61,5 @@ func main() {
 	websocketInit()
 	initLoginForm()
 	showLoginForm()
+	go Resume()
 }
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"itrak-cmms/shared"
+
+	"github.com/go-humble/router"
+	"github.com/steveoc64/formulate"
+	"honnef.co/go/js/dom"
+)
+
+// Working calendars, which keep generated tasks off weekends and holidays.
+// Each site picks a calendar, or uses the one from its parent site.
+
+func calendarList(context *router.Context) {
+	Session.Subscribe("calendar", _calendarList)
+	go _calendarList("list", 0)
+}
+
+func _calendarList(action string, id int) {
+	calendars := []shared.Calendar{}
+	rpcClient.Call("CalendarRPC.List", Session.Channel, &calendars)
+
+	form := formulate.ListForm{}
+	form.New("fa-calendar", "Work Calendars")
+
+	// Define the layout
+	form.Column("Name", "Name")
+	form.Column("Weekend", "Weekend")
+	form.Column("Notes", "Notes")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate("/")
+	})
+
+	form.NewRowEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate("/calendar/add")
+	})
+
+	form.RowEvent(func(key string) {
+		Session.Navigate("/calendar/" + key)
+	})
+
+	form.Render("calendar-list", "main", calendars)
+}
+
+func calendarAdd(context *router.Context) {
+	go func() {
+		calendar := shared.Calendar{Weekend: "Sat,Sun"}
+
+		BackURL := "/calendars"
+		title := "Add New Work Calendar"
+		form := formulate.EditForm{}
+		form.New("fa-calendar", title)
+
+		// Layout the fields
+		form.Row(2).
+			AddInput(1, "Name", "Name").
+			AddInput(1, "Weekend Days (eg Sat,Sun)", "Weekend")
+
+		form.Row(1).
+			AddTextarea(1, "Notes", "Notes")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&calendar)
+			go func() {
+				newID := 0
+				rpcClient.Call("CalendarRPC.Insert", shared.CalendarRPCData{
+					Channel:  Session.Channel,
+					Calendar: &calendar,
+				}, &newID)
+				print("added calendar", newID)
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &calendar)
+	}()
+}
+
+func calendarEdit(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	Session.ID["calendar"] = id
+
+	Session.Subscribe("calendar", _calendarEdit)
+	go _calendarEdit("edit", id)
+}
+
+func _calendarEdit(action string, id int) {
+
+	BackURL := "/calendars"
+
+	switch action {
+	case "edit":
+		print("manually edit")
+	case "delete":
+		if id != Session.ID["calendar"] {
+			return
+		}
+		print("current record has been deleted")
+		Session.Navigate(BackURL)
+		return
+	default:
+		if id != Session.ID["calendar"] {
+			return
+		}
+	}
+	calendar := shared.Calendar{}
+	rpcClient.Call("CalendarRPC.Get", shared.CalendarRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+	}, &calendar)
+
+	title := "Edit Work Calendar - " + calendar.Name
+
+	form := formulate.EditForm{}
+	form.New("fa-calendar", title)
+
+	// Layout the fields
+	form.Row(2).
+		AddInput(1, "Name", "Name").
+		AddInput(1, "Weekend Days (eg Sat,Sun)", "Weekend")
+
+	form.Row(1).
+		AddTextarea(1, "Notes", "Notes")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(BackURL)
+	})
+
+	form.DeleteEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		go func() {
+			done := false
+			rpcClient.Call("CalendarRPC.Delete", shared.CalendarRPCData{
+				Channel: Session.Channel,
+				ID:      id,
+			}, &done)
+			Session.Navigate(BackURL)
+		}()
+	})
+
+	form.SaveEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		form.Bind(&calendar)
+		go func() {
+			done := false
+			rpcClient.Call("CalendarRPC.Update", shared.CalendarRPCData{
+				Channel:  Session.Channel,
+				ID:       id,
+				Calendar: &calendar,
+			}, &done)
+			Session.Navigate(BackURL)
+		}()
+	})
+
+	// All done, so render the form
+	form.Render("edit-form", "main", &calendar)
+
+	// And attach actions
+	form.ActionGrid("calendar-actions", "#action-grid", calendar.ID, func(url string) {
+		Session.Navigate(url)
+	})
+}
+
+func calendarHolidays(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	Session.ID["calendar"] = id
+
+	Session.Subscribe("calendar", _calendarHolidays)
+	go _calendarHolidays("list", id)
+}
+
+func _calendarHolidays(action string, id int) {
+	if id != Session.ID["calendar"] {
+		return
+	}
+
+	calendar := shared.Calendar{}
+	rpcClient.Call("CalendarRPC.Get", shared.CalendarRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+	}, &calendar)
+
+	form := formulate.ListForm{}
+	form.New("fa-calendar", "Holidays - "+calendar.Name)
+
+	// Define the layout
+	form.Column("Date", "GetDate")
+	form.Column("Holiday", "Name")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(fmt.Sprintf("/calendar/%d", id))
+	})
+
+	form.NewRowEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(fmt.Sprintf("/calendar/%d/holiday/add", id))
+	})
+
+	form.RowEvent(func(key string) {
+		Session.Navigate(fmt.Sprintf("/calendar/%d/holiday/%s", id, key))
+	})
+
+	form.Render("calendar-holiday-list", "main", calendar.Holidays)
+}
+
+func calendarHolidayAdd(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		holiday := shared.Holiday{CalendarID: id}
+
+		BackURL := fmt.Sprintf("/calendar/%d/holidays", id)
+		title := "Add Holiday"
+		form := formulate.EditForm{}
+		form.New("fa-calendar", title)
+
+		// Layout the fields
+		form.Row(2).
+			AddDate(1, "Date", "Date").
+			AddInput(1, "Holiday", "Name")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&holiday)
+			go func() {
+				newID := 0
+				err := rpcClient.Call("CalendarRPC.InsertHoliday", shared.HolidayRPCData{
+					Channel: Session.Channel,
+					Holiday: &holiday,
+				}, &newID)
+				if err != nil {
+					print("RPC error", err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &holiday)
+	}()
+}
+
+// Holidays are only ever added or removed, so this just shows the holiday with a delete button
+func calendarHolidayEdit(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	holidayID, err := strconv.Atoi(context.Params["holiday"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		calendar := shared.Calendar{}
+		rpcClient.Call("CalendarRPC.Get", shared.CalendarRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}, &calendar)
+
+		holiday := shared.Holiday{}
+		for _, h := range calendar.Holidays {
+			if h.ID == holidayID {
+				holiday = h
+			}
+		}
+
+		BackURL := fmt.Sprintf("/calendar/%d/holidays", id)
+		title := "Holiday - " + calendar.Name
+		form := formulate.EditForm{}
+		form.New("fa-calendar", title)
+
+		// Layout the fields
+		form.Row(2).
+			AddDisplay(1, "Date", "GetDate").
+			AddDisplay(1, "Holiday", "Name")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.DeleteEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			go func() {
+				done := false
+				rpcClient.Call("CalendarRPC.DeleteHoliday", shared.HolidayRPCData{
+					Channel: Session.Channel,
+					ID:      holidayID,
+				}, &done)
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &holiday)
+	}()
+}
+
+func calendarImport(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		calendar := shared.Calendar{}
+		rpcClient.Call("CalendarRPC.Get", shared.CalendarRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}, &calendar)
+
+		data := shared.CalendarImport{
+			Channel:    Session.Channel,
+			CalendarID: id,
+		}
+
+		BackURL := fmt.Sprintf("/calendar/%d/holidays", id)
+		title := "Import Holidays - " + calendar.Name
+		form := formulate.EditForm{}
+		form.New("fa-calendar", title)
+
+		// Layout the fields
+		form.Row(1).
+			AddTextarea(1, "Paste the contents of the iCalendar (.ics) file", "ICS")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(fmt.Sprintf("/calendar/%d", id))
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&data)
+			go func() {
+				count := 0
+				err := rpcClient.Call("CalendarRPC.Import", data, &count)
+				if err != nil {
+					print("RPC error", err.Error())
+					return
+				}
+				print("imported holidays", count)
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &data)
+	}()
+}
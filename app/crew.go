@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"itrak-cmms/shared"
+
+	"github.com/go-humble/router"
+	"github.com/steveoc64/formulate"
+	"honnef.co/go/js/dom"
+)
+
+// The crew on a task, for the big jobs that need more than one technician
+
+func taskCrew(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	Session.ID["task"] = id
+
+	Session.Subscribe("task", _taskCrew)
+	go _taskCrew("list", id)
+}
+
+func _taskCrew(action string, id int) {
+	if id != Session.ID["task"] {
+		return
+	}
+
+	crew := []shared.CrewMember{}
+	rpcClient.Call("TaskRPC.Crew", shared.TaskRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+	}, &crew)
+
+	form := formulate.ListForm{}
+	form.New("fa-users", fmt.Sprintf("Crew - Task %06d", id))
+
+	// Define the layout
+	form.Column("Technician", "GetUsername")
+	form.Column("Acknowledged", "GetAcknowledged")
+	form.Column("Hours", "GetHours")
+	form.Column("Signed Off", "GetSignedOff")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(fmt.Sprintf("/task/%d", id))
+	})
+
+	if Session.UserRole == "Admin" || Session.CanAllocate {
+		form.NewRowEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(fmt.Sprintf("/task/crew/%d/add", id))
+		})
+
+		form.RowEvent(func(key string) {
+			Session.Navigate(fmt.Sprintf("/task/crew/%d/member/%s", id, key))
+		})
+	}
+
+	form.Render("task-crew-list", "main", crew)
+}
+
+func taskCrewAdd(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		techs := []shared.User{}
+		rpcClient.Call("UserRPC.GetTechnicians", shared.TechnicianQuery{
+			Channel: Session.Channel,
+			TaskID:  id,
+		}, &techs)
+
+		data := shared.CrewRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}
+
+		BackURL := fmt.Sprintf("/task/crew/%d", id)
+		title := fmt.Sprintf("Add to Crew - Task %06d", id)
+		form := formulate.EditForm{}
+		form.New("fa-users", title)
+
+		// Layout the fields
+		form.Row(1).
+			AddSelect(1, "Technician", "UserID", techs, "ID", "Ranking", 1, 0)
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&data)
+			go func() {
+				done := false
+				err := rpcClient.Call("TaskRPC.AddCrew", data, &done)
+				if err != nil {
+					print("RPC error", err.Error())
+					dom.GetWindow().Alert(err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &data)
+	}()
+}
+
+// Crew members are only ever added or taken off, so this just shows the member with a delete button
+func taskCrewMember(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	userID, err := strconv.Atoi(context.Params["user"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		crew := []shared.CrewMember{}
+		rpcClient.Call("TaskRPC.Crew", shared.TaskRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}, &crew)
+
+		member := shared.CrewMember{}
+		for _, c := range crew {
+			if c.ID == userID {
+				member = c
+			}
+		}
+
+		BackURL := fmt.Sprintf("/task/crew/%d", id)
+		title := fmt.Sprintf("Crew - Task %06d", id)
+		form := formulate.EditForm{}
+		form.New("fa-users", title)
+
+		// Layout the fields
+		form.Row(2).
+			AddDisplay(1, "Technician", "GetUsername").
+			AddDisplay(1, "Hours", "GetHours")
+
+		form.Row(2).
+			AddDisplay(1, "Acknowledged", "GetAcknowledged").
+			AddDisplay(1, "Signed Off", "GetSignedOff")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.DeleteEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			go func() {
+				done := false
+				err := rpcClient.Call("TaskRPC.RemoveCrew", shared.CrewRPCData{
+					Channel: Session.Channel,
+					ID:      id,
+					UserID:  userID,
+				}, &done)
+				if err != nil {
+					print("RPC error", err.Error())
+					dom.GetWindow().Alert(err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &member)
+	}()
+}
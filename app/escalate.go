@@ -0,0 +1,234 @@
+package main
+
+import (
+	"strconv"
+
+	"itrak-cmms/shared"
+
+	"github.com/go-humble/router"
+	"github.com/steveoc64/formulate"
+	"honnef.co/go/js/dom"
+)
+
+// Escalation tiers, which say who gets told about overdue tasks, and when.
+
+var escalationBases = []formulate.SelectOption{
+	{1, "Due Date"},
+	{2, "Escalate Date"},
+}
+
+var escalationNotifies = []formulate.SelectOption{
+	{1, "Assigner"},
+	{2, "Site Manager"},
+	{3, "Admins"},
+}
+
+var escalationBasisKeys = []string{"", "due", "escalate"}
+var escalationNotifyKeys = []string{"", "assigner", "manager", "admins"}
+
+// Get the option number for the stored key
+func escalationOption(keys []string, key string) int {
+	for i, k := range keys {
+		if k == key && i > 0 {
+			return i
+		}
+	}
+	return 1
+}
+
+// Convert the options picked on the form back into the stored keys
+func escalationBind(tier *shared.EscalationTier) {
+	if i, err := strconv.Atoi(tier.Basis); err == nil && i > 0 && i < len(escalationBasisKeys) {
+		tier.Basis = escalationBasisKeys[i]
+	}
+	if i, err := strconv.Atoi(tier.Notify); err == nil && i > 0 && i < len(escalationNotifyKeys) {
+		tier.Notify = escalationNotifyKeys[i]
+	}
+}
+
+func escalationList(context *router.Context) {
+	Session.Subscribe("escalation", _escalationList)
+	go _escalationList("list", 0)
+}
+
+func _escalationList(action string, id int) {
+	tiers := []shared.EscalationTier{}
+	rpcClient.Call("TaskRPC.EscalationTiers", Session.Channel, &tiers)
+
+	form := formulate.ListForm{}
+	form.New("fa-bullhorn", "Overdue Task Escalation")
+
+	// Define the layout
+	form.Column("Level", "Level")
+	form.Column("When", "ShowBasis")
+	form.Column("Notify", "ShowNotify")
+	form.Column("Via", "ShowVia")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate("/")
+	})
+
+	form.NewRowEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate("/escalation/add")
+	})
+
+	form.RowEvent(func(key string) {
+		Session.Navigate("/escalation/" + key)
+	})
+
+	form.Render("escalation-list", "main", tiers)
+}
+
+func escalationAdd(context *router.Context) {
+	go func() {
+		tiers := []shared.EscalationTier{}
+		rpcClient.Call("TaskRPC.EscalationTiers", Session.Channel, &tiers)
+		tier := shared.EscalationTier{Level: len(tiers) + 1, SMS: true, Email: true}
+
+		BackURL := "/escalation"
+		title := "Add Escalation Tier"
+		form := formulate.EditForm{}
+		form.New("fa-bullhorn", title)
+
+		// Layout the fields
+		form.Row(2).
+			AddNumber(1, "Level", "Level", "1").
+			AddNumber(1, "Days Overdue", "Days", "0")
+
+		form.Row(2).
+			AddRadio(1, "Days Past the", "Basis", escalationBases, "ID", "Name", 1).
+			AddRadio(1, "Notify", "Notify", escalationNotifies, "ID", "Name", 1)
+
+		form.Row(2).
+			AddCheck(1, "Send SMS", "SMS").
+			AddCheck(1, "Send Email", "Email")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&tier)
+			escalationBind(&tier)
+			go func() {
+				newID := 0
+				err := rpcClient.Call("TaskRPC.InsertEscalationTier", shared.EscalationTierRPCData{
+					Channel: Session.Channel,
+					Tier:    &tier,
+				}, &newID)
+				if err != nil {
+					print("RPC error", err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &tier)
+	}()
+}
+
+func escalationEdit(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	Session.ID["escalation"] = id
+
+	Session.Subscribe("escalation", _escalationEdit)
+	go _escalationEdit("edit", id)
+}
+
+func _escalationEdit(action string, id int) {
+
+	BackURL := "/escalation"
+
+	switch action {
+	case "edit":
+		print("manually edit")
+	case "delete":
+		if id != Session.ID["escalation"] {
+			return
+		}
+		print("current record has been deleted")
+		Session.Navigate(BackURL)
+		return
+	default:
+		if id != Session.ID["escalation"] {
+			return
+		}
+	}
+	tier := shared.EscalationTier{}
+	rpcClient.Call("TaskRPC.GetEscalationTier", shared.EscalationTierRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+	}, &tier)
+
+	title := "Edit Escalation Tier - Level " + strconv.Itoa(tier.Level)
+
+	form := formulate.EditForm{}
+	form.New("fa-bullhorn", title)
+
+	// Layout the fields
+	form.Row(2).
+		AddNumber(1, "Level", "Level", "1").
+		AddNumber(1, "Days Overdue", "Days", "0")
+
+	form.Row(2).
+		AddRadio(1, "Days Past the", "Basis", escalationBases, "ID", "Name",
+			escalationOption(escalationBasisKeys, tier.Basis)).
+		AddRadio(1, "Notify", "Notify", escalationNotifies, "ID", "Name",
+			escalationOption(escalationNotifyKeys, tier.Notify))
+
+	form.Row(2).
+		AddCheck(1, "Send SMS", "SMS").
+		AddCheck(1, "Send Email", "Email")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(BackURL)
+	})
+
+	form.DeleteEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		go func() {
+			done := false
+			rpcClient.Call("TaskRPC.DeleteEscalationTier", shared.EscalationTierRPCData{
+				Channel: Session.Channel,
+				ID:      id,
+			}, &done)
+			Session.Navigate(BackURL)
+		}()
+	})
+
+	form.SaveEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		form.Bind(&tier)
+		escalationBind(&tier)
+		go func() {
+			done := false
+			err := rpcClient.Call("TaskRPC.UpdateEscalationTier", shared.EscalationTierRPCData{
+				Channel: Session.Channel,
+				ID:      id,
+				Tier:    &tier,
+			}, &done)
+			if err != nil {
+				print("RPC error", err.Error())
+				return
+			}
+			Session.Navigate(BackURL)
+		}()
+	})
+
+	// All done, so render the form
+	form.Render("edit-form", "main", &tier)
+}
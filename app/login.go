@@ -29,6 +29,7 @@ func Login(username string, passwd string, rem bool) {
 	}
 	if lr.Result == "OK" {
 		// createMenu(lr.Menu)
+		saveToken(lr.Token, rem)
 		Session.Username = lc.Username
 		Session.UserRole = lr.Role
 		Session.UserID = lr.ID
@@ -43,8 +44,76 @@ func Login(username string, passwd string, rem bool) {
 	}
 }
 
+// Attempt to pick up an existing session from a saved token, rather than
+// forcing the user to log in again after a reload or reconnect
+func Resume() {
+	token := getToken()
+	if token == "" {
+		return
+	}
+
+	lr := &shared.LoginReply{}
+	err := rpcClient.Call("LoginRPC.Resume", shared.ResumeRequest{
+		Channel: Session.Channel,
+		Token:   token,
+	}, lr)
+	if err != nil {
+		print("RPC error", err.Error())
+	}
+	if lr.Result != "OK" {
+		print("session expired")
+		clearToken()
+		return
+	}
+
+	Session.Username = lr.Username
+	Session.UserRole = lr.Role
+	Session.UserID = lr.ID
+	Session.CanAllocate = lr.CanAllocate
+	loadRoutes(lr.Role, lr.Routes)
+	hideLoginForm()
+	GetPDFImage()
+	if lr.Route != "" {
+		Session.Navigate(lr.Route)
+	}
+}
+
+// Remembered tokens go in localStorage to survive the browser closing,
+// otherwise they only last as long as the tab
+func saveToken(token string, rem bool) {
+	clearToken()
+	if rem {
+		js.Global.Get("localStorage").Call("setItem", "cmms-token", token)
+	} else {
+		js.Global.Get("sessionStorage").Call("setItem", "cmms-token", token)
+	}
+}
+
+func getToken() string {
+	for _, store := range []string{"localStorage", "sessionStorage"} {
+		token := js.Global.Get(store).Call("getItem", "cmms-token")
+		if token != nil && token != js.Undefined {
+			return token.String()
+		}
+	}
+	return ""
+}
+
+func clearToken() {
+	js.Global.Get("localStorage").Call("removeItem", "cmms-token")
+	js.Global.Get("sessionStorage").Call("removeItem", "cmms-token")
+}
+
+// Reload the app on a fresh connection, keeping the session token
+func reloadApp() {
+	js.Global.Get("location").Call("replace", "/")
+}
+
 func Logout() {
 	print("log out")
+	clearToken()
+	done := false
+	rpcClient.Call("LoginRPC.Logout", Session.Channel, &done)
 	showLoginForm()
 	initRouter() // reset all the routes to nothing
 	// js.Global.Get("location").Set("hash", "")
@@ -136,7 +205,7 @@ func initLoginForm() {
 	logoutBtn := doc.GetElementByID("logoutbtn").(*dom.HTMLButtonElement)
 	logoutBtn.AddEventListener("click", false, func(evt dom.Event) {
 		evt.PreventDefault()
-		Logout()
+		go Logout()
 	})
 
 	userBtn := doc.GetElementByID("userbtn").(*dom.HTMLButtonElement)
@@ -124,6 +124,10 @@ func machineEdit(context *router.Context) {
 		form.Row(1).
 			AddInput(1, "Descrpition", "Descr")
 
+		form.Row(2).
+			AddDisplay(1, "Running Hours", "RunHours").
+			AddDisplay(1, "Jobs Run", "JobCount")
+
 		form.Row(2).
 			AddSelect(1, "Stoppage Alerts To", "AlertsTo", users, "ID", "Name", 0, machine.AlertsTo).
 			AddSelect(1, "Send Scheduled Tasks To", "TasksTo", technicians, "ID", "Name", 0, machine.TasksTo)
@@ -302,6 +306,221 @@ func machineStoppageList(context *router.Context) {
 	print("TODO - machineStoppageList")
 }
 
+// Show the job counts recorded against the machine, for Job Count sched tasks
+func machineJobCountList(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["machine"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	Session.ID["machine"] = id
+
+	Session.Subscribe("jobcount", _machineJobCountList)
+	go _machineJobCountList("list", id)
+}
+
+func _machineJobCountList(action string, id int) {
+	if id != Session.ID["machine"] {
+		return
+	}
+
+	machine := shared.Machine{}
+	counts := []shared.JobCount{}
+	data := shared.MachineRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+	}
+	rpcClient.Call("MachineRPC.Get", data, &machine)
+	rpcClient.Call("MachineRPC.JobCounts", data, &counts)
+
+	BackURL := fmt.Sprintf("/machine/%d", id)
+
+	form := formulate.ListForm{}
+	form.New("fa-tachometer", fmt.Sprintf("Job Counts for - %s - %d Jobs Total", machine.Name, machine.JobCount))
+
+	// Define the layout
+	form.Column("Date", "GetLogged")
+	form.Column("Jobs", "Count")
+	form.Column("Total", "Total")
+	form.Column("Source", "Source")
+	form.Column("User", "GetUsername")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(BackURL)
+	})
+
+	form.NewRowEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(fmt.Sprintf("/machine/jobcount/add/%d", id))
+	})
+
+	form.Render("machine-jobcount-list", "main", counts)
+}
+
+// Record a new batch of jobs run on the machine
+func machineJobCountAdd(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["machine"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		machine := shared.Machine{}
+		rpcClient.Call("MachineRPC.Get", shared.MachineRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}, &machine)
+
+		jobCount := shared.JobCountRPCData{
+			Channel:   Session.Channel,
+			MachineID: id,
+			Source:    "manual",
+		}
+
+		BackURL := fmt.Sprintf("/machine/jobcount/%d", id)
+		form := formulate.EditForm{}
+		form.New("fa-tachometer", "Add Job Count - "+machine.Name)
+
+		// Layout the fields
+		form.Row(2).
+			AddNumber(1, "Jobs Run", "Count", "1").
+			AddInput(1, "Source", "Source")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&jobCount)
+			go func() {
+				total := 0
+				err := rpcClient.Call("MachineRPC.AddJobCount", jobCount, &total)
+				if err != nil {
+					print("RPC error", err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &jobCount)
+	}()
+}
+
+// Show the hour meter readings taken off the machine
+func machineMeterList(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["machine"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	Session.ID["machine"] = id
+
+	Session.Subscribe("meter", _machineMeterList)
+	go _machineMeterList("list", id)
+}
+
+func _machineMeterList(action string, id int) {
+	if id != Session.ID["machine"] {
+		return
+	}
+
+	machine := shared.Machine{}
+	readings := []shared.MeterReading{}
+	data := shared.MachineRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+	}
+	rpcClient.Call("MachineRPC.Get", data, &machine)
+	rpcClient.Call("MachineRPC.MeterReadings", data, &readings)
+
+	BackURL := fmt.Sprintf("/machine/%d", id)
+
+	form := formulate.ListForm{}
+	form.New("fa-clock-o", fmt.Sprintf("Hour Meter for - %s - %.1f Running Hours", machine.Name, machine.RunHours))
+
+	// Define the layout
+	form.Column("Date", "GetLogged")
+	form.Column("Hours", "Hours")
+	form.Column("Notes", "Notes")
+	form.Column("User", "GetUsername")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(BackURL)
+	})
+
+	form.NewRowEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(fmt.Sprintf("/machine/meter/add/%d", id))
+	})
+
+	form.Render("machine-meter-list", "main", readings)
+}
+
+// Enter a new reading off the hour meter
+func machineMeterAdd(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["machine"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		machine := shared.Machine{}
+		rpcClient.Call("MachineRPC.Get", shared.MachineRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}, &machine)
+
+		reading := shared.MeterReadingRPCData{
+			Channel:   Session.Channel,
+			MachineID: id,
+			Hours:     machine.RunHours,
+		}
+
+		BackURL := fmt.Sprintf("/machine/meter/%d", id)
+		form := formulate.EditForm{}
+		form.New("fa-clock-o", "Hour Meter Reading - "+machine.Name)
+
+		// Layout the fields
+		form.Row(2).
+			AddDecimal(1, "Hours on the Meter", "Hours", 1, "1").
+			AddInput(1, "Notes", "Notes")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&reading)
+			go func() {
+				done := false
+				err := rpcClient.Call("MachineRPC.AddMeterReading", reading, &done)
+				if err != nil {
+					print("RPC error", err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &reading)
+	}()
+}
+
 type SiteMachineListData struct {
 	Site     shared.Site
 	Machines []shared.Machine
@@ -324,17 +543,7 @@ func machineTypes(context *router.Context) {
 		// Define the layout
 		form.Column("Name", "Name")
 		form.ImgColumn("Photo", "PhotoThumbnail")
-		form.BoolColumn("Elec", "Electrical")
-		form.BoolColumn("Hyd", "Hydraulic")
-		form.BoolColumn("Pnue", "Pnuematic")
-		form.BoolColumn("Lube", "Lube")
-		form.BoolColumn("Prnt", "Printer")
-		form.BoolColumn("Cnsl", "Console")
-		form.BoolColumn("Unclr", "Uncoiler")
-		form.BoolColumn("Rlbd", "Rollbed")
-		form.BoolColumn("Cnvyr", "Conveyor")
-		form.BoolColumn("Enc", "Encoder")
-		form.BoolColumn("SGd", "StripGuide")
+		form.Column("Subsystems", "SubsystemNames")
 
 		// Add event handlers
 		form.CancelEvent(func(evt dom.Event) {
@@ -372,25 +581,6 @@ func machineTypeAdd(context *router.Context) {
 		form.Row(1).
 			AddInput(1, "Name", "Name")
 
-		form.Row(3).
-			AddCheck(1, "Electrical", "Electrical").
-			AddCheck(1, "Hydraulic", "Hydraulic").
-			AddCheck(1, "Pnuematic", "Pnuematic")
-
-		form.Row(3).
-			AddCheck(1, "Console", "Console").
-			AddCheck(1, "Printer", "Printer").
-			AddCheck(1, "Lube", "Lube")
-
-		form.Row(3).
-			AddCheck(1, "UnCoiler", "Uncoiler").
-			AddCheck(1, "RollBed", "Rollbed").
-			AddCheck(1, "Conveyor", "Conveyor")
-
-		form.Row(3).
-			AddCheck(1, "Encoder", "Encoder").
-			AddCheck(1, "StripGuide", "StripGuide")
-
 		// Add event handlers
 		form.CancelEvent(func(evt dom.Event) {
 			evt.PreventDefault()
@@ -450,24 +640,8 @@ func machineTypeEdit(context *router.Context) {
 		form.Row(1).
 			AddPreview(1, "Photo", "PhotoPreview")
 
-		form.Row(3).
-			AddCheck(1, "Electrical", "Electrical").
-			AddCheck(1, "Hydraulic", "Hydraulic").
-			AddCheck(1, "Pnuematic", "Pnuematic")
-
-		form.Row(3).
-			AddCheck(1, "Console", "Console").
-			AddCheck(1, "Printer", "Printer").
-			AddCheck(1, "Lube", "Lube")
-
-		form.Row(3).
-			AddCheck(1, "UnCoiler", "Uncoiler").
-			AddCheck(1, "RollBed", "Rollbed").
-			AddCheck(1, "Conveyor", "Conveyor")
-
-		form.Row(3).
-			AddCheck(1, "Encoder", "Encoder").
-			AddCheck(1, "StripGuide", "StripGuide")
+		form.Row(1).
+			Add(1, "Subsystems", "div", "Subsystems", "")
 
 		// Add event handlers
 		form.CancelEvent(func(evt dom.Event) {
@@ -513,6 +687,44 @@ func machineTypeEdit(context *router.Context) {
 		// print("mt =", machineType)
 		loadTemplate("machine-type-diag", "[name=Diag]", &machineType)
 
+		// Load the subsystems array, and toggle them on the backend when clicked
+		subsystems := []shared.MachineTypeSubsystem{}
+		rpcClient.Call("MachineRPC.MachineTypeSubsystems", shared.MachineTypeRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}, &subsystems)
+		loadTemplate("machine-type-subsystems", "[name=Subsystems]", subsystems)
+
+		if el := dom.GetWindow().Document().QuerySelector("[name=Subsystems]"); el != nil {
+			el.AddEventListener("click", false, func(evt dom.Event) {
+				clickedOn := evt.Target()
+				switch clickedOn.TagName() {
+				case "INPUT":
+					ie := clickedOn.(*dom.HTMLInputElement)
+					key, _ := strconv.Atoi(ie.GetAttribute("key"))
+					data := shared.MachineTypeSubsystemSetRequest{
+						Channel:       Session.Channel,
+						MachineTypeID: id,
+						SubsystemID:   key,
+						IsSet:         ie.Checked,
+					}
+
+					go func() {
+						done := false
+						rpcClient.Call("MachineRPC.SetMachineTypeSubsystem", data, &done)
+
+						// redraw the diagram with the new set of subsystems
+						mt := shared.MachineType{}
+						rpcClient.Call("MachineRPC.GetMachineType", shared.MachineTypeRPCData{
+							Channel: Session.Channel,
+							ID:      id,
+						}, &mt)
+						loadTemplate("machine-type-diag", "[name=Diag]", &mt)
+					}()
+				}
+			})
+		}
+
 		// And attach actions
 		form.ActionGrid("machine-type-actions", "#action-grid", id, func(url string) {
 			Session.Navigate(fmt.Sprintf("/machinetype/%d/%s", id, url))
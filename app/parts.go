@@ -13,6 +13,11 @@ import (
 	"honnef.co/go/js/dom"
 )
 
+// The label for a part in the parts tree, with the stock not reserved by open tasks
+func partLabel(part *shared.Part) string {
+	return fmt.Sprintf(`%s : %s (%g available)`, part.StockCode, part.Name, part.Available())
+}
+
 func addTree(tree []shared.Category, ul *dom.HTMLUListElement, depth int) {
 
 	w := dom.GetWindow()
@@ -61,7 +66,7 @@ func addTree(tree []shared.Category, ul *dom.HTMLUListElement, depth int) {
 				partID := fmt.Sprintf("part-%d", part.ID)
 				li2 := doc.CreateElement("li")
 				li2.SetID(partID)
-				li2.SetInnerHTML(fmt.Sprintf(`%s : %s`, part.StockCode, part.Name))
+				li2.SetInnerHTML(partLabel(&part))
 				li2.Class().Add("stock-item")
 				li2.SetAttribute("data-type", "part")
 				li2.SetAttribute("data-id", fmt.Sprintf("%d", part.ID))
@@ -143,10 +148,11 @@ func partsList(context *router.Context) {
 		partPanel.Row(1).
 			AddInput(1, "Description", "Descr")
 
-		partPanel.Row(4).
+		partPanel.Row(5).
 			AddDecimal(1, "ReOrder Level", "ReorderStocklevel", 2, "1").
 			AddDecimal(1, "ReOrder Qty", "ReorderQty", 2, "1").
-			AddDecimal(1, "Current Stock", "CurrentStock", 2, "1").
+			AddDisplay(1, "Total Stock", "CurrentStock").
+			AddDisplay(1, "Available", "Available").
 			AddInput(1, "Qty Type", "QtyType")
 
 		partPanel.Row(4).
@@ -385,7 +391,7 @@ func partsList(context *router.Context) {
 				// Find the LI element for the current Part, and redraw it
 				theLI := doc.QuerySelector(fmt.Sprintf("#part-%d", currentPart)).(*dom.HTMLLIElement)
 				// print("got ", theLI)
-				theLI.SetInnerHTML(fmt.Sprintf(`%s : %s`, thePart.StockCode, thePart.Name))
+				theLI.SetInnerHTML(partLabel(&thePart))
 				thePart.ValuationString = thePart.DisplayValuation()
 				swapper.Panels[1].Paint(&thePart)
 			}()
@@ -596,32 +602,6 @@ func partsList(context *router.Context) {
 			}
 		})
 
-		// Auto calculate the valuation on change of fields
-		doc.QuerySelector("[name=CurrentStock]").AddEventListener("change", false, func(evt dom.Event) {
-			thePart := shared.Part{}
-			print("current stock count changes")
-			s := doc.QuerySelector("[name=CurrentStock]").(*dom.HTMLInputElement).Value
-			p := doc.QuerySelector("[name=LatestPrice]").(*dom.HTMLInputElement).Value
-			s1, _ := strconv.ParseFloat(s, 64)
-			p1, _ := strconv.ParseFloat(p, 64)
-			thePart.CurrentStock = s1
-			thePart.LatestPrice = p1
-			thePart.ValuationString = thePart.DisplayValuation()
-			doc.QuerySelector("[name=ValuationString]").(*dom.HTMLInputElement).Value = thePart.ValuationString
-		})
-		doc.QuerySelector("[name=LatestPrice]").AddEventListener("change", false, func(evt dom.Event) {
-			thePart := shared.Part{}
-			print("latest price changes")
-			s := doc.QuerySelector("[name=CurrentStock]").(*dom.HTMLInputElement).Value
-			p := doc.QuerySelector("[name=LatestPrice]").(*dom.HTMLInputElement).Value
-			s1, _ := strconv.ParseFloat(s, 64)
-			p1, _ := strconv.ParseFloat(p, 64)
-			thePart.CurrentStock = s1
-			thePart.LatestPrice = p1
-			thePart.ValuationString = thePart.DisplayValuation()
-			doc.QuerySelector("[name=ValuationString]").(*dom.HTMLInputElement).Value = thePart.ValuationString
-		})
-
 	}()
 }
 
@@ -862,10 +842,11 @@ func partEdit(context *router.Context) {
 		form.Row(1).
 			AddInput(1, "Description", "Descr")
 
-		form.Row(4).
+		form.Row(5).
 			AddDecimal(1, "ReOrder Level", "ReorderStocklevel", 2, "1").
 			AddDecimal(1, "ReOrder Qty", "ReorderQty", 2, "1").
-			AddDecimal(1, "Current Stock", "CurrentStock", 2, "1").
+			AddDisplay(1, "Total Stock", "CurrentStock").
+			AddDisplay(1, "Available", "Available").
 			AddInput(1, "Qty Type", "QtyType")
 
 		form.Row(4).
@@ -931,8 +912,9 @@ func partEdit(context *router.Context) {
 		// Inject the StockLevel list
 		stocklist := formulate.ListForm{}
 		stocklist.New("", "")
-		stocklist.ColumnFormat("Date", "DateFromDisplay", `width="30%"`)
-		stocklist.ColumnFormat("Description", "Descr", `width="50%" text-align="right"`)
+		stocklist.ColumnFormat("Store", "GetSiteName", `width="20%"`)
+		stocklist.ColumnFormat("Date", "DateFromDisplay", `width="25%"`)
+		stocklist.ColumnFormat("Description", "Descr", `width="35%" text-align="right"`)
 		stocklist.ColumnFormat("Stock", "StockLevel", `width="20%" text-align="right"`)
 		stocklist.Render("part-stock-list", "[name=StockList]", stocks)
 
@@ -944,34 +926,10 @@ func partEdit(context *router.Context) {
 		pricelist.ColumnFormat("Price", "PriceDisplay", `width="20%" text-align="right"`)
 		pricelist.Render("part-price-list", "[name=PriceList]", prices)
 
-		// Auto calculate the valuation on change of fields
-		w := dom.GetWindow()
-		doc := w.Document()
-		doc.QuerySelector("[name=CurrentStock]").AddEventListener("change", false, func(evt dom.Event) {
-			s := doc.QuerySelector("[name=CurrentStock]").(*dom.HTMLInputElement).Value
-			p := doc.QuerySelector("[name=LatestPrice]").(*dom.HTMLInputElement).Value
-			s1, _ := strconv.ParseFloat(s, 64)
-			p1, _ := strconv.ParseFloat(p, 64)
-			part.CurrentStock = s1
-			part.LatestPrice = p1
-			part.ValuationString = part.DisplayValuation()
-			doc.QuerySelector("[name=ValuationString]").(*dom.HTMLInputElement).Value = part.ValuationString
+		// And attach actions
+		form.ActionGrid("part-actions", "#action-grid", part.ID, func(url string) {
+			Session.Navigate(url)
 		})
-		doc.QuerySelector("[name=LatestPrice]").AddEventListener("change", false, func(evt dom.Event) {
-			s := doc.QuerySelector("[name=CurrentStock]").(*dom.HTMLInputElement).Value
-			p := doc.QuerySelector("[name=LatestPrice]").(*dom.HTMLInputElement).Value
-			s1, _ := strconv.ParseFloat(s, 64)
-			p1, _ := strconv.ParseFloat(p, 64)
-			part.CurrentStock = s1
-			part.LatestPrice = p1
-			part.ValuationString = part.DisplayValuation()
-			doc.QuerySelector("[name=ValuationString]").(*dom.HTMLInputElement).Value = part.ValuationString
-		})
-
-		// // And attach actions
-		// form.ActionGrid("part-actions", "#action-grid", part.ID, func(url string) {
-		// 	print("clicked on url", url)
-		// })
 
 	}()
 }
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"itrak-cmms/shared"
+
+	"github.com/go-humble/router"
+	"github.com/steveoc64/formulate"
+	"honnef.co/go/js/dom"
+)
+
+// Parts that are at or below their reorder level, waiting to be ordered
+
+func reorderList(context *router.Context) {
+	Session.Subscribe("reorder", _reorderList)
+	go _reorderList("list", 0)
+}
+
+func _reorderList(action string, id int) {
+	suggestions := []shared.ReorderSuggestion{}
+	rpcClient.Call("ReorderRPC.List", Session.Channel, &suggestions)
+
+	form := formulate.ListForm{}
+	form.New("fa-refresh", "Parts to Reorder")
+
+	// Define the layout
+	form.Column("Vendor", "GetVendorName")
+	form.Column("Store", "GetSiteName")
+	form.Column("Stock Code", "StockCode")
+	form.Column("Part", "PartName")
+	form.Column("Stock / Reorder Level", "GetStock")
+	form.Column("Order", "GetQty")
+	form.Column("Total", "GetTotal")
+	form.Column("Status", "Status")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate("/")
+	})
+
+	form.RowEvent(func(key string) {
+		Session.Navigate("/reorder/" + key)
+	})
+
+	form.Render("reorder-list", "main", suggestions)
+}
+
+func reorderEdit(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	Session.ID["reorder"] = id
+
+	Session.Subscribe("reorder", _reorderEdit)
+	go _reorderEdit("edit", id)
+}
+
+func _reorderEdit(action string, id int) {
+	BackURL := "/reorder"
+
+	switch action {
+	case "edit":
+	default:
+		if id != 0 && id != Session.ID["reorder"] {
+			return
+		}
+		id = Session.ID["reorder"]
+	}
+
+	s := shared.ReorderSuggestion{}
+	rpcClient.Call("ReorderRPC.Get", shared.ReorderRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+	}, &s)
+	if s.Closed != nil {
+		// ordered or cleared since, so nothing left to do here
+		Session.Navigate(BackURL)
+		return
+	}
+
+	vendors := []shared.PartVendors{}
+	rpcClient.Call("VendorRPC.PartVendors", shared.PartRPCData{
+		Channel: Session.Channel,
+		ID:      s.PartID,
+	}, &vendors)
+
+	form := formulate.EditForm{}
+	form.New("fa-refresh", fmt.Sprintf("Reorder %s - %s", s.StockCode, s.PartName))
+
+	// Layout the fields
+	form.Row(4).
+		AddDisplay(1, "Store", "GetSiteName").
+		AddDisplay(1, "Stock / Reorder Level", "GetStock").
+		AddDisplay(1, "Raised", "GetRaised").
+		AddDisplay(1, "Status", "Status")
+
+	form.Row(3).
+		AddSelect(1, "Vendor", "VendorID", vendors, "VendorId", "Name", 1, s.VendorID).
+		AddDecimal(1, "Qty to Order", "Qty", 2, "1").
+		AddDisplay(1, "Total", "GetTotal")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(BackURL)
+	})
+
+	form.SaveEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		form.Bind(&s)
+		go func() {
+			done := false
+			err := rpcClient.Call("ReorderRPC.Update", shared.ReorderRPCData{
+				Channel:           Session.Channel,
+				ID:                id,
+				ReorderSuggestion: &s,
+			}, &done)
+			if err != nil {
+				print("RPC error", err.Error())
+				dom.GetWindow().Alert(err.Error())
+				return
+			}
+			Session.Navigate(BackURL)
+		}()
+	})
+
+	// All done, so render the form
+	form.Render("edit-form", "main", &s)
+
+	// And attach actions
+	form.ActionGrid("reorder-actions", "#action-grid", &s, func(url string) {
+		w := dom.GetWindow()
+		go func() {
+			switch url {
+			case "dismiss":
+				done := false
+				rpcClient.Call("ReorderRPC.Dismiss", shared.ReorderRPCData{
+					Channel: Session.Channel,
+					ID:      id,
+				}, &done)
+				Session.Navigate(BackURL)
+			case "order", "orderall":
+				vendorID := s.VendorID
+				if url == "orderall" {
+					vendorID = 0
+				}
+				count := 0
+				err := rpcClient.Call("ReorderRPC.Order", shared.ReorderRPCData{
+					Channel:  Session.Channel,
+					VendorID: vendorID,
+				}, &count)
+				if err != nil {
+					print("RPC error", err.Error())
+					w.Alert(err.Error())
+					return
+				}
+				w.Alert(fmt.Sprintf("%d draft purchase orders raised", count))
+				Session.Navigate("/purchaseorders")
+			default:
+				Session.Navigate(url)
+			}
+		}()
+	})
+}
@@ -65,11 +65,22 @@ func enableRoutes(Role string) {
 			"machine-sched-add":     machineSchedAdd,
 			"machine-reports":       machineReports,
 			"machine-stoppage-list": machineStoppageList,
+			"machine-jobcount-list": machineJobCountList,
+			"machine-jobcount-add":  machineJobCountAdd,
+			"machine-meter-list":    machineMeterList,
+			"machine-meter-add":     machineMeterAdd,
 			"sched-edit":            schedEdit,
 			"sched-task-list":       schedTaskList,
+			"sched-skills":          schedSkills,
 			"task-list":             taskList,
 			"task-edit":             taskEdit,
 			"task-part-list":        taskPartList,
+			"task-history":          taskHistory,
+			"task-time":             taskTime,
+			"task-crew":             taskCrew,
+			"task-crew-add":         taskCrewAdd,
+			"task-crew-member":      taskCrewMember,
+			"task-skills":           taskSkills,
 			"task-invoices":         taskInvoices,
 			"task-invoice":          taskInvoice,
 			"task-invoice-add":      taskInvoiceAdd,
@@ -78,6 +89,7 @@ func enableRoutes(Role string) {
 			"stoppage-complete":     stoppageComplete,
 			"stoppage-new-task":     stoppageNewTask,
 			"stoppage-task-list":    stoppageTaskList,
+			"stoppage-history":      stoppageHistory,
 			// "class-select":          classSelect,
 			"class-select":           partsList,
 			"class-add":              classAdd,
@@ -103,6 +115,53 @@ func enableRoutes(Role string) {
 			"machine-type-tool-add":  machineTypeToolAdd,
 			"machine-type-tool-edit": machineTypeToolEdit,
 			"machine-type-parts":     machineTypeParts,
+			"subsystem-list":         subsystemList,
+			"subsystem-add":          subsystemAdd,
+			"subsystem-edit":         subsystemEdit,
+			"calendar-list":          calendarList,
+			"calendar-add":           calendarAdd,
+			"calendar-edit":          calendarEdit,
+			"calendar-holidays":      calendarHolidays,
+			"calendar-holiday-add":   calendarHolidayAdd,
+			"calendar-holiday-edit":  calendarHolidayEdit,
+			"calendar-import":        calendarImport,
+			"skill-list":             skillsList,
+			"skill-add":              skillAdd,
+			"skill-edit":             skillEdit,
+			"skill-users":            skillUsers,
+			"user-skills":            userSkills,
+			"user-skill-add":         userSkillAdd,
+			"user-skill-edit":        userSkillEdit,
+			"vendor-list":            vendorList,
+			"vendor-add":             vendorAdd,
+			"vendor-edit":            vendorEdit,
+			"vendor-prices":          vendorPrices,
+			"vendor-price-add":       vendorPriceAdd,
+			"vendor-price-edit":      vendorPriceEdit,
+			"vendor-orders":          vendorOrders,
+			"po-list":                purchaseOrderList,
+			"po-add":                 purchaseOrderAdd,
+			"po-edit":                purchaseOrderEdit,
+			"po-lines":               purchaseOrderLines,
+			"po-line-add":            purchaseOrderLineAdd,
+			"po-line-edit":           purchaseOrderLineEdit,
+			"reorder-list":           reorderList,
+			"reorder-edit":           reorderEdit,
+			"part-stock":             partSiteStock,
+			"part-count":             partStockCount,
+			"part-transfers":         partTransfers,
+			"transfer-add":           transferAdd,
+			"transfer-list":          transferList,
+			"transfer-edit":          transferEdit,
+			"part-costs":             partCostLayers,
+			"stocktake-list":         stockTakeList,
+			"stocktake-add":          stockTakeAdd,
+			"stocktake-edit":         stockTakeEdit,
+			"stocktake-sheet":        stockTakeSheet,
+			"stocktake-count":        stockTakeCount,
+			"escalation-list":        escalationList,
+			"escalation-add":         escalationAdd,
+			"escalation-edit":        escalationEdit,
 			"phototest":              phototest,
 			"phototest-edit":         phototestEdit,
 			"phototest-add":          phototestAdd,
@@ -118,12 +177,18 @@ func enableRoutes(Role string) {
 				"task-list":          taskList,
 				"task-edit":          taskEdit,
 				"task-part-list":     taskPartList,
+				"task-history":       taskHistory,
+				"task-time":          taskTime,
+				"task-crew":          taskCrew,
+				"task-crew-add":      taskCrewAdd,
+				"task-crew-member":   taskCrewMember,
 				"stoppages":          stoppageList,
 				"stoppage-list":      stoppageList,
 				"stoppage-edit":      stoppageEdit,
 				"stoppage-complete":  stoppageComplete,
 				"stoppage-new-task":  stoppageNewTask,
 				"stoppage-task-list": stoppageTaskList,
+				"stoppage-history":   stoppageHistory,
 				"parts":              partList,
 				"reports":            technicianReports,
 				"stops":              stops,
@@ -135,6 +200,9 @@ func enableRoutes(Role string) {
 				"task-list":      taskList,
 				"task-edit":      taskEdit,
 				"task-part-list": taskPartList,
+				"task-history":   taskHistory,
+				"task-time":      taskTime,
+				"task-crew":      taskCrew,
 				"stoppages":      stoppageList,
 				"parts":          partList,
 				"reports":        technicianReports,
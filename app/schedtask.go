@@ -104,6 +104,7 @@ func hashtagUsed(context *router.Context) {
 		// Define the layout
 		form.Column("Tool / Component", "Component")
 		form.Column("Frequency", "ShowFrequency")
+		form.Column("Next Due", "ShowNextDue")
 		form.Column("Description", "Descr")
 		form.MultiImgColumn("Documents", "Photos", "Thumb")
 		form.Column("$ Labour", "LabourCost")
@@ -152,6 +153,7 @@ func machineSchedList(context *router.Context) {
 		// Define the layout
 		form.Column("Tool / Component", "Component")
 		form.Column("Frequency", "ShowFrequency")
+		form.Column("Next Due", "ShowNextDue")
 		form.Column("Description", "Descr")
 		form.MultiImgColumn("Documents", "Photos", "Thumb")
 		// form.Column("$ Labour", "LabourCost")
@@ -196,6 +198,9 @@ func schedEdit(context *router.Context) {
 		{4, "Every N Months"},
 		{5, "One Off"},
 		{6, "Job Count"},
+		{7, "Every N Hours"},
+		{8, "Nth Weekday"},
+		{9, "Custom Rule"},
 	}
 
 	weeks := []formulate.SelectOption{
@@ -203,6 +208,7 @@ func schedEdit(context *router.Context) {
 		{2, "2nd Week"},
 		{3, "3rd Week"},
 		{4, "4th Week"},
+		{5, "Last Week"},
 	}
 
 	weekdays := []formulate.SelectOption{
@@ -211,6 +217,8 @@ func schedEdit(context *router.Context) {
 		{3, "Wed"},
 		{4, "Thur"},
 		{5, "Fri"},
+		{6, "Sat"},
+		{7, "Sun"},
 	}
 
 	go func() {
@@ -271,6 +279,8 @@ func schedEdit(context *router.Context) {
 		swapper.AddPanel("months").AddRow(1).AddNumber(1, "Every N Months", "Months", "1")
 		swapper.AddPanel("oneoff").AddRow(1).AddDate(1, "One Off Date", "OneOffDate")
 		swapper.AddPanel("job").AddRow(1).AddNumber(1, "Job Count", "Count", "1")
+		swapper.AddPanel("hours").AddRow(1).AddNumber(1, "Running Hours", "Hours", "1")
+		swapper.AddPanel("rule").AddRow(1).AddInput(1, "Rule (RRULE such as FREQ=MONTHLY;BYDAY=2TU)", "RRule")
 
 		// Layout the fields
 		currentFreq := 0
@@ -294,18 +304,10 @@ func schedEdit(context *router.Context) {
 			compTools = append(compTools, newOpt)
 		}
 
-		compOther := []formulate.SelectOption{
-			{101, "Uncoiler"},
-			{102, "RollBed"},
-			{103, "Conveyor"},
-			{104, "Electrical"},
-			{105, "Hydraulic"},
-			{106, "Pnuematic"},
-			{107, "Lube"},
-			{108, "Printer"},
-			{109, "Console"},
-			{110, "Encoder"},
-			{111, "StripGuide"},
+		compOther := []formulate.SelectOption{}
+		for i, sub := range machine.Subsystems {
+			newOpt := formulate.SelectOption{ID: i + 101, Name: sub.Name}
+			compOther = append(compOther, newOpt)
 		}
 
 		currentComp := 0
@@ -393,9 +395,7 @@ func schedEdit(context *router.Context) {
 				task.Component = compTools[comp-1].Name
 			} else {
 				task.CompType = "C"
-				offset := comp - len(machine.Components)
-				print("edit offset = ", offset)
-				task.Component = compOther[offset-1].Name
+				task.Component = compOther[comp-101].Name
 			}
 
 			// convert the selected freq into a meaningful string
@@ -406,43 +406,67 @@ func schedEdit(context *router.Context) {
 					break
 				}
 			}
+			if targetFreq != 9 {
+				task.RRule = ""
+			}
 			switch targetFreq {
-			case 1:
+			case 1, 8:
 				task.Days = nil
 				task.Months = nil
 				task.Count = nil
 				task.StartDate = nil
 				task.OneOffDate = nil
+				task.Hours = nil
 			case 2:
 				task.Days = nil
 				task.Months = nil
 				task.Count = nil
 				task.Week = nil
 				task.OneOffDate = nil
+				task.Hours = nil
 			case 3:
 				task.Week = nil
 				task.Count = nil
 				task.Months = nil
 				task.StartDate = nil
 				task.OneOffDate = nil
+				task.Hours = nil
 			case 4:
 				task.Days = nil
 				task.Week = nil
 				task.Count = nil
 				task.StartDate = nil
 				task.OneOffDate = nil
+				task.Hours = nil
 			case 5:
 				task.Days = nil
 				task.Months = nil
 				task.Count = nil
 				task.StartDate = nil
 				task.Week = nil
+				task.Hours = nil
 			case 6:
 				task.Days = nil
 				task.Months = nil
 				task.Week = nil
 				task.StartDate = nil
 				task.OneOffDate = nil
+				task.Hours = nil
+			case 7:
+				task.Days = nil
+				task.Months = nil
+				task.Count = nil
+				task.Week = nil
+				task.StartDate = nil
+				task.OneOffDate = nil
+			case 9:
+				task.Days = nil
+				task.Months = nil
+				task.Count = nil
+				task.Week = nil
+				task.StartDate = nil
+				task.OneOffDate = nil
+				task.Hours = nil
 			}
 
 			// If the uploaded data is a PDF, then use that data instead of the preview
@@ -500,6 +524,12 @@ func schedEdit(context *router.Context) {
 					swapper.SelectByName("oneoff")
 				case 6:
 					swapper.SelectByName("job")
+				case 7:
+					swapper.SelectByName("hours")
+				case 8:
+					swapper.SelectByName("week")
+				case 9:
+					swapper.SelectByName("rule")
 				}
 			}
 		})
@@ -567,6 +597,9 @@ func machineSchedAdd(context *router.Context) {
 		{4, "Every N Months"},
 		{5, "One Off"},
 		{6, "Job Count"},
+		{7, "Every N Hours"},
+		{8, "Nth Weekday"},
+		{9, "Custom Rule"},
 	}
 
 	weeks := []formulate.SelectOption{
@@ -574,6 +607,7 @@ func machineSchedAdd(context *router.Context) {
 		{2, "2nd Week"},
 		{3, "3rd Week"},
 		{4, "4th Week"},
+		{5, "Last Week"},
 	}
 
 	weekdays := []formulate.SelectOption{
@@ -582,6 +616,8 @@ func machineSchedAdd(context *router.Context) {
 		{3, "Wed"},
 		{4, "Thur"},
 		{5, "Fri"},
+		{6, "Sat"},
+		{7, "Sun"},
 	}
 
 	go func() {
@@ -618,6 +654,8 @@ func machineSchedAdd(context *router.Context) {
 		swapper.AddPanel("months").AddRow(1).AddNumber(1, "Every N Months", "Months", "1")
 		swapper.AddPanel("oneoff").AddRow(1).AddDate(1, "One Off Date", "OneOffDate")
 		swapper.AddPanel("job").AddRow(1).AddNumber(1, "Job Count", "Count", "1")
+		swapper.AddPanel("hours").AddRow(1).AddNumber(1, "Running Hours", "Hours", "1")
+		swapper.AddPanel("rule").AddRow(1).AddInput(1, "Rule (RRULE such as FREQ=MONTHLY;BYDAY=2TU)", "RRule")
 
 		// Layout the fields
 		form.Row(3).
@@ -634,18 +672,10 @@ func machineSchedAdd(context *router.Context) {
 			compTools = append(compTools, newOpt)
 		}
 
-		compOther := []formulate.SelectOption{
-			{101, "Uncoiler"},
-			{102, "RollBed"},
-			{103, "Conveyor"},
-			{104, "Electrical"},
-			{105, "Hydraulic"},
-			{106, "Pnuematic"},
-			{107, "Lube"},
-			{108, "Printer"},
-			{109, "Console"},
-			{110, "Encoder"},
-			{111, "StripGuide"},
+		compOther := []formulate.SelectOption{}
+		for i, sub := range machine.Subsystems {
+			newOpt := formulate.SelectOption{ID: i + 101, Name: sub.Name}
+			compOther = append(compOther, newOpt)
 		}
 
 		form.Row(2).
@@ -699,9 +729,7 @@ func machineSchedAdd(context *router.Context) {
 				task.Component = compTools[comp-1].Name
 			} else {
 				task.CompType = "C"
-				offset := comp - len(machine.Components)
-				print("offset = ", comp)
-				task.Component = compOther[offset].Name
+				task.Component = compOther[comp-101].Name
 			}
 
 			// convert the selected freq into a meaningful string
@@ -712,43 +740,67 @@ func machineSchedAdd(context *router.Context) {
 					break
 				}
 			}
+			if targetFreq != 9 {
+				task.RRule = ""
+			}
 			switch targetFreq {
-			case 1:
+			case 1, 8:
 				task.Days = nil
 				task.Months = nil
 				task.Count = nil
 				task.StartDate = nil
 				task.OneOffDate = nil
+				task.Hours = nil
 			case 2:
 				task.Days = nil
 				task.Months = nil
 				task.Count = nil
 				task.Week = nil
 				task.OneOffDate = nil
+				task.Hours = nil
 			case 3:
 				task.Week = nil
 				task.Months = nil
 				task.Count = nil
 				task.StartDate = nil
 				task.OneOffDate = nil
+				task.Hours = nil
 			case 4:
 				task.Week = nil
 				task.Days = nil
 				task.Count = nil
 				task.StartDate = nil
 				task.OneOffDate = nil
+				task.Hours = nil
 			case 5:
 				task.Days = nil
 				task.Months = nil
 				task.Count = nil
 				task.StartDate = nil
 				task.Week = nil
+				task.Hours = nil
 			case 6:
 				task.Days = nil
 				task.Months = nil
 				task.Week = nil
 				task.StartDate = nil
 				task.OneOffDate = nil
+				task.Hours = nil
+			case 7:
+				task.Days = nil
+				task.Months = nil
+				task.Count = nil
+				task.Week = nil
+				task.StartDate = nil
+				task.OneOffDate = nil
+			case 9:
+				task.Days = nil
+				task.Months = nil
+				task.Count = nil
+				task.Week = nil
+				task.StartDate = nil
+				task.OneOffDate = nil
+				task.Hours = nil
 			}
 
 			go func() {
@@ -792,6 +844,12 @@ func machineSchedAdd(context *router.Context) {
 					swapper.SelectByName("oneoff")
 				case 6:
 					swapper.SelectByName("job")
+				case 7:
+					swapper.SelectByName("hours")
+				case 8:
+					swapper.SelectByName("week")
+				case 9:
+					swapper.SelectByName("rule")
 				}
 			}
 		})
@@ -62,56 +62,17 @@ func _siteMap(action string, id int) {
 			})
 		}
 
-		// Add listeners for the yellow buttons
-		doc.QuerySelector("#edin-btn").AddEventListener("click", false, func(evt dom.Event) {
-			print("clicked on edin btn", data.Status)
-			// if data.Status.Edinburgh == "Running" {
-			// w.Alert("Edinburgh sites - all running")
-			// } else {
-			Session.Navigate("/stops/edinburgh")
-			// }
-
-		})
-
-		doc.QuerySelector("#chind-btn").AddEventListener("click", false, func(evt dom.Event) {
-			// print("clicked on chind btn")
-			// if data.Status.Chinderah == "Running" {
-			// w.Alert("Chinderah site - all running")
-			// } else {
-			Session.Navigate("/stops/chinderah")
-			// }
-
-		})
-
-		doc.QuerySelector("#tom-btn").AddEventListener("click", false, func(evt dom.Event) {
-			// print("clicked on tom btn")
-			// if data.Status.Tomago == "Running" {
-			// w.Alert("Tomago site - all running")
-			// } else {
-			Session.Navigate("/stops/tomago")
-			// }
-
-		})
-
-		doc.QuerySelector("#mint-btn").AddEventListener("click", false, func(evt dom.Event) {
-			// print("clicked on mint btn")
-			// if data.Status.Minto == "Running" {
-			// w.Alert("Minto site - all running")
-			// } else {
-			Session.Navigate("/stops/minto")
-			// }
-
-		})
-
-		doc.QuerySelector("#usa-btn").AddEventListener("click", false, func(evt dom.Event) {
-			// print("clicked on usa btn")
-			// if data.Status.USA == "Running" {
-			// w.Alert("USA sites - all running")
-			// } else {
-			Session.Navigate("/stops/usa")
-			// }
-
-		})
+		// Add listeners for the site group buttons on the map
+		for _, v := range data.Status.Groups {
+			if !v.OnMap() {
+				continue
+			}
+			gbtn := doc.GetElementByID(fmt.Sprintf("group-btn-%d", v.ID))
+			gbtn.AddEventListener("click", false, func(evt dom.Event) {
+				id := evt.CurrentTarget().GetAttribute("group-id")
+				Session.Navigate("/stops/" + id)
+			})
+		}
 
 		// Add an Action Grid depending on which role the user is logged in as
 		// print("user role =", Session.UserRole)
@@ -224,9 +185,11 @@ func siteAdd(context *router.Context) {
 		site := shared.Site{}
 		sites := []shared.Site{}
 		users := []shared.User{}
+		calendars := []shared.Calendar{}
 
 		rpcClient.Call("SiteRPC.List", Session.Channel, &sites)
 		rpcClient.Call("UserRPC.List", Session.Channel, &users)
+		rpcClient.Call("CalendarRPC.List", Session.Channel, &calendars)
 
 		BackURL := "/sites"
 		title := "Add New Site"
@@ -241,6 +204,9 @@ func siteAdd(context *router.Context) {
 			AddSelect(1, "Parent Site", "ParentSite", sites, "ID", "Name", 0, site.ParentSite).
 			AddSelect(1, "Stock Site", "StockSite", sites, "ID", "Name", 0, site.StockSite)
 
+		form.Row(1).
+			AddSelect(1, "Work Calendar (blank to use the Parent Site calendar)", "CalendarID", calendars, "ID", "Name", 0, site.CalendarID)
+
 		form.Row(1).
 			AddInput(1, "Address", "Address")
 
@@ -252,6 +218,11 @@ func siteAdd(context *router.Context) {
 			AddSelect(1, "Stoppage Alerts To", "AlertsTo", users, "ID", "Name", 0, site.AlertsTo).
 			AddSelect(1, "Scheduled Tasks To", "TasksTo", users, "ID", "Name", 0, site.TasksTo)
 
+		form.Row(3).
+			AddInput(1, "Map Label", "MapLabel").
+			AddNumber(1, "Map X", "X", "1").
+			AddNumber(1, "Map Y", "Y", "1")
+
 		form.Row(1).
 			AddTextarea(1, "Notes", "Notes")
 
@@ -293,6 +264,7 @@ func siteEdit(context *router.Context) {
 	go func() {
 		site := shared.Site{}
 		sites := []shared.Site{}
+		calendars := []shared.Calendar{}
 		allManagers := []shared.User{}
 		managers := []shared.User{}
 		technicians := []shared.User{}
@@ -302,6 +274,7 @@ func siteEdit(context *router.Context) {
 			ID:      id,
 		}, &site)
 		rpcClient.Call("SiteRPC.List", Session.Channel, &sites)
+		rpcClient.Call("CalendarRPC.List", Session.Channel, &calendars)
 		rpcClient.Call("UserRPC.GetManagers", shared.SiteRPCData{
 			Channel: Session.Channel,
 			ID:      0,
@@ -328,6 +301,9 @@ func siteEdit(context *router.Context) {
 			AddSelect(1, "Parent Site", "ParentSite", sites, "ID", "Name", 0, site.ParentSite).
 			AddSelect(1, "Stock Site", "StockSite", sites, "ID", "Name", 0, site.StockSite)
 
+		form.Row(1).
+			AddSelect(1, "Work Calendar (blank to use the Parent Site calendar)", "CalendarID", calendars, "ID", "Name", 0, site.CalendarID)
+
 		form.Row(1).
 			AddInput(1, "Address", "Address")
 
@@ -339,6 +315,11 @@ func siteEdit(context *router.Context) {
 			AddSelect(1, "Stoppage Alerts To", "AlertsTo", managers, "ID", "Name", 0, site.AlertsTo).
 			AddSelect(1, "Send Scheduled Tasks To", "TasksTo", technicians, "ID", "Name", 0, site.TasksTo)
 
+		form.Row(3).
+			AddInput(1, "Map Label", "MapLabel").
+			AddNumber(1, "Map X", "X", "1").
+			AddNumber(1, "Map Y", "Y", "1")
+
 		form.Row(1).
 			AddTextarea(1, "Notes", "Notes")
 
@@ -438,10 +419,6 @@ func _siteMachines(action string, id int) {
 	// Get a list of machines at this site
 	data := SiteMachineData{}
 
-	nonTools := []string{
-		"Electrical", "Hydraulic", "Pnuematic", "Lube", "Printer", "Console", "Uncoiler", "Rollbed", "Conveyor", "Encoder", "StripGuide",
-	}
-
 	RefreshURL := fmt.Sprintf("/sitemachines/%d", id)
 
 	data.MultiSite = true
@@ -594,10 +571,10 @@ func _siteMachines(action string, id int) {
 									menu += fmt.Sprintf(`<a href="#" id="machine-comp-%d" machine="%d" comp="%d" class="%s">%d %s</a>`,
 										c.ID, m.ID, c.ID, c.GetClass(), i+1, c.Name)
 								}
-								// add the non-tool components
-								for i, c := range nonTools {
+								// add the non-tool subsystems
+								for i, c := range m.Subsystems {
 									menu += fmt.Sprintf(`<a href="#" id="machine-nontool-%d" machine="%d" class="%s" comp="%s">%s</a>`,
-										i, m.ID, m.GetClass(m.GetStatus(c)), c, c)
+										i, m.ID, c.GetClass(), c.Name, c.GetLabel())
 								}
 								machinemenu.SetInnerHTML(menu)
 								tk := machinemenu.Class()
@@ -670,7 +647,7 @@ func _siteMachines(action string, id int) {
 									})
 								}
 								// attach event listeners to each non-tool menu item
-								for i := range nonTools {
+								for i := range m.Subsystems {
 									a := doc.GetElementByID(fmt.Sprintf("machine-nontool-%d", i))
 									a.AddEventListener("click", false, func(evt dom.Event) {
 										evt.PreventDefault()
@@ -761,6 +738,7 @@ func siteSchedList(context *router.Context) {
 		form.Column("Machine", "MachineName")
 		form.Column("Tool / Component", "Component")
 		form.Column("Frequency", "ShowFrequency")
+		form.Column("Next Due", "ShowNextDue")
 		form.Column("Description", "Descr")
 		form.MultiImgColumn("Documents", "Photos", "Thumb")
 		// form.Column("$ Labour", "LabourCost")
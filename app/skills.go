@@ -1,9 +1,567 @@
 package main
 
 import (
+	"fmt"
+	"strconv"
+
+	"itrak-cmms/shared"
+
 	"github.com/go-humble/router"
+	"github.com/steveoc64/formulate"
+	"honnef.co/go/js/dom"
 )
 
+// The skills matrix - the skills that technicians hold and at what level, and
+// the skills that scheduled tasks and tasks need
+
+var skillLevelOptions = []formulate.SelectOption{
+	{1, "Trainee"},
+	{2, "Competent"},
+	{3, "Expert"},
+}
+
 func skillsList(context *router.Context) {
-	print("TODO - skillsList")
+	Session.Subscribe("skill", _skillsList)
+	go _skillsList("list", 0)
+}
+
+func _skillsList(action string, id int) {
+	skills := []shared.Skill{}
+	rpcClient.Call("SkillRPC.List", Session.Channel, &skills)
+
+	form := formulate.ListForm{}
+	form.New("fa-graduation-cap", "Skills")
+
+	// Define the layout
+	form.Column("Name", "Name")
+	form.Column("Notes", "Notes")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate("/")
+	})
+
+	form.NewRowEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate("/skill/add")
+	})
+
+	form.RowEvent(func(key string) {
+		Session.Navigate("/skill/" + key)
+	})
+
+	form.Render("skill-list", "main", skills)
+}
+
+func skillAdd(context *router.Context) {
+	go func() {
+		skill := shared.Skill{}
+
+		BackURL := "/skills"
+		title := "Add New Skill"
+		form := formulate.EditForm{}
+		form.New("fa-graduation-cap", title)
+
+		// Layout the fields
+		form.Row(1).
+			AddInput(1, "Name", "Name")
+
+		form.Row(1).
+			AddTextarea(1, "Notes", "Notes")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&skill)
+			go func() {
+				newID := 0
+				err := rpcClient.Call("SkillRPC.Insert", shared.SkillRPCData{
+					Channel: Session.Channel,
+					Skill:   &skill,
+				}, &newID)
+				if err != nil {
+					print("RPC error", err.Error())
+					dom.GetWindow().Alert(err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &skill)
+	}()
+}
+
+func skillEdit(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	Session.ID["skill"] = id
+
+	Session.Subscribe("skill", _skillEdit)
+	go _skillEdit("edit", id)
+}
+
+func _skillEdit(action string, id int) {
+
+	BackURL := "/skills"
+
+	switch action {
+	case "edit":
+		print("manually edit")
+	case "delete":
+		if id != Session.ID["skill"] {
+			return
+		}
+		print("current record has been deleted")
+		Session.Navigate(BackURL)
+		return
+	default:
+		if id != Session.ID["skill"] {
+			return
+		}
+	}
+	skill := shared.Skill{}
+	rpcClient.Call("SkillRPC.Get", shared.SkillRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+	}, &skill)
+
+	title := "Edit Skill - " + skill.Name
+
+	form := formulate.EditForm{}
+	form.New("fa-graduation-cap", title)
+
+	// Layout the fields
+	form.Row(1).
+		AddInput(1, "Name", "Name")
+
+	form.Row(1).
+		AddTextarea(1, "Notes", "Notes")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(BackURL)
+	})
+
+	form.DeleteEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		go func() {
+			done := false
+			rpcClient.Call("SkillRPC.Delete", shared.SkillRPCData{
+				Channel: Session.Channel,
+				ID:      id,
+			}, &done)
+			Session.Navigate(BackURL)
+		}()
+	})
+
+	form.SaveEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		form.Bind(&skill)
+		go func() {
+			done := false
+			err := rpcClient.Call("SkillRPC.Update", shared.SkillRPCData{
+				Channel: Session.Channel,
+				ID:      id,
+				Skill:   &skill,
+			}, &done)
+			if err != nil {
+				print("RPC error", err.Error())
+				dom.GetWindow().Alert(err.Error())
+				return
+			}
+			Session.Navigate(BackURL)
+		}()
+	})
+
+	// All done, so render the form
+	form.Render("edit-form", "main", &skill)
+
+	// And attach actions
+	form.ActionGrid("skill-actions", "#action-grid", skill.ID, func(url string) {
+		Session.Navigate(url)
+	})
+}
+
+// Everyone that holds the skill, best first
+func skillUsers(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		skill := shared.Skill{}
+		rpcClient.Call("SkillRPC.Get", shared.SkillRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}, &skill)
+
+		users := []shared.UserSkill{}
+		rpcClient.Call("SkillRPC.SkillUsers", shared.SkillRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}, &users)
+
+		form := formulate.ListForm{}
+		form.New("fa-graduation-cap", "Who has the skill - "+skill.Name)
+
+		// Define the layout
+		form.Column("Technician", "GetUsername")
+		form.Column("Level", "GetLevel")
+		form.Column("Certified", "GetCertified")
+		form.Column("Expires", "GetExpires")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(fmt.Sprintf("/skill/%d", id))
+		})
+
+		form.RowEvent(func(key string) {
+			Session.Navigate("/user/skills/" + key)
+		})
+
+		form.Render("skill-user-list", "main", users)
+	}()
+}
+
+func userSkills(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	Session.ID["userskill"] = id
+
+	Session.Subscribe("userskill", _userSkills)
+	go _userSkills("list", id)
+}
+
+func _userSkills(action string, id int) {
+	if id != Session.ID["userskill"] {
+		return
+	}
+
+	user := shared.User{}
+	rpcClient.Call("UserRPC.Get", shared.UserRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+	}, &user)
+
+	skills := []shared.UserSkill{}
+	rpcClient.Call("SkillRPC.UserSkills", shared.UserSkillRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+	}, &skills)
+
+	form := formulate.ListForm{}
+	form.New("fa-graduation-cap", "Skills - "+user.Username)
+
+	// Define the layout
+	form.Column("Skill", "SkillName")
+	form.Column("Level", "GetLevel")
+	form.Column("Certified", "GetCertified")
+	form.Column("Expires", "GetExpires")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(fmt.Sprintf("/user/%d", id))
+	})
+
+	form.NewRowEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(fmt.Sprintf("/user/skills/%d/add", id))
+	})
+
+	form.RowEvent(func(key string) {
+		Session.Navigate(fmt.Sprintf("/user/skills/%d/skill/%s", id, key))
+	})
+
+	form.Render("user-skill-list", "main", skills)
+}
+
+func userSkillAdd(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		skills := []shared.Skill{}
+		rpcClient.Call("SkillRPC.List", Session.Channel, &skills)
+
+		userSkill := shared.UserSkill{
+			UserID: id,
+			Level:  1,
+		}
+
+		BackURL := fmt.Sprintf("/user/skills/%d", id)
+		form := formulate.EditForm{}
+		form.New("fa-graduation-cap", "Add Skill")
+
+		// Layout the fields
+		form.Row(2).
+			AddSelect(1, "Skill", "SkillID", skills, "ID", "Name", 1, 0).
+			AddSelect(1, "Level", "Level", skillLevelOptions, "ID", "Name", 1, 1)
+
+		form.Row(2).
+			AddDate(1, "Certified", "Certified").
+			AddDate(1, "Expires", "Expires")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&userSkill)
+			go func() {
+				done := false
+				err := rpcClient.Call("SkillRPC.SetUserSkill", shared.UserSkillRPCData{
+					Channel:   Session.Channel,
+					ID:        id,
+					UserSkill: &userSkill,
+				}, &done)
+				if err != nil {
+					print("RPC error", err.Error())
+					dom.GetWindow().Alert(err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &userSkill)
+	}()
+}
+
+func userSkillEdit(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	skillID, err := strconv.Atoi(context.Params["skill"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		skills := []shared.UserSkill{}
+		rpcClient.Call("SkillRPC.UserSkills", shared.UserSkillRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}, &skills)
+
+		userSkill := shared.UserSkill{UserID: id, SkillID: skillID}
+		for _, s := range skills {
+			if s.SkillID == skillID {
+				userSkill = s
+			}
+		}
+
+		BackURL := fmt.Sprintf("/user/skills/%d", id)
+		title := fmt.Sprintf("%s - %s", userSkill.GetUsername(), userSkill.SkillName)
+		form := formulate.EditForm{}
+		form.New("fa-graduation-cap", title)
+
+		// Layout the fields
+		form.Row(1).
+			AddSelect(1, "Level", "Level", skillLevelOptions, "ID", "Name", 1, userSkill.Level)
+
+		form.Row(2).
+			AddDate(1, "Certified", "Certified").
+			AddDate(1, "Expires", "Expires")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.DeleteEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			go func() {
+				done := false
+				rpcClient.Call("SkillRPC.DeleteUserSkill", shared.UserSkillRPCData{
+					Channel:   Session.Channel,
+					ID:        id,
+					UserSkill: &userSkill,
+				}, &done)
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&userSkill)
+			go func() {
+				done := false
+				err := rpcClient.Call("SkillRPC.SetUserSkill", shared.UserSkillRPCData{
+					Channel:   Session.Channel,
+					ID:        id,
+					UserSkill: &userSkill,
+				}, &done)
+				if err != nil {
+					print("RPC error", err.Error())
+					dom.GetWindow().Alert(err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &userSkill)
+	}()
+}
+
+// Skills needed by a sched task or a task. Sched tasks hand their skills on to
+// every task generated from them.
+
+func requiredSkillsRPCData(entity string, id int) shared.RequiredSkillRPCData {
+	data := shared.RequiredSkillRPCData{Channel: Session.Channel}
+	switch entity {
+	case "sched":
+		data.SchedID = id
+	case "task":
+		data.TaskID = id
+	}
+	return data
+}
+
+func requiredSkills(entity string, id int) {
+	skills := []shared.RequiredSkill{}
+	title := ""
+	switch entity {
+	case "sched":
+		rpcClient.Call("SkillRPC.SchedSkills", shared.SchedTaskRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}, &skills)
+		title = fmt.Sprintf("Required Skills - Sched %d", id)
+	case "task":
+		rpcClient.Call("SkillRPC.TaskSkills", shared.TaskRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}, &skills)
+		title = fmt.Sprintf("Required Skills - Task %06d", id)
+	}
+
+	form := formulate.ListForm{}
+	form.New("fa-graduation-cap", title)
+
+	// Define the layout
+	form.Column("Skill", "SkillName")
+	form.Column("Minimum Level", "GetLevel")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(fmt.Sprintf("/%s/%d", entity, id))
+	})
+
+	form.NewRowEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		go requiredSkillAdd(entity, id)
+	})
+
+	form.RowEvent(func(key string) {
+		skillID, _ := strconv.Atoi(key)
+		go func() {
+			if !dom.GetWindow().Confirm("Remove this skill ?") {
+				return
+			}
+			data := requiredSkillsRPCData(entity, id)
+			data.RequiredSkill = &shared.RequiredSkill{SkillID: skillID}
+			done := false
+			err := rpcClient.Call("SkillRPC.DeleteRequiredSkill", data, &done)
+			if err != nil {
+				print("RPC error", err.Error())
+				dom.GetWindow().Alert(err.Error())
+				return
+			}
+			requiredSkills(entity, id)
+		}()
+	})
+
+	form.Render("required-skill-list", "main", skills)
+}
+
+func requiredSkillAdd(entity string, id int) {
+	skills := []shared.Skill{}
+	rpcClient.Call("SkillRPC.List", Session.Channel, &skills)
+
+	required := shared.RequiredSkill{Level: 1}
+
+	form := formulate.EditForm{}
+	form.New("fa-graduation-cap", "Add Required Skill")
+
+	// Layout the fields
+	form.Row(2).
+		AddSelect(1, "Skill", "SkillID", skills, "ID", "Name", 1, 0).
+		AddSelect(1, "Minimum Level", "Level", skillLevelOptions, "ID", "Name", 1, 1)
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		go requiredSkills(entity, id)
+	})
+
+	form.SaveEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		form.Bind(&required)
+		go func() {
+			data := requiredSkillsRPCData(entity, id)
+			data.RequiredSkill = &required
+			done := false
+			err := rpcClient.Call("SkillRPC.SetRequiredSkill", data, &done)
+			if err != nil {
+				print("RPC error", err.Error())
+				dom.GetWindow().Alert(err.Error())
+				return
+			}
+			requiredSkills(entity, id)
+		}()
+	})
+
+	// All done, so render the form
+	form.Render("edit-form", "main", &required)
+}
+
+func schedSkills(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	go requiredSkills("sched", id)
+}
+
+func taskSkills(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	go requiredSkills("task", id)
 }
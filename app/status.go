@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"itrak-cmms/shared"
+
+	"github.com/go-humble/router"
+	"github.com/steveoc64/formulate"
+	"honnef.co/go/js/dom"
+)
+
+// Moving tasks and events between statuses, and showing the history of those moves.
+
+// Get the status off the end of an action url, such as /task/status/In Progress
+func statusFromURL(actionURL string) string {
+	status := actionURL[strings.LastIndex(actionURL, "/")+1:]
+	if s, err := url.QueryUnescape(status); err == nil {
+		status = s
+	}
+	return status
+}
+
+func setTaskStatus(id int, status string, nextURL string) {
+	done := false
+	err := rpcClient.Call("TaskRPC.SetStatus", shared.TaskStatusRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+		Status:  status,
+	}, &done)
+	if err != nil {
+		print("RPC error", err.Error())
+		dom.GetWindow().Alert(err.Error())
+		return
+	}
+	Session.Navigate(nextURL)
+}
+
+func setEventStatus(id int, status string, nextURL string) {
+	done := false
+	err := rpcClient.Call("EventRPC.SetStatus", shared.EventStatusRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+		Status:  status,
+	}, &done)
+	if err != nil {
+		print("RPC error", err.Error())
+		dom.GetWindow().Alert(err.Error())
+		return
+	}
+	Session.Navigate(nextURL)
+}
+
+func statusHistoryForm(title string, history []shared.StatusChange, BackURL string) {
+	form := formulate.ListForm{}
+	form.New("fa-history", title)
+
+	// Define the layout
+	form.Column("When", "GetChanged")
+	form.Column("Status", "GetTransition")
+	form.Column("By", "GetUsername")
+	form.Column("Notes", "Notes")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(BackURL)
+	})
+
+	form.PrintEvent(func(evt dom.Event) {
+		dom.GetWindow().Print()
+	})
+
+	form.Render("status-history", "main", history)
+}
+
+func taskHistory(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		history := []shared.StatusChange{}
+		rpcClient.Call("TaskRPC.StatusHistory", shared.TaskRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}, &history)
+
+		statusHistoryForm(fmt.Sprintf("Status History - Task %06d", id),
+			history, fmt.Sprintf("/task/%d", id))
+	}()
+}
+
+func stoppageHistory(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		history := []shared.StatusChange{}
+		rpcClient.Call("EventRPC.StatusHistory", shared.EventRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}, &history)
+
+		statusHistoryForm(fmt.Sprintf("Status History - Stoppage %06d", id),
+			history, fmt.Sprintf("/stoppage/%d", id))
+	}()
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"itrak-cmms/shared"
+
+	"github.com/go-humble/router"
+	"github.com/steveoc64/formulate"
+	"honnef.co/go/js/dom"
+)
+
+// Stock of each part at each store, and transfers between stores
+
+// Get the stores, with the stock not yet put into a store first
+func stockStores() []shared.Site {
+	stores := []shared.Site{}
+	rpcClient.Call("PartRPC.Stores", Session.Channel, &stores)
+	return append([]shared.Site{{Name: "Unallocated"}}, stores...)
+}
+
+func partSiteStock(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		part := shared.Part{}
+		stocks := []shared.StockLevel{}
+		data := shared.PartRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}
+		rpcClient.Call("PartRPC.Get", data, &part)
+		rpcClient.Call("PartRPC.SiteStock", data, &stocks)
+
+		BackURL := fmt.Sprintf("/part/%d", id)
+		form := formulate.ListForm{}
+		form.New("fa-book", fmt.Sprintf("Stock at each Store - %s - %s", part.StockCode, part.Name))
+
+		// Define the layout
+		form.Column("Store", "GetSiteName")
+		form.Column("On Hand", "Qty")
+		form.Column("In Transit", "InTransit")
+		form.Column("Reserved", "Reserved")
+		form.Column("Available", "Available")
+		form.Column("Last Moved", "GetDateFrom")
+		form.Column("Notes", "Notes")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.NewRowEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(fmt.Sprintf("/part/%d/transfer/add", id))
+		})
+
+		form.RowEvent(func(key string) {
+			Session.Navigate(fmt.Sprintf("/part/%d/count/%s", id, key))
+		})
+
+		form.Render("part-stock-sites", "main", stocks)
+	}()
+}
+
+// Enter the stock counted at a store
+func partStockCount(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	siteID, err := strconv.Atoi(context.Params["site"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		part := shared.Part{}
+		stocks := []shared.StockLevel{}
+		data := shared.PartRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}
+		rpcClient.Call("PartRPC.Get", data, &part)
+		rpcClient.Call("PartRPC.SiteStock", data, &stocks)
+		stores := stockStores()
+
+		count := shared.StockAdjustRPCData{
+			Channel: Session.Channel,
+			PartID:  id,
+			SiteID:  siteID,
+		}
+		for _, s := range stocks {
+			if s.SiteID == siteID {
+				count.Qty = s.Qty
+			}
+		}
+
+		BackURL := fmt.Sprintf("/part/%d/stock", id)
+		form := formulate.EditForm{}
+		form.New("fa-book", fmt.Sprintf("Stock Count - %s - %s", part.StockCode, part.Name))
+
+		// Layout the fields
+		form.Row(2).
+			AddSelect(1, "Store", "SiteID", stores, "ID", "Name", 1, siteID).
+			AddDecimal(1, "Qty Counted", "Qty", 2, "1")
+
+		form.Row(1).
+			AddInput(1, "Notes", "Notes")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&count)
+			go func() {
+				done := false
+				err := rpcClient.Call("PartRPC.AdjustStock", count, &done)
+				if err != nil {
+					print("RPC error", err.Error())
+					dom.GetWindow().Alert(err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &count)
+	}()
+}
+
+func partTransfers(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	Session.ID["part"] = id
+
+	Session.Subscribe("transfer", _transferList)
+	go _transferList("list", 0)
+}
+
+func transferList(context *router.Context) {
+	Session.ID["part"] = 0
+
+	Session.Subscribe("transfer", _transferList)
+	go _transferList("list", 0)
+}
+
+func _transferList(action string, id int) {
+	partID := Session.ID["part"]
+
+	transfers := []shared.StockTransfer{}
+	rpcClient.Call("PartRPC.Transfers", shared.PartRPCData{
+		Channel: Session.Channel,
+		ID:      partID,
+	}, &transfers)
+
+	BackURL := "/"
+	title := "Stock In Transit"
+	if partID != 0 {
+		BackURL = fmt.Sprintf("/part/%d", partID)
+		title = "Stock Transfers"
+		if len(transfers) > 0 {
+			title += fmt.Sprintf(" - %s - %s", transfers[0].StockCode, transfers[0].PartName)
+		}
+	}
+
+	form := formulate.ListForm{}
+	form.New("fa-truck", title)
+
+	// Define the layout
+	form.Column("Sent", "GetSent")
+	if partID == 0 {
+		form.Column("Stock Code", "StockCode")
+		form.Column("Part", "PartName")
+	}
+	form.Column("From", "GetFrom")
+	form.Column("To", "GetTo")
+	form.Column("Qty", "GetQty")
+	form.Column("Status", "Status")
+	form.Column("Received", "GetReceived")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(BackURL)
+	})
+
+	if partID != 0 {
+		form.NewRowEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(fmt.Sprintf("/part/%d/transfer/add", partID))
+		})
+	}
+
+	form.RowEvent(func(key string) {
+		Session.Navigate("/transfer/" + key)
+	})
+
+	form.Render("transfer-list", "main", transfers)
+}
+
+// Send stock of the part from one store to another
+func transferAdd(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		part := shared.Part{}
+		rpcClient.Call("PartRPC.Get", shared.PartRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}, &part)
+		stores := stockStores()
+
+		transfer := shared.StockTransfer{PartID: id}
+
+		BackURL := fmt.Sprintf("/part/%d/transfers", id)
+		form := formulate.EditForm{}
+		form.New("fa-truck", fmt.Sprintf("Send Stock - %s - %s", part.StockCode, part.Name))
+
+		// Layout the fields
+		form.Row(3).
+			AddSelect(1, "From", "FromSite", stores, "ID", "Name", 1, 0).
+			AddSelect(1, "To", "ToSite", stores, "ID", "Name", 1, 0).
+			AddDecimal(1, "Qty", "Qty", 2, "1")
+
+		form.Row(1).
+			AddInput(1, "Notes", "Notes")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&transfer)
+			go func() {
+				newID := 0
+				err := rpcClient.Call("PartRPC.Transfer", shared.StockTransferRPCData{
+					Channel:       Session.Channel,
+					StockTransfer: &transfer,
+				}, &newID)
+				if err != nil {
+					print("RPC error", err.Error())
+					dom.GetWindow().Alert(err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &transfer)
+	}()
+}
+
+func transferEdit(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	Session.ID["transfer"] = id
+
+	Session.Subscribe("transfer", _transferEdit)
+	go _transferEdit("edit", id)
+}
+
+func _transferEdit(action string, id int) {
+	if id != Session.ID["transfer"] {
+		return
+	}
+
+	transfer := shared.StockTransfer{}
+	rpcClient.Call("PartRPC.GetTransfer", shared.StockTransferRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+	}, &transfer)
+
+	BackURL := fmt.Sprintf("/part/%d/transfers", transfer.PartID)
+	form := formulate.EditForm{}
+	form.New("fa-truck", fmt.Sprintf("Stock Transfer %06d - %s", transfer.ID, transfer.Status))
+
+	// Layout the fields
+	form.Row(3).
+		AddDisplay(1, "Stock Code", "StockCode").
+		AddDisplay(2, "Part", "PartName")
+
+	form.Row(3).
+		AddDisplay(1, "From", "GetFrom").
+		AddDisplay(1, "To", "GetTo").
+		AddDisplay(1, "Qty", "GetQty")
+
+	form.Row(3).
+		AddDisplay(1, "Sent", "GetSent").
+		AddDisplay(1, "By", "GetSentBy").
+		AddDisplay(1, "Received", "GetReceived")
+
+	form.Row(1).
+		AddDisplay(1, "Notes", "Notes")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(BackURL)
+	})
+
+	// All done, so render the form
+	form.Render("edit-form", "main", &transfer)
+
+	// And attach actions
+	form.ActionGrid("transfer-actions", "#action-grid", &transfer, func(url string) {
+		call := ""
+		switch url {
+		case "receive":
+			call = "PartRPC.ReceiveTransfer"
+		case "cancel":
+			if !dom.GetWindow().Confirm("Cancel this transfer, and put the stock back ?") {
+				return
+			}
+			call = "PartRPC.CancelTransfer"
+		default:
+			Session.Navigate(url)
+			return
+		}
+		go func() {
+			done := false
+			err := rpcClient.Call(call, shared.StockTransferRPCData{
+				Channel: Session.Channel,
+				ID:      id,
+			}, &done)
+			if err != nil {
+				print("RPC error", err.Error())
+				dom.GetWindow().Alert(err.Error())
+			}
+		}()
+	})
+}
+
+// The cost layers that value the stock of the part on hand
+func partCostLayers(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		part := shared.Part{}
+		layers := []shared.CostLayer{}
+		data := shared.PartRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}
+		rpcClient.Call("PartRPC.Get", data, &part)
+		rpcClient.Call("PartRPC.CostLayers", data, &layers)
+
+		BackURL := fmt.Sprintf("/part/%d", id)
+		form := formulate.ListForm{}
+		form.New("fa-dollar", fmt.Sprintf("Valuation %s - %s - %s", part.DisplayValuation(), part.StockCode, part.Name))
+
+		// Define the layout
+		form.Column("Received", "GetDateFrom")
+		form.Column("Description", "Descr")
+		form.Column("Qty In", "Qty")
+		form.Column("Qty Left", "QtyLeft")
+		form.Column("Unit Cost", "GetCost")
+		form.Column("Value", "GetValue")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.PrintEvent(func(evt dom.Event) {
+			dom.GetWindow().Print()
+		})
+
+		form.Render("part-cost-layers", "main", layers)
+	}()
+}
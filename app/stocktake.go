@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"itrak-cmms/shared"
+
+	"github.com/go-humble/router"
+	"github.com/steveoc64/formulate"
+	"honnef.co/go/js/dom"
+)
+
+// Stock-takes, being count sheets for the parts in a category at a store
+
+func stockTakeList(context *router.Context) {
+	Session.Subscribe("stocktake", _stockTakeList)
+	go _stockTakeList("list", 0)
+}
+
+func _stockTakeList(action string, id int) {
+	takes := []shared.StockTake{}
+	rpcClient.Call("PartRPC.StockTakes", Session.Channel, &takes)
+
+	form := formulate.ListForm{}
+	form.New("fa-check-square-o", "Stock-Takes")
+
+	// Define the layout
+	form.Column("Created", "GetCreated")
+	form.Column("Store", "GetSiteName")
+	form.Column("Category", "GetCategoryName")
+	form.Column("Counted", "GetProgress")
+	form.Column("Status", "Status")
+	form.Column("Variance", "GetVarianceCost")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate("/")
+	})
+
+	form.NewRowEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate("/stocktake/add")
+	})
+
+	form.RowEvent(func(key string) {
+		Session.Navigate("/stocktake/" + key)
+	})
+
+	form.Render("stocktake-list", "main", takes)
+}
+
+// Make a count sheet for a store and category
+func stockTakeAdd(context *router.Context) {
+	go func() {
+		stores := stockStores()
+		cats := []shared.Category{}
+		rpcClient.Call("PartRPC.Categories", Session.Channel, &cats)
+		cats = append([]shared.Category{{Name: "All Parts"}}, cats...)
+
+		take := shared.StockTake{}
+
+		BackURL := "/stocktake"
+		form := formulate.EditForm{}
+		form.New("fa-check-square-o", "New Stock-Take")
+
+		// Layout the fields
+		form.Row(2).
+			AddSelect(1, "Store", "SiteID", stores, "ID", "Name", 1, 0).
+			AddSelect(1, "Category", "CategoryID", cats, "ID", "Name", 1, 0)
+
+		form.Row(1).
+			AddInput(1, "Notes", "Notes")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&take)
+			go func() {
+				newID := 0
+				err := rpcClient.Call("PartRPC.NewStockTake", shared.StockTakeRPCData{
+					Channel:   Session.Channel,
+					StockTake: &take,
+				}, &newID)
+				if err != nil {
+					print("RPC error", err.Error())
+					dom.GetWindow().Alert(err.Error())
+					return
+				}
+				Session.Navigate(fmt.Sprintf("/stocktake/%d/sheet", newID))
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &take)
+	}()
+}
+
+func stockTakeEdit(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	Session.ID["stocktake"] = id
+
+	Session.Subscribe("stocktake", _stockTakeEdit)
+	go _stockTakeEdit("edit", id)
+}
+
+func _stockTakeEdit(action string, id int) {
+	if id != Session.ID["stocktake"] {
+		return
+	}
+
+	take := shared.StockTake{}
+	rpcClient.Call("PartRPC.GetStockTake", shared.StockTakeRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+	}, &take)
+
+	BackURL := "/stocktake"
+	form := formulate.EditForm{}
+	form.New("fa-check-square-o", fmt.Sprintf("Stock-Take %06d - %s", take.ID, take.Status))
+
+	// Layout the fields
+	form.Row(3).
+		AddDisplay(1, "Store", "GetSiteName").
+		AddDisplay(1, "Category", "GetCategoryName").
+		AddDisplay(1, "Counted", "GetProgress")
+
+	form.Row(3).
+		AddDisplay(1, "Created", "GetCreated").
+		AddDisplay(1, "By", "GetCreatedBy").
+		AddDisplay(1, "Posted", "GetPosted")
+
+	form.Row(3).
+		AddDisplay(2, "Notes", "Notes").
+		AddDisplay(1, "Variance", "GetVarianceCost")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(BackURL)
+	})
+
+	// All done, so render the form
+	form.Render("edit-form", "main", &take)
+
+	// And attach actions
+	form.ActionGrid("stocktake-actions", "#action-grid", &take, func(url string) {
+		call := ""
+		switch url {
+		case "post":
+			if !dom.GetWindow().Confirm("Post the variances to the stock at " + take.GetSiteName() + " ?") {
+				return
+			}
+			call = "PartRPC.PostStockTake"
+		case "cancel":
+			if !dom.GetWindow().Confirm("Cancel this stock-take ?") {
+				return
+			}
+			call = "PartRPC.CancelStockTake"
+		default:
+			Session.Navigate(url)
+			return
+		}
+		go func() {
+			done := false
+			err := rpcClient.Call(call, shared.StockTakeRPCData{
+				Channel: Session.Channel,
+				ID:      id,
+			}, &done)
+			if err != nil {
+				print("RPC error", err.Error())
+				dom.GetWindow().Alert(err.Error())
+			}
+		}()
+	})
+}
+
+func stockTakeSheet(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	Session.ID["stocktake"] = id
+
+	Session.Subscribe("stocktake", _stockTakeSheet)
+	go _stockTakeSheet("list", id)
+}
+
+func _stockTakeSheet(action string, id int) {
+	if id != Session.ID["stocktake"] {
+		return
+	}
+
+	take := shared.StockTake{}
+	lines := []shared.StockTakeLine{}
+	data := shared.StockTakeRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+	}
+	rpcClient.Call("PartRPC.GetStockTake", data, &take)
+	rpcClient.Call("PartRPC.StockTakeLines", data, &lines)
+
+	form := formulate.ListForm{}
+	form.New("fa-list", fmt.Sprintf("Count Sheet %06d - %s - %s - %s",
+		take.ID, take.GetSiteName(), take.GetCategoryName(), take.Status))
+
+	// Define the layout
+	form.Column("Stock Code", "StockCode")
+	form.Column("Part", "PartName")
+	form.Column("Expected", "GetExpected")
+	form.Column("Counted", "GetCounted")
+	form.Column("Variance", "GetVariance")
+	form.Column("Value", "GetVarianceCost")
+	form.Column("Notes", "Notes")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(fmt.Sprintf("/stocktake/%d", id))
+	})
+
+	form.PrintEvent(func(evt dom.Event) {
+		dom.GetWindow().Print()
+	})
+
+	if take.IsOpen() {
+		form.RowEvent(func(key string) {
+			Session.Navigate("/stocktake/line/" + key)
+		})
+	}
+
+	form.Render("stocktake-sheet", "main", lines)
+}
+
+// Enter the qty counted for a part on the count sheet
+func stockTakeCount(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		line := shared.StockTakeLine{}
+		rpcClient.Call("PartRPC.GetStockTakeLine", shared.StockTakeLineRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}, &line)
+		if !line.IsCounted {
+			line.Counted = line.Expected
+		}
+
+		BackURL := fmt.Sprintf("/stocktake/%d/sheet", line.StockTakeID)
+		form := formulate.EditForm{}
+		form.New("fa-check-square-o", fmt.Sprintf("Count - %s - %s", line.StockCode, line.PartName))
+
+		// Layout the fields
+		form.Row(2).
+			AddDisplay(1, "Expected", "GetExpected").
+			AddDecimal(1, "Qty Counted", "Counted", 2, "1")
+
+		form.Row(1).
+			AddInput(1, "Notes", "Notes")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&line)
+			go func() {
+				done := false
+				err := rpcClient.Call("PartRPC.CountStockTake", shared.StockTakeLineRPCData{
+					Channel:       Session.Channel,
+					ID:            id,
+					StockTakeLine: &line,
+				}, &done)
+				if err != nil {
+					print("RPC error", err.Error())
+					dom.GetWindow().Alert(err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &line)
+	}()
+}
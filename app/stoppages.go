@@ -129,20 +129,20 @@ func _stoppageList(action string, id int) {
 
 }
 
+// Get the name of the site group from its ID
 func getSiteName(theSite string) string {
-	switch theSite {
-	case "edinburgh":
-		return "Edinburgh Sites - SA"
-	case "minto":
-		return "Minto Site - NSW"
-	case "tomago":
-		return "Tomago Site - NSW"
-	case "chinderah":
-		return "Chinderah - NSW / QLD"
-	case "usa":
-		return "Connecticut - USA"
+	site := shared.Site{}
+	id, err := strconv.Atoi(theSite)
+	if err == nil {
+		rpcClient.Call("SiteRPC.Get", shared.SiteRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}, &site)
 	}
-	return theSite
+	if site.Name == "" {
+		return theSite
+	}
+	return site.Name
 }
 
 func stops(context *router.Context) {
@@ -150,6 +150,7 @@ func stops(context *router.Context) {
 	go func() {
 
 		theSite := context.Params["site"]
+		siteName := getSiteName(theSite)
 
 		events := []shared.Event{}
 		rpcClient.Call("EventRPC.ListSite", shared.EventRPCData{
@@ -160,7 +161,7 @@ func stops(context *router.Context) {
 		// print("events =", events)
 
 		form := formulate.ListForm{}
-		form.New("fa-pause-circle-o", fmt.Sprintf("Current Stoppages - %s", getSiteName(theSite)))
+		form.New("fa-pause-circle-o", fmt.Sprintf("Current Stoppages - %s", siteName))
 
 		// Define the layout
 		form.Column("ID/User", "GetUserNameID")
@@ -214,7 +215,7 @@ func stops(context *router.Context) {
 		print("MLA returns", machines)
 
 		mform := formulate.ListForm{}
-		mform.New("fa-cogs", "Machine List for - "+getSiteName(theSite))
+		mform.New("fa-cogs", "Machine List for - "+siteName)
 
 		// Define the layout
 		mform.Column("Name", "Name")
@@ -254,7 +255,7 @@ func stoppageEdit(context *router.Context) {
 	}
 	Session.ID["event"] = id
 
-	Session.Subscribe("event", _stoppageEdit)
+	Session.Subscribe(fmt.Sprintf("event:%d", id), _stoppageEdit)
 	go _stoppageEdit("edit", id)
 }
 
@@ -457,6 +458,10 @@ func _stoppageEdit(action string, id int) {
 	switch Session.UserRole {
 	case "Admin":
 		form.ActionGrid("event-actions", "#action-grid", event, func(url string) {
+			if strings.HasPrefix(url, "/stoppage/status/") {
+				go setEventStatus(event.ID, statusFromURL(url), RefreshURL)
+				return
+			}
 			Session.Navigate(url)
 		})
 	case "Site Manager", "Technician":
@@ -468,6 +473,10 @@ func _stoppageEdit(action string, id int) {
 		}, &u)
 		if u.CanAllocate {
 			form.ActionGrid("event-actions", "#action-grid", event, func(url string) {
+				if strings.HasPrefix(url, "/stoppage/status/") {
+					go setEventStatus(event.ID, statusFromURL(url), RefreshURL)
+					return
+				}
 				Session.Navigate(url)
 			})
 		} else {
@@ -617,15 +626,20 @@ func stoppageNewTask(context *router.Context) {
 	go func() {
 		event := shared.Event{}
 		techs := []shared.User{}
+		skills := []shared.Skill{}
 
 		rpcClient.Call("EventRPC.Get", shared.EventRPCData{
 			Channel: Session.Channel,
 			ID:      id,
 		}, &event)
-		rpcClient.Call("UserRPC.GetTechnicians", shared.SiteRPCData{
+
+		// Offer the technicians best suited to the job first
+		rpcClient.Call("UserRPC.GetTechnicians", shared.TechnicianQuery{
 			Channel: Session.Channel,
-			ID:      0,
+			EventID: id,
 		}, &techs)
+		rpcClient.Call("SkillRPC.List", Session.Channel, &skills)
+		skills = append([]shared.Skill{{Name: "Any"}}, skills...)
 
 		now1 := time.Now()
 		now2 := time.Now()
@@ -663,8 +677,9 @@ func stoppageNewTask(context *router.Context) {
 			AddDisplay(1, "StartDate", "DisplayDate").
 			AddDisplay(1, "Raised By", "Username")
 
-		form.Row(3).
-			AddSelect(1, "Assign To", "AssignTo", techs, "ID", "Username", 1, 0).
+		form.Row(4).
+			AddSelect(1, "Skill Needed", "SkillID", skills, "ID", "Name", 1, 0).
+			AddSelect(1, "Assign To", "AssignTo", techs, "ID", "Ranking", 1, 0).
 			AddDate(1, "Workorder Start Date", "StartDate").
 			AddDate(1, "Workorder Due Date", "DueDate")
 
@@ -709,6 +724,34 @@ func stoppageNewTask(context *router.Context) {
 		form.Render("edit-form", "main", &assign)
 		setMarkupButtons("Notes")
 		setPhotoField("Photo")
+
+		// Re-rank the technicians whenever the skill needed changes
+		doc := dom.GetWindow().Document()
+		if el := doc.QuerySelector("[name=SkillID]"); el != nil {
+			el.AddEventListener("change", false, func(evt dom.Event) {
+				skillID, _ := strconv.Atoi(el.(*dom.HTMLSelectElement).Value)
+				go func() {
+					ranked := []shared.User{}
+					rpcClient.Call("UserRPC.GetTechnicians", shared.TechnicianQuery{
+						Channel: Session.Channel,
+						EventID: id,
+						SkillID: skillID,
+					}, &ranked)
+
+					sel := doc.QuerySelector("[name=AssignTo]")
+					if sel == nil {
+						return
+					}
+					sel.SetInnerHTML("")
+					for _, t := range ranked {
+						opt := doc.CreateElement("option")
+						opt.SetAttribute("value", strconv.Itoa(t.ID))
+						opt.SetTextContent(t.Ranking)
+						sel.AppendChild(opt)
+					}
+				}()
+			})
+		}
 	}()
 
 }
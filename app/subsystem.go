@@ -0,0 +1,182 @@
+package main
+
+import (
+	"strconv"
+
+	"itrak-cmms/shared"
+
+	"github.com/go-humble/router"
+	"github.com/steveoc64/formulate"
+	"honnef.co/go/js/dom"
+)
+
+// Subsystems are the non-tool parts of a machine, such as Electrical or Hydraulic.
+// Each machine type picks which subsystems it has from this list.
+
+func subsystemList(context *router.Context) {
+	Session.Subscribe("subsystem", _subsystemList)
+	go _subsystemList("list", 0)
+}
+
+func _subsystemList(action string, id int) {
+	subsystems := []shared.Subsystem{}
+	rpcClient.Call("MachineRPC.Subsystems", Session.Channel, &subsystems)
+
+	form := formulate.ListForm{}
+	form.New("fa-plug", "Machine Subsystems")
+
+	// Define the layout
+	form.Column("Name", "Name")
+	form.Column("Label", "Label")
+	form.Column("Icon", "Icon")
+	form.Column("Position", "Position")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate("/machinetypes")
+	})
+
+	form.NewRowEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate("/subsystem/add")
+	})
+
+	form.RowEvent(func(key string) {
+		Session.Navigate("/subsystem/" + key)
+	})
+
+	form.Render("subsystem-list", "main", subsystems)
+}
+
+func subsystemAdd(context *router.Context) {
+	go func() {
+		subsystem := shared.Subsystem{}
+
+		BackURL := "/subsystems"
+		title := "Add New Subsystem"
+		form := formulate.EditForm{}
+		form.New("fa-plug", title)
+
+		// Layout the fields
+		form.Row(2).
+			AddInput(1, "Name (as recorded against stoppages)", "Name").
+			AddInput(1, "Label", "Label")
+
+		form.Row(2).
+			AddInput(1, "Icon (file in /img)", "Icon").
+			AddNumber(1, "Position", "Position", "1")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&subsystem)
+			go func() {
+				newID := 0
+				rpcClient.Call("MachineRPC.InsertSubsystem", shared.SubsystemRPCData{
+					Channel:   Session.Channel,
+					Subsystem: &subsystem,
+				}, &newID)
+				print("added subsystem", newID)
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &subsystem)
+
+	}()
+
+}
+
+func subsystemEdit(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	Session.ID["subsystem"] = id
+
+	Session.Subscribe("subsystem", _subsystemEdit)
+	go _subsystemEdit("edit", id)
+}
+
+func _subsystemEdit(action string, id int) {
+
+	BackURL := "/subsystems"
+
+	switch action {
+	case "edit":
+		print("manually edit")
+	case "delete":
+		if id != Session.ID["subsystem"] {
+			return
+		}
+		print("current record has been deleted")
+		Session.Navigate(BackURL)
+		return
+	default:
+		if id != Session.ID["subsystem"] {
+			return
+		}
+	}
+	subsystem := shared.Subsystem{}
+	rpcClient.Call("MachineRPC.GetSubsystem", shared.SubsystemRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+	}, &subsystem)
+
+	title := "Edit Subsystem - " + subsystem.Name
+
+	form := formulate.EditForm{}
+	form.New("fa-plug", title)
+
+	// Layout the fields
+	form.Row(2).
+		AddDisplay(1, "Name (as recorded against stoppages)", "Name").
+		AddInput(1, "Label", "Label")
+
+	form.Row(2).
+		AddInput(1, "Icon (file in /img)", "Icon").
+		AddNumber(1, "Position", "Position", "1")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(BackURL)
+	})
+
+	form.DeleteEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		go func() {
+			done := false
+			rpcClient.Call("MachineRPC.DeleteSubsystem", shared.SubsystemRPCData{
+				Channel: Session.Channel,
+				ID:      id,
+			}, &done)
+			Session.Navigate(BackURL)
+		}()
+	})
+
+	form.SaveEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		form.Bind(&subsystem)
+		go func() {
+			done := false
+			rpcClient.Call("MachineRPC.UpdateSubsystem", shared.SubsystemRPCData{
+				Channel:   Session.Channel,
+				ID:        id,
+				Subsystem: &subsystem,
+			}, &done)
+			Session.Navigate(BackURL)
+		}()
+	})
+
+	// All done, so render the form
+	form.Render("edit-form", "main", &subsystem)
+}
@@ -69,7 +69,7 @@ func taskEdit(context *router.Context) {
 	}
 	Session.ID["task"] = id
 
-	Session.Subscribe("task", _taskEdit)
+	Session.Subscribe(fmt.Sprintf("task:%d", id), _taskEdit)
 	go _taskEdit("edit", id)
 }
 
@@ -162,6 +162,8 @@ func _taskEdit(action string, id int) {
 						print(result)
 						js.Global.Call("alert", result)
 					}()
+				} else if strings.HasPrefix(url, "/task/status/") {
+					go setTaskStatus(task.ID, statusFromURL(url), RefreshURL)
 				} else if strings.HasPrefix(url, "/task/complete") {
 					go func() {
 						done := false
@@ -177,6 +179,18 @@ func _taskEdit(action string, id int) {
 			})
 		case "Technician":
 			form.ActionGrid("task-actions", "#action-grid", task, func(url string) {
+				if strings.HasPrefix(url, "/task/status/") {
+					go setTaskStatus(task.ID, statusFromURL(url), RefreshURL)
+					return
+				}
+				if taskClockAction(url, task.ID, RefreshURL) {
+					return
+				}
+				if strings.HasPrefix(url, "/task/history/") || strings.HasPrefix(url, "/task/time/") ||
+					strings.HasPrefix(url, "/task/crew/") {
+					Session.Navigate(url)
+					return
+				}
 				go func() {
 					done := false
 					print("calling task.complete ???")
@@ -1104,13 +1118,14 @@ func _taskList(action string, id int) {
 	}
 	// form.Column("Src", "GetSource")
 	form.MultiImgColumn("Photos", "Photos", "Thumb")
-	form.Column("Date", "GetStartDate")
+	form.ColumnFormat("Date", "GetStartDate", "GetDateClass")
 	// form.Column("Due", "GetDueDate")
 	form.ColumnFormat("Site", "SiteName", "GetSiteClass")
 	// form.Column("Machine", "MachineName")
 	// form.Column("Component", "Component")
 	form.Column("Component", "GetComponent")
 	form.Column("Description", "Descr")
+	form.Column("Status", "Status")
 	form.Column("Duration", "DurationDays")
 
 	switch Session.UserRole {
@@ -1219,13 +1234,14 @@ func stoppageTaskList(context *router.Context) {
 		form.Column("User", "Username")
 		form.Column("TaskID", "ID")
 		form.MultiImgColumn("Photos", "Photos", "Thumb")
-		form.Column("Date", "GetStartDate")
+		form.ColumnFormat("Date", "GetStartDate", "GetDateClass")
 		// form.Column("Due", "GetDueDate")
 		form.Column("Site", "SiteName")
 		// form.Column("Machine", "MachineName")
 		// form.Column("Component", "Component")
 		form.Column("Component", "GetComponent")
 		form.Column("Description", "Descr")
+		form.Column("Status", "Status")
 		form.Column("Duration", "DurationDays")
 		form.Column("Completed", "CompletedDate")
 
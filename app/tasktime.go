@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"itrak-cmms/shared"
+
+	"github.com/go-humble/router"
+	"github.com/steveoc64/formulate"
+	"honnef.co/go/js/dom"
+)
+
+// Technicians acknowledging tasks, and clocking on and off them.
+
+// Call the TaskRPC method for a clock action url, such as /task/start/123,
+// returning false if the url is not a clock action
+func taskClockAction(actionURL string, id int, nextURL string) bool {
+	method := ""
+	hold := false
+	switch {
+	case strings.HasPrefix(actionURL, "/task/acknowledge/"):
+		method = "TaskRPC.Acknowledge"
+	case strings.HasPrefix(actionURL, "/task/start/"):
+		method = "TaskRPC.Start"
+	case strings.HasPrefix(actionURL, "/task/resume/"):
+		method = "TaskRPC.Resume"
+	case strings.HasPrefix(actionURL, "/task/pause/"):
+		method = "TaskRPC.Pause"
+	case strings.HasPrefix(actionURL, "/task/hold/"):
+		method = "TaskRPC.Pause"
+		hold = true
+	case strings.HasPrefix(actionURL, "/task/signoff/"):
+		method = "TaskRPC.SignOff"
+	default:
+		return false
+	}
+
+	go func() {
+		done := false
+		err := rpcClient.Call(method, shared.TaskTimeRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+			Hold:    hold,
+		}, &done)
+		if err != nil {
+			print("RPC error", err.Error())
+			dom.GetWindow().Alert(err.Error())
+			return
+		}
+		Session.Navigate(nextURL)
+	}()
+	return true
+}
+
+func taskTime(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		entries := []shared.TaskTime{}
+		rpcClient.Call("TaskRPC.TimeEntries", shared.TaskRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}, &entries)
+
+		BackURL := fmt.Sprintf("/task/%d", id)
+
+		form := formulate.ListForm{}
+		form.New("fa-clock-o", fmt.Sprintf("Time Sheet - Task %06d", id))
+
+		// Define the layout
+		form.Column("Technician", "GetUsername")
+		form.Column("On", "GetStarted")
+		form.Column("Off", "GetStopped")
+		form.Column("Hours", "GetHours")
+		form.Column("Notes", "Notes")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.PrintEvent(func(evt dom.Event) {
+			dom.GetWindow().Print()
+		})
+
+		form.Render("task-time-list", "main", entries)
+	}()
+}
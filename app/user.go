@@ -74,6 +74,7 @@ func userProfile() {
 				if err != nil {
 					print("sms", err.Error())
 				}
+				p0, _ := f.GetString("p0")
 				p1, _ := f.GetString("p1")
 				p2, _ := f.GetString("p2")
 
@@ -81,21 +82,23 @@ func userProfile() {
 				if p1 != p2 {
 					w.Alert("Passwords do not match")
 				} else {
-					if p1 != "" {
-						data.Passwd = p1
-					}
 					d := false
 					req := shared.UserUpdate{
-						Channel: Session.Channel,
-						ID:      data.ID,
-						Name:    data.Name,
-						Passwd:  data.Passwd,
-						Email:   data.Email,
-						SMS:     data.SMS,
+						Channel:   Session.Channel,
+						ID:        data.ID,
+						Name:      data.Name,
+						Passwd:    p1,
+						OldPasswd: p0,
+						Email:     data.Email,
+						SMS:       data.SMS,
 					}
 					// print("passing update req", req)
 					go func() {
-						rpcClient.Call("UserRPC.Set", &req, &d)
+						err := rpcClient.Call("UserRPC.Set", &req, &d)
+						if err != nil {
+							w.Alert(err.Error())
+							return
+						}
 						el.Class().Remove("md-show")
 					}()
 				}
@@ -287,7 +290,7 @@ func userEdit(context *router.Context) {
 
 		form.Row(2).
 			Add(1, "Username", "text", "Username", `id="focusme"`).
-			Add(1, "Password", "text", "Passwd", "")
+			Add(1, "New Password", "text", "Passwd", "")
 
 		form.Row(11).
 			Add(3, "Name", "text", "Name", "").
@@ -378,6 +381,10 @@ func userEdit(context *router.Context) {
 		loadTemplate("user-sites-array", "[name=Sites]", userSites)
 		loadTemplate("user-highlight-array", "[name=Highlights]", userSites)
 
+		form.ActionGrid("user-actions", "#action-grid", user.ID, func(url string) {
+			Session.Navigate(url)
+		})
+
 		// add a click handler for the sites array
 		w := dom.GetWindow()
 		doc := w.Document()
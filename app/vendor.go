@@ -1,9 +1,914 @@
 package main
 
 import (
+	"fmt"
+	"strconv"
+
+	"itrak-cmms/shared"
+
 	"github.com/go-humble/router"
+	"github.com/steveoc64/formulate"
+	"honnef.co/go/js/dom"
 )
 
+// Vendors, their prices for parts, and purchase orders
+
 func vendorList(context *router.Context) {
-	print("TODO - vendorList")
+	Session.Subscribe("vendor", _vendorList)
+	go _vendorList("list", 0)
+}
+
+func _vendorList(action string, id int) {
+	vendors := []shared.Vendor{}
+	rpcClient.Call("VendorRPC.List", Session.Channel, &vendors)
+
+	form := formulate.ListForm{}
+	form.New("fa-truck", "Vendors")
+
+	// Define the layout
+	form.Column("Name", "Name")
+	form.Column("Contact", "ContactName")
+	form.Column("Phone", "Phone")
+	form.Column("Orders Email", "OrdersEmail")
+	form.Column("Rating", "Rating")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate("/")
+	})
+
+	form.NewRowEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate("/vendor/add")
+	})
+
+	form.RowEvent(func(key string) {
+		Session.Navigate("/vendor/" + key)
+	})
+
+	form.Render("vendor-list", "main", vendors)
+}
+
+func vendorFields(form *formulate.EditForm) {
+	form.Row(2).
+		AddInput(1, "Name", "Name").
+		AddInput(1, "Description", "Descr")
+
+	form.Row(1).
+		AddTextarea(1, "Address", "Address")
+
+	form.Row(2).
+		AddInput(1, "Phone", "Phone").
+		AddInput(1, "Fax", "Fax")
+
+	form.Row(3).
+		AddInput(1, "Contact Name", "ContactName").
+		AddInput(1, "Contact Email", "ContactEmail").
+		AddInput(1, "Orders Email", "OrdersEmail")
+
+	form.Row(1).
+		AddInput(1, "Rating", "Rating")
+
+	form.Row(1).
+		AddTextarea(1, "Notes", "Notes")
+}
+
+func vendorAdd(context *router.Context) {
+	go func() {
+		vendor := shared.Vendor{}
+
+		BackURL := "/vendors"
+		form := formulate.EditForm{}
+		form.New("fa-truck", "Add New Vendor")
+
+		// Layout the fields
+		vendorFields(&form)
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&vendor)
+			go func() {
+				newID := 0
+				err := rpcClient.Call("VendorRPC.Insert", shared.VendorRPCData{
+					Channel: Session.Channel,
+					Vendor:  &vendor,
+				}, &newID)
+				if err != nil {
+					print("RPC error", err.Error())
+					dom.GetWindow().Alert(err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &vendor)
+	}()
+}
+
+func vendorEdit(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	Session.ID["vendor"] = id
+
+	Session.Subscribe("vendor", _vendorEdit)
+	go _vendorEdit("edit", id)
+}
+
+func _vendorEdit(action string, id int) {
+
+	BackURL := "/vendors"
+
+	switch action {
+	case "edit":
+		print("manually edit")
+	case "delete":
+		if id != Session.ID["vendor"] {
+			return
+		}
+		print("current record has been deleted")
+		Session.Navigate(BackURL)
+		return
+	default:
+		if id != Session.ID["vendor"] {
+			return
+		}
+	}
+	vendor := shared.Vendor{}
+	rpcClient.Call("VendorRPC.Get", shared.VendorRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+	}, &vendor)
+
+	form := formulate.EditForm{}
+	form.New("fa-truck", "Vendor - "+vendor.Name)
+
+	// Layout the fields
+	vendorFields(&form)
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(BackURL)
+	})
+
+	if Session.UserRole == "Admin" {
+		form.DeleteEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			go func() {
+				done := false
+				err := rpcClient.Call("VendorRPC.Delete", shared.VendorRPCData{
+					Channel: Session.Channel,
+					ID:      id,
+				}, &done)
+				if err != nil {
+					print("RPC error", err.Error())
+					dom.GetWindow().Alert(err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&vendor)
+			go func() {
+				done := false
+				err := rpcClient.Call("VendorRPC.Update", shared.VendorRPCData{
+					Channel: Session.Channel,
+					ID:      id,
+					Vendor:  &vendor,
+				}, &done)
+				if err != nil {
+					print("RPC error", err.Error())
+					dom.GetWindow().Alert(err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+	}
+
+	// All done, so render the form
+	form.Render("edit-form", "main", &vendor)
+
+	// And attach actions
+	form.ActionGrid("vendor-actions", "#action-grid", vendor.ID, func(url string) {
+		Session.Navigate(url)
+	})
+}
+
+func vendorPrices(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	Session.ID["vendor"] = id
+
+	Session.Subscribe("vendorprice", _vendorPrices)
+	go _vendorPrices("list", id)
+}
+
+func _vendorPrices(action string, id int) {
+	if id != Session.ID["vendor"] {
+		return
+	}
+
+	vendor := shared.Vendor{}
+	rpcClient.Call("VendorRPC.Get", shared.VendorRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+	}, &vendor)
+
+	prices := []shared.VendorPrice{}
+	rpcClient.Call("VendorRPC.Prices", shared.VendorRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+	}, &prices)
+
+	form := formulate.ListForm{}
+	form.New("fa-dollar", "Prices - "+vendor.Name)
+
+	// Define the layout
+	form.Column("Stock Code", "StockCode")
+	form.Column("Part", "PartName")
+	form.Column("Vendor Code", "VendorCode")
+	form.Column("Price", "GetPrice")
+	form.Column("Min Qty", "MinQty")
+	form.Column("Since", "GetDateFrom")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(fmt.Sprintf("/vendor/%d", id))
+	})
+
+	if Session.UserRole == "Admin" {
+		form.NewRowEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(fmt.Sprintf("/vendor/%d/price/add", id))
+		})
+
+		form.RowEvent(func(key string) {
+			Session.Navigate(fmt.Sprintf("/vendor/%d/price/%s", id, key))
+		})
+	}
+
+	form.Render("vendor-price-list", "main", prices)
+}
+
+func vendorPriceAdd(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		price := shared.VendorPrice{VendorID: id, MinQty: 1}
+
+		BackURL := fmt.Sprintf("/vendor/%d/prices", id)
+		form := formulate.EditForm{}
+		form.New("fa-dollar", "Add Vendor Price")
+
+		// Layout the fields
+		form.Row(2).
+			AddInput(1, "Stock Code", "StockCode").
+			AddInput(1, "Vendor Code", "VendorCode")
+
+		form.Row(2).
+			AddDecimal(1, "Price", "LatestPrice", 2, "0.01").
+			AddDecimal(1, "Min Qty", "MinQty", 2, "1")
+
+		form.Row(1).
+			AddTextarea(1, "Notes", "Notes")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&price)
+			go func() {
+				done := false
+				err := rpcClient.Call("VendorRPC.SetPrice", shared.VendorPriceRPCData{
+					Channel:     Session.Channel,
+					ID:          id,
+					VendorPrice: &price,
+				}, &done)
+				if err != nil {
+					print("RPC error", err.Error())
+					dom.GetWindow().Alert(err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &price)
+	}()
+}
+
+func vendorPriceEdit(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	partID, err := strconv.Atoi(context.Params["part"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		price := shared.VendorPrice{}
+		rpcClient.Call("VendorRPC.GetPrice", shared.VendorPriceRPCData{
+			Channel:     Session.Channel,
+			ID:          id,
+			VendorPrice: &shared.VendorPrice{PartID: partID},
+		}, &price)
+
+		BackURL := fmt.Sprintf("/vendor/%d/prices", id)
+		form := formulate.EditForm{}
+		form.New("fa-dollar", fmt.Sprintf("Vendor Price - %s %s", price.StockCode, price.PartName))
+
+		// Layout the fields
+		form.Row(2).
+			AddDisplay(1, "Stock Code", "StockCode").
+			AddInput(1, "Vendor Code", "VendorCode")
+
+		form.Row(2).
+			AddDecimal(1, "Price", "LatestPrice", 2, "0.01").
+			AddDecimal(1, "Min Qty", "MinQty", 2, "1")
+
+		form.Row(1).
+			AddTextarea(1, "Notes", "Notes")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.DeleteEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			go func() {
+				done := false
+				rpcClient.Call("VendorRPC.DeletePrice", shared.VendorPriceRPCData{
+					Channel:     Session.Channel,
+					ID:          id,
+					VendorPrice: &price,
+				}, &done)
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&price)
+			price.PartID = partID
+			go func() {
+				done := false
+				err := rpcClient.Call("VendorRPC.SetPrice", shared.VendorPriceRPCData{
+					Channel:     Session.Channel,
+					ID:          id,
+					VendorPrice: &price,
+				}, &done)
+				if err != nil {
+					print("RPC error", err.Error())
+					dom.GetWindow().Alert(err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &price)
+	}()
+}
+
+// Purchase orders, for all vendors or just the one
+
+func purchaseOrderList(context *router.Context) {
+	Session.ID["vendor"] = 0
+	Session.Subscribe("purchaseorder", _purchaseOrderList)
+	go _purchaseOrderList("list", 0)
+}
+
+func vendorOrders(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	Session.ID["vendor"] = id
+	Session.Subscribe("purchaseorder", _purchaseOrderList)
+	go _purchaseOrderList("list", id)
+}
+
+func _purchaseOrderList(action string, id int) {
+	vendorID := Session.ID["vendor"]
+
+	orders := []shared.PurchaseOrder{}
+	rpcClient.Call("VendorRPC.Orders", shared.VendorRPCData{
+		Channel: Session.Channel,
+		ID:      vendorID,
+	}, &orders)
+
+	BackURL := "/"
+	title := "Purchase Orders"
+	if vendorID != 0 {
+		BackURL = fmt.Sprintf("/vendor/%d", vendorID)
+		if len(orders) > 0 {
+			title += " - " + orders[0].VendorName
+		}
+	}
+
+	form := formulate.ListForm{}
+	form.New("fa-file-text-o", title)
+
+	// Define the layout
+	form.Column("PO", "GetID")
+	form.Column("Vendor", "VendorName")
+	form.Column("Deliver To", "GetSiteName")
+	form.Column("Ref", "Ref")
+	form.Column("Status", "Status")
+	form.Column("Raised", "GetCreated")
+	form.Column("By", "GetUsername")
+	form.Column("Total", "GetTotal")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(BackURL)
+	})
+
+	form.NewRowEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate("/purchaseorder/add")
+	})
+
+	form.RowEvent(func(key string) {
+		Session.Navigate("/purchaseorder/" + key)
+	})
+
+	form.Render("purchase-order-list", "main", orders)
+}
+
+func purchaseOrderAdd(context *router.Context) {
+	go func() {
+		vendors := []shared.Vendor{}
+		rpcClient.Call("VendorRPC.List", Session.Channel, &vendors)
+		stores := []shared.Site{}
+		rpcClient.Call("PartRPC.Stores", Session.Channel, &stores)
+
+		po := shared.PurchaseOrder{VendorID: Session.ID["vendor"]}
+
+		BackURL := "/purchaseorders"
+		form := formulate.EditForm{}
+		form.New("fa-file-text-o", "New Purchase Order")
+
+		// Layout the fields
+		form.Row(3).
+			AddSelect(1, "Vendor", "VendorID", vendors, "ID", "Name", 1, po.VendorID).
+			AddSelect(1, "Deliver To", "SiteID", stores, "ID", "Name", 0, 0).
+			AddInput(1, "Ref", "Ref")
+
+		form.Row(1).
+			AddTextarea(1, "Notes", "Notes")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&po)
+			go func() {
+				newID := 0
+				err := rpcClient.Call("VendorRPC.InsertOrder", shared.PurchaseOrderRPCData{
+					Channel:       Session.Channel,
+					PurchaseOrder: &po,
+				}, &newID)
+				if err != nil {
+					print("RPC error", err.Error())
+					dom.GetWindow().Alert(err.Error())
+					return
+				}
+				Session.Navigate(fmt.Sprintf("/purchaseorder/%d", newID))
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &po)
+	}()
+}
+
+func purchaseOrderEdit(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	Session.ID["purchaseorder"] = id
+
+	Session.Subscribe("purchaseorder", _purchaseOrderEdit)
+	go _purchaseOrderEdit("edit", id)
+}
+
+func _purchaseOrderEdit(action string, id int) {
+
+	BackURL := "/purchaseorders"
+
+	switch action {
+	case "edit":
+		print("manually edit")
+	case "delete":
+		if id != Session.ID["purchaseorder"] {
+			return
+		}
+		Session.Navigate(BackURL)
+		return
+	default:
+		if id != Session.ID["purchaseorder"] {
+			return
+		}
+	}
+
+	po := shared.PurchaseOrder{}
+	rpcClient.Call("VendorRPC.GetOrder", shared.PurchaseOrderRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+	}, &po)
+
+	form := formulate.EditForm{}
+	form.New("fa-file-text-o", fmt.Sprintf("Purchase Order %06d - %s", po.ID, po.Status))
+
+	// Layout the fields
+	row := form.Row(4).
+		AddDisplay(1, "Vendor", "VendorName")
+	if po.IsDraft() {
+		stores := []shared.Site{}
+		rpcClient.Call("PartRPC.Stores", Session.Channel, &stores)
+		row.AddSelect(1, "Deliver To", "SiteID", stores, "ID", "Name", 0, po.SiteID)
+	} else {
+		row.AddDisplay(1, "Deliver To", "GetSiteName")
+	}
+	row.AddInput(1, "Ref", "Ref").
+		AddDisplay(1, "Total", "GetTotal")
+
+	form.Row(4).
+		AddDisplay(1, "Raised", "GetCreated").
+		AddDisplay(1, "By", "GetUsername").
+		AddDisplay(1, "Sent", "GetSentDate").
+		AddDisplay(1, "Received", "GetReceivedDate")
+
+	form.Row(1).
+		AddTextarea(1, "Notes", "Notes")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(BackURL)
+	})
+
+	if po.IsDraft() {
+		form.DeleteEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			go func() {
+				done := false
+				err := rpcClient.Call("VendorRPC.DeleteOrder", shared.PurchaseOrderRPCData{
+					Channel: Session.Channel,
+					ID:      id,
+				}, &done)
+				if err != nil {
+					print("RPC error", err.Error())
+					dom.GetWindow().Alert(err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+	}
+
+	form.SaveEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		form.Bind(&po)
+		go func() {
+			done := false
+			rpcClient.Call("VendorRPC.UpdateOrder", shared.PurchaseOrderRPCData{
+				Channel:       Session.Channel,
+				ID:            id,
+				PurchaseOrder: &po,
+			}, &done)
+			Session.Navigate(BackURL)
+		}()
+	})
+
+	// All done, so render the form
+	form.Render("edit-form", "main", &po)
+
+	// And attach actions
+	form.ActionGrid("purchase-order-actions", "#action-grid", &po, func(url string) {
+		call := ""
+		switch url {
+		case "send":
+			call = "VendorRPC.SendOrder"
+		case "cancel":
+			if !dom.GetWindow().Confirm("Cancel this purchase order ?") {
+				return
+			}
+			call = "VendorRPC.CancelOrder"
+		case "receive":
+			go func() {
+				done := false
+				err := rpcClient.Call("VendorRPC.Receive", shared.POReceiveRPCData{
+					Channel: Session.Channel,
+					ID:      id,
+				}, &done)
+				if err != nil {
+					print("RPC error", err.Error())
+					dom.GetWindow().Alert(err.Error())
+				}
+			}()
+			return
+		default:
+			Session.Navigate(url)
+			return
+		}
+		go func() {
+			done := false
+			err := rpcClient.Call(call, shared.PurchaseOrderRPCData{
+				Channel: Session.Channel,
+				ID:      id,
+			}, &done)
+			if err != nil {
+				print("RPC error", err.Error())
+				dom.GetWindow().Alert(err.Error())
+			}
+		}()
+	})
+}
+
+func purchaseOrderLines(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	Session.ID["purchaseorder"] = id
+
+	Session.Subscribe("purchaseorder", _purchaseOrderLines)
+	go _purchaseOrderLines("list", id)
+}
+
+func _purchaseOrderLines(action string, id int) {
+	if id != Session.ID["purchaseorder"] {
+		return
+	}
+
+	po := shared.PurchaseOrder{}
+	rpcClient.Call("VendorRPC.GetOrder", shared.PurchaseOrderRPCData{
+		Channel: Session.Channel,
+		ID:      id,
+	}, &po)
+
+	form := formulate.ListForm{}
+	form.New("fa-file-text-o", fmt.Sprintf("Purchase Order %06d - %s", po.ID, po.VendorName))
+
+	// Define the layout
+	form.Column("Stock Code", "StockCode")
+	form.Column("Part", "PartName")
+	form.Column("Vendor Code", "VendorCode")
+	form.Column("Qty", "GetQty")
+	form.Column("Price", "GetPrice")
+	form.Column("Total", "GetTotal")
+	form.Column("Received", "GetReceived")
+
+	// Add event handlers
+	form.CancelEvent(func(evt dom.Event) {
+		evt.PreventDefault()
+		Session.Navigate(fmt.Sprintf("/purchaseorder/%d", id))
+	})
+
+	if po.IsDraft() {
+		form.NewRowEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(fmt.Sprintf("/purchaseorder/%d/line/add", id))
+		})
+	}
+
+	if po.IsDraft() || po.IsOpen() {
+		form.RowEvent(func(key string) {
+			Session.Navigate(fmt.Sprintf("/purchaseorder/%d/line/%s", id, key))
+		})
+	}
+
+	form.Render("purchase-order-line-list", "main", po.Lines)
+}
+
+func purchaseOrderLineAdd(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		line := shared.PurchaseOrderLine{POID: id, Qty: 1}
+
+		BackURL := fmt.Sprintf("/purchaseorder/%d/lines", id)
+		form := formulate.EditForm{}
+		form.New("fa-file-text-o", fmt.Sprintf("Add to Purchase Order %06d", id))
+
+		// Layout the fields
+		form.Row(2).
+			AddInput(1, "Stock Code", "StockCode").
+			AddInput(1, "Vendor Code (blank for the vendor's usual)", "VendorCode")
+
+		form.Row(2).
+			AddDecimal(1, "Qty", "Qty", 2, "1").
+			AddDecimal(1, "Price (0 for the vendor's price)", "Price", 2, "0.01")
+
+		form.Row(1).
+			AddTextarea(1, "Notes", "Notes")
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&line)
+			go func() {
+				newID := 0
+				err := rpcClient.Call("VendorRPC.AddLine", shared.PurchaseOrderLineRPCData{
+					Channel: Session.Channel,
+					ID:      id,
+					Line:    &line,
+				}, &newID)
+				if err != nil {
+					print("RPC error", err.Error())
+					dom.GetWindow().Alert(err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// All done, so render the form
+		form.Render("edit-form", "main", &line)
+	}()
+}
+
+// A line on a draft order can be changed, and a line on a sent order can be received
+func purchaseOrderLineEdit(context *router.Context) {
+	id, err := strconv.Atoi(context.Params["id"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+	lineID, err := strconv.Atoi(context.Params["line"])
+	if err != nil {
+		print(err.Error())
+		return
+	}
+
+	go func() {
+		po := shared.PurchaseOrder{}
+		rpcClient.Call("VendorRPC.GetOrder", shared.PurchaseOrderRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+		}, &po)
+
+		line := shared.PurchaseOrderLine{}
+		for _, l := range po.Lines {
+			if l.ID == lineID {
+				line = l
+			}
+		}
+
+		BackURL := fmt.Sprintf("/purchaseorder/%d/lines", id)
+		form := formulate.EditForm{}
+		form.New("fa-file-text-o", fmt.Sprintf("PO %06d - %s %s", id, line.StockCode, line.PartName))
+
+		// Add event handlers
+		form.CancelEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			Session.Navigate(BackURL)
+		})
+
+		if po.IsDraft() {
+			// Layout the fields
+			form.Row(1).
+				AddInput(1, "Vendor Code", "VendorCode")
+
+			form.Row(2).
+				AddDecimal(1, "Qty", "Qty", 2, "1").
+				AddDecimal(1, "Price", "Price", 2, "0.01")
+
+			form.Row(1).
+				AddTextarea(1, "Notes", "Notes")
+
+			form.DeleteEvent(func(evt dom.Event) {
+				evt.PreventDefault()
+				go func() {
+					done := false
+					rpcClient.Call("VendorRPC.DeleteLine", shared.PurchaseOrderLineRPCData{
+						Channel: Session.Channel,
+						ID:      id,
+						Line:    &line,
+					}, &done)
+					Session.Navigate(BackURL)
+				}()
+			})
+
+			form.SaveEvent(func(evt dom.Event) {
+				evt.PreventDefault()
+				form.Bind(&line)
+				go func() {
+					done := false
+					err := rpcClient.Call("VendorRPC.UpdateLine", shared.PurchaseOrderLineRPCData{
+						Channel: Session.Channel,
+						ID:      id,
+						Line:    &line,
+					}, &done)
+					if err != nil {
+						print("RPC error", err.Error())
+						dom.GetWindow().Alert(err.Error())
+						return
+					}
+					Session.Navigate(BackURL)
+				}()
+			})
+
+			form.Render("edit-form", "main", &line)
+			return
+		}
+
+		receipt := shared.POReceiveRPCData{
+			Channel: Session.Channel,
+			ID:      id,
+			LineID:  lineID,
+			Qty:     line.GetOutstanding(),
+		}
+
+		// Layout the fields
+		form.Row(3).
+			AddDisplay(1, "Vendor Code", "VendorCode").
+			AddDisplay(1, "Price", "GetPrice").
+			AddDisplay(1, "Received so far", "GetReceived")
+
+		form.Row(2).
+			AddDecimal(1, "Qty Received", "Qty", 2, "1").
+			AddInput(1, "Delivery Notes", "Notes")
+
+		form.SaveEvent(func(evt dom.Event) {
+			evt.PreventDefault()
+			form.Bind(&receipt)
+			go func() {
+				done := false
+				err := rpcClient.Call("VendorRPC.Receive", receipt, &done)
+				if err != nil {
+					print("RPC error", err.Error())
+					dom.GetWindow().Alert(err.Error())
+					return
+				}
+				Session.Navigate(BackURL)
+			}()
+		})
+
+		// Show the line details, with the receipt fields filled in
+		form.Render("edit-form", "main", &line)
+		doc := dom.GetWindow().Document()
+		if el := doc.QuerySelector("[name=Qty]"); el != nil {
+			el.(*dom.HTMLInputElement).Value = fmt.Sprintf("%g", receipt.Qty)
+		}
+		if el := doc.QuerySelector("[name=Notes]"); el != nil {
+			el.(*dom.HTMLInputElement).Value = ""
+		}
+	}()
 }
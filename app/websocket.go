@@ -207,7 +207,7 @@ func (c *myClientCodec) ReadResponseHeader(r *rpc.Response) error {
 func autoReload() {
 
 	print("Connection has expired !!")
-	print("Logging out in ... 3")
+	print("Reconnecting in ... 3")
 
 	go func() {
 		time.Sleep(time.Second)
@@ -216,7 +216,7 @@ func autoReload() {
 		print("........... 1")
 		time.Sleep(time.Second)
 		print(" !! BYE !!")
-		Logout()
+		reloadApp()
 	}()
 }
 
@@ -407,3 +407,618 @@ alter table users add local bool default true;
 alter table sms_trans add local bool default true;
  
 insert into migration (name) values ('Extend tables for non-local SMS carrier');	
+
+
+-- 2016 09 12
+-- Store user passwords as salted bcrypt hashes
+
+create extension if not exists pgcrypto;
+alter table users alter column passwd type text;
+update users set passwd=crypt(passwd, gen_salt('bf', 10)) where passwd not like '$2a$%';
+
+-- Login.Login used to log the password it was given, as username,password,rememberme,channel,
+-- so take the password out of the user_log rows written before
+update user_log
+	set input=regexp_replace(input, '^([^,]*),.*,(true|false),(-?[0-9]+)$', '\1,\2,\3')
+	where func='Login.Login'
+	and input ~ '^[^,]*,.*,(true|false),-?[0-9]+$';
+
+insert into migration (name) values ('Hash user passwords');
+
+
+-- 2016 09 14
+-- Signed session tokens, so that a dropped connection can resume
+
+drop table if exists session_key;
+create table session_key (
+	key text not null
+);
+insert into session_key (key) values (encode(gen_random_bytes(32), 'hex'));
+
+drop table if exists user_session;
+create table user_session (
+	id serial not null primary key,
+	token text not null default '',
+	user_id int not null,
+	remember bool not null default false,
+	created timestamptz not null default localtimestamp,
+	expires timestamptz not null,
+	route text not null default '',
+	routes text not null default ''
+);
+create index user_session_user_idx on user_session (user_id);
+
+insert into migration (name) values ('Add user_session table');
+
+
+-- 2016 09 15
+-- Site groups for the status map, built on site.parent_site
+-- Top level sites (parent_site = 0) are the groups, and x,y place them on the map
+
+alter table site add map_label text not null default '';
+
+update site set parent_site=0 where name like 'Minto%';
+update site set map_label='Edinburgh', x=260, y=250 where name like 'Edinburgh%' and parent_site=0;
+update site set map_label='Chinderah', x=390, y=200 where name like 'Chinderah%' and parent_site=0;
+update site set map_label='Tomago', x=380, y=230 where name like 'Tomago%' and parent_site=0;
+update site set map_label='Minto', x=360, y=260 where name like 'Minto%' and parent_site=0;
+update site set map_label='USA', x=420, y=126 where name like 'Connecticut%' and parent_site=0;
+
+insert into migration (name) values ('Add site groups for the status map');
+
+
+-- 2016 09 16
+-- Machine subsystems as configuration, rather than fixed columns on machine and machine_type
+-- The subsystem name is what gets stored in event.tool_type for a non-tool stoppage
+
+drop table if exists subsystem;
+create table subsystem (
+	id serial not null primary key,
+	name text not null unique,
+	label text not null default '',
+	icon text not null default '',
+	position int not null default 0
+);
+
+insert into subsystem (name,label,icon,position) values
+('Electrical','Electrical','elec.png',1),
+('Hydraulic','Hydraulic','hydraulic.png',2),
+('Pnuematic','Pnuematic','pnue.png',3),
+('Lube','Lube','lube.png',4),
+('Printer','Printer','printer.png',5),
+('Console','Console','console.png',6),
+('Uncoiler','Uncoiler','',7),
+('Rollbed','Roll Bed','',8),
+('Conveyor','Conveyor','',9),
+('Encoder','Encoder','encoder.png',10),
+('StripGuide','Strip Guide','stripguide.png',11);
+
+drop table if exists machine_type_subsystem;
+create table machine_type_subsystem (
+	machine_type_id int not null,
+	subsystem_id int not null,
+	primary key (machine_type_id, subsystem_id)
+);
+
+insert into machine_type_subsystem (machine_type_id,subsystem_id)
+select t.id,s.id
+from machine_type t, subsystem s
+where (s.name='Electrical' and t.electrical)
+or (s.name='Hydraulic' and t.hydraulic)
+or (s.name='Pnuematic' and t.pnuematic)
+or (s.name='Lube' and t.lube)
+or (s.name='Printer' and t.printer)
+or (s.name='Console' and t.console)
+or (s.name='Uncoiler' and t.uncoiler)
+or (s.name='Rollbed' and t.rollbed)
+or (s.name='Conveyor' and t.conveyor)
+or (s.name='Encoder' and t.encoder)
+or (s.name='StripGuide' and t.strip_guide);
+
+drop table if exists machine_subsystem;
+create table machine_subsystem (
+	machine_id int not null,
+	subsystem_id int not null,
+	status text not null default 'Running',
+	primary key (machine_id, subsystem_id)
+);
+
+insert into machine_subsystem (machine_id,subsystem_id,status)
+select m.id,s.id,
+	case s.name
+		when 'Electrical' then m.electrical
+		when 'Hydraulic' then m.hydraulic
+		when 'Pnuematic' then m.pnuematic
+		when 'Lube' then m.lube
+		when 'Printer' then m.printer
+		when 'Console' then m.console
+		when 'Uncoiler' then m.uncoiler
+		when 'Rollbed' then m.rollbed
+		when 'Conveyor' then m.conveyor
+		when 'Encoder' then m.encoder
+		when 'StripGuide' then m.strip_guide
+	end
+from machine m, subsystem s;
+
+alter table machine drop column electrical, drop column hydraulic, drop column pnuematic,
+	drop column lube, drop column printer, drop column console, drop column uncoiler,
+	drop column rollbed, drop column conveyor, drop column encoder, drop column strip_guide;
+alter table machine_type drop column electrical, drop column hydraulic, drop column pnuematic,
+	drop column lube, drop column printer, drop column console, drop column uncoiler,
+	drop column rollbed, drop column conveyor, drop column encoder, drop column strip_guide;
+
+insert into migration (name) values ('Add configurable machine subsystems');
+
+-- 2016 09 19
+-- Job counts per machine, for Job Count scheduled tasks
+-- machine.job_count is the running total, sched_control_task.last_jobcount is the
+-- total at the time each sched task last generated a task
+
+alter table machine add job_count int not null default 0;
+
+drop table if exists machine_jobcount;
+create table machine_jobcount (
+	id serial not null primary key,
+	machine_id int not null,
+	count int not null,
+	total int not null,
+	logged timestamptz not null default localtimestamp,
+	user_id int not null default 0,
+	source text not null default ''
+);
+create index machine_jobcount_machine_idx on machine_jobcount (machine_id, logged);
+
+insert into migration (name) values ('Add machine job counts');
+
+-- 2016 09 21
+-- Machine running hours, for Every N Hours scheduled tasks
+-- machine_runtime has one row per run of the machine, the open row has no stopped time
+-- machine_meter holds hour meter readings entered by hand, which reset the running total
+
+drop table if exists machine_runtime;
+create table machine_runtime (
+	id serial not null primary key,
+	machine_id int not null,
+	started timestamptz not null default localtimestamp,
+	stopped timestamptz
+);
+create index machine_runtime_machine_idx on machine_runtime (machine_id, started);
+
+-- machines that are running now start their clock from here
+insert into machine_runtime (machine_id) select id from machine where status='Running';
+update machine set started_at=localtimestamp where status='Running';
+
+drop table if exists machine_meter;
+create table machine_meter (
+	id serial not null primary key,
+	machine_id int not null,
+	hours numeric(12,2) not null,
+	logged timestamptz not null default localtimestamp,
+	user_id int not null default 0,
+	notes text not null default ''
+);
+create index machine_meter_machine_idx on machine_meter (machine_id, logged);
+
+alter table sched_task add hours int;
+alter table sched_control_task add last_hours numeric(12,2);
+
+insert into migration (name) values ('Add machine running hours');
+
+-- 2016 09 22
+-- Scheduler catch up, for occurrences missed while the server was down
+-- sched_control row 1 holds the date of the last successful run
+
+alter table task add overdue bool not null default false;
+insert into sched_control (id,last_run) select 1,null where not exists (select 1 from sched_control where id=1);
+
+insert into migration (name) values ('Add scheduler catch up');
+
+-- 2016 09 23
+-- Working calendars for scheduled task generation
+-- weekend is a comma separated list of day names, such as Sat,Sun
+-- A site with no calendar uses its parent site's calendar, or Mon-Fri with no holidays
+
+drop table if exists calendar;
+create table calendar (
+	id serial not null primary key,
+	name text not null unique,
+	weekend text not null default 'Sat,Sun',
+	notes text not null default ''
+);
+
+drop table if exists calendar_holiday;
+create table calendar_holiday (
+	id serial not null primary key,
+	calendar_id int not null,
+	date date not null,
+	name text not null default '',
+	unique (calendar_id, date)
+);
+
+alter table site add calendar_id int not null default 0;
+
+insert into calendar (name) values ('Australia'),('USA');
+update site set calendar_id=(select id from calendar where name='USA') where map_label='USA' and parent_site=0;
+update site set calendar_id=(select id from calendar where name='Australia') where map_label!='USA' and parent_site=0;
+
+insert into migration (name) values ('Add working calendars');
+
+-- 2016 09 26
+-- Recurrence rules for scheduled tasks
+-- Monthly week 5 is now the last week of the month, and weekday runs Mon (1) to Sun (7)
+-- rrule is an RFC 5545 RRULE, optionally with a DTSTART, for the Custom Rule freq
+
+alter table sched_task add rrule text not null default '';
+
+insert into migration (name) values ('Add sched task recurrence rules');
+
+-- 2016 09 27
+-- Task escalation
+-- Each tier fires once an open task is more than the given number of days past its
+-- due date (basis 'due') or escalate date (basis 'escalate'), and notifies
+-- the assigner, the site manager, or all the admins (notify 'assigner', 'manager' or 'admins')
+-- Tiers fire in order of level, and task.escalation_level is the last one fired
+
+drop table if exists escalation_tier;
+create table escalation_tier (
+	id serial not null primary key,
+	level int not null unique,
+	basis text not null default 'due',
+	days int not null default 0,
+	notify text not null default 'assigner',
+	sms bool not null default true,
+	email bool not null default true
+);
+
+insert into escalation_tier (level,basis,days,notify) values
+	(1,'due',0,'assigner'),
+	(2,'due',7,'manager'),
+	(3,'escalate',0,'admins');
+
+alter table task add escalation_level int not null default 0;
+alter table task add escalated_at timestamptz;
+
+insert into migration (name) values ('Add task escalation');
+
+-- 2016 09 28
+-- Explicit statuses for tasks and events
+-- Open, Assigned, Acknowledged, In Progress, On Hold - Parts, Completed, Verified, Cancelled
+-- The allowed moves between them are in shared.StatusTransitions, and every move
+-- is stamped into status_history, for response time and MTTR reporting
+
+alter table task add status text not null default 'Open';
+update task set status='Completed' where completed_date is not null;
+update task set status='Acknowledged' where completed_date is null and assigned_to is not null and coalesce(is_read,false);
+update task set status='Assigned' where completed_date is null and assigned_to is not null and not coalesce(is_read,false);
+
+update event set status='Completed' where completed is not null;
+update event set status='Assigned' where completed is null and exists (select 1 from task t where t.event_id=event.id);
+update event set status='Open' where completed is null and not exists (select 1 from task t where t.event_id=event.id);
+alter table event alter status set default 'Open';
+
+drop table if exists status_history;
+create table status_history (
+	id serial not null primary key,
+	entity text not null,
+	entity_id int not null,
+	from_status text not null default '',
+	to_status text not null,
+	user_id int not null default 0,
+	changed timestamptz not null default localtimestamp,
+	notes text not null default ''
+);
+create index status_history_entity_idx on status_history (entity, entity_id, changed);
+
+-- Seed the history with what is already known, so the reports have something to go on
+insert into status_history (entity,entity_id,to_status,user_id,changed)
+	select 'task',id,'Open',0,created_date from task;
+insert into status_history (entity,entity_id,from_status,to_status,user_id,changed)
+	select 'task',id,'Open','Assigned',coalesce(assigned_by,0),assigned_date from task
+	where assigned_to is not null and assigned_date is not null;
+insert into status_history (entity,entity_id,from_status,to_status,user_id,changed)
+	select 'task',id,'Assigned','Acknowledged',assigned_to,read_date from task
+	where assigned_to is not null and read_date is not null;
+insert into status_history (entity,entity_id,from_status,to_status,user_id,changed)
+	select 'task',id,case when read_date is not null then 'Acknowledged' when assigned_to is not null then 'Assigned' else 'Open' end,'Completed',coalesce(assigned_to,0),completed_date from task
+	where completed_date is not null;
+insert into status_history (entity,entity_id,to_status,user_id,changed)
+	select 'event',id,'Open',created_by,startdate from event;
+insert into status_history (entity,entity_id,from_status,to_status,user_id,changed)
+	select 'event',id,'Assigned','Completed',0,completed from event
+	where completed is not null;
+
+insert into migration (name) values ('Add task and event statuses');
+
+-- 2016 09 29
+-- Technician time on tasks
+-- Each technician clocks on and off a task, and each stretch of work is a task_time
+-- row, with hours filled in when it is clocked off. A technician only ever has one
+-- open (unstopped) entry per task. task_tech is everyone who has acknowledged or
+-- worked on the task, so a task can have more than the one assigned technician.
+
+drop table if exists task_time;
+create table task_time (
+	id serial not null primary key,
+	task_id int not null,
+	user_id int not null,
+	started timestamptz not null default localtimestamp,
+	stopped timestamptz,
+	hours numeric(12,2) not null default 0,
+	notes text not null default ''
+);
+create index task_time_task_idx on task_time (task_id);
+create unique index task_time_open_idx on task_time (task_id, user_id) where stopped is null;
+
+drop table if exists task_tech;
+create table task_tech (
+	task_id int not null,
+	user_id int not null,
+	acknowledged timestamptz,
+	primary key (task_id, user_id)
+);
+
+insert into task_tech (task_id,user_id,acknowledged)
+	select id,assigned_to,read_date from task where assigned_to is not null;
+
+insert into migration (name) values ('Add task time entries');
+
+-- 2016 09 30
+-- Crews on work orders
+-- A work order is a task, so its crew is the task_tech rows for the task, with the
+-- task's assigned_to as the lead. The old wo_assignee table hangs off the unused
+-- workorder table, so it is left alone. Each crew member signs off their own part
+-- of the job, and the task cannot be completed until the whole crew has signed off.
+
+alter table task_tech add signed_off timestamptz;
+update task_tech set signed_off=t.completed_date from task t where t.id=task_tech.task_id;
+
+insert into migration (name) values ('Add work order crews');
+
+-- 2016 10 01
+-- Skills matrix
+-- user_skill gets a proficiency level (1 Trainee, 2 Competent, 3 Expert) and the
+-- dates of any certification, and a skill with an expired certification does not
+-- count when matching technicians to work.
+-- Scheduled tasks and tasks list the skills they need, and the minimum level.
+-- Generated tasks get the skills of their sched task.
+
+alter table user_skill add level int not null default 1;
+alter table user_skill add certified date;
+alter table user_skill add expires date;
+
+drop table if exists sched_skill;
+create table sched_skill (
+	sched_id int not null,
+	skill_id int not null,
+	level int not null default 1,
+	primary key (sched_id, skill_id)
+);
+
+drop table if exists task_skill;
+create table task_skill (
+	task_id int not null,
+	skill_id int not null,
+	level int not null default 1,
+	primary key (task_id, skill_id)
+);
+
+insert into migration (name) values ('Add skills matrix');
+
+-- 2016 10 02
+-- Vendors and purchase orders
+-- vendor_price keeps the history of each vendor's price for a part, and part_vendor
+-- the current one. A new vendor price also becomes the part's latest_price, with a
+-- part_price row to match.
+-- Purchase orders go Draft -> Sent -> Partially Received -> Received, and can be
+-- Cancelled before anything is received. Receiving adds to the part's current_stock,
+-- with a part_stock row for each receipt.
+
+create unique index part_vendor_idx on part_vendor (part_id, vendor_id);
+
+drop table if exists purchase_order;
+create table purchase_order (
+	id serial not null primary key,
+	vendor_id int not null,
+	status text not null default 'Draft',
+	ref text not null default '',
+	notes text not null default '',
+	created timestamptz not null default localtimestamp,
+	created_by int not null default 0,
+	sent_date timestamptz,
+	received_date timestamptz
+);
+create index purchase_order_vendor_idx on purchase_order (vendor_id, created);
+
+drop table if exists purchase_order_line;
+create table purchase_order_line (
+	id serial not null primary key,
+	po_id int not null,
+	part_id int not null,
+	vendor_code text not null default '',
+	qty numeric(12,2) not null default 0,
+	qty_received numeric(12,2) not null default 0,
+	price numeric(12,2) not null default 0,
+	notes text not null default ''
+);
+create index purchase_order_line_po_idx on purchase_order_line (po_id);
+
+insert into migration (name) values ('Add vendors and purchase orders');
+
+-- 2016 10 03
+-- Reorder suggestions
+-- A part is flagged for reorder when its stock on hand, plus whatever is still
+-- outstanding on open purchase orders, is at or below its reorder_stocklevel.
+-- Each part has at most one suggestion that is still live (closed is null), which
+-- is either Open, or Dismissed by a manager. The suggestion is closed once the part
+-- is back above its reorder level (Cleared), or when a PO is raised for it (Ordered).
+
+drop table if exists reorder_suggestion;
+create table reorder_suggestion (
+	id serial not null primary key,
+	part_id int not null,
+	vendor_id int not null default 0,
+	vendor_code text not null default '',
+	price numeric(12,2) not null default 0,
+	stock numeric(12,2) not null default 0,
+	on_order numeric(12,2) not null default 0,
+	reorder_level numeric(12,2) not null default 0,
+	qty numeric(12,2) not null default 0,
+	status text not null default 'Open',
+	raised timestamptz not null default localtimestamp,
+	closed timestamptz,
+	po_id int not null default 0
+);
+create unique index reorder_suggestion_live_idx on reorder_suggestion (part_id) where closed is null;
+create index reorder_suggestion_vendor_idx on reorder_suggestion (vendor_id, status);
+
+insert into migration (name) values ('Add reorder suggestions');
+
+-- 2016 10 04
+-- Per-site stock
+-- Each site draws its stock from its stock_site, or keeps its own store if that is 0.
+-- stock_level now holds the stock on hand at each store, one row per part and store,
+-- and part.current_stock stays as the total across all stores plus anything in transit.
+-- Site 0 holds the stock that has not been put into a store yet, which to start with
+-- is all of the stock on hand from before this change.
+-- part_stock rows now say which store the stock moved in or out of, with the
+-- stock_level being the stock at that store after the move.
+-- Purchase orders are delivered to a store, and stock_transfer moves stock between
+-- stores, being In Transit until it is received at the other end.
+
+alter table stock_level alter column part_id drop default;
+drop sequence if exists stock_level_part_id_seq;
+delete from stock_level;
+create unique index stock_level_idx on stock_level (part_id, site_id);
+insert into stock_level (part_id, site_id, qty, notes)
+	select id, 0, current_stock, 'Stock on hand before per-site stock'
+	from part
+	where current_stock!=0;
+
+alter table part_stock add site_id int not null default 0;
+alter table purchase_order add site_id int not null default 0;
+
+drop table if exists stock_transfer;
+create table stock_transfer (
+	id serial not null primary key,
+	part_id int not null,
+	from_site int not null default 0,
+	to_site int not null,
+	qty numeric(12,2) not null default 0,
+	status text not null default 'In Transit',
+	notes text not null default '',
+	sent timestamptz not null default localtimestamp,
+	sent_by int not null default 0,
+	received timestamptz,
+	received_by int not null default 0
+);
+create index stock_transfer_part_idx on stock_transfer (part_id, sent);
+create index stock_transfer_status_idx on stock_transfer (status, to_site);
+
+insert into migration (name) values ('Add per-site stock and stock transfers');
+
+-- 2016 10 05
+-- Part reservations
+-- task_part.qty is what the task needs of the part, and qty_used is what has been
+-- used so far. Until the task is completed or cancelled, whatever it still needs is
+-- reserved, so the stock available is the stock on hand less the reservations.
+-- Generated tasks now reserve the parts listed against their sched in sched_task_part.
+-- Reservations on tasks starting within the next few weeks (CMMS_RESERVE_WEEKS, 4 by
+-- default) count against the stock when checking the reorder levels.
+
+create index task_part_part_idx on task_part (part_id);
+alter table reorder_suggestion add reserved numeric(12,2) not null default 0;
+
+insert into migration (name) values ('Add part reservations');
+
+-- 2016 10 06
+-- Inventory valuation and stock-takes
+-- Stock on hand is valued from cost layers, one per receipt into stock, with the qty
+-- left in each layer and what it cost. Each part starts with a single layer holding
+-- its current stock at its latest price.
+-- task_part.cost is the cost of the qty used, fixed when the part was used, so that
+-- repricing a part no longer changes the cost of past tasks. Existing task parts are
+-- costed at the price in part_price when the task was completed.
+-- A stock-take is a count sheet for the parts in a category at a store, with the
+-- stock expected there as at when each part was counted. Posting it moves the stock
+-- by the variance between what was counted and what was expected.
+
+drop table if exists cost_layer;
+create table cost_layer (
+	id serial not null primary key,
+	part_id int not null,
+	datefrom timestamptz not null default localtimestamp,
+	qty numeric(12,2) not null default 0,
+	qty_left numeric(12,2) not null default 0,
+	cost numeric(12,4) not null default 0,
+	descr text not null default ''
+);
+create index cost_layer_part_idx on cost_layer (part_id, datefrom);
+
+insert into cost_layer (part_id, qty, qty_left, cost, descr)
+	select id, current_stock, current_stock, latest_price, 'Stock on hand before cost layers'
+	from part
+	where current_stock>0;
+
+alter table task_part add cost numeric(12,2) not null default 0;
+update task_part r
+	set cost=r.qty_used*coalesce((select x.price from part_price x
+		where x.part_id=r.part_id
+		and x.datefrom<=coalesce((select t.completed_date from task t where t.id=r.task_id),now())
+		order by x.datefrom desc limit 1),
+		(select p.latest_price from part p where p.id=r.part_id),0)
+	where r.qty_used!=0;
+
+drop table if exists stock_take;
+create table stock_take (
+	id serial not null primary key,
+	site_id int not null default 0,
+	category_id int not null default 0,
+	status text not null default 'Open',
+	notes text not null default '',
+	created timestamptz not null default localtimestamp,
+	created_by int not null default 0,
+	posted timestamptz,
+	posted_by int not null default 0
+);
+create index stock_take_site_idx on stock_take (site_id, created);
+
+drop table if exists stock_take_line;
+create table stock_take_line (
+	id serial not null primary key,
+	stock_take_id int not null,
+	part_id int not null,
+	expected numeric(12,2) not null default 0,
+	counted numeric(12,2) not null default 0,
+	is_counted bool not null default false,
+	variance_cost numeric(12,2) not null default 0,
+	notes text not null default ''
+);
+create index stock_take_line_idx on stock_take_line (stock_take_id, part_id);
+
+insert into migration (name) values ('Add cost layers and stock-takes');
+
+-- 2016 10 07
+-- Reorder per store
+-- Reorder levels are checked at each store, against the stock on hand there, what is
+-- on order or in transit to it, and what is reserved by the sites that draw from it.
+-- Each part has at most one live suggestion per store, and the store's manager is
+-- the one told about it.
+
+alter table reorder_suggestion add site_id int not null default 0;
+drop index if exists reorder_suggestion_live_idx;
+create unique index reorder_suggestion_live_idx on reorder_suggestion (part_id, site_id) where closed is null;
+
+insert into migration (name) values ('Check reorder levels per store');
+
+-- 2016 10 08
+-- Sched start dates
+-- Every N Days, Every N Months and Custom Rule scheds now count from their startdate,
+-- rather than from whenever they last generated a task, so that COUNT and month end
+-- rules hold. Scheds that are already running start from their last generated date.
+
+update sched_task
+	set startdate=coalesce(last_generated,localtimestamp)::date
+	where startdate is null
+	and freq in ('Every N Days','Every N Months','Custom Rule');
+
+insert into migration (name) values ('Add sched start dates');
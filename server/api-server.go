@@ -0,0 +1,570 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"itrak-cmms/shared"
+
+	"github.com/labstack/echo"
+)
+
+// JSON over HTTP access to the same RPC methods that the websocket client uses.
+//
+// Clients POST to /api/v1/login to get a session token, and then pass it as a
+// bearer token on every other request. Each session is bound to a socketless
+// Connection, so the RPC methods see the same user, role and permissions as
+// they would over the websocket.
+
+var apiConns = struct {
+	sync.Mutex
+	m map[int]*Connection
+}{m: make(map[int]*Connection)}
+
+type apiLoginReply struct {
+	Token string
+	ID    int
+	Role  string
+}
+
+func initAPI(e *echo.Echo) {
+	api := e.Group("/api/v1")
+	api.Post("/login", apiLogin)
+
+	api.Get("/sites", apiSites, apiAuth)
+	api.Get("/sites/:id", apiSite, apiAuth)
+	api.Get("/sites/:id/machines", apiSiteMachines, apiAuth)
+	api.Get("/sites/:id/sched", apiSiteSched, apiAuth)
+	api.Get("/machines", apiMachines, apiAuth)
+	api.Get("/machines/:id", apiMachine, apiAuth)
+	api.Get("/machines/:id/sched", apiMachineSched, apiAuth)
+	api.Get("/machines/:id/jobcount", apiJobCounts, apiAuth)
+	api.Post("/machines/:id/jobcount", apiAddJobCount, apiAuth)
+	api.Get("/events", apiEvents, apiAuth)
+	api.Get("/events/:id", apiEvent, apiAuth)
+	api.Post("/events", apiRaise, apiAuth)
+	api.Get("/tasks", apiTasks, apiAuth)
+	api.Get("/tasks/:id", apiTask, apiAuth)
+	api.Get("/tasks/:id/parts", apiTaskParts, apiAuth)
+	api.Get("/parts", apiParts, apiAuth)
+	api.Get("/parts/:id", apiPart, apiAuth)
+	api.Get("/sched/:id", apiSched, apiAuth)
+	api.Get("/forecast", apiForecast, apiAuth)
+	api.Get("/calendars", apiCalendars, apiAuth)
+	api.Get("/calendars/:id", apiCalendar, apiAuth)
+	api.Post("/calendars/:id/import", apiCalendarImport, apiAuth)
+
+	log.Println("» REST API on /api/v1")
+}
+
+// Exchange a username and password for a bearer token
+func apiLogin(c echo.Context) error {
+	start := time.Now()
+
+	lc := shared.LoginCredentials{}
+	if err := c.Bind(&lc); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	res := &dbLoginResponse{}
+	err := DB.SQL(`select id,username,role,passwd from users where lower(username)=lower($1)`,
+		lc.Username).QueryStruct(res)
+	if err != nil || !checkPasswd(res.Passwd, lc.Password) {
+		logger(start, "API.Login", lc.Username, "Failed", 0, 0, "users", 0, false)
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid username or password")
+	}
+
+	token, _ := newSession(res.ID, lc.RememberMe)
+	logger(start, "API.Login", lc.Username, "OK", 0, res.ID, "users", res.ID, false)
+	return c.JSON(http.StatusOK, apiLoginReply{
+		Token: token,
+		ID:    res.ID,
+		Role:  res.Role,
+	})
+}
+
+// Middleware to resolve the bearer token into a Connection for the RPC layer
+func apiAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		auth := c.Request().Header().Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Missing bearer token")
+		}
+		s, err := getSession(strings.TrimPrefix(auth, "Bearer "))
+		if err != nil {
+			pruneAPIConns()
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+
+		conn := apiConn(s)
+		if conn == nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Unknown user")
+		}
+		c.Set("conn", conn)
+		return next(c)
+	}
+}
+
+// Get the Connection for this session, creating it on first use.
+// The user is re-read on every request, so a change of role takes effect
+// straight away and a deleted user loses access.
+func apiConn(s *dbSession) *Connection {
+	apiConns.Lock()
+	defer apiConns.Unlock()
+
+	res := &dbLoginResponse{}
+	err := DB.SQL(`select id,username,role from users where id=$1`, s.UserID).QueryStruct(res)
+	conn, ok := apiConns.m[s.ID]
+	if err != nil {
+		if ok {
+			delete(apiConns.m, s.ID)
+			Connections.Drop(conn)
+		}
+		return nil
+	}
+
+	if ok {
+		if conn.UserRole != res.Role || conn.Username != res.Username {
+			conn.Login(res.Username, res.ID, res.Role)
+		}
+		return conn
+	}
+
+	conn = Connections.AddAPI()
+	conn.Login(res.Username, res.ID, res.Role)
+	conn.SessionID = s.ID
+	apiConns.m[s.ID] = conn
+	return conn
+}
+
+// Forget the Connection for a session that has been logged out
+func dropAPIConn(sessionID int) {
+	apiConns.Lock()
+	defer apiConns.Unlock()
+
+	if conn, ok := apiConns.m[sessionID]; ok {
+		delete(apiConns.m, sessionID)
+		Connections.Drop(conn)
+	}
+}
+
+// Forget the Connections for any sessions that have expired or been removed
+func pruneAPIConns() {
+	apiConns.Lock()
+	defer apiConns.Unlock()
+
+	if len(apiConns.m) == 0 {
+		return
+	}
+	live := []int{}
+	DB.SQL(`select id from user_session where expires > localtimestamp`).QuerySlice(&live)
+	keep := make(map[int]bool, len(live))
+	for _, id := range live {
+		keep[id] = true
+	}
+	for id, conn := range apiConns.m {
+		if !keep[id] {
+			delete(apiConns.m, id)
+			Connections.Drop(conn)
+		}
+	}
+}
+
+// Check permissions, make the RPC call and send back the reply as JSON
+func apiCall(c echo.Context, method string, args interface{}, call func() error, reply interface{}) error {
+	conn := c.Get("conn").(*Connection)
+	if err := authorize(conn, method, args); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	if err := call(); err != nil {
+		if he, ok := err.(*echo.HTTPError); ok {
+			return he
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, reply)
+}
+
+func apiID(c echo.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 1 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid ID %s", c.Param("id")))
+	}
+	return id, nil
+}
+
+func apiNotFound(what string, id int) error {
+	return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("%s %d not found", what, id))
+}
+
+func apiChannel(c echo.Context) int {
+	return c.Get("conn").(*Connection).ID
+}
+
+func apiSites(c echo.Context) error {
+	channel := apiChannel(c)
+	sites := []shared.Site{}
+	return apiCall(c, "SiteRPC.List", &channel, func() error {
+		return new(SiteRPC).List(channel, &sites)
+	}, &sites)
+}
+
+func apiSite(c echo.Context) error {
+	id, err := apiID(c)
+	if err != nil {
+		return err
+	}
+	data := shared.SiteRPCData{Channel: apiChannel(c), ID: id}
+	site := shared.Site{}
+	return apiCall(c, "SiteRPC.Get", &data, func() error {
+		if err := new(SiteRPC).Get(data, &site); err != nil {
+			return err
+		}
+		if site.ID == 0 {
+			return apiNotFound("Site", id)
+		}
+		return nil
+	}, &site)
+}
+
+func apiSiteMachines(c echo.Context) error {
+	id, err := apiID(c)
+	if err != nil {
+		return err
+	}
+	data := shared.SiteRPCData{Channel: apiChannel(c), ID: id}
+	machines := []shared.Machine{}
+	return apiCall(c, "SiteRPC.MachineList", &data, func() error {
+		return new(SiteRPC).MachineList(data, &machines)
+	}, &machines)
+}
+
+func apiSiteSched(c echo.Context) error {
+	id, err := apiID(c)
+	if err != nil {
+		return err
+	}
+	data := shared.TaskRPCData{Channel: apiChannel(c), ID: id}
+	tasks := []shared.SchedTask{}
+	return apiCall(c, "TaskRPC.ListSiteSched", &data, func() error {
+		return new(TaskRPC).ListSiteSched(data, &tasks)
+	}, &tasks)
+}
+
+func apiMachines(c echo.Context) error {
+	data := shared.EventRPCData{Channel: apiChannel(c), Site: c.QueryParam("site")}
+	machines := []shared.Machine{}
+	return apiCall(c, "SiteRPC.MachineListAll", &data, func() error {
+		return new(SiteRPC).MachineListAll(data, &machines)
+	}, &machines)
+}
+
+func apiMachine(c echo.Context) error {
+	id, err := apiID(c)
+	if err != nil {
+		return err
+	}
+	data := shared.MachineRPCData{Channel: apiChannel(c), ID: id}
+	machine := shared.Machine{}
+	return apiCall(c, "MachineRPC.Get", &data, func() error {
+		if err := new(MachineRPC).Get(data, &machine); err != nil {
+			return err
+		}
+		if machine.ID == 0 {
+			return apiNotFound("Machine", id)
+		}
+		return nil
+	}, &machine)
+}
+
+func apiMachineSched(c echo.Context) error {
+	id, err := apiID(c)
+	if err != nil {
+		return err
+	}
+	data := shared.MachineRPCData{Channel: apiChannel(c), ID: id}
+	tasks := []shared.SchedTask{}
+	return apiCall(c, "TaskRPC.ListMachineSched", &data, func() error {
+		return new(TaskRPC).ListMachineSched(data, &tasks)
+	}, &tasks)
+}
+
+func apiJobCounts(c echo.Context) error {
+	id, err := apiID(c)
+	if err != nil {
+		return err
+	}
+	data := shared.MachineRPCData{Channel: apiChannel(c), ID: id}
+	counts := []shared.JobCount{}
+	return apiCall(c, "MachineRPC.JobCounts", &data, func() error {
+		return new(MachineRPC).JobCounts(data, &counts)
+	}, &counts)
+}
+
+// Counter ingestion, for line counters to post the number of jobs run since their last post
+func apiAddJobCount(c echo.Context) error {
+	id, err := apiID(c)
+	if err != nil {
+		return err
+	}
+	data := shared.JobCountRPCData{}
+	if err := c.Bind(&data); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	data.Channel = apiChannel(c)
+	data.MachineID = id
+	if data.Count < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Count must be 1 or more")
+	}
+	if data.Source == "" {
+		data.Source = "api"
+	}
+
+	machine := shared.Machine{}
+	DB.SQL(`select * from machine where id=$1`, id).QueryStruct(&machine)
+	if machine.ID == 0 {
+		return apiNotFound("Machine", id)
+	}
+
+	total := 0
+	return apiCall(c, "MachineRPC.AddJobCount", &data, func() error {
+		return new(MachineRPC).AddJobCount(data, &total)
+	}, &total)
+}
+
+func apiEvents(c echo.Context) error {
+	channel := apiChannel(c)
+	events := []shared.Event{}
+	return apiCall(c, "EventRPC.List", &channel, func() error {
+		return new(EventRPC).List(channel, &events)
+	}, &events)
+}
+
+func apiEvent(c echo.Context) error {
+	id, err := apiID(c)
+	if err != nil {
+		return err
+	}
+	data := shared.EventRPCData{Channel: apiChannel(c), ID: id}
+	event := shared.Event{}
+	return apiCall(c, "EventRPC.Get", &data, func() error {
+		if err := new(EventRPC).Get(data, &event); err != nil {
+			return err
+		}
+		if event.ID == 0 {
+			return apiNotFound("Event", id)
+		}
+		return nil
+	}, &event)
+}
+
+// Raise a new issue against a machine
+func apiRaise(c echo.Context) error {
+	issue := shared.RaiseIssue{}
+	if err := c.Bind(&issue); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	issue.Channel = apiChannel(c)
+	if issue.Descr == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing Descr")
+	}
+
+	machine := shared.Machine{}
+	DB.SQL(`select * from machine where id=$1`, issue.MachineID).QueryStruct(&machine)
+	if machine.ID == 0 {
+		return apiNotFound("Machine", issue.MachineID)
+	}
+	issue.Machine = &machine
+
+	if issue.IsTool {
+		comp := shared.Component{}
+		DB.SQL(`select * from component where id=$1 and machine_id=$2`,
+			issue.CompID, issue.MachineID).QueryStruct(&comp)
+		if comp.ID == 0 {
+			return apiNotFound("Component", issue.CompID)
+		}
+		issue.Component = &comp
+	}
+
+	if err := authorize(c.Get("conn").(*Connection), "EventRPC.Raise", &issue); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	id := 0
+	if err := new(EventRPC).Raise(issue, &id); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusCreated, id)
+}
+
+func apiTasks(c echo.Context) error {
+	channel := apiChannel(c)
+	tasks := []shared.Task{}
+	return apiCall(c, "TaskRPC.List", &channel, func() error {
+		return new(TaskRPC).List(channel, &tasks)
+	}, &tasks)
+}
+
+func apiTask(c echo.Context) error {
+	id, err := apiID(c)
+	if err != nil {
+		return err
+	}
+	data := shared.TaskRPCData{Channel: apiChannel(c), ID: id}
+	task := shared.Task{}
+	return apiCall(c, "TaskRPC.Get", &data, func() error {
+		if err := new(TaskRPC).Get(data, &task); err != nil {
+			return err
+		}
+		if task.ID == 0 {
+			return apiNotFound("Task", id)
+		}
+		return nil
+	}, &task)
+}
+
+func apiTaskParts(c echo.Context) error {
+	id, err := apiID(c)
+	if err != nil {
+		return err
+	}
+	data := shared.TaskRPCData{Channel: apiChannel(c), ID: id}
+	parts := []shared.TaskPart{}
+	return apiCall(c, "TaskRPC.GetParts", &data, func() error {
+		return new(TaskRPC).GetParts(data, &parts)
+	}, &parts)
+}
+
+func apiParts(c echo.Context) error {
+	class, _ := strconv.Atoi(c.QueryParam("class"))
+	data := shared.PartRPCData{Channel: apiChannel(c), ID: class}
+	parts := []shared.Part{}
+	return apiCall(c, "PartRPC.List", &data, func() error {
+		return new(PartRPC).List(data, &parts)
+	}, &parts)
+}
+
+func apiPart(c echo.Context) error {
+	id, err := apiID(c)
+	if err != nil {
+		return err
+	}
+	data := shared.PartRPCData{Channel: apiChannel(c), ID: id}
+	part := shared.Part{}
+	return apiCall(c, "PartRPC.Get", &data, func() error {
+		if err := new(PartRPC).Get(data, &part); err != nil {
+			return err
+		}
+		if part.ID == 0 {
+			return apiNotFound("Part", id)
+		}
+		return nil
+	}, &part)
+}
+
+func apiSched(c echo.Context) error {
+	id, err := apiID(c)
+	if err != nil {
+		return err
+	}
+	data := shared.TaskRPCData{Channel: apiChannel(c), ID: id}
+	task := shared.SchedTask{}
+	return apiCall(c, "TaskRPC.GetSched", &data, func() error {
+		if err := new(TaskRPC).GetSched(data, &task); err != nil {
+			return err
+		}
+		if task.ID == 0 {
+			return apiNotFound("Sched Task", id)
+		}
+		return nil
+	}, &task)
+}
+
+// Forecast the sched tasks between ?from= and ?to= (yyyy-mm-dd), defaulting to the next 12 months
+func apiForecast(c echo.Context) error {
+	data := shared.ForecastRequest{
+		Channel: apiChannel(c),
+		From:    time.Now(),
+	}
+	var err error
+	if from := c.QueryParam("from"); from != "" {
+		if data.From, err = time.ParseInLocation(rfc3339DateLayout, from, time.Local); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid from date "+from)
+		}
+	}
+	data.To = data.From.AddDate(1, 0, 0)
+	if to := c.QueryParam("to"); to != "" {
+		if data.To, err = time.ParseInLocation(rfc3339DateLayout, to, time.Local); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid to date "+to)
+		}
+	}
+	data.SiteID, _ = strconv.Atoi(c.QueryParam("site"))
+	data.MachineID, _ = strconv.Atoi(c.QueryParam("machine"))
+
+	tasks := []shared.ForecastTask{}
+	return apiCall(c, "TaskRPC.Forecast", &data, func() error {
+		return new(TaskRPC).Forecast(data, &tasks)
+	}, &tasks)
+}
+
+func apiCalendars(c echo.Context) error {
+	channel := apiChannel(c)
+	calendars := []shared.Calendar{}
+	return apiCall(c, "CalendarRPC.List", &channel, func() error {
+		return new(CalendarRPC).List(channel, &calendars)
+	}, &calendars)
+}
+
+func apiCalendar(c echo.Context) error {
+	id, err := apiID(c)
+	if err != nil {
+		return err
+	}
+	data := shared.CalendarRPCData{Channel: apiChannel(c), ID: id}
+	calendar := shared.Calendar{}
+	return apiCall(c, "CalendarRPC.Get", &data, func() error {
+		if err := new(CalendarRPC).Get(data, &calendar); err != nil {
+			return err
+		}
+		if calendar.ID == 0 {
+			return apiNotFound("Calendar", id)
+		}
+		return nil
+	}, &calendar)
+}
+
+// Load holidays into a calendar, the request body being the iCalendar file as is
+func apiCalendarImport(c echo.Context) error {
+	id, err := apiID(c)
+	if err != nil {
+		return err
+	}
+	ics, err := ioutil.ReadAll(c.Request().Body())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	calendar := shared.Calendar{}
+	DB.SQL(`select * from calendar where id=$1`, id).QueryStruct(&calendar)
+	if calendar.ID == 0 {
+		return apiNotFound("Calendar", id)
+	}
+
+	data := shared.CalendarImport{
+		Channel:    apiChannel(c),
+		CalendarID: id,
+		ICS:        string(ics),
+	}
+	count := 0
+	return apiCall(c, "CalendarRPC.Import", &data, func() error {
+		if err := new(CalendarRPC).Import(data, &count); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return nil
+	}, &count)
+}
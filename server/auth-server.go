@@ -0,0 +1,457 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"itrak-cmms/shared"
+)
+
+// Central authorization table, checked by the RPC codec before any method is dispatched.
+// Every registered RPC method must have an entry here, or the call is refused.
+
+type Permission struct {
+	Roles  []string // roles allowed to call the method, empty means anyone, even before login
+	Scoped bool     // Site Managers may only touch sites they are linked to in user_site
+}
+
+var (
+	roleAnyone  = []string{}
+	roleAll     = []string{"Admin", "Site Manager", "Technician", "Floor", "Service Contractor"}
+	roleStaff   = []string{"Admin", "Site Manager", "Technician"}
+	roleManager = []string{"Admin", "Site Manager"}
+	roleAdmin   = []string{"Admin"}
+)
+
+var errDenied = errors.New("Permission Denied")
+
+var Permissions = map[string]Permission{
+	"LoginRPC.Login":       {Roles: roleAnyone},
+	"LoginRPC.Resume":      {Roles: roleAnyone},
+	"LoginRPC.Logout":      {Roles: roleAnyone},
+	"LoginRPC.Nav":         {Roles: roleAll},
+	"LoginRPC.Subscribe":   {Roles: roleAll},
+	"LoginRPC.UsersOnline": {Roles: roleAdmin},
+
+	"PingRPC.Ping": {Roles: roleAnyone},
+
+	"SiteRPC.List":            {Roles: roleAll},
+	"SiteRPC.UserList":        {Roles: roleAll},
+	"SiteRPC.Get":             {Roles: roleAll},
+	"SiteRPC.GetHome":         {Roles: roleAll},
+	"SiteRPC.HomeMachineList": {Roles: roleAll},
+	"SiteRPC.MachineList":     {Roles: roleAll},
+	"SiteRPC.MachineListAll":  {Roles: roleAll},
+	"SiteRPC.SiteCount":       {Roles: roleAll},
+	"SiteRPC.StatusReport":    {Roles: roleAll},
+	"SiteRPC.Update":          {Roles: roleManager, Scoped: true},
+	"SiteRPC.Insert":          {Roles: roleAdmin},
+	"SiteRPC.Delete":          {Roles: roleAdmin},
+
+	"MachineRPC.Get":                     {Roles: roleAll},
+	"MachineRPC.MachineOfType":           {Roles: roleAll},
+	"MachineRPC.MachineTypes":            {Roles: roleAll},
+	"MachineRPC.StartStop":               {Roles: roleManager, Scoped: true},
+	"MachineRPC.Update":                  {Roles: roleManager, Scoped: true},
+	"MachineRPC.Insert":                  {Roles: roleAdmin},
+	"MachineRPC.Delete":                  {Roles: roleAdmin},
+	"MachineRPC.GetMachineType":          {Roles: roleAll},
+	"MachineRPC.MachineTypeTools":        {Roles: roleAll},
+	"MachineRPC.GetMachineTypeTool":      {Roles: roleAll},
+	"MachineRPC.InsertMachineType":       {Roles: roleAdmin},
+	"MachineRPC.UpdateMachineType":       {Roles: roleAdmin},
+	"MachineRPC.DeleteMachineType":       {Roles: roleAdmin},
+	"MachineRPC.InsertMachineTypeTool":   {Roles: roleAdmin},
+	"MachineRPC.UpdateMachineTypeTool":   {Roles: roleAdmin},
+	"MachineRPC.DeleteMachineTypeTool":   {Roles: roleAdmin},
+	"MachineRPC.Subsystems":              {Roles: roleAll},
+	"MachineRPC.GetSubsystem":            {Roles: roleAll},
+	"MachineRPC.InsertSubsystem":         {Roles: roleAdmin},
+	"MachineRPC.UpdateSubsystem":         {Roles: roleAdmin},
+	"MachineRPC.DeleteSubsystem":         {Roles: roleAdmin},
+	"MachineRPC.MachineTypeSubsystems":   {Roles: roleAll},
+	"MachineRPC.SetMachineTypeSubsystem": {Roles: roleAdmin},
+	"MachineRPC.AddJobCount":             {Roles: roleAll, Scoped: true},
+	"MachineRPC.JobCounts":               {Roles: roleAll, Scoped: true},
+	"MachineRPC.AddMeterReading":         {Roles: roleStaff, Scoped: true},
+	"MachineRPC.MeterReadings":           {Roles: roleAll, Scoped: true},
+
+	"UserRPC.Me":             {Roles: roleAll},
+	"UserRPC.Set":            {Roles: roleAll},
+	"UserRPC.Get":            {Roles: roleStaff},
+	"UserRPC.List":           {Roles: roleManager},
+	"UserRPC.GetSites":       {Roles: roleManager},
+	"UserRPC.GetSiteUsers":   {Roles: roleManager},
+	"UserRPC.GetTechnicians": {Roles: roleStaff},
+	"UserRPC.GetManagers":    {Roles: roleStaff},
+	"UserRPC.SetSite":        {Roles: roleAdmin},
+	"UserRPC.SetHighlight":   {Roles: roleAdmin},
+	"UserRPC.Update":         {Roles: roleAdmin},
+	"UserRPC.Insert":         {Roles: roleAdmin},
+	"UserRPC.Delete":         {Roles: roleAdmin},
+
+	"PartRPC.ClassList":      {Roles: roleAll},
+	"PartRPC.GetClass":       {Roles: roleAll},
+	"PartRPC.List":           {Roles: roleAll},
+	"PartRPC.Get":            {Roles: roleAll},
+	"PartRPC.GetTree":        {Roles: roleAll},
+	"PartRPC.GetCategory":    {Roles: roleAll},
+	"PartRPC.PriceList":      {Roles: roleAll},
+	"PartRPC.StockList":      {Roles: roleAll},
+	"PartRPC.Insert":         {Roles: roleManager},
+	"PartRPC.Update":         {Roles: roleManager},
+	"PartRPC.Delete":         {Roles: roleManager},
+	"PartRPC.AddPart":        {Roles: roleManager},
+	"PartRPC.DelPart":        {Roles: roleManager},
+	"PartRPC.InsertClass":    {Roles: roleAdmin},
+	"PartRPC.UpdateClass":    {Roles: roleAdmin},
+	"PartRPC.DeleteClass":    {Roles: roleAdmin},
+	"PartRPC.AddCategory":    {Roles: roleAdmin},
+	"PartRPC.UpdateCategory": {Roles: roleAdmin},
+	"PartRPC.DelCategory":    {Roles: roleAdmin},
+
+	"PartRPC.Stores":          {Roles: roleAll},
+	"PartRPC.SiteStock":       {Roles: roleAll},
+	"PartRPC.Transfers":       {Roles: roleAll},
+	"PartRPC.GetTransfer":     {Roles: roleAll},
+	"PartRPC.AdjustStock":     {Roles: roleManager, Scoped: true},
+	"PartRPC.Transfer":        {Roles: roleManager, Scoped: true},
+	"PartRPC.ReceiveTransfer": {Roles: roleManager, Scoped: true},
+	"PartRPC.CancelTransfer":  {Roles: roleAdmin},
+
+	"PartRPC.CostLayers":       {Roles: roleManager},
+	"PartRPC.Categories":       {Roles: roleAll},
+	"PartRPC.StockTakes":       {Roles: roleManager},
+	"PartRPC.GetStockTake":     {Roles: roleManager},
+	"PartRPC.StockTakeLines":   {Roles: roleManager},
+	"PartRPC.GetStockTakeLine": {Roles: roleManager},
+	"PartRPC.CountStockTake":   {Roles: roleManager, Scoped: true},
+	"PartRPC.NewStockTake":     {Roles: roleManager, Scoped: true},
+	"PartRPC.PostStockTake":    {Roles: roleManager, Scoped: true},
+	"PartRPC.CancelStockTake":  {Roles: roleManager, Scoped: true},
+
+	"EventRPC.Raise":             {Roles: roleAll, Scoped: true},
+	"EventRPC.List":              {Roles: roleAll},
+	"EventRPC.ListByMachineType": {Roles: roleAll},
+	"EventRPC.ListSite":          {Roles: roleAll},
+	"EventRPC.ListCompleted":     {Roles: roleAll},
+	"EventRPC.Get":               {Roles: roleAll},
+	"EventRPC.Update":            {Roles: roleStaff, Scoped: true},
+	"EventRPC.Workorder":         {Roles: roleStaff, Scoped: true},
+	"EventRPC.Complete":          {Roles: roleAdmin},
+
+	"TaskRPC.List":             {Roles: roleStaff},
+	"TaskRPC.ListCompleted":    {Roles: roleStaff},
+	"TaskRPC.Get":              {Roles: roleStaff},
+	"TaskRPC.GetParts":         {Roles: roleStaff},
+	"TaskRPC.GetQtyUsed":       {Roles: roleStaff},
+	"TaskRPC.SiteList":         {Roles: roleStaff},
+	"TaskRPC.StoppageList":     {Roles: roleStaff},
+	"TaskRPC.SchedList":        {Roles: roleStaff},
+	"TaskRPC.Update":           {Roles: roleStaff, Scoped: true},
+	"TaskRPC.UpdateHours":      {Roles: roleStaff, Scoped: true},
+	"TaskRPC.UpdateNotes":      {Roles: roleStaff, Scoped: true},
+	"TaskRPC.AddAttach":        {Roles: roleStaff, Scoped: true},
+	"TaskRPC.Check":            {Roles: roleStaff},
+	"TaskRPC.Complete":         {Roles: roleStaff, Scoped: true},
+	"TaskRPC.AddParts":         {Roles: roleStaff},
+	"TaskRPC.Retransmit":       {Roles: roleManager, Scoped: true},
+	"TaskRPC.Delete":           {Roles: roleManager, Scoped: true},
+	"TaskRPC.Generate":         {Roles: roleAdmin},
+	"TaskRPC.Forecast":         {Roles: roleManager, Scoped: true},
+	"TaskRPC.GetInvoices":      {Roles: roleManager},
+	"TaskRPC.GetInvoice":       {Roles: roleManager},
+	"TaskRPC.InsertInvoice":    {Roles: roleAdmin},
+	"TaskRPC.UpdateInvoice":    {Roles: roleAdmin},
+	"TaskRPC.DeleteInvoice":    {Roles: roleAdmin},
+	"TaskRPC.GetSched":         {Roles: roleStaff},
+	"TaskRPC.ListMachineSched": {Roles: roleStaff},
+	"TaskRPC.ListSiteSched":    {Roles: roleStaff},
+	"TaskRPC.ListHashSched":    {Roles: roleStaff},
+	"TaskRPC.InsertSched":      {Roles: roleManager, Scoped: true},
+	"TaskRPC.UpdateSched":      {Roles: roleManager, Scoped: true},
+	"TaskRPC.DeleteSched":      {Roles: roleManager, Scoped: true},
+	"TaskRPC.SchedPause":       {Roles: roleManager, Scoped: true},
+	"TaskRPC.SchedPlay":        {Roles: roleManager, Scoped: true},
+	"TaskRPC.SchedPart":        {Roles: roleManager},
+	"TaskRPC.HashtagList":      {Roles: roleStaff},
+	"TaskRPC.HashtagListByLen": {Roles: roleStaff},
+	"TaskRPC.HashtagGet":       {Roles: roleStaff},
+	"TaskRPC.HashtagInsert":    {Roles: roleAdmin},
+	"TaskRPC.HashtagUpdate":    {Roles: roleAdmin},
+	"TaskRPC.HashtagDelete":    {Roles: roleAdmin},
+
+	"TaskRPC.Escalate":             {Roles: roleAdmin},
+	"TaskRPC.EscalationTiers":      {Roles: roleAdmin},
+	"TaskRPC.GetEscalationTier":    {Roles: roleAdmin},
+	"TaskRPC.InsertEscalationTier": {Roles: roleAdmin},
+	"TaskRPC.UpdateEscalationTier": {Roles: roleAdmin},
+	"TaskRPC.DeleteEscalationTier": {Roles: roleAdmin},
+
+	"TaskRPC.SetStatus":      {Roles: roleStaff, Scoped: true},
+	"TaskRPC.StatusHistory":  {Roles: roleStaff, Scoped: true},
+	"EventRPC.SetStatus":     {Roles: roleStaff, Scoped: true},
+	"EventRPC.StatusHistory": {Roles: roleAll, Scoped: true},
+
+	"TaskRPC.Acknowledge": {Roles: roleStaff, Scoped: true},
+	"TaskRPC.Start":       {Roles: roleStaff, Scoped: true},
+	"TaskRPC.Pause":       {Roles: roleStaff, Scoped: true},
+	"TaskRPC.Resume":      {Roles: roleStaff, Scoped: true},
+	"TaskRPC.TimeEntries": {Roles: roleStaff, Scoped: true},
+
+	"TaskRPC.Crew":       {Roles: roleStaff, Scoped: true},
+	"TaskRPC.AddCrew":    {Roles: roleStaff, Scoped: true},
+	"TaskRPC.RemoveCrew": {Roles: roleStaff, Scoped: true},
+	"TaskRPC.SignOff":    {Roles: roleStaff, Scoped: true},
+
+	"UtilRPC.GetPDFImage":     {Roles: roleAll},
+	"UtilRPC.GetRawDataImage": {Roles: roleAll},
+	"UtilRPC.GetPhoto":        {Roles: roleAll},
+	"UtilRPC.GetFullPhoto":    {Roles: roleAll},
+	"UtilRPC.PhotoList":       {Roles: roleAdmin},
+	"UtilRPC.AddPhoto":        {Roles: roleAdmin},
+	"UtilRPC.UpdatePhoto":     {Roles: roleAdmin},
+	"UtilRPC.DeletePhoto":     {Roles: roleAdmin},
+	"UtilRPC.Backup":          {Roles: roleAdmin},
+	"UtilRPC.Top":             {Roles: roleAdmin},
+	"UtilRPC.Logs":            {Roles: roleAdmin},
+	"UtilRPC.Machine":         {Roles: roleAdmin},
+	"UtilRPC.Parts":           {Roles: roleAdmin},
+	"UtilRPC.MTT":             {Roles: roleAdmin},
+	"UtilRPC.Thumbnails":      {Roles: roleAdmin},
+	"UtilRPC.Cats":            {Roles: roleAdmin},
+	"UtilRPC.TaskFigs":        {Roles: roleAdmin},
+
+	"SMSRPC.List": {Roles: roleAdmin},
+
+	"CalendarRPC.List":          {Roles: roleAll},
+	"CalendarRPC.Get":           {Roles: roleAll},
+	"CalendarRPC.Insert":        {Roles: roleAdmin},
+	"CalendarRPC.Update":        {Roles: roleAdmin},
+	"CalendarRPC.Delete":        {Roles: roleAdmin},
+	"CalendarRPC.InsertHoliday": {Roles: roleAdmin},
+	"CalendarRPC.DeleteHoliday": {Roles: roleAdmin},
+	"CalendarRPC.Import":        {Roles: roleAdmin},
+
+	"SkillRPC.List":                {Roles: roleStaff},
+	"SkillRPC.Get":                 {Roles: roleStaff},
+	"SkillRPC.Insert":              {Roles: roleAdmin},
+	"SkillRPC.Update":              {Roles: roleAdmin},
+	"SkillRPC.Delete":              {Roles: roleAdmin},
+	"SkillRPC.UserSkills":          {Roles: roleStaff},
+	"SkillRPC.SkillUsers":          {Roles: roleManager},
+	"SkillRPC.SetUserSkill":        {Roles: roleManager},
+	"SkillRPC.DeleteUserSkill":     {Roles: roleManager},
+	"SkillRPC.SchedSkills":         {Roles: roleStaff},
+	"SkillRPC.TaskSkills":          {Roles: roleStaff},
+	"SkillRPC.SetRequiredSkill":    {Roles: roleManager, Scoped: true},
+	"SkillRPC.DeleteRequiredSkill": {Roles: roleManager, Scoped: true},
+
+	"VendorRPC.List":        {Roles: roleManager},
+	"VendorRPC.Get":         {Roles: roleManager},
+	"VendorRPC.Insert":      {Roles: roleAdmin},
+	"VendorRPC.Update":      {Roles: roleAdmin},
+	"VendorRPC.Delete":      {Roles: roleAdmin},
+	"VendorRPC.Prices":      {Roles: roleManager},
+	"VendorRPC.GetPrice":    {Roles: roleManager},
+	"VendorRPC.PartVendors": {Roles: roleAll},
+	"VendorRPC.SetPrice":    {Roles: roleAdmin},
+	"VendorRPC.DeletePrice": {Roles: roleAdmin},
+	"VendorRPC.Orders":      {Roles: roleManager},
+	"VendorRPC.GetOrder":    {Roles: roleManager},
+	"VendorRPC.InsertOrder": {Roles: roleManager},
+	"VendorRPC.UpdateOrder": {Roles: roleManager},
+	"VendorRPC.DeleteOrder": {Roles: roleManager},
+	"VendorRPC.AddLine":     {Roles: roleManager},
+	"VendorRPC.UpdateLine":  {Roles: roleManager},
+	"VendorRPC.DeleteLine":  {Roles: roleManager},
+	"VendorRPC.SendOrder":   {Roles: roleManager},
+	"VendorRPC.CancelOrder": {Roles: roleManager},
+	"VendorRPC.Receive":     {Roles: roleManager},
+
+	"ReorderRPC.List":    {Roles: roleManager},
+	"ReorderRPC.Get":     {Roles: roleManager},
+	"ReorderRPC.Update":  {Roles: roleManager},
+	"ReorderRPC.Dismiss": {Roles: roleManager},
+	"ReorderRPC.Order":   {Roles: roleManager},
+	"ReorderRPC.Check":   {Roles: roleManager},
+}
+
+// Check that the user on this connection may call the method with the given args
+func authorize(conn *Connection, method string, body interface{}) error {
+	p, ok := Permissions[method]
+	if !ok {
+		return denied(conn, method, "No permission entry")
+	}
+	if len(p.Roles) == 0 {
+		return nil
+	}
+	if conn.UserID == 0 {
+		return denied(conn, method, "Not logged in")
+	}
+
+	allowed := false
+	for _, r := range p.Roles {
+		if r == conn.UserRole {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return denied(conn, method, "Role not permitted")
+	}
+
+	if p.Scoped && conn.UserRole == "Site Manager" {
+		siteID := siteOf(body)
+		if siteID != 0 && !userHasSite(conn.UserID, siteID) {
+			return denied(conn, method, fmt.Sprintf("Site %d not permitted", siteID))
+		}
+	}
+	return nil
+}
+
+// Every method acts as the connection on the channel in its args, so make that the
+// channel the call came in on, whatever the client sent. The args are either the
+// channel itself, or a struct with a Channel field.
+func pinChannel(conn *Connection, body interface{}) {
+	v := reflect.ValueOf(body)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return
+	}
+	v = v.Elem()
+	switch v.Kind() {
+	case reflect.Int:
+		v.SetInt(int64(conn.ID))
+	case reflect.Struct:
+		if f := v.FieldByName("Channel"); f.IsValid() && f.CanSet() && f.Kind() == reflect.Int {
+			f.SetInt(int64(conn.ID))
+		}
+	}
+}
+
+func denied(conn *Connection, method string, reason string) error {
+	logger(time.Now(), "Auth.Denied",
+		fmt.Sprintf("Channel %d, User %d %s %s, %s",
+			conn.ID, conn.UserID, conn.Username, conn.UserRole, method),
+		reason,
+		conn.ID, conn.UserID, "users", conn.UserID, false)
+	return errDenied
+}
+
+func userHasSite(userID int, siteID int) bool {
+	count := 0
+	DB.SQL(`select count(*) from user_site where user_id=$1 and site_id=$2`,
+		userID, siteID).QueryScalar(&count)
+	return count > 0
+}
+
+// Work out which site the RPC args refer to, or 0 if there is no site involved
+func siteOf(body interface{}) int {
+	siteID := 0
+	switch b := body.(type) {
+	case *shared.SiteRPCData:
+		siteID = b.ID
+		if siteID == 0 && b.Site != nil {
+			siteID = b.Site.ID
+		}
+	case *shared.MachineRPCData:
+		id := b.ID
+		if id == 0 && b.Machine != nil {
+			id = b.Machine.ID
+		}
+		if id == 0 && b.Machine != nil {
+			// new machine, so check the site it is going into
+			return b.Machine.SiteID
+		}
+		DB.SQL(`select site_id from machine where id=$1`, id).QueryScalar(&siteID)
+	case *shared.RaiseIssue:
+		DB.SQL(`select site_id from machine where id=$1`, b.MachineID).QueryScalar(&siteID)
+	case *shared.JobCountRPCData:
+		DB.SQL(`select site_id from machine where id=$1`, b.MachineID).QueryScalar(&siteID)
+	case *shared.MeterReadingRPCData:
+		DB.SQL(`select site_id from machine where id=$1`, b.MachineID).QueryScalar(&siteID)
+	case *shared.EventRPCData:
+		id := b.ID
+		if id == 0 && b.Event != nil {
+			id = b.Event.ID
+		}
+		DB.SQL(`select site_id from event where id=$1`, id).QueryScalar(&siteID)
+	case *shared.AssignEvent:
+		if b.Event != nil {
+			DB.SQL(`select site_id from event where id=$1`, b.Event.ID).QueryScalar(&siteID)
+		}
+	case *shared.StockAdjustRPCData:
+		siteID = b.SiteID
+	case *shared.StockTransferRPCData:
+		// sending is checked against the store it comes from, and receiving
+		// against the store it goes to
+		if b.ID != 0 {
+			DB.SQL(`select to_site from stock_transfer where id=$1`, b.ID).QueryScalar(&siteID)
+		} else if b.StockTransfer != nil {
+			siteID = b.StockTransfer.FromSite
+		}
+	case *shared.StockTakeRPCData:
+		if b.ID != 0 {
+			DB.SQL(`select site_id from stock_take where id=$1`, b.ID).QueryScalar(&siteID)
+		} else if b.StockTake != nil {
+			siteID = b.StockTake.SiteID
+		}
+	case *shared.StockTakeLineRPCData:
+		DB.SQL(`select k.site_id
+			from stock_take_line l
+			left join stock_take k on k.id=l.stock_take_id
+			where l.id=$1`, b.ID).QueryScalar(&siteID)
+	case *shared.TaskRPCData:
+		id := b.ID
+		if id == 0 && b.Task != nil {
+			id = b.Task.ID
+		}
+		DB.SQL(`select m.site_id from task t
+			left join machine m on m.id=t.machine_id
+			where t.id=$1`, id).QueryScalar(&siteID)
+	case *shared.TaskStatusRPCData:
+		DB.SQL(`select m.site_id from task t
+			left join machine m on m.id=t.machine_id
+			where t.id=$1`, b.ID).QueryScalar(&siteID)
+	case *shared.CrewRPCData:
+		DB.SQL(`select m.site_id from task t
+			left join machine m on m.id=t.machine_id
+			where t.id=$1`, b.ID).QueryScalar(&siteID)
+	case *shared.TaskTimeRPCData:
+		DB.SQL(`select m.site_id from task t
+			left join machine m on m.id=t.machine_id
+			where t.id=$1`, b.ID).QueryScalar(&siteID)
+	case *shared.EventStatusRPCData:
+		DB.SQL(`select site_id from event where id=$1`, b.ID).QueryScalar(&siteID)
+	case *shared.ForecastRequest:
+		siteID = b.SiteID
+		if b.MachineID != 0 {
+			DB.SQL(`select site_id from machine where id=$1`, b.MachineID).QueryScalar(&siteID)
+		}
+	case *shared.RequiredSkillRPCData:
+		if b.SchedID != 0 {
+			DB.SQL(`select m.site_id from sched_task t
+				left join machine m on m.id=t.machine_id
+				where t.id=$1`, b.SchedID).QueryScalar(&siteID)
+		} else {
+			DB.SQL(`select m.site_id from task t
+				left join machine m on m.id=t.machine_id
+				where t.id=$1`, b.TaskID).QueryScalar(&siteID)
+		}
+	case *shared.SchedTaskRPCData:
+		id := b.ID
+		if id == 0 && b.SchedTask != nil {
+			id = b.SchedTask.ID
+		}
+		if id == 0 && b.SchedTask != nil {
+			DB.SQL(`select site_id from machine where id=$1`, b.SchedTask.MachineID).QueryScalar(&siteID)
+			return siteID
+		}
+		DB.SQL(`select m.site_id from sched_task t
+			left join machine m on m.id=t.machine_id
+			where t.id=$1`, id).QueryScalar(&siteID)
+	}
+	return siteID
+}
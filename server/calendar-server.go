@@ -0,0 +1,377 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"itrak-cmms/shared"
+)
+
+// Working calendars, so that generated tasks start and fall due on working days.
+//
+// Each site may have a calendar, and a site without one uses the calendar of
+// its parent site. Holidays can be loaded from the all day events in an
+// iCalendar file, such as the public holiday feeds.
+
+// CalendarRPC exported struct for catching RPC calls
+type CalendarRPC struct{}
+
+const MaxHolidayDays = 31 // longest multi day event that gets loaded as holidays
+
+// Get the working calendar for the site, or the default Mon-Fri calendar if neither it
+// nor any of its parents have one
+func siteCalendar(siteID int) shared.Calendar {
+	cal := shared.Calendar{Name: "Default", Weekend: "Sat,Sun"}
+
+	// walk up the parents, with a limit in case there is a loop
+	for i := 0; i < 10 && siteID != 0; i++ {
+		site := shared.Site{}
+		DB.SQL(`select id,calendar_id,parent_site from site where id=$1`, siteID).QueryStruct(&site)
+		if site.CalendarID != 0 {
+			DB.SQL(`select * from calendar where id=$1`, site.CalendarID).QueryStruct(&cal)
+			DB.SQL(`select * from calendar_holiday where calendar_id=$1 order by date`, site.CalendarID).
+				QueryStructs(&cal.Holidays)
+			break
+		}
+		siteID = site.ParentSite
+	}
+	return cal
+}
+
+// Get the working calendar for the site that the machine is at
+func machineCalendar(machineID int) shared.Calendar {
+	siteID := 0
+	DB.SQL(`select site_id from machine where id=$1`, machineID).QueryScalar(&siteID)
+	return siteCalendar(siteID)
+}
+
+// Get the date out of an iCalendar DATE or DATE-TIME value
+func parseICSDate(value string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("Invalid iCalendar date %s", value)
+	}
+	return time.ParseInLocation("20060102", value[:8], time.Local)
+}
+
+// Get the holidays from the VEVENTs in an iCalendar file. Events that run over
+// several days become a holiday on each day, DTEND being the day after the last.
+func parseICS(ics string) ([]shared.Holiday, error) {
+	// unfold any long lines, which continue on the next line after a space or tab
+	ics = strings.Replace(ics, "\r\n", "\n", -1)
+	ics = strings.Replace(ics, "\n ", "", -1)
+	ics = strings.Replace(ics, "\n\t", "", -1)
+
+	unescape := strings.NewReplacer(`\,`, ",", `\;`, ";", `\n`, " ", `\N`, " ", `\\`, `\`)
+
+	holidays := []shared.Holiday{}
+	inEvent := false
+	var startDate, endDate *time.Time
+	name := ""
+
+	for _, line := range strings.Split(ics, "\n") {
+		line = strings.TrimSpace(line)
+		switch line {
+		case "BEGIN:VEVENT":
+			inEvent = true
+			startDate, endDate, name = nil, nil, ""
+			continue
+		case "END:VEVENT":
+			inEvent = false
+			if startDate == nil {
+				continue
+			}
+			d := *startDate
+			for i := 0; i < MaxHolidayDays; i++ {
+				day := d
+				holidays = append(holidays, shared.Holiday{Date: &day, Name: name})
+				d = d.AddDate(0, 0, 1)
+				if endDate == nil || !d.Before(*endDate) {
+					break
+				}
+			}
+			continue
+		}
+		if !inEvent {
+			continue
+		}
+
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		prop, value := line[:i], line[i+1:]
+		if j := strings.Index(prop, ";"); j >= 0 {
+			prop = prop[:j]
+		}
+		switch strings.ToUpper(prop) {
+		case "DTSTART":
+			t, err := parseICSDate(value)
+			if err != nil {
+				return nil, err
+			}
+			startDate = &t
+		case "DTEND":
+			t, err := parseICSDate(value)
+			if err != nil {
+				return nil, err
+			}
+			endDate = &t
+		case "SUMMARY":
+			name = unescape.Replace(value)
+		}
+	}
+
+	if len(holidays) == 0 {
+		return nil, errors.New("No events found in the iCalendar data")
+	}
+	return holidays, nil
+}
+
+// Get all the calendars
+func (c *CalendarRPC) List(channel int, calendars *[]shared.Calendar) error {
+	start := time.Now()
+
+	conn := Connections.Get(channel)
+
+	DB.SQL(`select * from calendar order by lower(name)`).QueryStructs(calendars)
+
+	logger(start, "Calendar.List",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d calendars", len(*calendars)),
+		channel, conn.UserID, "calendar", 0, false)
+
+	return nil
+}
+
+// Get a calendar, with its holidays
+func (c *CalendarRPC) Get(data shared.CalendarRPCData, calendar *shared.Calendar) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(`select * from calendar where id=$1`, data.ID).QueryStruct(calendar)
+	DB.SQL(`select * from calendar_holiday where calendar_id=$1 order by date`, data.ID).
+		QueryStructs(&calendar.Holidays)
+
+	logger(start, "Calendar.Get",
+		fmt.Sprintf("Channel %d, ID %d User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%s %d holidays", calendar.Name, len(calendar.Holidays)),
+		data.Channel, conn.UserID, "calendar", data.ID, false)
+
+	return nil
+}
+
+// Add a new calendar
+func (c *CalendarRPC) Insert(data shared.CalendarRPCData, id *int) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	err := DB.InsertInto("calendar").
+		Whitelist("name", "weekend", "notes").
+		Record(data.Calendar).
+		Returning("id").
+		QueryScalar(id)
+	if err != nil {
+		log.Println("Calendar.Insert:", err.Error())
+		return err
+	}
+
+	logger(start, "Calendar.Insert",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			data.Channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("ID %d Name %s", *id, data.Calendar.Name),
+		data.Channel, conn.UserID, "calendar", *id, true)
+
+	conn.BroadcastAdmin("calendar", "insert", *id)
+	return nil
+}
+
+// Save a calendar
+func (c *CalendarRPC) Update(data shared.CalendarRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	_, err := DB.Update("calendar").
+		SetWhitelist(data.Calendar, "name", "weekend", "notes").
+		Where("id = $1", data.ID).
+		Exec()
+	if err != nil {
+		log.Println("Calendar.Update:", err.Error())
+		return err
+	}
+
+	logger(start, "Calendar.Update",
+		fmt.Sprintf("Channel %d, ID %d User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%s weekend %s", data.Calendar.Name, data.Calendar.Weekend),
+		data.Channel, conn.UserID, "calendar", data.ID, true)
+
+	conn.BroadcastAdmin("calendar", "update", data.ID)
+	*done = true
+	return nil
+}
+
+// Delete a calendar, and drop it from any sites that use it
+func (c *CalendarRPC) Delete(data shared.CalendarRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Calendar.Delete:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	for _, sql := range []string{
+		`update site set calendar_id=0 where calendar_id=$1`,
+		`delete from calendar_holiday where calendar_id=$1`,
+		`delete from calendar where id=$1`,
+	} {
+		if _, err = tx.SQL(sql, data.ID).Exec(); err != nil {
+			log.Println("Calendar.Delete:", err.Error())
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Calendar.Delete:", err.Error())
+		return err
+	}
+
+	logger(start, "Calendar.Delete",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			data.Channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("ID %d", data.ID),
+		data.Channel, conn.UserID, "calendar", data.ID, true)
+
+	conn.BroadcastAdmin("calendar", "delete", data.ID)
+	*done = true
+	return nil
+}
+
+// Add a holiday to a calendar, replacing any existing one on the same date
+func (c *CalendarRPC) InsertHoliday(data shared.HolidayRPCData, id *int) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if data.Holiday.Date == nil {
+		return errors.New("Holiday has no date")
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Calendar.InsertHoliday:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	_, err = tx.SQL(`delete from calendar_holiday where calendar_id=$1 and date=$2`,
+		data.Holiday.CalendarID, data.Holiday.Date).Exec()
+	if err != nil {
+		log.Println("Calendar.InsertHoliday:", err.Error())
+		return err
+	}
+	err = tx.InsertInto("calendar_holiday").
+		Whitelist("calendar_id", "date", "name").
+		Record(data.Holiday).
+		Returning("id").
+		QueryScalar(id)
+	if err != nil {
+		log.Println("Calendar.InsertHoliday:", err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Calendar.InsertHoliday:", err.Error())
+		return err
+	}
+
+	logger(start, "Calendar.InsertHoliday",
+		fmt.Sprintf("Channel %d, Calendar %d User %d %s %s",
+			data.Channel, data.Holiday.CalendarID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%s %s", data.Holiday.Date.Format(rfc3339DateLayout), data.Holiday.Name),
+		data.Channel, conn.UserID, "calendar", data.Holiday.CalendarID, true)
+
+	conn.BroadcastAdmin("calendar", "update", data.Holiday.CalendarID)
+	return nil
+}
+
+// Remove a holiday from a calendar
+func (c *CalendarRPC) DeleteHoliday(data shared.HolidayRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	calendarID := 0
+	DB.SQL(`delete from calendar_holiday where id=$1 returning calendar_id`, data.ID).QueryScalar(&calendarID)
+
+	logger(start, "Calendar.DeleteHoliday",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			data.Channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("ID %d Calendar %d", data.ID, calendarID),
+		data.Channel, conn.UserID, "calendar", calendarID, true)
+
+	conn.BroadcastAdmin("calendar", "update", calendarID)
+	*done = true
+	return nil
+}
+
+// Load the holidays from an iCalendar file into the calendar, and return the number of days loaded
+func (c *CalendarRPC) Import(data shared.CalendarImport, count *int) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	holidays, err := parseICS(data.ICS)
+	if err != nil {
+		log.Println("Calendar.Import:", err.Error())
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Calendar.Import:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	for _, h := range holidays {
+		_, err = tx.SQL(`delete from calendar_holiday where calendar_id=$1 and date=$2`,
+			data.CalendarID, h.Date).Exec()
+		if err != nil {
+			log.Println("Calendar.Import:", err.Error())
+			return err
+		}
+		_, err = tx.SQL(`insert into calendar_holiday (calendar_id,date,name) values ($1,$2,$3)`,
+			data.CalendarID, h.Date, h.Name).Exec()
+		if err != nil {
+			log.Println("Calendar.Import:", err.Error())
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Calendar.Import:", err.Error())
+		return err
+	}
+	*count = len(holidays)
+
+	logger(start, "Calendar.Import",
+		fmt.Sprintf("Channel %d, Calendar %d User %d %s %s",
+			data.Channel, data.CalendarID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d holidays", *count),
+		data.Channel, conn.UserID, "calendar", data.CalendarID, true)
+
+	conn.BroadcastAdmin("calendar", "update", data.CalendarID)
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/http"
 	"os/exec"
+	"strings"
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/engine/standard"
@@ -64,6 +65,16 @@ func main() {
 	// e.ServeDir("/", "public/")
 	e.SetHTTPErrorHandler(func(err error, context echo.Context) {
 		httpError, ok := err.(*echo.HTTPError)
+
+		// REST API callers get the error code and message back as JSON
+		if strings.HasPrefix(context.Request().URL().Path(), "/api/") {
+			if !ok {
+				httpError = echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+			}
+			context.JSON(httpError.Code, httpError)
+			return
+		}
+
 		if ok {
 			// errorCode := httpError.Code()
 			errorCode := httpError.Code
@@ -106,7 +117,17 @@ func main() {
 	// On startup, generate a batch of tasks, and continue scanning on the hour
 	autoGenerate()
 
-	e.Get("/ws", standard.WrapHandler(websocket.Handler(webSocket)))
+	// and escalate any overdue tasks, also on the hour
+	autoEscalate()
+
+	// and check stock against the reorder levels, daily
+	autoReorder()
+
+	e.Get("/ws", standard.WrapHandler(websocket.Server{
+		Handler:   webSocket,
+		Handshake: wsHandshake,
+	}))
+	initAPI(e)
 	// e.Get("/ws", fasthttp.WrapHandler(websocket.Handler(webSocket)))
 
 	e.SetDebug(true)
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"itrak-cmms/shared"
+
+	runner "gopkg.in/mgutz/dat.v1/sqlx-runner"
+)
+
+// Inventory valuation.
+//
+// Stock on hand is valued from cost layers in cost_layer. Each receipt into stock
+// adds a layer with the qty received and what it cost, and stock going out is
+// taken from the oldest layers first, so the stock is valued at what it cost to
+// bring in, rather than at the part's latest price.
+//
+// With CMMS_VALUATION=FIFO, each layer keeps the cost it came in at, so the stock
+// going out is costed at the oldest costs. Otherwise stock is valued at its
+// weighted average cost, with each receipt bringing every layer still holding
+// stock to the new average.
+//
+// Stock found without a cost to go on, such as a stock-take surplus, comes in at
+// the part's unit cost. Stock going out beyond what the layers hold is costed at
+// the latest price.
+
+// The valuation method in use
+func valuationMethod() string {
+	if strings.ToUpper(os.Getenv("CMMS_VALUATION")) == "FIFO" {
+		return shared.ValuationFIFO
+	}
+	return shared.ValuationAverage
+}
+
+// The value of the stock on hand for the part p
+const partValuation = `coalesce((select sum(c.qty_left*c.cost)
+	from cost_layer c
+	where c.part_id=p.id and c.qty_left>0),0)`
+
+// Add a layer of qty at the given unit cost to the part's stock
+func addCostLayer(tx *runner.Tx, partID int, qty float64, cost float64, descr string) error {
+	_, err := tx.SQL(`insert into cost_layer (part_id,qty,qty_left,cost,descr)
+		values ($1,$2,$2,$3,$4)`, partID, qty, cost, descr).Exec()
+	if err != nil || valuationMethod() == shared.ValuationFIFO {
+		return err
+	}
+
+	_, err = tx.SQL(`update cost_layer
+		set cost=(select sum(x.qty_left*x.cost)/sum(x.qty_left)
+			from cost_layer x
+			where x.part_id=$1 and x.qty_left>0)
+		where part_id=$1 and qty_left>0`, partID).Exec()
+	return err
+}
+
+// Take qty of the part's stock out of its cost layers, oldest first, and return
+// what it cost
+func takeCostLayers(tx *runner.Tx, partID int, qty float64) (float64, error) {
+	layers := []shared.CostLayer{}
+	err := tx.SQL(`select * from cost_layer
+		where part_id=$1 and qty_left>0
+		order by datefrom,id
+		for update`, partID).QueryStructs(&layers)
+	if err != nil {
+		return 0, err
+	}
+
+	cost := 0.0
+	for _, l := range layers {
+		if qty <= 0 {
+			break
+		}
+		take := l.QtyLeft
+		if take > qty {
+			take = qty
+		}
+		_, err = tx.SQL(`update cost_layer set qty_left=qty_left-$2 where id=$1`, l.ID, take).Exec()
+		if err != nil {
+			return 0, err
+		}
+		cost += take * l.Cost
+		qty -= take
+	}
+
+	// More went out than came in, so cost the rest at the latest price
+	if qty > 0 {
+		price := 0.0
+		tx.SQL(`select latest_price from part where id=$1`, partID).QueryScalar(&price)
+		cost += qty * price
+	}
+	return cost, nil
+}
+
+// The cost of one of the part's stock on hand, or its latest price if it has none
+func unitCost(tx *runner.Tx, partID int) float64 {
+	cost := 0.0
+	tx.SQL(`select coalesce(
+		(select sum(c.qty_left*c.cost)/sum(c.qty_left)
+			from cost_layer c
+			where c.part_id=p.id and c.qty_left>0),
+		p.latest_price)
+		from part p
+		where p.id=$1`, partID).QueryScalar(&cost)
+	return cost
+}
+
+// Put stock found into the cost layers at the part's unit cost, or take stock lost
+// out of them, and return the value gained (or lost, if negative)
+func adjustCost(tx *runner.Tx, partID int, delta float64, descr string) (float64, error) {
+	if delta > 0 {
+		cost := unitCost(tx, partID)
+		return delta * cost, addCostLayer(tx, partID, delta, cost, descr)
+	}
+	if delta < 0 {
+		cost, err := takeCostLayers(tx, partID, -delta)
+		return -cost, err
+	}
+	return 0, nil
+}
+
+// Get the cost layers still holding stock for the part
+func (p *PartRPC) CostLayers(data shared.PartRPCData, layers *[]shared.CostLayer) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(`select * from cost_layer
+		where part_id=$1 and qty_left>0
+		order by datefrom,id`, data.ID).QueryStructs(layers)
+
+	logger(start, "Part.CostLayers",
+		fmt.Sprintf("Channel %d, Part %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d layers %s", len(*layers), valuationMethod()),
+		data.Channel, conn.UserID, "cost_layer", data.ID, false)
+
+	return nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"itrak-cmms/shared"
+
+	runner "gopkg.in/mgutz/dat.v1/sqlx-runner"
+)
+
+// Crews on work orders.
+//
+// A task can have a whole crew on it, rather than just the one technician that it
+// is assigned to. The crew is the task_tech table, with the assigned technician as
+// the lead. Each crew member has their own time entries, and signs off their own
+// part of the job, and the task cannot be completed until they all have.
+
+// Can the user hand out work, and so change who is on the crew
+func canAllocate(conn *Connection) bool {
+	if conn.UserRole == "Admin" {
+		return true
+	}
+	allocate := false
+	DB.SQL(`select can_allocate from users where id=$1`, conn.UserID).QueryScalar(&allocate)
+	return allocate
+}
+
+// Get the usernames of everyone on the crews for the tasks on an event
+func eventAssignees(eventID int) []string {
+	names := []string{}
+	DB.SQL(`select u.username
+		from users u
+		where u.id in (
+			select t.assigned_to from task t where t.event_id=$1
+			union
+			select x.user_id from task_tech x
+			left join task t on t.id=x.task_id
+			where t.event_id=$1)
+		order by u.username`, eventID).QuerySlice(&names)
+	return names
+}
+
+func isCrew(taskID int, userID int) bool {
+	count := 0
+	DB.SQL(`select count(*) from task_tech
+		where task_id=$1 and user_id=$2`, taskID, userID).QueryScalar(&count)
+	return count > 0
+}
+
+func isSignedOff(taskID int, userID int) bool {
+	count := 0
+	DB.SQL(`select count(*) from task_tech
+		where task_id=$1 and user_id=$2 and signed_off is not null`, taskID, userID).QueryScalar(&count)
+	return count > 0
+}
+
+// Sign the user off the task, clocking them off if they are still on the job
+func signOff(tx *runner.Tx, taskID int, userID int) error {
+	if _, err := clockOff(tx, taskID, userID, ""); err != nil {
+		return err
+	}
+	_, err := tx.SQL(`update task_tech
+		set signed_off=coalesce(signed_off,now())
+		where task_id=$1 and user_id=$2`, taskID, userID).Exec()
+	if err != nil {
+		return err
+	}
+	return updateTaskLabour(tx, taskID)
+}
+
+// Get the usernames of the crew that are yet to sign off the task
+func crewNotSignedOff(tx *runner.Tx, taskID int) ([]string, error) {
+	names := []string{}
+	err := tx.SQL(`select u.username
+		from task_tech x
+		left join users u on u.id=x.user_id
+		where x.task_id=$1 and x.signed_off is null
+		order by u.username`, taskID).QuerySlice(&names)
+	return names, err
+}
+
+// Send the new task out by SMS to each member of the crew
+func notifyCrew(taskID int, userIDs []int, message string) {
+	for _, userID := range userIDs {
+		phoneNumber := ""
+		DB.SQL(`select sms from users where id=$1`, userID).QueryScalar(&phoneNumber)
+
+		if !Config.SMSOn {
+			log.Println("Will send SMS:", message, "to", phoneNumber)
+			continue
+		}
+		if phoneNumber == "" {
+			log.Println("No Phone Number for SMS:", message)
+			continue
+		}
+		SendSMS(phoneNumber, message, fmt.Sprintf("%d", taskID), userID)
+	}
+}
+
+func (t *TaskRPC) Crew(data shared.TaskRPCData, crew *[]shared.CrewMember) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	err := DB.SQL(`select x.user_id as id,x.task_id,u.username as username,
+		coalesce(t.assigned_to=x.user_id,false) as lead,
+		x.acknowledged,x.signed_off,
+		coalesce((select sum(hours) from task_time h
+			where h.task_id=x.task_id and h.user_id=x.user_id),0) as hours
+		from task_tech x
+		left join task t on t.id=x.task_id
+		left join users u on u.id=x.user_id
+		where x.task_id=$1
+		order by lead desc,u.username`, data.ID).QueryStructs(crew)
+	if err != nil {
+		log.Println("Task.Crew:", err.Error())
+		return err
+	}
+
+	logger(start, "Task.Crew",
+		fmt.Sprintf("Channel %d, Task %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d crew", len(*crew)),
+		data.Channel, conn.UserID, "task", data.ID, false)
+
+	return nil
+}
+
+func (t *TaskRPC) AddCrew(data shared.CrewRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if !canAllocate(conn) {
+		return errDenied
+	}
+	if isCrew(data.ID, data.UserID) {
+		return fmt.Errorf("Already on the crew for task %06d", data.ID)
+	}
+
+	task := shared.Task{}
+	err := DB.SQL(`select t.*,m.name as machine_name
+		from task t
+		left join machine m on m.id=t.machine_id
+		where t.id=$1`, data.ID).QueryStruct(&task)
+	if err != nil {
+		log.Println("Task.AddCrew:", err.Error())
+		return err
+	}
+	if task.CompletedDate != nil {
+		return fmt.Errorf("Task %06d is already complete", data.ID)
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Task.AddCrew:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	if err = addTaskTech(tx, data.ID, data.UserID, false); err != nil {
+		log.Println("Task.AddCrew:", err.Error())
+		return err
+	}
+
+	// A task with nobody on it gets the first crew member as the lead
+	if task.AssignedTo == nil || *task.AssignedTo == 0 {
+		_, err = tx.SQL(`update task
+			set assigned_to=$2, assigned_by=$3, assigned_date=now()
+			where id=$1`, data.ID, data.UserID, conn.UserID).Exec()
+		if err != nil {
+			log.Println("Task.AddCrew:", err.Error())
+			return err
+		}
+		if task.Status == shared.StatusOpen {
+			_, err = setStatus(tx, "task", data.ID, shared.StatusAssigned, conn.UserID, "")
+			if err != nil {
+				log.Println("Task.AddCrew:", err.Error())
+				return err
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Task.AddCrew:", err.Error())
+		return err
+	}
+
+	notes := task.Descr
+	if len(notes) > 40 {
+		notes = notes[:40] + "..."
+	}
+	notifyCrew(data.ID, []int{data.UserID}, fmt.Sprintf("Task %06d:\n %s - %s : %s",
+		data.ID, notes, task.MachineName, task.Component))
+
+	logger(start, "Task.AddCrew",
+		fmt.Sprintf("Channel %d, Task %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Added User %d", data.UserID),
+		data.Channel, conn.UserID, "task", data.ID, true)
+
+	conn.Broadcast("task", "update", data.ID)
+	if task.EventID != 0 {
+		conn.Broadcast("event", "update", task.EventID)
+	}
+	*done = true
+	return nil
+}
+
+func (t *TaskRPC) RemoveCrew(data shared.CrewRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if !canAllocate(conn) {
+		return errDenied
+	}
+
+	task := shared.Task{}
+	DB.SQL(`select * from task where id=$1`, data.ID).QueryStruct(&task)
+	if task.AssignedTo != nil && *task.AssignedTo == data.UserID {
+		return fmt.Errorf("Reassign task %06d before taking the lead off the crew", data.ID)
+	}
+
+	count := 0
+	DB.SQL(`select count(*) from task_time
+		where task_id=$1 and user_id=$2`, data.ID, data.UserID).QueryScalar(&count)
+	if count > 0 {
+		return fmt.Errorf("Cannot take someone off the crew once they have time on the job")
+	}
+
+	_, err := DB.SQL(`delete from task_tech
+		where task_id=$1 and user_id=$2`, data.ID, data.UserID).Exec()
+	if err != nil {
+		log.Println("Task.RemoveCrew:", err.Error())
+		return err
+	}
+
+	logger(start, "Task.RemoveCrew",
+		fmt.Sprintf("Channel %d, Task %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Removed User %d", data.UserID),
+		data.Channel, conn.UserID, "task", data.ID, true)
+
+	conn.Broadcast("task", "update", data.ID)
+	if task.EventID != 0 {
+		conn.Broadcast("event", "update", task.EventID)
+	}
+	*done = true
+	return nil
+}
+
+// Sign off the user's part of the job, leaving the task open for the rest of the crew
+func (t *TaskRPC) SignOff(data shared.TaskTimeRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if !isCrew(data.ID, conn.UserID) {
+		return fmt.Errorf("You are not on the crew for task %06d", data.ID)
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Task.SignOff:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	if err = signOff(tx, data.ID, conn.UserID); err != nil {
+		log.Println("Task.SignOff:", err.Error())
+		return err
+	}
+	waiting, err := crewNotSignedOff(tx, data.ID)
+	if err != nil {
+		log.Println("Task.SignOff:", err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Task.SignOff:", err.Error())
+		return err
+	}
+
+	logger(start, "Task.SignOff",
+		fmt.Sprintf("Channel %d, Task %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Still waiting on %s", strings.Join(waiting, ", ")),
+		data.Channel, conn.UserID, "task", data.ID, true)
+
+	conn.Broadcast("task", "update", data.ID)
+	*done = true
+	return nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"itrak-cmms/shared"
+)
+
+// Escalation of overdue tasks.
+//
+// Open tasks that are past their due date or escalate date are escalated
+// through the escalation_tier table in order of level. Each tier notifies the
+// person who assigned the task, the site manager, or all of the admins, by SMS
+// and/or email, and is written into the task log. A tier only fires once the
+// ones before it have, so a task never skips straight to the admins.
+
+var EscalateMutex sync.Mutex
+
+func autoEscalate() {
+
+	log.Printf("... Running task escalation")
+	go func() {
+		for {
+			escalateTasks(0, 0, time.Now())
+			time.Sleep(1 * time.Hour)
+		}
+	}()
+}
+
+// Get the people that a tier of escalation goes to
+func escalationRecipients(task shared.Task, tier shared.EscalationTier) []shared.User {
+	users := []shared.User{}
+	switch tier.Notify {
+	case "assigner":
+		if task.AssignedBy != nil {
+			DB.SQL(`select id,username,name,email,sms,use_mobile
+				from users
+				where id=$1`, *task.AssignedBy).QueryStructs(&users)
+		}
+	case "manager":
+		DB.SQL(`select u.id,u.username,u.name,u.email,u.sms,u.use_mobile
+			from site s
+			left join users u on u.id=s.manager
+			where s.id=$1 and u.id is not null`, task.SiteID).QueryStructs(&users)
+	case "admins":
+		DB.SQL(`select id,username,name,email,sms,use_mobile
+			from users
+			where role='Admin'
+			order by id`).QueryStructs(&users)
+	}
+	return users
+}
+
+// Has the task gone past the point where the tier fires
+func escalationDue(task shared.Task, tier shared.EscalationTier, today time.Time) bool {
+	basis := task.DueDate
+	if tier.Basis == "escalate" {
+		basis = task.EscalateDate
+	}
+	if basis == nil {
+		return false
+	}
+	return today.After(dateOnly(*basis).AddDate(0, 0, tier.Days))
+}
+
+// Send out the notifications for a tier of escalation, and record it against the task
+func escalateTask(task shared.Task, tier shared.EscalationTier, now time.Time) error {
+	dueDate := ""
+	if task.DueDate != nil {
+		dueDate = task.DueDate.Format("Mon, Jan 2 2006")
+	}
+	subject := fmt.Sprintf("Overdue Task %d at %s", task.ID, task.SiteName)
+	message := fmt.Sprintf("Task %d at Site %s on Machine %s %s was due %s and is not complete: %s",
+		task.ID, task.SiteName, task.MachineName, task.Component, dueDate, task.Descr)
+
+	names := []string{}
+	for _, u := range escalationRecipients(task, tier) {
+		names = append(names, u.Name)
+		if tier.SMS && u.SMS != "" {
+			if err := SendSMS(u.SMS, message, fmt.Sprintf("%d", task.ID), u.ID); err != nil {
+				log.Println("Escalate SMS to", u.Username, err.Error())
+			}
+		}
+		if tier.Email && u.Email != "" {
+			if err := SendEmail(u.Email, subject, message); err != nil {
+				log.Println("Escalate Email to", u.Username, err.Error())
+			}
+		}
+	}
+	if len(names) == 0 {
+		names = append(names, "nobody to notify")
+	}
+
+	logLine := fmt.Sprintf("%s Escalated to %s (tier %d, %s): %s",
+		now.Format("Mon, Jan 2 2006 15:04"),
+		tier.ShowNotify(), tier.Level, tier.ShowVia(), strings.Join(names, ", "))
+
+	_, err := DB.SQL(`update task
+		set escalation_level=$2,
+		escalated_at=$3,
+		log=case when log='' then $4 else log || E'\n' || $4 end
+		where id=$1`, task.ID, tier.Level, now, logLine).Exec()
+	if err != nil {
+		return err
+	}
+
+	Connections.BroadcastAll("task", "escalated", task.ID)
+	return nil
+}
+
+// Escalate every open task that has gone past the next tier, and return the number of escalations
+func escalateTasks(channel int, userID int, now time.Time) int {
+
+	EscalateMutex.Lock()
+	defer EscalateMutex.Unlock()
+
+	start := time.Now()
+	today := dateOnly(now)
+
+	tiers := []shared.EscalationTier{}
+	DB.SQL(`select * from escalation_tier order by level`).QueryStructs(&tiers)
+	if len(tiers) == 0 {
+		return 0
+	}
+
+	tasks := []shared.Task{}
+	err := DB.SQL(`select t.*,m.name as machine_name,m.site_id,s.name as site_name
+		from task t
+		left join machine m on m.id=t.machine_id
+		left join site s on s.id=m.site_id
+		where t.completed_date is null
+		and t.status!='Cancelled'
+		and t.escalation_level < $1
+		and (t.due_date < $2 or t.escalate_date < $2)
+		order by t.id`, tiers[len(tiers)-1].Level, today).QueryStructs(&tasks)
+	if err != nil {
+		log.Println("escalateTasks:", err.Error())
+		return 0
+	}
+
+	count := 0
+	for _, task := range tasks {
+		for _, tier := range tiers {
+			if tier.Level <= task.EscalationLevel {
+				continue
+			}
+			if !escalationDue(task, tier, today) {
+				break
+			}
+
+			log.Printf("»»» Task %d escalated to tier %d %s", task.ID, tier.Level, tier.ShowNotify())
+			if err := escalateTask(task, tier, now); err != nil {
+				log.Println("escalateTasks:", err.Error())
+				break
+			}
+			task.EscalationLevel = tier.Level
+			count++
+		}
+	}
+
+	logger(start, "Task.Escalate",
+		fmt.Sprintf("As of date %s", now.Format(rfc3339DateLayout)),
+		fmt.Sprintf("%d open tasks overdue, %d escalations", len(tasks), count),
+		channel, userID, "task", 0, true)
+
+	return count
+}
+
+// Run the escalation now, rather than waiting for the hourly run
+func (t *TaskRPC) Escalate(channel int, count *int) error {
+	conn := Connections.Get(channel)
+	*count = escalateTasks(channel, conn.UserID, time.Now())
+	return nil
+}
+
+// Get the escalation tiers in order
+func (t *TaskRPC) EscalationTiers(channel int, tiers *[]shared.EscalationTier) error {
+	start := time.Now()
+
+	conn := Connections.Get(channel)
+
+	DB.SQL(`select * from escalation_tier order by level`).QueryStructs(tiers)
+
+	logger(start, "Task.EscalationTiers",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d tiers", len(*tiers)),
+		channel, conn.UserID, "escalation_tier", 0, false)
+
+	return nil
+}
+
+func (t *TaskRPC) GetEscalationTier(data shared.EscalationTierRPCData, tier *shared.EscalationTier) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(`select * from escalation_tier where id=$1`, data.ID).QueryStruct(tier)
+
+	logger(start, "Task.GetEscalationTier",
+		fmt.Sprintf("Channel %d, ID %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Level %d %s %s", tier.Level, tier.ShowBasis(), tier.ShowNotify()),
+		data.Channel, conn.UserID, "escalation_tier", data.ID, false)
+
+	return nil
+}
+
+// Check that the tier makes sense before saving it
+func validEscalationTier(tier *shared.EscalationTier) error {
+	if tier.Level < 1 {
+		return fmt.Errorf("Escalation level must be 1 or more")
+	}
+	if tier.Days < 0 {
+		tier.Days = 0
+	}
+	switch tier.Basis {
+	case "due", "escalate":
+	default:
+		return fmt.Errorf("Invalid escalation basis %s", tier.Basis)
+	}
+	switch tier.Notify {
+	case "assigner", "manager", "admins":
+	default:
+		return fmt.Errorf("Invalid escalation notify %s", tier.Notify)
+	}
+	return nil
+}
+
+func (t *TaskRPC) InsertEscalationTier(data shared.EscalationTierRPCData, id *int) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if err := validEscalationTier(data.Tier); err != nil {
+		return err
+	}
+
+	err := DB.InsertInto("escalation_tier").
+		Whitelist("level", "basis", "days", "notify", "sms", "email").
+		Record(data.Tier).
+		Returning("id").
+		QueryScalar(id)
+	if err != nil {
+		log.Println("Task.InsertEscalationTier:", err.Error())
+		return err
+	}
+
+	logger(start, "Task.InsertEscalationTier",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			data.Channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("ID %d Level %d %s %s", *id, data.Tier.Level, data.Tier.ShowBasis(), data.Tier.ShowNotify()),
+		data.Channel, conn.UserID, "escalation_tier", *id, true)
+
+	conn.BroadcastAdmin("escalation", "insert", *id)
+	return nil
+}
+
+func (t *TaskRPC) UpdateEscalationTier(data shared.EscalationTierRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if err := validEscalationTier(data.Tier); err != nil {
+		return err
+	}
+
+	_, err := DB.Update("escalation_tier").
+		SetWhitelist(data.Tier, "level", "basis", "days", "notify", "sms", "email").
+		Where("id = $1", data.ID).
+		Exec()
+	if err != nil {
+		log.Println("Task.UpdateEscalationTier:", err.Error())
+		return err
+	}
+
+	logger(start, "Task.UpdateEscalationTier",
+		fmt.Sprintf("Channel %d, ID %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Level %d %s %s", data.Tier.Level, data.Tier.ShowBasis(), data.Tier.ShowNotify()),
+		data.Channel, conn.UserID, "escalation_tier", data.ID, true)
+
+	conn.BroadcastAdmin("escalation", "update", data.ID)
+	*done = true
+	return nil
+}
+
+func (t *TaskRPC) DeleteEscalationTier(data shared.EscalationTierRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(`delete from escalation_tier where id=$1`, data.ID).Exec()
+
+	logger(start, "Task.DeleteEscalationTier",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			data.Channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("ID %d", data.ID),
+		data.Channel, conn.UserID, "escalation_tier", data.ID, true)
+
+	conn.BroadcastAdmin("escalation", "delete", data.ID)
+	*done = true
+	return nil
+}
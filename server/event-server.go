@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"itrak-cmms/shared"
+
+	runner "gopkg.in/mgutz/dat.v1/sqlx-runner"
 )
 
 type EventRPC struct{}
@@ -35,16 +37,33 @@ func (t *EventRPC) Raise(issue shared.RaiseIssue, id *int) error {
 		CreatedBy: conn.UserID,
 		Notes:     issue.Descr,
 		Priority:  1,
-		Status:    "Pending",
+		Status:    shared.StatusOpen,
+	}
+
+	// Create the event, photo and status changes as one transaction, so that
+	// a failure part way through does not leave the machine flagged with no event
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Event.Raise:", err.Error())
+		return err
 	}
+	defer tx.AutoRollback()
 
 	// Create the event record and get its ID
-	DB.InsertInto("event").
+	err = tx.InsertInto("event").
 		Whitelist("site_id", "type", "machine_id", "tool_id", "tool_type", "created_by",
 			"notes", "priority", "status").
 		Record(evt).
 		Returning("id").
-		QueryScalar(id)
+		QueryScalar(&evt.ID)
+	if err != nil {
+		log.Println("Event.Raise:", err.Error())
+		return err
+	}
+	if err = recordStatus(tx, "event", evt.ID, "", evt.Status, conn.UserID, ""); err != nil {
+		log.Println("Event.Raise:", err.Error())
+		return err
+	}
 
 	// Process the photo if present
 	if issue.Photo.Data != "" {
@@ -53,77 +72,64 @@ func (t *EventRPC) Raise(issue shared.RaiseIssue, id *int) error {
 			Data:     issue.Photo.Data,
 			Filename: issue.Photo.Filename,
 			Entity:   "event",
-			EntityID: *id,
+			EntityID: evt.ID,
 		}
 
 		// decodePhoto(photo.Data, &photo.Preview, &photo.Thumb)
-		decodePhoto(&photo)
-		DB.InsertInto("photo").
+		if err = decodePhoto(&photo); err != nil {
+			log.Println("Event.Raise:", err.Error())
+			return err
+		}
+		_, err = tx.InsertInto("photo").
 			Columns("entity", "entity_id", "photo", "thumb", "preview", "type", "datatype", "filename").
 			Record(photo).
 			Exec()
+		if err != nil {
+			log.Println("Event.Raise:", err.Error())
+			return err
+		}
 	}
 
-	// if issue.Photo.Data != "" {
-	// 	issue.Photo.Entity = "event"
-	// 	issue.Photo.EntityID = *id
-	// 	decodePhoto(&issue.Photo)
-	// 	DB.InsertInto("photo").
-	// 		Columns("entity", "entity_id", "photo", "thumb", "preview", "type", "datatype", "filename").
-	// 		Record(issue.Photo).
-	// 		Exec()
-	// }
-
-	conn.Broadcast("event", "insert", *id)
-
-	DB.SQL(`update machine 
+	_, err = tx.SQL(`update machine 
 			set alert_at=localtimestamp, status=$2 
 			where id=$1`,
 		issue.Machine.ID,
 		`Needs Attention`).
 		Exec()
-
-	conn.Broadcast("machine", "update", issue.Machine.ID)
-	conn.Broadcast("sitestatus", "update", 1)
+	if err != nil {
+		log.Println("Event.Raise:", err.Error())
+		return err
+	}
+	if err = machineStopped(tx, issue.Machine.ID); err != nil {
+		log.Println("Event.Raise:", err.Error())
+		return err
+	}
 
 	// if its a tool, then update the tool record, otherwise update the non-tool field on the machine record
 	if evt.ToolID == 0 {
-		// is a non-tool.
-		fieldName := ""
-		switch evt.ToolType {
-		case "Electrical":
-			fieldName = "electrical"
-		case "Hydraulic":
-			fieldName = "hydraulic"
-		case "Lube":
-			fieldName = "lube"
-		case "Printer":
-			fieldName = "printer"
-		case "Console":
-			fieldName = "console"
-		case "Uncoiler":
-			fieldName = "uncoiler"
-		case "Rollbed":
-			fieldName = "rollbed"
-		case "Pnuematic":
-			fieldName = "pnuematic"
-		case "Encoder":
-			fieldName = "encoder"
-		case "Conveyor":
-			fieldName = "conveyor"
-		case "StripGuide":
-			fieldName = "strip_guide"
-		}
-		if fieldName != "" {
-			DB.SQL(fmt.Sprintf("update machine set %s='Needs Attention' where id=$1", fieldName), evt.MachineID).Exec()
-		}
+		// is a non-tool, so flag the subsystem on the machine
+		err = setSubsystemStatus(tx, evt.MachineID, evt.ToolType, "Needs Attention")
 	} else {
 		// is a tool
-		DB.SQL(`update component
+		_, err = tx.SQL(`update component
 			set status='Needs Attention'
 			where id=$1`, evt.ToolID).
 			Exec()
 	}
+	if err != nil {
+		log.Println("Event.Raise:", err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Event.Raise:", err.Error())
+		return err
+	}
+	*id = evt.ID
+
+	conn.Broadcast("event", "insert", *id)
+	conn.Broadcast("machine", "update", issue.Machine.ID)
+	conn.Broadcast("sitestatus", "update", 1)
 
 	// Generate an SMS
 	// err = SendSMS("0417824950",
@@ -226,11 +232,7 @@ func (e *EventRPC) List(channel int, events *[]shared.Event) error {
 
 	// fetch all assignments
 	for i, v := range *events {
-		DB.SQL(`select u.username
-			from task t
-			left join users u on u.id=t.assigned_to
-			where t.event_id=$1`, v.ID).
-			QueryStructs(&v.AssignedTo)
+		v.AssignedTo = eventAssignees(v.ID)
 
 		// log.Println("assignments for event", v.ID, "=", v.AssignedTo)
 		(*events)[i].AssignedTo = v.AssignedTo
@@ -262,27 +264,6 @@ func (e *EventRPC) List(channel int, events *[]shared.Event) error {
 	return nil
 }
 
-func getSiteIDs(site string) []int {
-
-	retval := []int{}
-
-	switch site {
-	case "edinburgh":
-		DB.SQL(`select id from site where name like 'Edinburgh%'`).QuerySlice(&retval)
-	case "minto":
-		DB.SQL(`select id from site where name like 'Minto%'`).QuerySlice(&retval)
-	case "tomago":
-		DB.SQL(`select id from site where name like 'Tomago%'`).QuerySlice(&retval)
-	case "chinderah":
-		DB.SQL(`select id from site where name like 'Chinderah%'`).QuerySlice(&retval)
-	case "usa":
-		DB.SQL(`select id from site where name like 'Connecticut%'`).QuerySlice(&retval)
-	default:
-		println("dont know about site", site)
-	}
-	return retval
-}
-
 func (e *EventRPC) ListByMachineType(data shared.EventRPCData, events *[]shared.Event) error {
 	start := time.Now()
 
@@ -331,11 +312,7 @@ func (e *EventRPC) ListByMachineType(data shared.EventRPCData, events *[]shared.
 
 	// fetch all assignments
 	for i, v := range *events {
-		DB.SQL(`select u.username
-			from task t
-			left join users u on u.id=t.assigned_to
-			where t.event_id=$1`, v.ID).
-			QueryStructs(&v.AssignedTo)
+		v.AssignedTo = eventAssignees(v.ID)
 
 		// log.Println("assignments for event", v.ID, "=", v.AssignedTo)
 		(*events)[i].AssignedTo = v.AssignedTo
@@ -367,7 +344,7 @@ func (e *EventRPC) ListByMachineType(data shared.EventRPCData, events *[]shared.
 	return nil
 }
 
-// List active stoppages for a the given site, as expressed as a descriptive name
+// List active stoppages for the given site group
 func (e *EventRPC) ListSite(data shared.EventRPCData, events *[]shared.Event) error {
 	start := time.Now()
 
@@ -382,7 +359,7 @@ func (e *EventRPC) ListSite(data shared.EventRPCData, events *[]shared.Event) er
 			left join user_site x on x.user_id=$1 and x.site_id=e.site_id
 		where e.completed is null	
 		and e.site_id in $2
-		order by e.completed desc,e.startdate desc`, conn.UserID, getSiteIDs(data.Site)).
+		order by e.completed desc,e.startdate desc`, conn.UserID, getSites(data.Site)).
 		QueryStructs(events)
 
 	if err != nil {
@@ -391,11 +368,7 @@ func (e *EventRPC) ListSite(data shared.EventRPCData, events *[]shared.Event) er
 
 	// fetch all assignments
 	for i, v := range *events {
-		DB.SQL(`select u.username
-			from task t
-			left join users u on u.id=t.assigned_to
-			where t.event_id=$1`, v.ID).
-			QueryStructs(&v.AssignedTo)
+		v.AssignedTo = eventAssignees(v.ID)
 
 		// log.Println("assignments for event", v.ID, "=", v.AssignedTo)
 		(*events)[i].AssignedTo = v.AssignedTo
@@ -473,11 +446,7 @@ func (e *EventRPC) ListCompleted(channel int, events *[]shared.Event) error {
 
 	// fetch all assignments
 	for i, v := range *events {
-		DB.SQL(`select u.username
-			from task t
-			left join users u on u.id=t.assigned_to
-			where t.event_id=$1`, v.ID).
-			QueryStructs(&v.AssignedTo)
+		v.AssignedTo = eventAssignees(v.ID)
 
 		// log.Println("assignments for event", v.ID, "=", v.AssignedTo)
 		(*events)[i].AssignedTo = v.AssignedTo
@@ -527,11 +496,7 @@ func (e *EventRPC) Get(data shared.EventRPCData, event *shared.Event) error {
 	}
 
 	// fetch all assignments
-	DB.SQL(`select u.username
-			from task t
-			left join users u on u.id=t.assigned_to
-			where t.event_id=$1`, id).
-		QueryStructs(&event.AssignedTo)
+	event.AssignedTo = eventAssignees(id)
 
 	// fetch all tasks
 	DB.SQL(`select t.*,u.username as username
@@ -606,88 +571,47 @@ func (e *EventRPC) Complete(data shared.EventRPCData, done *bool) error {
 
 	// Read the sites that this user has access to
 	event := shared.Event{}
-	DB.SQL(`select
+	err := DB.SQL(`select
 		e.*,m.name as machine_name,s.name as site_name,u.username as username
 		from event e
 			left join machine m on m.id=e.machine_id
 			left join site s on s.id=m.site_id
 			left join users u on u.id=e.created_by
 		where e.id=$1`, data.Event.ID).QueryStruct(&event)
+	if err != nil {
+		log.Println("Event.Complete:", err.Error())
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Event.Complete:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
 
 	// Mark the event as complete
-	DB.SQL(`update event 
-		set completed=now(), status='Complete'
+	_, err = tx.SQL(`update event 
+		set completed=now()
 		where id=$1`, data.Event.ID).Exec()
+	if err != nil {
+		log.Println("Event.Complete:", err.Error())
+		return err
+	}
+	_, err = setStatus(tx, "event", data.Event.ID, shared.StatusCompleted, conn.UserID, "Manually Completed by Admin")
+	if err != nil {
+		log.Println("Event.Complete:", err.Error())
+		return err
+	}
 
-	// Reset the affected component - this code is the reverse of
-	// the code in the RaiseEvent function above
-	if event.ToolID == 0 {
-		// Reset the status of the basic component on this machine
-		fieldName := ""
-		switch event.ToolType {
-		case "Electrical":
-			fieldName = "electrical"
-		case "Hydraulic":
-			fieldName = "hydraulic"
-		case "Lube":
-			fieldName = "lube"
-		case "Printer":
-			fieldName = "printer"
-		case "Console":
-			fieldName = "console"
-		case "Uncoiler":
-			fieldName = "uncoiler"
-		case "Rollbed":
-			fieldName = "rollbed"
-		case "Pnuematic":
-			fieldName = "pnuematic"
-		case "Conveyor":
-			fieldName = "conveyor"
-		case "Encoder":
-			fieldName = "encoder"
-		case "StripGuide":
-			fieldName = "strip_guide"
-		}
-		if fieldName != "" {
-			DB.SQL(fmt.Sprintf("update machine set %s='Running' where id=$1", fieldName), event.MachineID).Exec()
-		}
-	} else {
-		// is a tool
-		DB.SQL(`update component
-			set status='Running'
-			where id=$1`, event.ToolID).
-			Exec()
+	if _, err = clearEventMachine(tx, &event); err != nil {
+		log.Println("Event.Complete:", err.Error())
+		return err
 	}
 
-	// Reset the whole machine if clear
-	machineIsClear := true
-	machine := shared.Machine{}
-	DB.SQL(`select * from machine where id=$1`, event.MachineID).QueryStruct(&machine)
-
-	if machine.Electrical != "Running" ||
-		machine.Hydraulic != "Running" ||
-		machine.Printer != "Running" ||
-		machine.Console != "Running" ||
-		machine.Rollbed != "Running" ||
-		machine.Uncoiler != "Running" ||
-		machine.Pnuematic != "Running" ||
-		machine.Encoder != "Running" ||
-		machine.StripGuide != "Running" ||
-		machine.Conveyor != "Running" ||
-		machine.Lube != "Running" {
-		machineIsClear = false
-	}
-
-	if machineIsClear {
-		badComps := 0
-		DB.SQL(`select count(*) 
-			from component 
-			where status != 'Running' and machine_id=$1`, machine.ID).
-			QueryScalar(&badComps)
-
-		if badComps == 0 {
-			DB.SQL("update machine set status='Running' where id=$1", event.MachineID).Exec()
-		}
+	if err = tx.Commit(); err != nil {
+		log.Println("Event.Complete:", err.Error())
+		return err
 	}
 
 	logger(start, "Event.Complete",
@@ -697,11 +621,52 @@ func (e *EventRPC) Complete(data shared.EventRPCData, done *bool) error {
 		data.Channel, conn.UserID, "event", data.Event.ID, true)
 
 	conn.Broadcast("event", "update", data.Event.ID)
+	conn.Broadcast("machine", "update", event.MachineID)
 	conn.Broadcast("sitestatus", "update", 1)
 
+	*done = true
 	return nil
 }
 
+// Reset the component or subsystem that the event was raised against - this is the
+// reverse of EventRPC.Raise - and then the whole machine if nothing else on it is
+// still waiting on a fix. Returns true if the machine is back to Running.
+func clearEventMachine(tx *runner.Tx, event *shared.Event) (bool, error) {
+	var err error
+	if event.ToolID == 0 {
+		err = setSubsystemStatus(tx, event.MachineID, event.ToolType, "Running")
+	} else {
+		_, err = tx.SQL(`update component
+			set status='Running'
+			where id=$1`, event.ToolID).
+			Exec()
+	}
+	if err != nil {
+		return false, err
+	}
+
+	clear, err := subsystemsClear(tx, event.MachineID)
+	if err != nil || !clear {
+		return false, err
+	}
+
+	badComps := 0
+	err = tx.SQL(`select count(*) 
+		from component 
+		where status != 'Running' and machine_id=$1`, event.MachineID).
+		QueryScalar(&badComps)
+	if err != nil || badComps > 0 {
+		return false, err
+	}
+
+	_, err = tx.SQL("update machine set status='Running' where id=$1", event.MachineID).Exec()
+	if err != nil {
+		return false, err
+	}
+	err = machineStarted(tx, event.MachineID)
+	return err == nil, err
+}
+
 // Add a site
 func (e *EventRPC) Workorder(data shared.AssignEvent, id *int) error {
 	start := time.Now()
@@ -738,19 +703,58 @@ func (e *EventRPC) Workorder(data shared.AssignEvent, id *int) error {
 		AssignedDate: &now,
 		LabourEst:    data.LabourEst,
 		MaterialEst:  data.MaterialEst,
+		Status:       shared.StatusAssigned,
 	}
 	// log.Printf("task is %v", task)
 
-	DB.InsertInto("task").
+	// The task, its photo and the event status all go in together, or not at all
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Event.Workorder:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	err = tx.InsertInto("task").
 		Whitelist("machine_id", "sched_id", "event_id", "comp_type", "tool_id", "component",
 			"descr", "startdate", "due_date", "escalate_date",
 			"assigned_by", "assigned_to", "assigned_date",
-			"labour_est", "material_est").
+			"labour_est", "material_est", "status").
 		Record(&task).
 		Returning("id").
 		QueryScalar(&task.ID)
+	if err != nil {
+		log.Println("Event.Workorder:", err.Error())
+		return err
+	}
+	if err = recordStatus(tx, "task", task.ID, "", task.Status, conn.UserID, ""); err != nil {
+		log.Println("Event.Workorder:", err.Error())
+		return err
+	}
 
-	*id = task.ID
+	// Put the whole crew on the task, with the assigned technician as the lead
+	crew := []int{}
+	for _, userID := range append([]int{data.AssignTo}, data.Crew...) {
+		if userID != 0 && !containsInt(crew, userID) {
+			crew = append(crew, userID)
+		}
+	}
+	for _, userID := range crew {
+		if err = addTaskTech(tx, task.ID, userID, false); err != nil {
+			log.Println("Event.Workorder:", err.Error())
+			return err
+		}
+	}
+
+	// The skill picked when raising the work order is what the task needs
+	if data.SkillID != 0 {
+		_, err = tx.SQL(`insert into task_skill (task_id,skill_id,level)
+			values ($1,$2,1)`, task.ID, data.SkillID).Exec()
+		if err != nil {
+			log.Println("Event.Workorder:", err.Error())
+			return err
+		}
+	}
 
 	// if data.Photo.Data != "" {
 	// 	data.Photo.Entity = "task"
@@ -774,15 +778,41 @@ func (e *EventRPC) Workorder(data shared.AssignEvent, id *int) error {
 		}
 
 		// decodePhoto(photo.Data, &photo.Preview, &photo.Thumb)
-		decodePhoto(&photo)
-		DB.InsertInto("photo").
+		if err = decodePhoto(&photo); err != nil {
+			log.Println("Event.Workorder:", err.Error())
+			return err
+		}
+		_, err = tx.InsertInto("photo").
 			Columns("entity", "entity_id", "photo", "thumb", "preview", "type", "datatype", "filename").
 			Record(photo).
 			Exec()
+		if err != nil {
+			log.Println("Event.Workorder:", err.Error())
+			return err
+		}
 	}
 
-	// Stamp the event as assigned
-	DB.SQL(`update event set status='Assigned' where id=$1`, data.Event.ID).Exec()
+	// Stamp the event as assigned, unless it has already moved past that
+	eventStatus := ""
+	err = tx.SQL(`select status from event where id=$1`, data.Event.ID).QueryScalar(&eventStatus)
+	if err != nil {
+		log.Println("Event.Workorder:", err.Error())
+		return err
+	}
+	if eventStatus == shared.StatusOpen {
+		_, err = setStatus(tx, "event", data.Event.ID, shared.StatusAssigned, conn.UserID,
+			fmt.Sprintf("Task %06d", task.ID))
+		if err != nil {
+			log.Println("Event.Workorder:", err.Error())
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Event.Workorder:", err.Error())
+		return err
+	}
+	*id = task.ID
 
 	if false {
 		print("TODO - all this code here is redundant - apply bits that are needed, and kill the rest")
@@ -843,7 +873,7 @@ func (e *EventRPC) Workorder(data shared.AssignEvent, id *int) error {
 		}
 	}
 
-	// Now generate an SMS to the technician
+	// Now generate an SMS to each of the crew
 	// smsMsg := fmt.Sprintf("New Workorder at %s for Machine %s : %s",
 	// 	data.SiteName,
 	// 	data.MachineName,
@@ -858,19 +888,7 @@ func (e *EventRPC) Workorder(data shared.AssignEvent, id *int) error {
 		data.MachineName,
 		data.ToolType)
 
-	phoneNumber := ""
-	DB.SQL(`select sms from users where id=$1`, data.AssignTo).QueryScalar(&phoneNumber)
-
-	if Config.SMSOn {
-
-		if phoneNumber != "" {
-			SendSMS(phoneNumber, smsMsg, fmt.Sprintf("%d", task.ID), data.AssignTo)
-		} else {
-			log.Println("No Phone Number for SMS:", smsMsg)
-		}
-	} else {
-		log.Println("Will send SMS:", smsMsg, "to", phoneNumber)
-	}
+	notifyCrew(task.ID, crew, smsMsg)
 
 	if false {
 		// HET - yactn are no longer tightly coupled to the 3aAaya
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"itrak-cmms/shared"
+)
+
+// Dry run of the scheduler, for the maintenance calendar and budgeting.
+//
+// The date based schedules use the same rules as schedTaskScan. Job Count and
+// Every N Hours schedules are projected forward from the jobs and running hours
+// over the last ForecastDays, so they are only as good as the recent history.
+
+const MaxForecastPerSched = 1000 // stop runaway projections on very short usage intervals
+
+type forecastByDate []shared.ForecastTask
+
+func (f forecastByDate) Len() int           { return len(f) }
+func (f forecastByDate) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f forecastByDate) Less(i, j int) bool { return f[i].StartDate.Before(f[j].StartDate) }
+
+// Project usage based occurrences, given the usage so far, the usage between
+// tasks, and the average daily usage
+func usageOccurrences(st shared.SchedTask, used float64, every float64, daily float64, from time.Time, to time.Time) []schedOccurrence {
+	occurs := []schedOccurrence{}
+	if daily <= 0 || every <= 0 {
+		return occurs
+	}
+
+	days := (every - used) / daily
+	if days < 0 {
+		days = 0
+	}
+	day := float64(24 * time.Hour)
+	nextDate := time.Now().Add(time.Duration(days * day))
+	step := time.Duration(every / daily * day)
+
+	for !nextDate.After(to) && len(occurs) < MaxForecastPerSched {
+		if !nextDate.Before(from) {
+			occurs = append(occurs, schedOccurrence{nextDate, nextDate.AddDate(0, 0, st.DurationDays)})
+		}
+		nextDate = nextDate.Add(step)
+	}
+	return occurs
+}
+
+// Get the expected occurrences of any sched task between the 2 dates
+func forecastOccurrences(st shared.SchedTask, from time.Time, to time.Time) []schedOccurrence {
+	now := time.Now()
+	historyStart := now.AddDate(0, 0, -ForecastDays)
+
+	switch st.Freq {
+	case "Job Count":
+		if st.Count == nil {
+			return nil
+		}
+		jobs := 0.0
+		DB.SQL(`select coalesce(sum(count),0)
+			from machine_jobcount
+			where machine_id=$1 and logged > $2`, st.MachineID, historyStart).QueryScalar(&jobs)
+		jobsRun, _ := schedJobsRun(&st)
+		return usageOccurrences(st, float64(jobsRun), float64(*st.Count+1), jobs/ForecastDays, from, to)
+	case "Every N Hours":
+		if st.Hours == nil {
+			return nil
+		}
+		hoursRun, _ := schedHoursRun(&st)
+		daily := runtimeHours(st.MachineID, historyStart, now) / ForecastDays
+		return usageOccurrences(st, hoursRun, float64(*st.Hours), daily, from, to)
+	}
+	return schedOccurrences(st, from, to)
+}
+
+// Get every task that the scheduler is expected to generate between the 2 dates, without generating anything
+func (t *TaskRPC) Forecast(data shared.ForecastRequest, tasks *[]shared.ForecastTask) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	// only the future can be forecast, anything earlier has already been generated
+	today := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.Local)
+	from := data.From
+	if from.Before(today) {
+		from = today
+	}
+	if data.To.Before(from) {
+		return errors.New("Forecast: the To date is before the From date")
+	}
+
+	sql := `select t.*
+		from sched_task t
+		left join machine m on m.id=t.machine_id
+		where t.paused=false`
+	args := []interface{}{}
+	if data.MachineID != 0 {
+		args = append(args, data.MachineID)
+		sql += fmt.Sprintf(" and t.machine_id=$%d", len(args))
+	}
+	if data.SiteID != 0 {
+		args = append(args, data.SiteID)
+		sql += fmt.Sprintf(" and m.site_id=$%d", len(args))
+	}
+	if conn.UserRole == "Site Manager" {
+		args = append(args, conn.UserID)
+		sql += fmt.Sprintf(" and m.site_id in (select site_id from user_site where user_id=$%d)", len(args))
+	}
+	sql += " order by t.id"
+
+	scheds := []shared.SchedTask{}
+	DB.SQL(sql, args...).QueryStructs(&scheds)
+
+	machines := make(map[int]shared.Machine)
+	usernames := make(map[int]string)
+	*tasks = []shared.ForecastTask{}
+
+	for _, st := range scheds {
+		occurs := forecastOccurrences(st, from, data.To)
+		if len(occurs) == 0 {
+			continue
+		}
+
+		machine, ok := machines[st.MachineID]
+		if !ok {
+			DB.SQL(`select m.id,m.name,m.site_id,s.name as site_name
+				from machine m
+				left join site s on s.id=m.site_id
+				where m.id=$1`, st.MachineID).QueryStruct(&machine)
+			machines[st.MachineID] = machine
+		}
+		siteName := ""
+		if machine.SiteName != nil {
+			siteName = *machine.SiteName
+		}
+
+		userID := schedUser(st)
+		username, ok := usernames[userID]
+		if !ok {
+			DB.SQL(`select username from users where id=$1`, userID).QueryScalar(&username)
+			usernames[userID] = username
+		}
+
+		cal := machineCalendar(st.MachineID)
+		for _, o := range occurs {
+			startDate := cal.NextWorkday(o.StartDate)
+			dueDate := cal.NextWorkday(o.DueDate)
+			if dueDate.Before(startDate) {
+				dueDate = startDate
+			}
+			*tasks = append(*tasks, shared.ForecastTask{
+				SchedID:     st.ID,
+				MachineID:   st.MachineID,
+				MachineName: machine.Name,
+				SiteID:      machine.SiteID,
+				SiteName:    siteName,
+				Component:   st.Component,
+				Descr:       st.Descr,
+				Freq:        st.ShowFrequency(),
+				UserID:      userID,
+				Username:    username,
+				StartDate:   startDate,
+				DueDate:     dueDate,
+				LabourEst:   st.LabourCost,
+				MaterialEst: st.MaterialCost,
+			})
+		}
+	}
+	sort.Sort(forecastByDate(*tasks))
+
+	logger(start, "Task.Forecast",
+		fmt.Sprintf("Channel %d, %s to %s Site %d Machine %d User %d %s %s",
+			data.Channel, from.Format(rfc3339DateLayout), data.To.Format(rfc3339DateLayout),
+			data.SiteID, data.MachineID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d scheds %d tasks", len(scheds), len(*tasks)),
+		data.Channel, conn.UserID, "sched_task", 0, false)
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/rpc"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// JSON-RPC 2.0 codec for the /ws endpoint, for tooling that cannot speak gob.
+//
+// A client selects it by asking for the "jsonrpc" websocket subprotocol, or
+// by connecting to /ws?codec=jsonrpc. Params may be given as a single element
+// array or as the arg object itself. Pushes from Connection.Send arrive as
+// notifications, with the AsyncMessage as params.
+
+const JSONRPCProtocol = "jsonrpc"
+
+// Accept the jsonrpc subprotocol if asked for, without insisting on an Origin header
+func wsHandshake(config *websocket.Config, req *http.Request) error {
+	var err error
+	config.Origin, err = websocket.Origin(config, req)
+	if err != nil {
+		return err
+	}
+	for _, p := range config.Protocol {
+		if p == JSONRPCProtocol {
+			config.Protocol = []string{JSONRPCProtocol}
+			return nil
+		}
+	}
+	if len(config.Protocol) > 1 {
+		config.Protocol = config.Protocol[:1]
+	}
+	return nil
+}
+
+// Is this socket using the JSON-RPC codec rather than gob
+func isJSONRPC(ws *websocket.Conn) bool {
+	for _, p := range ws.Config().Protocol {
+		if p == JSONRPCProtocol {
+			return true
+		}
+	}
+	return ws.Request().URL.Query().Get("codec") == JSONRPCProtocol
+}
+
+type jsonRequest struct {
+	Version string           `json:"jsonrpc"`
+	Method  string           `json:"method"`
+	Params  *json.RawMessage `json:"params"`
+	ID      *json.RawMessage `json:"id"`
+}
+
+type jsonError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type jsonNotification struct {
+	Version string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+var errNoParams = errors.New("jsonrpc: no params")
+
+type jsonServerCodec struct {
+	rwc  io.ReadWriteCloser
+	conn *Connection
+	dec  *json.Decoder
+	enc  *json.Encoder
+	req  jsonRequest
+
+	// net/rpc wants a uint64 sequence, so map them back to the client's id
+	seqMutex sync.Mutex
+	seq      uint64
+	pending  map[uint64]*json.RawMessage
+}
+
+func newJSONServerCodec(ws *websocket.Conn, conn *Connection) *jsonServerCodec {
+	return &jsonServerCodec{
+		rwc:     ws,
+		conn:    conn,
+		dec:     json.NewDecoder(ws),
+		enc:     conn.jenc,
+		pending: make(map[uint64]*json.RawMessage),
+	}
+}
+
+// On receiving a new request, lock the connection until the whole RPC call has finished
+func (c *jsonServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	c.req = jsonRequest{}
+	err := c.dec.Decode(&c.req)
+	if err != nil {
+		log.Println("Dropped Connection:", err.Error(), ", connection:", c.conn.ID)
+		Connections.Drop(c.conn)
+		return err
+	}
+	c.conn.Mutex.Lock()
+
+	r.ServiceMethod = c.req.Method
+
+	c.seqMutex.Lock()
+	c.seq++
+	c.pending[c.seq] = c.req.ID
+	r.Seq = c.seq
+	c.seqMutex.Unlock()
+	return nil
+}
+
+func (c *jsonServerCodec) ReadRequestBody(body interface{}) error {
+	if body == nil {
+		return nil
+	}
+	if c.req.Params == nil {
+		return errNoParams
+	}
+
+	// Unwrap a single element params array
+	raw := *c.req.Params
+	if strings.HasPrefix(strings.TrimSpace(string(raw)), "[") {
+		params := []json.RawMessage{}
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return err
+		}
+		if len(params) != 1 {
+			return fmt.Errorf("jsonrpc: expected 1 param, got %d", len(params))
+		}
+		raw = params[0]
+	}
+	if err := json.Unmarshal(raw, body); err != nil {
+		return err
+	}
+	pinChannel(c.conn, body)
+	return authorize(c.conn, c.req.Method, body)
+}
+
+func (c *jsonServerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	// as soon as we are done, unlock the connection Mutex
+	defer c.conn.Mutex.Unlock()
+
+	c.seqMutex.Lock()
+	id, ok := c.pending[r.Seq]
+	delete(c.pending, r.Seq)
+	c.seqMutex.Unlock()
+	if !ok {
+		return errors.New("jsonrpc: invalid sequence number in response")
+	}
+	if id == nil {
+		// client sent a notification, so does not want a reply
+		return nil
+	}
+
+	// result and error are mutually exclusive, and result must be present even if empty
+	resp := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+	}
+	if r.Error == "" {
+		resp["result"] = body
+	} else {
+		code := -32000
+		if strings.HasPrefix(r.Error, "rpc: can't find") {
+			code = -32601
+		}
+		resp["error"] = jsonError{Code: code, Message: r.Error}
+	}
+	return c.enc.Encode(resp)
+}
+
+func (c *jsonServerCodec) Close() error {
+	return c.rwc.Close()
+}
@@ -2,15 +2,21 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
+	"golang.org/x/crypto/bcrypt"
+
 	"itrak-cmms/shared"
 )
 
 type LoginRPC struct{}
 
+var errPasswd = errors.New("Invalid username or password")
+
 type dbLoginResponse struct {
 	ID          int            `db:"id"`
 	Username    string         `db:"username"`
@@ -19,6 +25,24 @@ type dbLoginResponse struct {
 	SiteID      int            `db:"site_id"`
 	SiteName    sql.NullString `db:"sitename"`
 	CanAllocate bool           `db:"can_allocate"`
+	Passwd      string         `db:"passwd"`
+}
+
+// Generate a salted bcrypt hash of the given plaintext password
+func hashPasswd(passwd string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(passwd), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Check a plaintext password against the stored bcrypt hash
+func checkPasswd(hash string, passwd string) bool {
+	if hash == "" || passwd == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(passwd)) == nil
 }
 
 func (l *LoginRPC) Nav(data shared.Nav, r *string) error {
@@ -26,12 +50,23 @@ func (l *LoginRPC) Nav(data shared.Nav, r *string) error {
 	conn.Routes = append(conn.Routes, data.Route)
 	conn.Route = data.Route
 	*r = conn.Route
+	conn.ClearSubscriptions(data.Route)
+	saveSessionRoutes(conn)
 	println("\n----------------------------------")
 	log.Printf("%s:%s -> %s\n", conn.Username, conn.UserRole, conn.Route)
 	conn.BroadcastAdmin("nav", data.Route, data.Channel)
 	return nil
 }
 
+// Subscribe this connection to a topic of async messages, for the page at the given route
+func (l *LoginRPC) Subscribe(data shared.SubscribeRequest, done *bool) error {
+	conn := Connections.Get(data.Channel)
+	conn.Subscribe(data.Topic, data.Route)
+	log.Printf("%s:%s subscribe %s on %s\n", conn.Username, conn.UserRole, data.Topic, data.Route)
+	*done = true
+	return nil
+}
+
 func (l *LoginRPC) Login(lc *shared.LoginCredentials, lr *shared.LoginReply) error {
 	start := time.Now()
 
@@ -47,20 +82,18 @@ func (l *LoginRPC) Login(lc *shared.LoginCredentials, lr *shared.LoginReply) err
 		// validate that username and passwd is correct
 		res := &dbLoginResponse{}
 
-		// log.Println(`select u.id,u.username,u.name,u.role,u.site_id,s.name as sitename
-		// 	from users u
-		// 	left join site s on (s.id = u.site_id)
-		// 	where lower(u.username) = lower('`, lc.Username, `') and lower(passwd) = lower('`, lc.Password, `')`)
-
 		err := DB.
-			Select("u.id,u.username,u.name,u.role,u.site_id,s.name as sitename,u.can_allocate as can_allocate").
+			Select("u.id,u.username,u.name,u.role,u.site_id,s.name as sitename,u.can_allocate as can_allocate,u.passwd").
 			From(`users u
 			left join site s on (s.id = u.site_id)`).
-			Where("lower(u.username) = lower($1) and lower(passwd) = lower($2)",
-				lc.Username, lc.Password).
+			Where("lower(u.username) = lower($1)", lc.Username).
 			QueryStruct(res)
 		// log.Println(res)
 
+		if err == nil && !checkPasswd(res.Passwd, lc.Password) {
+			err = errPasswd
+		}
+
 		if err != nil {
 			log.Println("Login Failed:", err.Error())
 			lr.Result = "Failed"
@@ -72,7 +105,8 @@ func (l *LoginRPC) Login(lc *shared.LoginCredentials, lr *shared.LoginReply) err
 		} else {
 			// log.Println("Login OK")
 			lr.Result = "OK"
-			lr.Token = fmt.Sprintf("%d", lc.Channel)
+			lr.Token, conn.SessionID = newSession(res.ID, lc.RememberMe)
+			lr.Username = res.Username
 
 			//lr.Menu = []string{"RPC Dashboard", "Events", "Sites", "Machines", "Tools", "Parts", "Vendors", "Users", "Skills", "Reports"}
 			// lr.Menu = getMenu(res.Role)
@@ -90,13 +124,87 @@ func (l *LoginRPC) Login(lc *shared.LoginCredentials, lr *shared.LoginReply) err
 	}
 
 	logger(start, "Login.Login",
-		fmt.Sprintf("%s,%s,%t,%d", lc.Username, lc.Password, lc.RememberMe, lc.Channel),
+		fmt.Sprintf("%s,%t,%d", lc.Username, lc.RememberMe, lc.Channel),
 		fmt.Sprintf("%s,%s,%s", lr.Result, lr.Role, lr.Site),
 		lc.Channel, lr.ID, "users", lr.ID, false)
 
 	return nil
 }
 
+// Rebind a fresh connection to the user, role and route history of an existing session
+func (l *LoginRPC) Resume(data shared.ResumeRequest, lr *shared.LoginReply) error {
+	start := time.Now()
+
+	lr.Result = "Failed"
+	conn := Connections.Get(data.Channel)
+	if conn != nil {
+		res := &dbLoginResponse{}
+		s, err := getSession(data.Token)
+		if err == nil {
+			err = DB.
+				Select("u.id,u.username,u.name,u.role,u.site_id,s.name as sitename,u.can_allocate as can_allocate").
+				From(`users u
+				left join site s on (s.id = u.site_id)`).
+				Where("u.id = $1", s.UserID).
+				QueryStruct(res)
+		}
+
+		if err != nil {
+			log.Println("Resume Failed:", err.Error())
+		} else {
+			lr.Result = "OK"
+			lr.Token = data.Token
+			lr.Username = res.Username
+			lr.Routes = getRoutes(res.ID, res.Role)
+			lr.Role = res.Role
+			lr.ID = res.ID
+			lr.CanAllocate = res.CanAllocate
+			if res.SiteName.Valid {
+				lr.Site = res.SiteName.String
+			}
+			lr.Route = s.Route
+			conn.Login(res.Username, res.ID, res.Role)
+			conn.SessionID = s.ID
+			conn.Route = s.Route
+			if s.Routes != "" {
+				conn.Routes = strings.Split(s.Routes, "\n")
+			}
+			Connections.Show("connections after resume")
+			conn.Broadcast("login", "insert", lr.ID)
+		}
+	}
+
+	logger(start, "Login.Resume",
+		fmt.Sprintf("%d", data.Channel),
+		fmt.Sprintf("%s,%s,%s,%s", lr.Result, lr.Username, lr.Role, lr.Route),
+		data.Channel, lr.ID, "users", lr.ID, false)
+
+	return nil
+}
+
+// End the session on this connection, so the token can no longer be resumed
+func (l *LoginRPC) Logout(channel int, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(channel)
+	if conn == nil {
+		return nil
+	}
+	if conn.SessionID != 0 {
+		dropSession(conn.SessionID)
+		conn.SessionID = 0
+		*done = true
+	}
+
+	logger(start, "Login.Logout",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%t", *done),
+		channel, conn.UserID, "users", conn.UserID, false)
+
+	return nil
+}
+
 func (l *LoginRPC) UsersOnline(channel int, u *[]shared.UserOnline) error {
 	start := time.Now()
 	conn := Connections.Get(channel)
@@ -105,15 +213,19 @@ func (l *LoginRPC) UsersOnline(channel int, u *[]shared.UserOnline) error {
 		for _, k := range Connections.Keys() {
 			v := Connections.Get(k)
 			println("k,v = ", k, v)
-			req := v.Socket.Request()
-			theIP := ""
-			if theIP = req.Header.Get("X-Real-Ip"); theIP == "" {
-				theIP = req.RemoteAddr
+			theIP := "API"
+			browser := "REST API"
+			if v.Socket != nil {
+				req := v.Socket.Request()
+				if theIP = req.Header.Get("X-Real-Ip"); theIP == "" {
+					theIP = req.RemoteAddr
+				}
+				browser = fmt.Sprintf("%s", req.Header["User-Agent"])
 			}
 			user := shared.UserOnline{
 				ID:          v.UserID,
 				Username:    v.Username,
-				Browser:     fmt.Sprintf("%s", req.Header["User-Agent"]),
+				Browser:     browser,
 				IP:          theIP,
 				Name:        "lookup",
 				Email:       "lookup",
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"itrak-cmms/shared"
+
+	runner "gopkg.in/mgutz/dat.v1/sqlx-runner"
 )
 
 type MachineRPC struct{}
@@ -52,12 +54,17 @@ func (m *MachineRPC) Get(data shared.MachineRPCData, machine *shared.Machine) er
 	// 	OrderBy("position,zindex,lower(name)").
 	// 	QueryStructs(&machine.Components)
 
-	// fetch some basic info, flags and thumbnail from the parent machine type
-	DB.Select(`name,photo_thumbnail,electrical,hydraulic,pnuematic,lube,printer,console,uncoiler,rollbed,conveyor,encoder,strip_guide`).
+	// fetch some basic info and thumbnail from the parent machine type
+	DB.Select(`name,photo_thumbnail`).
 		From(`machine_type`).
 		Where(`id=$1`, machine.MachineType).
 		QueryStruct(&machine.MachineTypeData)
 
+	// and the status of each subsystem
+	machineSubsystems(machine)
+
+	machine.RunHours = machineHours(machine.ID, time.Now())
+
 	logger(start, "Machine.Get",
 		fmt.Sprintf("%d", data.ID),
 		machine.Name,
@@ -167,6 +174,9 @@ func (m *MachineRPC) Delete(data shared.MachineRPCData, ok *bool) error {
 	DB.DeleteFrom("machine").
 		Where("id=$1", id).
 		Exec()
+	DB.DeleteFrom("machine_subsystem").
+		Where("machine_id=$1", id).
+		Exec()
 
 	logger(start, "Machine.Delete",
 		fmt.Sprintf("Channel %d, Machine %d, User %d %s %s",
@@ -184,10 +194,13 @@ func (m *MachineRPC) MachineTypes(data shared.MachineRPCData, machineTypes *[]sh
 	// log.Println("here", data)
 	conn := Connections.Get(data.Channel)
 
-	DB.Select(`id,name,photo_thumbnail,
-		electrical,hydraulic,pnuematic,lube,printer,console,uncoiler,rollbed,conveyor,encoder,strip_guide`).
+	DB.Select(`id,name,photo_thumbnail`).
 		From(`machine_type`).OrderBy(`name`).QueryStructs(machineTypes)
 
+	for k := range *machineTypes {
+		machineTypeSubsystems(&(*machineTypes)[k])
+	}
+
 	logger(start, "Machine.MachineTypes",
 		fmt.Sprintf("Channel %d, User %d %s %s",
 			data.Channel, conn.UserID, conn.Username, conn.UserRole),
@@ -204,12 +217,13 @@ func (m *MachineRPC) GetMachineType(data shared.MachineTypeRPCData, machineType
 	conn := Connections.Get(data.Channel)
 	// log.Println("conn", conn)
 
-	DB.Select(`id,name,photo_preview,
-		electrical,hydraulic,pnuematic,lube,printer,console,uncoiler,rollbed,conveyor,encoder,strip_guide`).
+	DB.Select(`id,name,photo_preview`).
 		From(`machine_type`).
 		Where(`id=$1`, data.ID).
 		QueryStruct(machineType)
 
+	machineTypeSubsystems(machineType)
+
 	// fetch the tool count
 	DB.SQL(`select count(*) as num_tools from machine_type_tool where machine_id=$1`, data.ID).
 		QueryScalar(&machineType.NumTools)
@@ -238,12 +252,7 @@ func (m *MachineRPC) UpdateMachineType(data shared.MachineTypeRPCData, done *boo
 	// log.Println("conn", conn)
 
 	DB.Update("machine_type").
-		SetWhitelist(data.MachineType,
-			"name",
-			"electrical", "hydraulic", "pnuematic",
-			"console", "printer", "lube",
-			"uncoiler", "rollbed", "conveyor",
-			"encoder", "strip_guide").
+		SetWhitelist(data.MachineType, "name").
 		Where("id = $1", data.ID).
 		Exec()
 
@@ -265,6 +274,7 @@ func (m *MachineRPC) DeleteMachineType(data shared.MachineTypeRPCData, done *boo
 	// log.Println("conn", conn)
 
 	DB.SQL(`delete from machine_type where id=$1`, data.ID).Exec()
+	DB.SQL(`delete from machine_type_subsystem where machine_type_id=$1`, data.ID).Exec()
 
 	logger(start, "Machine.DeleteMachineType",
 		fmt.Sprintf("Channel %d, User %d %s %s",
@@ -284,11 +294,7 @@ func (m *MachineRPC) InsertMachineType(data shared.MachineTypeRPCData, id *int)
 	// log.Println("conn", conn)
 
 	DB.InsertInto("machine_type").
-		Columns("name",
-			"electrical", "hydraulic", "pnuematic",
-			"console", "printer", "lube",
-			"uncoiler", "rollbed", "conveyor",
-			"encoder", "strip_guide").
+		Columns("name").
 		Record(data.MachineType).
 		Returning("id").
 		QueryScalar(id)
@@ -453,7 +459,34 @@ func (m *MachineRPC) StartStop(data shared.MachineRPCData, newStatus *string) er
 		*newStatus = "Running"
 	}
 
-	DB.SQL(`update machine set status=$2 where id=$1`, data.ID, *newStatus).Exec()
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Machine.StartStop:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	_, err = tx.SQL(`update machine set status=$2 where id=$1`, data.ID, *newStatus).Exec()
+	if err != nil {
+		log.Println("Machine.StartStop:", err.Error())
+		return err
+	}
+
+	// Keep the runtime clock going only while the machine is Running
+	if *newStatus == "Running" {
+		err = machineStarted(tx, data.ID)
+	} else {
+		err = machineStopped(tx, data.ID)
+	}
+	if err != nil {
+		log.Println("Machine.StartStop:", err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Machine.StartStop:", err.Error())
+		return err
+	}
 
 	logger(start, "Machine.StartStop",
 		fmt.Sprintf("Channel %d, User %d %s %s",
@@ -506,6 +539,273 @@ func (m *MachineRPC) InsertMachineTypeTool(data shared.MachineTypeToolRPCData, i
 	return nil
 }
 
+// Fetch the subsystems for the machine's type, with their current status on this machine
+func machineSubsystems(machine *shared.Machine) {
+	DB.SQL(`select
+		s.*,coalesce(x.status,'Running') as status
+		from machine_type_subsystem t
+		left join subsystem s on s.id=t.subsystem_id
+		left join machine_subsystem x on x.machine_id=$1 and x.subsystem_id=s.id
+		where t.machine_type_id=$2
+		order by s.position,lower(s.name)`, machine.ID, machine.MachineType).
+		QueryStructs(&machine.Subsystems)
+}
+
+// Fetch the subsystems that this machine type has
+func machineTypeSubsystems(machineType *shared.MachineType) {
+	DB.SQL(`select
+		s.* from machine_type_subsystem t
+		left join subsystem s on s.id=t.subsystem_id
+		where t.machine_type_id=$1
+		order by s.position,lower(s.name)`, machineType.ID).
+		QueryStructs(&machineType.Subsystems)
+}
+
+// Set the status of the named subsystem on the given machine, as part of a transaction
+func setSubsystemStatus(tx *runner.Tx, machineID int, name string, status string) error {
+	_, err := tx.SQL(`delete from machine_subsystem
+		where machine_id=$1
+		and subsystem_id=(select id from subsystem where name=$2)`, machineID, name).
+		Exec()
+	if err != nil {
+		return err
+	}
+	_, err = tx.SQL(`insert into machine_subsystem (machine_id,subsystem_id,status)
+		select $1,id,$3 from subsystem where name=$2`, machineID, name, status).
+		Exec()
+	return err
+}
+
+// Are all the subsystems on the given machine running
+func subsystemsClear(tx *runner.Tx, machineID int) (bool, error) {
+	c := 0
+	err := tx.SQL(`select count(*) from machine_subsystem
+		where machine_id=$1 and status != 'Running'`, machineID).
+		QueryScalar(&c)
+	return c == 0, err
+}
+
+func (m *MachineRPC) Subsystems(channel int, subsystems *[]shared.Subsystem) error {
+	start := time.Now()
+
+	conn := Connections.Get(channel)
+
+	DB.SQL(`select * from subsystem order by position,lower(name)`).QueryStructs(subsystems)
+
+	logger(start, "Machine.Subsystems",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d subsystems", len(*subsystems)),
+		channel, conn.UserID, "subsystem", 0, false)
+
+	return nil
+}
+
+func (m *MachineRPC) GetSubsystem(data shared.SubsystemRPCData, subsystem *shared.Subsystem) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(`select * from subsystem where id=$1`, data.ID).QueryStruct(subsystem)
+
+	logger(start, "Machine.GetSubsystem",
+		fmt.Sprintf("Channel %d, ID %d User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		subsystem.Name,
+		data.Channel, conn.UserID, "subsystem", data.ID, false)
+
+	return nil
+}
+
+func (m *MachineRPC) InsertSubsystem(data shared.SubsystemRPCData, id *int) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	*id = 0
+	DB.InsertInto("subsystem").
+		Columns("name", "label", "icon", "position").
+		Record(data.Subsystem).
+		Returning("id").
+		QueryScalar(id)
+
+	logger(start, "Machine.InsertSubsystem",
+		fmt.Sprintf("Channel %d, ID %d User %d %s %s",
+			data.Channel, *id, conn.UserID, conn.Username, conn.UserRole),
+		data.Subsystem.Name,
+		data.Channel, conn.UserID, "subsystem", *id, true)
+
+	conn.BroadcastAdmin("subsystem", "insert", *id)
+	return nil
+}
+
+func (m *MachineRPC) UpdateSubsystem(data shared.SubsystemRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	// The name is what gets stored against stoppages, so it cannot be changed once used
+	DB.Update("subsystem").
+		SetWhitelist(data.Subsystem, "label", "icon", "position").
+		Where("id = $1", data.ID).
+		Exec()
+
+	logger(start, "Machine.UpdateSubsystem",
+		fmt.Sprintf("Channel %d, ID %d User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		data.Subsystem.Label,
+		data.Channel, conn.UserID, "subsystem", data.ID, true)
+
+	conn.BroadcastAdmin("subsystem", "update", data.ID)
+	*done = true
+	return nil
+}
+
+func (m *MachineRPC) DeleteSubsystem(data shared.SubsystemRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(`delete from machine_type_subsystem where subsystem_id=$1`, data.ID).Exec()
+	DB.SQL(`delete from machine_subsystem where subsystem_id=$1`, data.ID).Exec()
+	DB.SQL(`delete from subsystem where id=$1`, data.ID).Exec()
+
+	logger(start, "Machine.DeleteSubsystem",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			data.Channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("ID: %d", data.ID),
+		data.Channel, conn.UserID, "subsystem", data.ID, true)
+
+	conn.BroadcastAdmin("subsystem", "delete", data.ID)
+	*done = true
+	return nil
+}
+
+// Get all the subsystems, flagged with whether this machine type has them
+func (m *MachineRPC) MachineTypeSubsystems(data shared.MachineTypeRPCData, subsystems *[]shared.MachineTypeSubsystem) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(`select
+		s.id as subsystem_id,coalesce(nullif(s.label,''),s.name) as name,count(t.*)
+		from subsystem s
+		left join machine_type_subsystem t
+			on t.subsystem_id=s.id
+			and t.machine_type_id=$1
+		group by s.id
+		order by s.position,lower(s.name)`, data.ID).QueryStructs(subsystems)
+
+	logger(start, "Machine.MachineTypeSubsystems",
+		fmt.Sprintf("Channel %d, ID %d User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d subsystems", len(*subsystems)),
+		data.Channel, conn.UserID, "machine_type", data.ID, false)
+
+	return nil
+}
+
+// Add or remove a subsystem from a machine type
+func (m *MachineRPC) SetMachineTypeSubsystem(data shared.MachineTypeSubsystemSetRequest, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.DeleteFrom("machine_type_subsystem").
+		Where("machine_type_id=$1 and subsystem_id=$2", data.MachineTypeID, data.SubsystemID).
+		Exec()
+
+	if data.IsSet {
+		DB.SQL(`insert into
+			machine_type_subsystem (machine_type_id,subsystem_id)
+			values ($1, $2)`, data.MachineTypeID, data.SubsystemID).
+			Exec()
+	}
+
+	logger(start, "Machine.SetMachineTypeSubsystem",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			data.Channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Type %d Subsystem %d %t",
+			data.MachineTypeID, data.SubsystemID, data.IsSet),
+		data.Channel, conn.UserID, "machine_type", data.MachineTypeID, true)
+
+	*done = true
+	return nil
+}
+
+// Record a batch of jobs run on the machine, and return the new running total
+func (m *MachineRPC) AddJobCount(data shared.JobCountRPCData, total *int) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if data.Count < 1 {
+		return fmt.Errorf("Invalid job count %d", data.Count)
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Machine.AddJobCount:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	err = tx.SQL(`update machine 
+		set job_count=job_count+$2 
+		where id=$1 
+		returning job_count`, data.MachineID, data.Count).QueryScalar(total)
+	if err != nil {
+		log.Println("Machine.AddJobCount:", err.Error())
+		return err
+	}
+
+	_, err = tx.SQL(`insert into machine_jobcount
+		(machine_id,count,total,user_id,source)
+		values ($1,$2,$3,$4,$5)`,
+		data.MachineID, data.Count, *total, conn.UserID, data.Source).Exec()
+	if err != nil {
+		log.Println("Machine.AddJobCount:", err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Machine.AddJobCount:", err.Error())
+		return err
+	}
+
+	logger(start, "Machine.AddJobCount",
+		fmt.Sprintf("Channel %d, Machine %d Count %d %s User %d %s %s",
+			data.Channel, data.MachineID, data.Count, data.Source, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Total %d", *total),
+		data.Channel, conn.UserID, "machine", data.MachineID, true)
+
+	conn.Broadcast("jobcount", "insert", data.MachineID)
+	conn.Broadcast("machine", "update", data.MachineID)
+	return nil
+}
+
+// Get the job count history for the machine, latest first
+func (m *MachineRPC) JobCounts(data shared.MachineRPCData, counts *[]shared.JobCount) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(`select j.*,u.username
+		from machine_jobcount j
+		left join users u on u.id=j.user_id
+		where j.machine_id=$1
+		order by j.logged desc
+		limit 200`, data.ID).QueryStructs(counts)
+
+	logger(start, "Machine.JobCounts",
+		fmt.Sprintf("Channel %d, Machine %d User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d counts", len(*counts)),
+		data.Channel, conn.UserID, "machine", data.ID, false)
+
+	return nil
+}
+
 func rehashTools(mt int, mtt int, mode string, data *shared.MachineTypeTool) {
 	println("rehashTools", mt, mtt, mode)
 
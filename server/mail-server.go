@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Outbound email, for alerts that go out alongside the SMS.
+//
+// The mail server is set in the environment, as CMMS_SMTP_SERVER (host:port),
+// CMMS_SMTP_USER, CMMS_SMTP_PASSWD and CMMS_SMTP_FROM. With no server set,
+// mail is logged and dropped.
+
+func SendEmail(to string, subject string, message string) error {
+
+	server := os.Getenv("CMMS_SMTP_SERVER")
+	if server == "" {
+		println("No Mail Server Defined, not sending to", to, ":", subject)
+		return nil
+	}
+
+	from := os.Getenv("CMMS_SMTP_FROM")
+	if from == "" {
+		from = "cmms@localhost"
+	}
+
+	var auth smtp.Auth
+	if user := os.Getenv("CMMS_SMTP_USER"); user != "" {
+		host, _, err := net.SplitHostPort(server)
+		if err != nil {
+			host = server
+		}
+		auth = smtp.PlainAuth("", user, os.Getenv("CMMS_SMTP_PASSWD"), host)
+	}
+
+	log.Println("Sending Email to", to, ":", subject)
+
+	body := strings.Join([]string{
+		"From: " + from,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		message,
+	}, "\r\n")
+
+	err := smtp.SendMail(server, auth, from, []string{to}, []byte(body))
+	if err != nil {
+		log.Println("SendEmail:", err.Error())
+		return fmt.Errorf("Email to %s failed: %s", to, err.Error())
+	}
+	return nil
+}
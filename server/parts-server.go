@@ -18,7 +18,9 @@ func (p *PartRPC) Get(data shared.PartRPCData, part *shared.Part) error {
 	conn := Connections.Get(data.Channel)
 
 	// Read the sites that this user has access to
-	err := DB.SQL(`select * from part where id=$1`, data.ID).QueryStruct(part)
+	err := DB.SQL(`select p.*,`+partReserved+` as reserved,`+partValuation+` as valuation
+		from part p
+		where p.id=$1`, data.ID).QueryStruct(part)
 
 	if err != nil {
 		log.Println(err.Error())
@@ -196,38 +198,42 @@ func (p *PartRPC) Update(data shared.PartRPCData, done *bool) error {
 
 	conn := Connections.Get(data.Channel)
 
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Part.Update:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
 	// get the last price and stock level
 	existingPart := shared.Part{}
-	DB.SQL(`select * from part where id=$1`, data.Part.ID).QueryStruct(&existingPart)
+	err = tx.SQL(`select * from part where id=$1`, data.Part.ID).QueryStruct(&existingPart)
+	if err != nil {
+		log.Println("Part.Update:", err.Error())
+		return err
+	}
 
-	DB.Update("part").
+	_, err = tx.Update("part").
 		SetWhitelist(data.Part,
 			"class", "name", "descr", "stock_code", "reorder_stocklevel",
-			"reorder_qty", "latest_price", "qty_type", "notes", "current_stock", "supplier_info").
+			"reorder_qty", "latest_price", "qty_type", "notes", "supplier_info").
 		Where("id = $1", data.Part.ID).
 		Exec()
-
-	*done = true
-
-	if existingPart.CurrentStock != data.Part.CurrentStock {
-		// create a new part_stock record
-		partStock := shared.PartStock{
-			PartID:     data.Part.ID,
-			StockLevel: data.Part.CurrentStock,
-			Descr:      fmt.Sprintf("Updated by %s", conn.Username),
-		}
-		DB.InsertInto("part_stock").
-			Columns("part_id", "stock_level").
-			Record(partStock).
-			Exec()
-		*done = false
+	if err != nil {
+		log.Println("Part.Update:", err.Error())
+		return err
 	}
 
-	if existingPart.LatestPrice != data.Part.LatestPrice {
+	// The stock on hand is changed at each store with AdjustStock, rather than here
+	priceChanged := existingPart.LatestPrice != data.Part.LatestPrice
+
+	if priceChanged {
 		// update the last price date, and create a new part_price record
-		DB.SQL(`update part set last_price_date=now() where id=$1`,
-			data.Part.ID,
-			fmt.Sprintf("Updated by %s", conn.Username)).Exec()
+		_, err = tx.SQL(`update part set last_price_date=now() where id=$1`, data.Part.ID).Exec()
+		if err != nil {
+			log.Println("Part.Update:", err.Error())
+			return err
+		}
 
 		partPrice := shared.PartPrice{
 			PartID:       data.Part.ID,
@@ -235,13 +241,27 @@ func (p *PartRPC) Update(data shared.PartRPCData, done *bool) error {
 			Descr:        fmt.Sprintf("Updated by %s", conn.Username),
 			SupplierInfo: data.Part.SupplierInfo,
 		}
-		DB.InsertInto("part_price").
+		_, err = tx.InsertInto("part_price").
 			Columns("part_id", "price", "descr", "supplier_info").
 			Record(partPrice).
 			Exec()
-		*done = false
+		if err != nil {
+			log.Println("Part.Update:", err.Error())
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Part.Update:", err.Error())
+		return err
 	}
 
+	// the reorder level may have changed
+	checkReorder(data.Part.ID)
+
+	// let the client know to reload, if the price history has changed
+	*done = !priceChanged
+
 	logger(start, "Part.Update",
 		data.Part.Name,
 		"",
@@ -263,7 +283,7 @@ func (p *PartRPC) Insert(data shared.PartRPCData, id *int) error {
 		Returning("id").
 		QueryScalar(id)
 
-	// create a new part_stock record
+	// create a new part_stock record, with the stock not yet in any store
 	partStock := shared.PartStock{
 		PartID:     *id,
 		StockLevel: data.Part.CurrentStock,
@@ -272,6 +292,28 @@ func (p *PartRPC) Insert(data shared.PartRPCData, id *int) error {
 		Columns("part_id", "stock_level").
 		Record(partStock).
 		Exec()
+	if data.Part.CurrentStock != 0 {
+		DB.SQL(`insert into stock_level (part_id,site_id,qty,notes) values ($1,0,$2,'New part')`,
+			*id, data.Part.CurrentStock).Exec()
+	}
+
+	// and value the stock at the new part's price
+	if data.Part.CurrentStock > 0 {
+		tx, err := DB.Begin()
+		if err != nil {
+			log.Println("Part.Insert:", err.Error())
+			return err
+		}
+		defer tx.AutoRollback()
+		err = addCostLayer(tx, *id, data.Part.CurrentStock, data.Part.LatestPrice, "New part")
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Println("Part.Insert:", err.Error())
+			return err
+		}
+	}
 
 	// update the last price date, and create a new part_price record
 	DB.SQL(`update part set last_price_date=now(), where id=$1`, *id).Exec()
@@ -285,6 +327,8 @@ func (p *PartRPC) Insert(data shared.PartRPCData, id *int) error {
 		Record(partPrice).
 		Exec()
 
+	checkReorder(*id)
+
 	logger(start, "Part.Insert",
 		data.Part.Name,
 		fmt.Sprintf("%d", *id),
@@ -322,12 +366,15 @@ func (p *PartRPC) StockList(data shared.PartRPCData, stocks *[]shared.PartStock)
 
 	conn := Connections.Get(data.Channel)
 
-	// Read the stock records for this part in reverse date order
-	err := DB.SQL(`select * 
-		from part_stock 
-		where part_id=$1 
-		order by datefrom desc
-		limit 5`, data.ID).
+	// Read the latest stock records at each store for this part, in reverse date order
+	err := DB.SQL(`select h.part_id,h.site_id,h.site_name,h.datefrom,h.stock_level,h.descr
+		from (select x.*,s.name as site_name,
+			row_number() over (partition by x.site_id order by x.datefrom desc) as n
+			from part_stock x
+			left join site s on s.id=x.site_id
+			where x.part_id=$1) h
+		where h.n<=5
+		order by h.site_id=0,h.site_name,h.datefrom desc`, data.ID).
 		QueryStructs(stocks)
 
 	if err != nil {
@@ -540,7 +587,10 @@ func getTree(parentCat int) []shared.Category {
 	// fmt.Printf("subcats of %d = %v (%d)\n", parentCat, cats, len(cats))
 
 	for i, c := range cats {
-		DB.SQL(`select * from part where category=$1 order by name`, c.ID).QueryStructs(&cats[i].Parts)
+		DB.SQL(`select p.*,`+partReserved+` as reserved,`+partValuation+` as valuation
+			from part p
+			where p.category=$1
+			order by p.name`, c.ID).QueryStructs(&cats[i].Parts)
 		// fmt.Printf("parts of cat %d = %v (%d)\n", c.ID, cats[i].Parts, len(cats[i].Parts))
 		cats[i].Subcats = getTree(c.ID)
 	}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"itrak-cmms/shared"
+)
+
+// Topic based delivery of async messages.
+//
+// Clients subscribe to topics through LoginRPC.Subscribe. A topic is either the
+// bare message name ("task"), a single entity ("task:1234"), or all messages of
+// that name for one site ("site:4/machine"). Users that are not Admin only ever
+// receive messages for sites that they have in user_site.
+//
+// Each websocket connection has a bounded outbound queue drained by a single
+// writer, so a slow client drops messages rather than piling up goroutines.
+
+const OutQueueSize = 64
+
+type Subscription struct {
+	Topic string
+	Route string
+}
+
+type outMessage struct {
+	name string
+	msg  shared.AsyncMessage
+}
+
+// Start the writer that drains the outbound queue for this connection
+func (c *Connection) startQueue() {
+	c.out = make(chan outMessage, OutQueueSize)
+	c.done = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case m := <-c.out:
+				if err := c.Send(m.name, m.msg); err != nil {
+					log.Println("Send error on", c.ID, err.Error())
+				}
+			case <-c.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop the writer, once the connection has gone
+func (c *Connection) stopQueue() {
+	if c.done != nil {
+		c.closeOnce.Do(func() { close(c.done) })
+	}
+}
+
+// Add a message to the outbound queue, dropping it if the queue is full
+func (c *Connection) Queue(name string, action string, id int) {
+	if c.out == nil {
+		return
+	}
+	select {
+	case c.out <- outMessage{name, shared.AsyncMessage{Action: action, ID: id}}:
+	default:
+		log.Println("Queue full, dropping", name, action, id, "»", c.ID)
+	}
+}
+
+// Register a subscription, keyed by the full topic so that a page can follow
+// several tasks or sites for the same message name
+func (c *Connection) Subscribe(topic string, route string) {
+	c.subMutex.Lock()
+	defer c.subMutex.Unlock()
+
+	if c.subs == nil {
+		c.subs = make(map[string]Subscription)
+	}
+	c.subs[topic] = Subscription{Topic: topic, Route: route}
+}
+
+// Drop the subscriptions that belong to pages other than the given route
+func (c *Connection) ClearSubscriptions(route string) {
+	c.subMutex.Lock()
+	defer c.subMutex.Unlock()
+
+	for k, v := range c.subs {
+		if v.Route != route {
+			delete(c.subs, k)
+		}
+	}
+}
+
+// Does this connection want the message
+func (c *Connection) Wants(name string, id int, siteID int) bool {
+	if c.UserID == 0 {
+		return false
+	}
+	if siteID != 0 && c.UserRole != "Admin" && !c.Sites[siteID] {
+		return false
+	}
+
+	c.subMutex.Lock()
+	defer c.subMutex.Unlock()
+
+	for topic := range c.subs {
+		if shared.TopicName(topic) != name {
+			continue
+		}
+		switch topic {
+		case name, fmt.Sprintf("%s:%d", name, id):
+			return true
+		}
+		if siteID != 0 && topic == fmt.Sprintf("site:%d/%s", siteID, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Load the sites that this user has access to, for scoping messages
+func (c *Connection) loadSites() {
+	sites := []int{}
+	DB.SQL(`select site_id from user_site where user_id=$1`, c.UserID).QuerySlice(&sites)
+	c.Sites = make(map[int]bool)
+	for _, v := range sites {
+		c.Sites[v] = true
+	}
+}
+
+// Work out which site a message is about, or 0 if it is not site specific
+func messageSite(name string, id int) int {
+	siteID := 0
+	switch name {
+	case "machine", "jobcount", "meter":
+		DB.SQL(`select site_id from machine where id=$1`, id).QueryScalar(&siteID)
+	case "event":
+		DB.SQL(`select site_id from event where id=$1`, id).QueryScalar(&siteID)
+	case "task":
+		DB.SQL(`select m.site_id from task t
+			left join machine m on m.id=t.machine_id
+			where t.id=$1`, id).QueryScalar(&siteID)
+	}
+	return siteID
+}
+
+// Queue the message for every connection that has a matching subscription,
+// skipping the sender. Pass adminOnly to limit delivery to Admin users.
+func (c *ConnectionsList) Publish(from *Connection, name string, action string, id int, adminOnly bool) {
+	start := time.Now()
+	siteID := messageSite(name, id)
+
+	count := 0
+	for _, v := range c.List() {
+		if v == from || (adminOnly && v.UserRole != "Admin") {
+			continue
+		}
+		if v.Wants(name, id, siteID) {
+			v.Queue(name, action, id)
+			count++
+		}
+	}
+	log.Println("publish", name, action, id, "site", siteID, "»", count, "conns", time.Since(start))
+}
+
+// Get a snapshot of the current connections
+func (c *ConnectionsList) List() []*Connection {
+	c.Lock()
+	defer c.Unlock()
+
+	list := make([]*Connection, 0, len(c.cmap))
+	for _, v := range c.cmap {
+		list = append(list, v)
+	}
+	return list
+}
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"itrak-cmms/shared"
+)
+
+// Recurrence rules for the date based scheduled tasks.
+//
+// The rules are a subset of the RFC 5545 RRULE - FREQ of DAILY, WEEKLY, MONTHLY
+// or YEARLY, with INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY, BYMONTH and
+// BYSETPOS. The Monthly, Nth Weekday, Every N Days and Every N Months
+// schedules are all turned into a rule, and Custom Rule schedules take one
+// as is, so they all generate, catch up and forecast the same way.
+
+const MaxRecurPeriods = 5000 // stop expanding a rule after this many days, weeks, months or years
+
+// Monthly and Nth Weekday rules repeat every month forever, so they run from a
+// fixed date well in the past, so that catch up and late scans still see the
+// occurrences before today
+var recurEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.Local)
+
+// A day of the week in a BYDAY, with an optional position such as the 2nd or last (-1)
+type recurDay struct {
+	N       int
+	Weekday time.Weekday
+}
+
+type recurRule struct {
+	Freq       string
+	Interval   int
+	Count      int
+	Until      *time.Time
+	ByDay      []recurDay
+	ByMonthDay []int
+	ByMonth    []int
+	BySetPos   []int
+}
+
+var recurWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// Get a list of numbers out of a rule part, each of which must be in the range
+// -max to max, excluding 0
+func parseRecurInts(name string, value string, max int) ([]int, error) {
+	nums := []int{}
+	for _, s := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(s)
+		if err != nil || n == 0 || n < -max || n > max {
+			return nil, fmt.Errorf("Invalid %s %s", name, s)
+		}
+		nums = append(nums, n)
+	}
+	return nums, nil
+}
+
+// Parse a recurrence, which is an RRULE, optionally with a DTSTART before it
+// such as "DTSTART:20160901 RRULE:FREQ=WEEKLY;INTERVAL=2". The rule may also
+// be given on its own, without the RRULE: in front.
+func parseRecurrence(text string) (recurRule, *time.Time, error) {
+	var dtstart *time.Time
+	ruleText := ""
+	for _, line := range strings.Fields(strings.ToUpper(text)) {
+		switch {
+		case strings.HasPrefix(line, "DTSTART"):
+			i := strings.LastIndex(line, ":")
+			t, err := parseICSDate(line[i+1:])
+			if err != nil {
+				return recurRule{}, nil, err
+			}
+			dtstart = &t
+		case strings.HasPrefix(line, "RRULE:"):
+			ruleText = line[len("RRULE:"):]
+		default:
+			ruleText = line
+		}
+	}
+	rule, err := parseRRule(ruleText)
+	return rule, dtstart, err
+}
+
+// Parse the parts of an RRULE
+func parseRRule(text string) (recurRule, error) {
+	rule := recurRule{Interval: 1}
+	if text == "" {
+		return rule, errors.New("Recurrence rule is empty")
+	}
+
+	var err error
+	for _, part := range strings.Split(strings.ToUpper(strings.TrimSpace(text)), ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return rule, fmt.Errorf("Invalid rule part %s", part)
+		}
+		name, value := kv[0], kv[1]
+
+		switch name {
+		case "FREQ":
+			switch value {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				rule.Freq = value
+			default:
+				return rule, fmt.Errorf("FREQ %s is not supported", value)
+			}
+		case "INTERVAL":
+			if rule.Interval, err = strconv.Atoi(value); err != nil || rule.Interval < 1 {
+				return rule, fmt.Errorf("Invalid INTERVAL %s", value)
+			}
+		case "COUNT":
+			if rule.Count, err = strconv.Atoi(value); err != nil || rule.Count < 1 {
+				return rule, fmt.Errorf("Invalid COUNT %s", value)
+			}
+		case "UNTIL":
+			t, err := parseICSDate(value)
+			if err != nil {
+				return rule, err
+			}
+			rule.Until = &t
+		case "BYDAY":
+			for _, d := range strings.Split(value, ",") {
+				if len(d) < 2 {
+					return rule, fmt.Errorf("Invalid BYDAY %s", d)
+				}
+				weekday, ok := recurWeekdays[d[len(d)-2:]]
+				if !ok {
+					return rule, fmt.Errorf("Invalid BYDAY %s", d)
+				}
+				n := 0
+				if len(d) > 2 {
+					if n, err = strconv.Atoi(d[:len(d)-2]); err != nil || n == 0 || n < -53 || n > 53 {
+						return rule, fmt.Errorf("Invalid BYDAY %s", d)
+					}
+				}
+				rule.ByDay = append(rule.ByDay, recurDay{n, weekday})
+			}
+		case "BYMONTHDAY":
+			if rule.ByMonthDay, err = parseRecurInts(name, value, 31); err != nil {
+				return rule, err
+			}
+		case "BYMONTH":
+			if rule.ByMonth, err = parseRecurInts(name, value, 12); err != nil {
+				return rule, err
+			}
+			for _, m := range rule.ByMonth {
+				if m < 0 {
+					return rule, fmt.Errorf("Invalid BYMONTH %d", m)
+				}
+			}
+		case "BYSETPOS":
+			if rule.BySetPos, err = parseRecurInts(name, value, 366); err != nil {
+				return rule, err
+			}
+		case "WKST":
+			// weeks always start on a monday
+		default:
+			return rule, fmt.Errorf("%s is not supported", name)
+		}
+	}
+
+	if rule.Freq == "" {
+		return rule, errors.New("Recurrence rule has no FREQ")
+	}
+	if rule.Count > 0 && rule.Until != nil {
+		return rule, errors.New("Recurrence rule cannot have both COUNT and UNTIL")
+	}
+	return rule, nil
+}
+
+// Strip the time from a date
+func dateOnly(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// Get the day of the month counting back from the end if negative, or 0 if there is no such day
+func monthDay(year int, month time.Month, n int) int {
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.Local).Day()
+	if n < 0 {
+		n = last + n + 1
+	}
+	if n < 1 || n > last {
+		return 0
+	}
+	return n
+}
+
+func containsInt(list []int, n int) bool {
+	for _, i := range list {
+		if i == n {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the days that match the BYDAY, where a position such as 2TU picks the
+// 2nd tuesday of the period, or of the month for monthly rules and yearly
+// rules by month
+func (r recurRule) filterByDay(period []time.Time, days []time.Time) []time.Time {
+	byMonth := r.Freq == "MONTHLY" || len(r.ByMonth) > 0
+
+	keep := []time.Time{}
+	for _, d := range days {
+		for _, bd := range r.ByDay {
+			if d.Weekday() != bd.Weekday {
+				continue
+			}
+			if bd.N == 0 {
+				keep = append(keep, d)
+				break
+			}
+
+			// work out the position of this day amongst the same weekdays in the period
+			pos, count := 0, 0
+			for _, other := range period {
+				if byMonth && other.Month() != d.Month() {
+					continue
+				}
+				if other.Weekday() == bd.Weekday {
+					count++
+					if other.Equal(d) {
+						pos = count
+					}
+				}
+			}
+			if pos == bd.N || pos-count-1 == bd.N {
+				keep = append(keep, d)
+				break
+			}
+		}
+	}
+	return keep
+}
+
+// Expand a set of days, being one week, month or year of the rule, into the occurrences in it
+func (r recurRule) expand(period []time.Time, dtstart time.Time) []time.Time {
+	days := period
+	if len(r.ByMonth) > 0 {
+		keep := []time.Time{}
+		for _, d := range days {
+			if containsInt(r.ByMonth, int(d.Month())) {
+				keep = append(keep, d)
+			}
+		}
+		days = keep
+	}
+
+	if len(r.ByMonthDay) > 0 {
+		keep := []time.Time{}
+		for _, d := range days {
+			for _, n := range r.ByMonthDay {
+				if monthDay(d.Year(), d.Month(), n) == d.Day() {
+					keep = append(keep, d)
+					break
+				}
+			}
+		}
+		days = keep
+	}
+
+	if len(r.ByDay) > 0 {
+		days = r.filterByDay(period, days)
+	}
+
+	// with nothing to say which days, it falls on the same day as the start
+	if len(r.ByDay) == 0 && len(r.ByMonthDay) == 0 {
+		keep := []time.Time{}
+		for _, d := range days {
+			switch r.Freq {
+			case "WEEKLY":
+				if d.Weekday() != dtstart.Weekday() {
+					continue
+				}
+			case "MONTHLY":
+				if d.Day() != dtstart.Day() {
+					continue
+				}
+			case "YEARLY":
+				if d.Day() != dtstart.Day() || (len(r.ByMonth) == 0 && d.Month() != dtstart.Month()) {
+					continue
+				}
+			}
+			keep = append(keep, d)
+		}
+		days = keep
+	}
+
+	if len(r.BySetPos) > 0 {
+		keep := []time.Time{}
+		for i, d := range days {
+			if containsInt(r.BySetPos, i+1) || containsInt(r.BySetPos, i-len(days)) {
+				keep = append(keep, d)
+			}
+		}
+		days = keep
+	}
+	return days
+}
+
+// Get the days of the nth period of the rule after the one that the start date is in
+func (r recurRule) period(dtstart time.Time, n int) []time.Time {
+	var first, next time.Time
+	step := n * r.Interval
+	switch r.Freq {
+	case "DAILY":
+		first = dtstart.AddDate(0, 0, step)
+		next = first.AddDate(0, 0, 1)
+	case "WEEKLY":
+		monday := dtstart.AddDate(0, 0, -((int(dtstart.Weekday()) + 6) % 7))
+		first = monday.AddDate(0, 0, 7*step)
+		next = first.AddDate(0, 0, 7)
+	case "MONTHLY":
+		first = time.Date(dtstart.Year(), dtstart.Month()+time.Month(step), 1, 0, 0, 0, 0, time.Local)
+		next = first.AddDate(0, 1, 0)
+	case "YEARLY":
+		first = time.Date(dtstart.Year()+step, time.January, 1, 0, 0, 0, 0, time.Local)
+		next = first.AddDate(1, 0, 0)
+	}
+
+	days := []time.Time{}
+	for d := first; d.Before(next); d = d.AddDate(0, 0, 1) {
+		days = append(days, d)
+	}
+	return days
+}
+
+// Get the number of periods of the rule that are over by the given date, so
+// that rules which started long ago dont have to be expanded from the start
+func (r recurRule) periodsBefore(dtstart time.Time, from time.Time) int {
+	if r.Count > 0 || !from.After(dtstart) {
+		// have to count every occurrence
+		return 0
+	}
+	n := 0
+	switch r.Freq {
+	case "DAILY":
+		n = int(from.Sub(dtstart).Hours() / 24)
+	case "WEEKLY":
+		n = int(from.Sub(dtstart).Hours() / 24 / 7)
+	case "MONTHLY":
+		n = (from.Year()-dtstart.Year())*12 + int(from.Month()-dtstart.Month())
+	case "YEARLY":
+		n = from.Year() - dtstart.Year()
+	}
+	// back off one period, to allow for daylight saving and partial periods
+	n = n/r.Interval - 1
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// Get the occurrences of the rule starting at dtstart, that fall between the 2 dates inclusive
+func (r recurRule) between(dtstart time.Time, from time.Time, to time.Time) []time.Time {
+	dtstart = dateOnly(dtstart)
+	from = dateOnly(from)
+	to = dateOnly(to)
+
+	occurs := []time.Time{}
+	count := 0
+	first := r.periodsBefore(dtstart, from)
+	for n := first; n < first+MaxRecurPeriods; n++ {
+		days := r.period(dtstart, n)
+		if len(days) == 0 || days[0].After(to) {
+			break
+		}
+		if r.Until != nil && days[0].After(*r.Until) {
+			break
+		}
+
+		for _, d := range r.expand(days, dtstart) {
+			if d.Before(dtstart) {
+				continue
+			}
+			if r.Until != nil && d.After(*r.Until) {
+				return occurs
+			}
+			count++
+			if r.Count > 0 && count > r.Count {
+				return occurs
+			}
+			if !d.Before(from) && !d.After(to) {
+				occurs = append(occurs, d)
+			}
+		}
+	}
+	return occurs
+}
+
+// Get the recurrence rule for a date based sched task, with the date that the
+// rule runs from, and the number of days after each occurrence that the task starts
+func schedRecurrence(st shared.SchedTask) (recurRule, time.Time, int, error) {
+	today := dateOnly(time.Now())
+	switch st.Freq {
+	case "Monthly", "Nth Weekday":
+		if st.Week == nil || *st.Week < 1 || *st.Week > 5 {
+			return recurRule{}, today, 0, fmt.Errorf("Task %d is %s but has no week", st.ID, st.Freq)
+		}
+		weekDay := 1
+		if st.WeekDay != nil && *st.WeekDay >= 1 && *st.WeekDay <= 7 {
+			weekDay = *st.WeekDay
+		}
+		n := *st.Week
+		if n == 5 {
+			n = -1 // the last one in the month
+		}
+		if st.Freq == "Nth Weekday" {
+			weekday := time.Weekday(weekDay % 7)
+			return recurRule{Freq: "MONTHLY", Interval: 1, ByDay: []recurDay{{n, weekday}}}, recurEpoch, 0, nil
+		}
+		// Monthly weeks start on the nth monday, with the task on the given day of that week
+		return recurRule{Freq: "MONTHLY", Interval: 1, ByDay: []recurDay{{n, time.Monday}}}, recurEpoch, weekDay - 1, nil
+	case "Every N Days", "Every N Months":
+		// counts on from the start date, so a late or rolled task does not shift the rest
+		from := schedAnchor(st)
+		if st.Freq == "Every N Days" {
+			if st.Days == nil || *st.Days < 1 {
+				return recurRule{}, today, 0, fmt.Errorf("Task %d is every N days but has no days", st.ID)
+			}
+			return recurRule{Freq: "DAILY", Interval: *st.Days}, from, 0, nil
+		}
+		if st.Months == nil || *st.Months < 1 {
+			return recurRule{}, today, 0, fmt.Errorf("Task %d is every N months but has no months", st.ID)
+		}
+		rule := recurRule{Freq: "MONTHLY", Interval: *st.Months, ByMonthDay: []int{from.Day()}}
+		if from.Day() > 28 {
+			// on the 29th-31st, short months fall on their last day
+			rule.ByMonthDay = []int{from.Day(), -1}
+			rule.BySetPos = []int{1}
+		}
+		return rule, from, 0, nil
+	case "Custom Rule":
+		rule, dtstart, err := parseRecurrence(st.RRule)
+		if err != nil {
+			return rule, today, 0, fmt.Errorf("Task %d: %s", st.ID, err.Error())
+		}
+		if dtstart != nil {
+			return rule, *dtstart, 0, nil
+		}
+		return rule, schedAnchor(st), 0, nil
+	}
+	return recurRule{}, today, 0, fmt.Errorf("Task %d freq %s has no recurrence rule", st.ID, st.Freq)
+}
+
+// Get the date that an Every N Days, Every N Months or Custom Rule sched task counts
+// from, which is its start date. The start date is set when the sched takes on the
+// frequency, so the last generated date or today is only a fall back for a sched
+// that has somehow lost it.
+func schedAnchor(st shared.SchedTask) time.Time {
+	if st.StartDate != nil {
+		return dateOnly(*st.StartDate)
+	}
+	if st.LastGenerated != nil {
+		return dateOnly(*st.LastGenerated)
+	}
+	return dateOnly(time.Now())
+}
+
+type timesByDate []time.Time
+
+func (t timesByDate) Len() int           { return len(t) }
+func (t timesByDate) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t timesByDate) Less(i, j int) bool { return t[i].Before(t[j]) }
+
+// Get the start dates of the sched task between the 2 dates inclusive, using its recurrence rule
+func recurOccurrences(st shared.SchedTask, from time.Time, to time.Time) []schedOccurrence {
+	occurs := []schedOccurrence{}
+	rule, dtstart, offset, err := schedRecurrence(st)
+	if err != nil {
+		return occurs
+	}
+
+	// shift the window back by the offset, so that it applies to the start date
+	dates := rule.between(dtstart, from.AddDate(0, 0, -offset), to.AddDate(0, 0, -offset))
+	sort.Sort(timesByDate(dates))
+
+	for _, d := range dates {
+		startDate := d.AddDate(0, 0, offset)
+		if st.LastGenerated != nil && !startDate.After(dateOnly(*st.LastGenerated)) {
+			// already generated
+			continue
+		}
+		dueDate := startDate.AddDate(0, 0, st.DurationDays)
+		if st.Freq == "Monthly" {
+			// due by the friday of the week
+			dueDate = d.AddDate(0, 0, 4)
+			if dueDate.Before(startDate) {
+				dueDate = startDate
+			}
+		}
+		occurs = append(occurs, schedOccurrence{startDate, dueDate})
+	}
+	return occurs
+}
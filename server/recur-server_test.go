@@ -0,0 +1,231 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"itrak-cmms/shared"
+)
+
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.Local)
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func sameDates(got []time.Time, want []time.Time) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if !got[i].Equal(want[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func startDates(occurs []schedOccurrence) []time.Time {
+	dates := []time.Time{}
+	for _, o := range occurs {
+		dates = append(dates, o.StartDate)
+	}
+	return dates
+}
+
+// 2nd tuesday of the month
+var nthWeekday = shared.SchedTask{
+	ID:           1,
+	Freq:         "Nth Weekday",
+	Week:         intPtr(2),
+	WeekDay:      intPtr(2),
+	DurationDays: 1,
+}
+
+// wednesday of the 1st week of the month
+var monthly = shared.SchedTask{
+	ID:           2,
+	Freq:         "Monthly",
+	Week:         intPtr(1),
+	WeekDay:      intPtr(3),
+	DurationDays: 1,
+}
+
+func TestBetween(t *testing.T) {
+	rule := recurRule{Freq: "MONTHLY", Interval: 1, ByDay: []recurDay{{1, time.Monday}}}
+
+	got := rule.between(recurEpoch, day(2016, time.August, 1), day(2016, time.October, 31))
+	want := []time.Time{day(2016, time.August, 1), day(2016, time.September, 5), day(2016, time.October, 3)}
+	if !sameDates(got, want) {
+		t.Errorf("between got %v, want %v", got, want)
+	}
+
+	// nothing before the start of the rule
+	got = rule.between(day(2016, time.September, 1), day(2016, time.August, 1), day(2016, time.October, 31))
+	want = []time.Time{day(2016, time.September, 5), day(2016, time.October, 3)}
+	if !sameDates(got, want) {
+		t.Errorf("between after dtstart got %v, want %v", got, want)
+	}
+}
+
+func TestSchedRecurrenceAnchor(t *testing.T) {
+	for _, st := range []shared.SchedTask{nthWeekday, monthly} {
+		_, dtstart, _, err := schedRecurrence(st)
+		if err != nil {
+			t.Fatalf("%s: %s", st.Freq, err.Error())
+		}
+		if !dtstart.Equal(recurEpoch) {
+			t.Errorf("%s runs from %v, want %v", st.Freq, dtstart, recurEpoch)
+		}
+	}
+
+	_, _, offset, _ := schedRecurrence(monthly)
+	if offset != 2 {
+		t.Errorf("Monthly on a wednesday has offset %d, want 2", offset)
+	}
+}
+
+func TestRecurOccurrencesPast(t *testing.T) {
+	got := startDates(recurOccurrences(nthWeekday, day(2016, time.August, 1), day(2016, time.September, 30)))
+	want := []time.Time{day(2016, time.August, 9), day(2016, time.September, 13)}
+	if !sameDates(got, want) {
+		t.Errorf("Nth Weekday got %v, want %v", got, want)
+	}
+
+	occurs := recurOccurrences(monthly, day(2016, time.October, 1), day(2016, time.October, 10))
+	if len(occurs) != 1 {
+		t.Fatalf("Monthly got %d occurrences, want 1", len(occurs))
+	}
+	if !occurs[0].StartDate.Equal(day(2016, time.October, 5)) {
+		t.Errorf("Monthly starts %v, want %v", occurs[0].StartDate, day(2016, time.October, 5))
+	}
+	if !occurs[0].DueDate.Equal(day(2016, time.October, 7)) {
+		t.Errorf("Monthly due %v, want %v", occurs[0].DueDate, day(2016, time.October, 7))
+	}
+}
+
+func TestRecurOccurrencesGenerated(t *testing.T) {
+	st := nthWeekday
+	lastGen := day(2016, time.August, 9)
+	st.LastGenerated = &lastGen
+
+	got := startDates(recurOccurrences(st, day(2016, time.August, 1), day(2016, time.September, 30)))
+	want := []time.Time{day(2016, time.September, 13)}
+	if !sameDates(got, want) {
+		t.Errorf("after last generated got %v, want %v", got, want)
+	}
+}
+
+func TestCatchUpWindow(t *testing.T) {
+	got := startDates(missedOccurrences(nthWeekday, day(2016, time.July, 31), day(2016, time.September, 30)))
+	want := []time.Time{day(2016, time.August, 9), day(2016, time.September, 13)}
+	if !sameDates(got, want) {
+		t.Errorf("Nth Weekday catch up got %v, want %v", got, want)
+	}
+
+	got = startDates(missedOccurrences(monthly, day(2016, time.August, 31), day(2016, time.October, 1)))
+	want = []time.Time{day(2016, time.September, 7)}
+	if !sameDates(got, want) {
+		t.Errorf("Monthly catch up got %v, want %v", got, want)
+	}
+}
+
+func TestLateScanWindow(t *testing.T) {
+	// scanned 3 days after the 2nd tuesday still picks it up
+	got := startDates(schedScanOccurrences(nthWeekday, day(2016, time.August, 12)))
+	want := []time.Time{day(2016, time.August, 9)}
+	if !sameDates(got, want) {
+		t.Errorf("Nth Weekday late scan got %v, want %v", got, want)
+	}
+
+	// monthly is picked up once its week has started, up to 6 days late
+	got = startDates(schedScanOccurrences(monthly, day(2016, time.October, 10)))
+	want = []time.Time{day(2016, time.October, 5)}
+	if !sameDates(got, want) {
+		t.Errorf("Monthly late scan got %v, want %v", got, want)
+	}
+	got = startDates(schedScanOccurrences(monthly, day(2016, time.October, 3)))
+	if !sameDates(got, want) {
+		t.Errorf("Monthly scan at the start of its week got %v, want %v", got, want)
+	}
+}
+
+func TestEveryNMonthsMonthEnd(t *testing.T) {
+	startDate := day(2016, time.January, 31)
+	lastGen := day(2016, time.February, 29)
+	st := shared.SchedTask{
+		ID:            3,
+		Freq:          "Every N Months",
+		Months:        intPtr(1),
+		StartDate:     &startDate,
+		LastGenerated: &lastGen,
+		DurationDays:  1,
+	}
+
+	// back to the month end after a short month
+	got := startDates(recurOccurrences(st, day(2016, time.March, 1), day(2016, time.May, 31)))
+	want := []time.Time{day(2016, time.March, 31), day(2016, time.April, 30), day(2016, time.May, 31)}
+	if !sameDates(got, want) {
+		t.Errorf("Every N Months got %v, want %v", got, want)
+	}
+}
+
+func TestEveryNDaysAnchor(t *testing.T) {
+	startDate := day(2016, time.August, 1)
+	lastGen := day(2016, time.August, 9) // generated a day late
+	st := shared.SchedTask{
+		ID:            4,
+		Freq:          "Every N Days",
+		Days:          intPtr(7),
+		StartDate:     &startDate,
+		LastGenerated: &lastGen,
+		DurationDays:  1,
+	}
+
+	got := startDates(recurOccurrences(st, day(2016, time.August, 10), day(2016, time.August, 31)))
+	want := []time.Time{day(2016, time.August, 15), day(2016, time.August, 22), day(2016, time.August, 29)}
+	if !sameDates(got, want) {
+		t.Errorf("Every N Days got %v, want %v", got, want)
+	}
+}
+
+func TestCustomRuleCount(t *testing.T) {
+	startDate := day(2016, time.August, 1)
+	lastGen := day(2016, time.August, 2)
+	st := shared.SchedTask{
+		ID:            5,
+		Freq:          "Custom Rule",
+		RRule:         "FREQ=DAILY;COUNT=2",
+		StartDate:     &startDate,
+		LastGenerated: &lastGen,
+		DurationDays:  1,
+	}
+
+	// both occurrences have been generated, so there are no more
+	got := recurOccurrences(st, day(2016, time.August, 1), day(2016, time.September, 30))
+	if len(got) != 0 {
+		t.Errorf("Custom Rule with COUNT=2 got %v after both were generated", startDates(got))
+	}
+}
+
+func TestCatchUpBounded(t *testing.T) {
+	startDate := day(2015, time.January, 1)
+	lastGen := day(2015, time.August, 1)
+	st := shared.SchedTask{
+		ID:            6,
+		Freq:          "Every N Days",
+		Days:          intPtr(1),
+		StartDate:     &startDate,
+		LastGenerated: &lastGen,
+		DurationDays:  1,
+	}
+
+	// left alone for a year, only the days the scheduler was down are caught up
+	got := startDates(missedOccurrences(st, day(2016, time.August, 1), day(2016, time.August, 3)))
+	want := []time.Time{day(2016, time.August, 2), day(2016, time.August, 3)}
+	if !sameDates(got, want) {
+		t.Errorf("Every N Days catch up got %v, want %v", got, want)
+	}
+}
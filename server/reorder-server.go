@@ -0,0 +1,532 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"itrak-cmms/shared"
+)
+
+// Reordering of parts.
+//
+// After every stock movement, and once a day for all parts, each part is checked
+// against its reorder_stocklevel at each store that holds it. Stock on hand at the
+// store plus whatever is still outstanding on open purchase orders to it, or in
+// transit to it from another store, is what counts, so a part that is already on
+// its way does not get flagged again. A part at or below its reorder level at a
+// store gets a suggestion to order its reorder_qty from its preferred vendor (the
+// cheapest one in part_vendor), and the manager of that store is told about the
+// new suggestions, grouped by vendor. With CMMS_REORDER_AUTO_PO set, the new
+// suggestions are also raised straight away as draft purchase orders, one per
+// vendor and store.
+//
+// Parts reserved by open tasks starting within the next CMMS_RESERVE_WEEKS weeks
+// (4 by default) are taken off the stock at the store that the task's site draws
+// from, so a part also gets flagged when those reservations would take it below
+// its reorder level, or below nothing at all for a part without a reorder level.
+
+type ReorderRPC struct{}
+
+const reorderQuery = `select r.*,
+	p.name as part_name,p.stock_code,p.qty_type,p.reorder_qty,
+	v.name as vendor_name,s.name as site_name
+	from reorder_suggestion r
+	left join part p on p.id=r.part_id
+	left join vendor v on v.id=r.vendor_id
+	left join site s on s.id=r.site_id`
+
+// The number of weeks ahead that reservations count against the stock
+func reserveWeeks() int {
+	weeks, err := strconv.Atoi(os.Getenv("CMMS_RESERVE_WEEKS"))
+	if err != nil || weeks < 0 {
+		return 4
+	}
+	return weeks
+}
+
+// Run the reorder check on startup, and then daily
+func autoReorder() {
+
+	log.Printf("... Running reorder check")
+	go func() {
+		for {
+			reorderAll(0, 0)
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+}
+
+// Check every part that has a reorder level, a reservation on an open task, or a
+// suggestion that is still live, and return the number of new suggestions
+func reorderAll(channel int, userID int) int {
+	start := time.Now()
+
+	partIDs := []int{}
+	err := DB.SQL(`select id from part where reorder_stocklevel>0
+		union
+		select r.part_id from task_part r
+		left join task t on t.id=r.task_id
+		where r.qty>r.qty_used and t.completed_date is null and t.status!='Cancelled'
+		union
+		select part_id from reorder_suggestion where closed is null`).QuerySlice(&partIDs)
+	if err != nil {
+		log.Println("reorderAll:", err.Error())
+		return 0
+	}
+
+	count := checkReorder(partIDs...)
+
+	logger(start, "Part.Reorder",
+		fmt.Sprintf("%d parts", len(partIDs)),
+		fmt.Sprintf("%d new reorder suggestions", count),
+		channel, userID, "reorder_suggestion", 0, true)
+
+	return count
+}
+
+// Check the parts against their reorder levels after their stock has moved. Any new
+// suggestions are sent out to the store managers, and raised as draft
+// purchase orders if that is turned on. Returns the number of new suggestions.
+func checkReorder(partIDs ...int) int {
+	raised := []shared.ReorderSuggestion{}
+	for _, partID := range partIDs {
+		for _, siteID := range reorderStores(partID) {
+			s, err := reorderPart(partID, siteID)
+			if err != nil {
+				log.Println("checkReorder:", partID, siteID, err.Error())
+				continue
+			}
+			if s != nil {
+				raised = append(raised, *s)
+			}
+		}
+	}
+	if len(raised) == 0 {
+		return 0
+	}
+
+	notifyReorder(raised)
+	if os.Getenv("CMMS_REORDER_AUTO_PO") != "" {
+		if _, err := raiseReorderOrders(0, 0); err != nil {
+			log.Println("checkReorder:", err.Error())
+		}
+	}
+
+	Connections.BroadcastAllAdmin("reorder", "insert", 0)
+	return len(raised)
+}
+
+// Get the stores to check the part at, being those that hold it, those that supply
+// the tasks that have it reserved, and those that already have a live suggestion
+// for it. A part that is not held anywhere is checked as unallocated stock, so
+// that it still gets flagged against its reorder level.
+func reorderStores(partID int) []int {
+	stores := []int{}
+	DB.SQL(`select site_id from stock_level
+		where part_id=$1 and (site_id!=0 or qty!=0)
+		union
+		select case when s.stock_site=0 then s.id else s.stock_site end
+		from task_part r
+		left join task t on t.id=r.task_id
+		left join machine m on m.id=t.machine_id
+		left join site s on s.id=m.site_id
+		where r.part_id=$1 and r.qty>r.qty_used and s.id is not null
+		and t.completed_date is null and t.status!='Cancelled'
+		union
+		select site_id from reorder_suggestion where part_id=$1 and closed is null
+		order by 1`, partID).QuerySlice(&stores)
+	if len(stores) == 0 {
+		stores = []int{0}
+	}
+	return stores
+}
+
+// Check the one part against its reorder level and its reservations at the store,
+// keeping its live suggestion there up to date. Returns the suggestion if a new
+// one was raised.
+func reorderPart(partID int, siteID int) (*shared.ReorderSuggestion, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.AutoRollback()
+
+	s := shared.ReorderSuggestion{}
+	err = tx.SQL(`select p.id as part_id,p.name as part_name,p.stock_code,p.qty_type,
+		$6::int as site_id,(select name from site where id=$6) as site_name,
+		coalesce((select qty from stock_level
+			where part_id=p.id and site_id=$6),0) as stock,
+		p.reorder_stocklevel as reorder_level,p.reorder_qty,
+		coalesce((select sum(l.qty-l.qty_received)
+			from purchase_order_line l
+			left join purchase_order o on o.id=l.po_id
+			where l.part_id=p.id and l.qty>l.qty_received and o.site_id=$6
+			and o.status in ($2,$3,$4)),0) +
+		coalesce((select sum(x.qty) from stock_transfer x
+			where x.part_id=p.id and x.to_site=$6 and x.status=$7),0) as on_order,
+		coalesce((select sum(greatest(r.qty-r.qty_used,0))
+			from task_part r
+			left join task t on t.id=r.task_id
+			left join machine m on m.id=t.machine_id
+			left join site x on x.id=m.site_id
+			where r.part_id=p.id and t.completed_date is null and t.status!='Cancelled'
+			and case when x.stock_site=0 then x.id else x.stock_site end=$6
+			and (t.startdate is null or t.startdate<current_date+$5::int*7)),0) as reserved
+		from part p
+		where p.id=$1
+		for update`, partID, shared.PODraft, shared.POSent, shared.POPartial, reserveWeeks(),
+		siteID, shared.TransferInTransit).QueryStruct(&s)
+	if err != nil {
+		return nil, err
+	}
+
+	// Enough stock, so clear any suggestion still outstanding
+	if !s.NeedsOrder() {
+		_, err = tx.SQL(`update reorder_suggestion
+			set closed=now(), status=case when status=$2 then $3 else status end
+			where part_id=$1 and site_id=$4 and closed is null`,
+			partID, shared.ReorderOpen, shared.ReorderCleared, siteID).Exec()
+		if err != nil {
+			return nil, err
+		}
+		return nil, tx.Commit()
+	}
+
+	// Already flagged, so just bring the stock figures up to date, leaving the
+	// vendor and qty as the manager may have changed them
+	liveID := 0
+	tx.SQL(`select id from reorder_suggestion
+		where part_id=$1 and site_id=$2 and closed is null`, partID, siteID).QueryScalar(&liveID)
+	if liveID != 0 {
+		_, err = tx.SQL(`update reorder_suggestion
+			set stock=$2, on_order=$3, reserved=$4, reorder_level=$5
+			where id=$1`, liveID, s.Stock, s.OnOrder, s.Reserved, s.ReorderLevel).Exec()
+		if err != nil {
+			return nil, err
+		}
+		return nil, tx.Commit()
+	}
+
+	// Order from the cheapest vendor, leaving any without a price till last
+	vendor := shared.ReorderSuggestion{}
+	tx.SQL(`select x.vendor_id,x.vendor_code,x.latest_price as price,v.name as vendor_name,
+		coalesce((select h.min_qty from vendor_price h
+			where h.part_id=x.part_id and h.vendor_id=x.vendor_id
+			order by h.datefrom desc limit 1),0) as min_qty
+		from part_vendor x
+		left join vendor v on v.id=x.vendor_id
+		where x.part_id=$1
+		order by x.latest_price=0,x.latest_price,v.name
+		limit 1`, partID).QueryStruct(&vendor)
+	s.VendorID = vendor.VendorID
+	s.VendorName = vendor.VendorName
+	s.VendorCode = vendor.VendorCode
+	s.Price = vendor.Price
+	s.MinQty = vendor.MinQty
+	s.Qty = s.OrderQty()
+	s.Status = shared.ReorderOpen
+	s.Raised = time.Now()
+
+	err = tx.SQL(`insert into reorder_suggestion
+		(part_id,site_id,vendor_id,vendor_code,price,stock,on_order,reserved,reorder_level,qty,status)
+		values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		returning id`,
+		partID, siteID, s.VendorID, s.VendorCode, s.Price, s.Stock, s.OnOrder, s.Reserved, s.ReorderLevel,
+		s.Qty, s.Status).QueryScalar(&s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	log.Printf("»»» Part %d %s at %g in %s, reorder level %g, reorder %g",
+		partID, s.StockCode, s.Projected(), s.GetSiteName(), s.ReorderLevel, s.Qty)
+	return &s, nil
+}
+
+// The manager of the store, or the admins if the store has no manager
+func reorderRecipients(siteID int) []shared.User {
+	users := []shared.User{}
+	DB.SQL(`select u.id,u.username,u.name,u.email,u.sms,u.use_mobile
+		from site s
+		left join users u on u.id=s.manager
+		where s.id=$1 and u.id is not null`, siteID).QueryStructs(&users)
+	if len(users) == 0 {
+		DB.SQL(`select id,username,name,email,sms,use_mobile
+			from users
+			where role='Admin'
+			order by id`).QueryStructs(&users)
+	}
+	return users
+}
+
+// Tell the manager of each store about its new reorder suggestions
+func notifyReorder(raised []shared.ReorderSuggestion) {
+	byStore := make(map[int][]shared.ReorderSuggestion)
+	stores := []int{}
+	for _, s := range raised {
+		if _, ok := byStore[s.SiteID]; !ok {
+			stores = append(stores, s.SiteID)
+		}
+		byStore[s.SiteID] = append(byStore[s.SiteID], s)
+	}
+	for _, siteID := range stores {
+		notifyStoreReorder(siteID, byStore[siteID])
+	}
+}
+
+// Tell the store manager about new reorder suggestions at the store, grouped by vendor
+func notifyStoreReorder(siteID int, raised []shared.ReorderSuggestion) {
+	byVendor := make(map[string][]shared.ReorderSuggestion)
+	vendors := []string{}
+	for _, s := range raised {
+		name := s.GetVendorName()
+		if _, ok := byVendor[name]; !ok {
+			vendors = append(vendors, name)
+		}
+		byVendor[name] = append(byVendor[name], s)
+	}
+	sort.Strings(vendors)
+
+	lines := []string{}
+	codes := []string{}
+	for _, name := range vendors {
+		lines = append(lines, "", name+":")
+		for _, s := range byVendor[name] {
+			lines = append(lines, fmt.Sprintf("  %s %s - %g in stock, %g on order, %g reserved, reorder level %g. Order %s",
+				s.StockCode, s.PartName, s.Stock, s.OnOrder, s.Reserved, s.ReorderLevel, s.GetQty()))
+			codes = append(codes, s.StockCode)
+		}
+	}
+
+	store := raised[0].GetSiteName()
+	subject := fmt.Sprintf("%d Parts to Reorder for %s", len(raised), store)
+	message := fmt.Sprintf("The following parts are at or below their reorder level at %s, or short of what is reserved :\n", store) +
+		strings.Join(lines, "\n")
+	smsMsg := fmt.Sprintf("%d parts to reorder for %s from %d vendors: %s",
+		len(raised), store, len(vendors), strings.Join(codes, ", "))
+
+	for _, u := range reorderRecipients(siteID) {
+		if u.SMS != "" {
+			if err := SendSMS(u.SMS, smsMsg, "reorder", u.ID); err != nil {
+				log.Println("Reorder SMS to", u.Username, err.Error())
+			}
+		}
+		if u.Email != "" {
+			if err := SendEmail(u.Email, subject, message); err != nil {
+				log.Println("Reorder Email to", u.Username, err.Error())
+			}
+		}
+	}
+}
+
+// Raise a draft purchase order for each vendor and store with open suggestions (or
+// just the one vendor), and return the number of orders raised
+func raiseReorderOrders(vendorID int, userID int) (int, error) {
+	open := []shared.ReorderSuggestion{}
+	err := DB.SQL(reorderQuery+`
+		where r.closed is null and r.status=$1
+		and r.vendor_id!=0 and ($2=0 or r.vendor_id=$2)
+		order by r.vendor_id,r.site_id,p.stock_code`, shared.ReorderOpen, vendorID).QueryStructs(&open)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := 0; i < len(open); {
+		// the suggestions for the one vendor and store
+		j := i
+		for j < len(open) && open[j].VendorID == open[i].VendorID && open[j].SiteID == open[i].SiteID {
+			j++
+		}
+		poID, err := raiseReorderOrder(open[i:j], userID)
+		if err != nil {
+			return count, err
+		}
+		Connections.BroadcastAllAdmin("purchaseorder", "insert", poID)
+		count++
+		i = j
+	}
+	if count > 0 {
+		Connections.BroadcastAllAdmin("reorder", "update", 0)
+	}
+	return count, nil
+}
+
+// Raise a draft purchase order for the suggestions, which are all for the one vendor,
+// to be delivered to the one store
+func raiseReorderOrder(suggestions []shared.ReorderSuggestion, userID int) (int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.AutoRollback()
+
+	poID := 0
+	err = tx.SQL(`insert into purchase_order (vendor_id,site_id,ref,notes,created_by,status)
+		values ($1,$2,$3,$4,$5,$6)
+		returning id`,
+		suggestions[0].VendorID, suggestions[0].SiteID, "Reorder",
+		fmt.Sprintf("Raised from %d reorder suggestions", len(suggestions)),
+		userID, shared.PODraft).QueryScalar(&poID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, s := range suggestions {
+		_, err = tx.SQL(`insert into purchase_order_line (po_id,part_id,vendor_code,qty,price,notes)
+			values ($1,$2,$3,$4,$5,$6)`,
+			poID, s.PartID, s.VendorCode, s.Qty, s.Price,
+			fmt.Sprintf("Reorder, %g in stock, reorder level %g", s.Stock, s.ReorderLevel)).Exec()
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.SQL(`update reorder_suggestion
+			set status=$2, closed=now(), po_id=$3
+			where id=$1`, s.ID, shared.ReorderOrdered, poID).Exec()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return poID, tx.Commit()
+}
+
+// Get the suggestions that are still live
+func (r *ReorderRPC) List(channel int, suggestions *[]shared.ReorderSuggestion) error {
+	start := time.Now()
+
+	conn := Connections.Get(channel)
+
+	DB.SQL(reorderQuery + `
+		where r.closed is null
+		order by r.status desc,v.name,s.name,p.stock_code`).QueryStructs(suggestions)
+
+	logger(start, "Reorder.List",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d suggestions", len(*suggestions)),
+		channel, conn.UserID, "reorder_suggestion", 0, false)
+
+	return nil
+}
+
+func (r *ReorderRPC) Get(data shared.ReorderRPCData, suggestion *shared.ReorderSuggestion) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	err := DB.SQL(reorderQuery+`
+		where r.id=$1`, data.ID).QueryStruct(suggestion)
+	if err != nil {
+		log.Println("Reorder.Get:", err.Error())
+		return err
+	}
+
+	logger(start, "Reorder.Get",
+		fmt.Sprintf("Channel %d, ID %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Part %d %s", suggestion.PartID, suggestion.Status),
+		data.Channel, conn.UserID, "reorder_suggestion", data.ID, false)
+
+	return nil
+}
+
+// Change the vendor or qty to order, which also reopens a dismissed suggestion
+func (r *ReorderRPC) Update(data shared.ReorderRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	s := data.ReorderSuggestion
+	if s.Qty <= 0 {
+		return fmt.Errorf("The quantity to order must be more than 0")
+	}
+
+	res, err := DB.SQL(`update reorder_suggestion r
+		set qty=$2, vendor_id=$3, status=$4,
+		vendor_code=coalesce((select x.vendor_code from part_vendor x
+			where x.part_id=r.part_id and x.vendor_id=$3),''),
+		price=coalesce((select x.latest_price from part_vendor x
+			where x.part_id=r.part_id and x.vendor_id=$3),0)
+		where r.id=$1 and r.closed is null`,
+		data.ID, s.Qty, s.VendorID, shared.ReorderOpen).Exec()
+	if err != nil {
+		log.Println("Reorder.Update:", err.Error())
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("The suggestion has already been ordered or cleared")
+	}
+
+	logger(start, "Reorder.Update",
+		fmt.Sprintf("Channel %d, ID %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Vendor %d Qty %g", s.VendorID, s.Qty),
+		data.Channel, conn.UserID, "reorder_suggestion", data.ID, true)
+
+	conn.BroadcastAdmin("reorder", "update", data.ID)
+	*done = true
+	return nil
+}
+
+// Dismiss the suggestion, so it is not raised again until the part has been
+// back above its reorder level
+func (r *ReorderRPC) Dismiss(data shared.ReorderRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	_, err := DB.SQL(`update reorder_suggestion
+		set status=$2
+		where id=$1 and closed is null`, data.ID, shared.ReorderDismissed).Exec()
+	if err != nil {
+		log.Println("Reorder.Dismiss:", err.Error())
+		return err
+	}
+
+	logger(start, "Reorder.Dismiss",
+		fmt.Sprintf("Channel %d, ID %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		"Dismissed",
+		data.Channel, conn.UserID, "reorder_suggestion", data.ID, true)
+
+	conn.BroadcastAdmin("reorder", "update", data.ID)
+	*done = true
+	return nil
+}
+
+// Raise draft purchase orders for the open suggestions, for the one vendor or all of them
+func (r *ReorderRPC) Order(data shared.ReorderRPCData, count *int) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	n, err := raiseReorderOrders(data.VendorID, conn.UserID)
+	*count = n
+	if err != nil {
+		log.Println("Reorder.Order:", err.Error())
+		return err
+	}
+
+	logger(start, "Reorder.Order",
+		fmt.Sprintf("Channel %d, Vendor %d, User %d %s %s",
+			data.Channel, data.VendorID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d purchase orders raised", n),
+		data.Channel, conn.UserID, "purchase_order", 0, true)
+
+	return nil
+}
+
+// Run the reorder check now, rather than waiting for the daily run
+func (r *ReorderRPC) Check(channel int, count *int) error {
+	conn := Connections.Get(channel)
+	*count = reorderAll(channel, conn.UserID)
+	return nil
+}
@@ -24,11 +24,22 @@ func getRoutes(uid int, role string) []shared.UserRoute {
 			{Route: "/machine/sched/add/{machine}", Func: "machine-sched-add"},
 			{Route: "/machine/reports/{machine}", Func: "machine-reports"},
 			{Route: "/machine/stoppages/{machine}", Func: "machine-stoppage-list"},
+			{Route: "/machine/jobcount/{machine}", Func: "machine-jobcount-list"},
+			{Route: "/machine/jobcount/add/{machine}", Func: "machine-jobcount-add"},
+			{Route: "/machine/meter/{machine}", Func: "machine-meter-list"},
+			{Route: "/machine/meter/add/{machine}", Func: "machine-meter-add"},
 			{Route: "/sched/{id}", Func: "sched-edit"},
 			{Route: "/sched/task/{id}", Func: "sched-task-list"},
+			{Route: "/sched/skills/{id}", Func: "sched-skills"},
 			{Route: "/tasks", Func: "task-list"},
 			{Route: "/task/{id}", Func: "task-edit"},
 			{Route: "/task/parts/{id}", Func: "task-part-list"},
+			{Route: "/task/history/{id}", Func: "task-history"},
+			{Route: "/task/time/{id}", Func: "task-time"},
+			{Route: "/task/crew/{id}", Func: "task-crew"},
+			{Route: "/task/crew/{id}/add", Func: "task-crew-add"},
+			{Route: "/task/crew/{id}/member/{user}", Func: "task-crew-member"},
+			{Route: "/task/skills/{id}", Func: "task-skills"},
 			{Route: "/task/complete/{id}", Func: "task-complete"},
 			{Route: "/task/invoices/{id}", Func: "task-invoices"},
 			{Route: "/task/invoice/{id}", Func: "task-invoice"},
@@ -38,6 +49,7 @@ func getRoutes(uid int, role string) []shared.UserRoute {
 			{Route: "/stoppage/complete/{id}", Func: "stoppage-complete"},
 			{Route: "/stoppage/newtask/{id}", Func: "stoppage-new-task"},
 			{Route: "/stoppage/tasks/{id}", Func: "stoppage-task-list"},
+			{Route: "/stoppage/history/{id}", Func: "stoppage-history"},
 			{Route: "/class/add", Func: "class-add"},
 			{Route: "/class/select", Func: "class-select"},
 			{Route: "/parts/{id}", Func: "part-list"},
@@ -62,6 +74,53 @@ func getRoutes(uid int, role string) []shared.UserRoute {
 			{Route: "/machinetype/{id}/parts", Func: "machine-type-parts"},
 			{Route: "/machinetype/{id}/machines", Func: "machine-type-machines"},
 			{Route: "/machinetype/{id}/stoppages", Func: "machine-type-stoppages"},
+			{Route: "/subsystems", Func: "subsystem-list"},
+			{Route: "/subsystem/add", Func: "subsystem-add"},
+			{Route: "/subsystem/{id}", Func: "subsystem-edit"},
+			{Route: "/calendars", Func: "calendar-list"},
+			{Route: "/calendar/add", Func: "calendar-add"},
+			{Route: "/calendar/{id}", Func: "calendar-edit"},
+			{Route: "/calendar/{id}/holidays", Func: "calendar-holidays"},
+			{Route: "/calendar/{id}/holiday/add", Func: "calendar-holiday-add"},
+			{Route: "/calendar/{id}/holiday/{holiday}", Func: "calendar-holiday-edit"},
+			{Route: "/calendar/{id}/import", Func: "calendar-import"},
+			{Route: "/skills", Func: "skill-list"},
+			{Route: "/skill/add", Func: "skill-add"},
+			{Route: "/skill/{id}", Func: "skill-edit"},
+			{Route: "/skill/users/{id}", Func: "skill-users"},
+			{Route: "/user/skills/{id}", Func: "user-skills"},
+			{Route: "/user/skills/{id}/add", Func: "user-skill-add"},
+			{Route: "/user/skills/{id}/skill/{skill}", Func: "user-skill-edit"},
+			{Route: "/vendors", Func: "vendor-list"},
+			{Route: "/vendor/add", Func: "vendor-add"},
+			{Route: "/vendor/{id}", Func: "vendor-edit"},
+			{Route: "/vendor/{id}/prices", Func: "vendor-prices"},
+			{Route: "/vendor/{id}/price/add", Func: "vendor-price-add"},
+			{Route: "/vendor/{id}/price/{part}", Func: "vendor-price-edit"},
+			{Route: "/vendor/{id}/orders", Func: "vendor-orders"},
+			{Route: "/purchaseorders", Func: "po-list"},
+			{Route: "/purchaseorder/add", Func: "po-add"},
+			{Route: "/purchaseorder/{id}", Func: "po-edit"},
+			{Route: "/purchaseorder/{id}/lines", Func: "po-lines"},
+			{Route: "/purchaseorder/{id}/line/add", Func: "po-line-add"},
+			{Route: "/purchaseorder/{id}/line/{line}", Func: "po-line-edit"},
+			{Route: "/reorder", Func: "reorder-list"},
+			{Route: "/reorder/{id}", Func: "reorder-edit"},
+			{Route: "/part/{id}/stock", Func: "part-stock"},
+			{Route: "/part/{id}/count/{site}", Func: "part-count"},
+			{Route: "/part/{id}/transfers", Func: "part-transfers"},
+			{Route: "/part/{id}/transfer/add", Func: "transfer-add"},
+			{Route: "/transfers", Func: "transfer-list"},
+			{Route: "/transfer/{id}", Func: "transfer-edit"},
+			{Route: "/part/{id}/costs", Func: "part-costs"},
+			{Route: "/stocktake", Func: "stocktake-list"},
+			{Route: "/stocktake/add", Func: "stocktake-add"},
+			{Route: "/stocktake/{id}", Func: "stocktake-edit"},
+			{Route: "/stocktake/{id}/sheet", Func: "stocktake-sheet"},
+			{Route: "/stocktake/line/{id}", Func: "stocktake-count"},
+			{Route: "/escalation", Func: "escalation-list"},
+			{Route: "/escalation/add", Func: "escalation-add"},
+			{Route: "/escalation/{id}", Func: "escalation-edit"},
 			{Route: "/phototest", Func: "phototest"},
 			{Route: "/phototest/{id}", Func: "phototest-edit"},
 			{Route: "/phototest/add", Func: "phototest-add"},
@@ -84,14 +143,26 @@ func getRoutes(uid int, role string) []shared.UserRoute {
 			{Route: "/machine/sched/{machine}", Func: "machine-sched-list"},
 			{Route: "/machine/reports/{machine}", Func: "machine-reports"},
 			{Route: "/machine/stoppages/{machine}", Func: "machine-stoppage-list"},
+			{Route: "/machine/jobcount/{machine}", Func: "machine-jobcount-list"},
+			{Route: "/machine/jobcount/add/{machine}", Func: "machine-jobcount-add"},
+			{Route: "/machine/meter/{machine}", Func: "machine-meter-list"},
+			{Route: "/machine/meter/add/{machine}", Func: "machine-meter-add"},
 			{Route: "/sched/{id}", Func: "sched-edit"},
 			{Route: "/sched/task/{id}", Func: "sched-task-list"},
+			{Route: "/sched/skills/{id}", Func: "sched-skills"},
 			{Route: "/tasks", Func: "task-list"},
 			{Route: "/task/{id}", Func: "task-edit"},
 			{Route: "/task/parts/{id}", Func: "task-part-list"},
+			{Route: "/task/history/{id}", Func: "task-history"},
+			{Route: "/task/time/{id}", Func: "task-time"},
+			{Route: "/task/crew/{id}", Func: "task-crew"},
+			{Route: "/task/crew/{id}/add", Func: "task-crew-add"},
+			{Route: "/task/crew/{id}/member/{user}", Func: "task-crew-member"},
+			{Route: "/task/skills/{id}", Func: "task-skills"},
 			{Route: "/stoppages", Func: "stoppage-list"},
 			{Route: "/stoppage/{id}", Func: "stoppage-edit"},
 			{Route: "/stoppage/tasks/{id}", Func: "stoppage-task-list"},
+			{Route: "/stoppage/history/{id}", Func: "stoppage-history"},
 			{Route: "/stoppage/complete/{id}", Func: "stoppage-complete"},
 			{Route: "/stoppage/newtask/{id}", Func: "stoppage-new-task"},
 			{Route: "/class/select", Func: "class-select"},
@@ -99,6 +170,30 @@ func getRoutes(uid int, role string) []shared.UserRoute {
 			{Route: "/part/add/{id}", Func: "part-add"},
 			{Route: "/part/{id}", Func: "part-edit"},
 			{Route: "/reports", Func: "reports"},
+			{Route: "/vendors", Func: "vendor-list"},
+			{Route: "/vendor/{id}", Func: "vendor-edit"},
+			{Route: "/vendor/{id}/prices", Func: "vendor-prices"},
+			{Route: "/vendor/{id}/orders", Func: "vendor-orders"},
+			{Route: "/purchaseorders", Func: "po-list"},
+			{Route: "/purchaseorder/add", Func: "po-add"},
+			{Route: "/purchaseorder/{id}", Func: "po-edit"},
+			{Route: "/purchaseorder/{id}/lines", Func: "po-lines"},
+			{Route: "/purchaseorder/{id}/line/add", Func: "po-line-add"},
+			{Route: "/purchaseorder/{id}/line/{line}", Func: "po-line-edit"},
+			{Route: "/reorder", Func: "reorder-list"},
+			{Route: "/reorder/{id}", Func: "reorder-edit"},
+			{Route: "/part/{id}/stock", Func: "part-stock"},
+			{Route: "/part/{id}/count/{site}", Func: "part-count"},
+			{Route: "/part/{id}/transfers", Func: "part-transfers"},
+			{Route: "/part/{id}/transfer/add", Func: "transfer-add"},
+			{Route: "/transfers", Func: "transfer-list"},
+			{Route: "/transfer/{id}", Func: "transfer-edit"},
+			{Route: "/part/{id}/costs", Func: "part-costs"},
+			{Route: "/stocktake", Func: "stocktake-list"},
+			{Route: "/stocktake/add", Func: "stocktake-add"},
+			{Route: "/stocktake/{id}", Func: "stocktake-edit"},
+			{Route: "/stocktake/{id}/sheet", Func: "stocktake-sheet"},
+			{Route: "/stocktake/line/{id}", Func: "stocktake-count"},
 		}
 	case "Technician":
 		return []shared.UserRoute{
@@ -109,9 +204,15 @@ func getRoutes(uid int, role string) []shared.UserRoute {
 			{Route: "/tasks", Func: "task-list"},
 			{Route: "/task/{id}", Func: "task-edit"},
 			{Route: "/task/parts/{id}", Func: "task-part-list"},
+			{Route: "/task/history/{id}", Func: "task-history"},
+			{Route: "/task/time/{id}", Func: "task-time"},
+			{Route: "/task/crew/{id}", Func: "task-crew"},
+			{Route: "/task/crew/{id}/add", Func: "task-crew-add"},
+			{Route: "/task/crew/{id}/member/{user}", Func: "task-crew-member"},
 			{Route: "/stoppages", Func: "stoppages"},
 			{Route: "/stoppage/{id}", Func: "stoppage-edit"},
 			{Route: "/stoppage/tasks/{id}", Func: "stoppage-task-list"},
+			{Route: "/stoppage/history/{id}", Func: "stoppage-history"},
 			{Route: "/stoppage/complete/{id}", Func: "stoppage-complete"},
 			{Route: "/stoppage/newtask/{id}", Func: "stoppage-new-task"},
 			{Route: "/parts", Func: "parts"},
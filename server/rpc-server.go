@@ -53,4 +53,24 @@ func registerRPC() {
 		log.Fatal(err)
 	}
 	log.Println("» SMS")
+
+	if err := rpc.Register(new(CalendarRPC)); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("» Calendar")
+
+	if err := rpc.Register(new(SkillRPC)); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("» Skill")
+
+	if err := rpc.Register(new(VendorRPC)); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("» Vendor")
+
+	if err := rpc.Register(new(ReorderRPC)); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("» Reorder")
 }
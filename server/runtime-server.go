@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"itrak-cmms/shared"
+
+	runner "gopkg.in/mgutz/dat.v1/sqlx-runner"
+)
+
+// Machine running hours, for the Every N Hours scheduled tasks.
+//
+// A machine_runtime row is opened each time the machine goes to Running, and
+// closed when it is stopped or has a stoppage raised against it. Hour meter
+// readings entered by hand reset the total as at the time they were taken, so
+// the hours at any point are the last reading plus the runtime since then.
+
+const ForecastDays = 28 // days of runtime history used to forecast when a task falls due
+
+// Start the runtime clock on the machine, if it is not already going
+func machineStarted(tx *runner.Tx, machineID int) error {
+	running := 0
+	err := tx.SQL(`select count(*)
+		from machine_runtime
+		where machine_id=$1 and stopped is null`, machineID).QueryScalar(&running)
+	if err != nil || running > 0 {
+		return err
+	}
+
+	_, err = tx.SQL(`insert into machine_runtime (machine_id) values ($1)`, machineID).Exec()
+	if err != nil {
+		return err
+	}
+	_, err = tx.SQL(`update machine set started_at=localtimestamp where id=$1`, machineID).Exec()
+	return err
+}
+
+// Stop the runtime clock on the machine
+func machineStopped(tx *runner.Tx, machineID int) error {
+	res, err := tx.SQL(`update machine_runtime
+		set stopped=localtimestamp
+		where machine_id=$1 and stopped is null`, machineID).Exec()
+	if err != nil || res.RowsAffected == 0 {
+		return err
+	}
+	_, err = tx.SQL(`update machine set stopped_at=localtimestamp where id=$1`, machineID).Exec()
+	return err
+}
+
+// Get the number of hours the machine was running between the 2 times
+func runtimeHours(machineID int, from time.Time, to time.Time) float64 {
+	seconds := 0.0
+	DB.SQL(`select coalesce(sum(extract(epoch from
+			least(coalesce(stopped,$3),$3) - greatest(started,$2))),0)
+		from machine_runtime
+		where machine_id=$1 and started < $3 and coalesce(stopped,$3) > $2`,
+		machineID, from, to).QueryScalar(&seconds)
+	return seconds / 3600
+}
+
+// Get the running hours on the machine as at the given time
+func machineHours(machineID int, at time.Time) float64 {
+	reading := shared.MeterReading{}
+	DB.SQL(`select * from machine_meter
+		where machine_id=$1 and logged <= $2
+		order by logged desc
+		limit 1`, machineID, at).QueryStruct(&reading)
+
+	from := time.Time{}
+	if reading.Logged != nil {
+		from = *reading.Logged
+	}
+	return reading.Hours + runtimeHours(machineID, from, at)
+}
+
+// Get the hours run since the sched task last generated a task, and the current machine hours
+func schedHoursRun(st *shared.SchedTask) (float64, float64) {
+	hours := machineHours(st.MachineID, time.Now())
+
+	lastHours := 0.0
+	err := DB.SQL(`select last_hours
+		from sched_control_task
+		where task_id=$1 and last_hours is not null`, st.ID).QueryScalar(&lastHours)
+	if err != nil && st.LastGenerated != nil {
+		// not generated on hours before, so count from the last generated date
+		lastHours = machineHours(st.MachineID, *st.LastGenerated)
+	}
+
+	hoursRun := hours - lastHours
+	if hoursRun < 0 {
+		// the hour meter has been replaced, so count from the new reading
+		hoursRun = hours
+	}
+	return hoursRun, hours
+}
+
+// Fill in the hours run, and forecast the due date from the recent daily running hours
+func schedHoursForecast(st *shared.SchedTask) {
+	if st.Freq != "Every N Hours" || st.Hours == nil {
+		return
+	}
+
+	now := time.Now()
+	st.HoursRun, _ = schedHoursRun(st)
+	remaining := float64(*st.Hours) - st.HoursRun
+	if remaining <= 0 {
+		st.NextDue = &now
+		return
+	}
+
+	daily := runtimeHours(st.MachineID, now.AddDate(0, 0, -ForecastDays), now) / ForecastDays
+	if daily <= 0 {
+		// machine has not been running, so there is no telling when it will be due
+		st.NextDue = nil
+		return
+	}
+	due := now.Add(time.Duration(remaining / daily * 24 * float64(time.Hour)))
+	st.NextDue = &due
+}
+
+// Record a reading off the hour meter on the machine
+func (m *MachineRPC) AddMeterReading(data shared.MeterReadingRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if data.Hours < 0 {
+		return fmt.Errorf("Invalid meter reading %.1f", data.Hours)
+	}
+
+	_, err := DB.SQL(`insert into machine_meter
+		(machine_id,hours,user_id,notes)
+		values ($1,$2,$3,$4)`,
+		data.MachineID, data.Hours, conn.UserID, data.Notes).Exec()
+	if err != nil {
+		log.Println("Machine.AddMeterReading:", err.Error())
+		return err
+	}
+
+	logger(start, "Machine.AddMeterReading",
+		fmt.Sprintf("Channel %d, Machine %d Hours %.1f User %d %s %s",
+			data.Channel, data.MachineID, data.Hours, conn.UserID, conn.Username, conn.UserRole),
+		data.Notes,
+		data.Channel, conn.UserID, "machine", data.MachineID, true)
+
+	conn.Broadcast("meter", "insert", data.MachineID)
+	conn.Broadcast("machine", "update", data.MachineID)
+	*done = true
+	return nil
+}
+
+// Get the hour meter readings for the machine, latest first
+func (m *MachineRPC) MeterReadings(data shared.MachineRPCData, readings *[]shared.MeterReading) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(`select r.*,u.username
+		from machine_meter r
+		left join users u on u.id=r.user_id
+		where r.machine_id=$1
+		order by r.logged desc`, data.ID).QueryStructs(readings)
+
+	logger(start, "Machine.MeterReadings",
+		fmt.Sprintf("Channel %d, Machine %d User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d readings", len(*readings)),
+		data.Channel, conn.UserID, "machine", data.ID, false)
+
+	return nil
+}
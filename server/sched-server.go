@@ -37,6 +37,7 @@ func (t *TaskRPC) ListMachineSched(data shared.MachineRPCData, tasks *[]shared.S
 			order by type, id desc`, v.ID).
 			QueryStructs(&photos)
 		(*tasks)[i].Photos = photos
+		schedHoursForecast(&(*tasks)[i])
 	}
 
 	logger(start, "Task.ListMachineSched",
@@ -74,6 +75,7 @@ func (t *TaskRPC) ListSiteSched(data shared.TaskRPCData, tasks *[]shared.SchedTa
 			order by type, id desc`, v.ID).
 			QueryStructs(&photos)
 		(*tasks)[i].Photos = photos
+		schedHoursForecast(&(*tasks)[i])
 	}
 
 	logger(start, "Task.ListSiteSched",
@@ -179,6 +181,7 @@ func (t *TaskRPC) GetSched(data shared.TaskRPCData, task *shared.SchedTask) erro
 		QueryStructs(&photos)
 
 	task.Photos = photos
+	schedHoursForecast(task)
 
 	logger(start, "Task.GetSched",
 		fmt.Sprintf("Sched %d", data.ID),
@@ -213,21 +216,49 @@ func (t *TaskRPC) UpdateSched(data shared.SchedTaskRPCData, ok *bool) error {
 		}
 	}
 
+	if data.SchedTask.Freq == "Every N Hours" {
+		if data.SchedTask.Hours == nil {
+			i := 1
+			data.SchedTask.Hours = &i
+		} else {
+			if *data.SchedTask.Hours < 1 {
+				*data.SchedTask.Hours = 1
+			}
+		}
+	}
+
+	if data.SchedTask.Freq == "Custom Rule" {
+		if _, _, err := parseRecurrence(data.SchedTask.RRule); err != nil {
+			log.Println("Task.UpdateSched:", err.Error())
+			return err
+		}
+	}
+
 	if data.SchedTask.DurationDays < 1 {
 		data.SchedTask.DurationDays = 1
 	}
 
 	conn := Connections.Get(data.Channel)
 
+	was := shared.SchedTask{}
+	DB.SQL(`select id,machine_id,freq,startdate from sched_task where id=$1`, data.SchedTask.ID).QueryStruct(&was)
+	setSchedStart(data.SchedTask, was)
+
 	DB.Update("sched_task").
 		SetWhitelist(data.SchedTask,
 			"comp_type", "tool_id",
 			"component", "descr", "startdate", "oneoffdate",
-			"freq", "days", "months", "week", "weekday", "count", "user_id",
+			"freq", "days", "months", "week", "weekday", "count", "hours", "rrule", "user_id",
 			"labour_cost", "material_cost", "duration_days").
 		Where("id = $1", data.SchedTask.ID).
 		Exec()
 
+	// Switching to a meter based frequency counts from the meter as it is now
+	if data.SchedTask.Freq != was.Freq {
+		was.Freq = data.SchedTask.Freq
+		setSchedBaseline(was)
+	}
+
 	// fmt.Printf("passed in newphoto %v\n", data.SchedTask.NewPhoto)
 	// If there is a new photo to be added to the task, then add it
 	if data.SchedTask.NewPhoto.Data != "" {
@@ -387,6 +418,24 @@ func (t *TaskRPC) InsertSched(data shared.SchedTaskRPCData, id *int) error {
 		println("after", *data.SchedTask.Months)
 	}
 
+	if data.SchedTask.Freq == "Every N Hours" {
+		if data.SchedTask.Hours == nil {
+			i := 1
+			data.SchedTask.Hours = &i
+		} else {
+			if *data.SchedTask.Hours < 1 {
+				*data.SchedTask.Hours = 1
+			}
+		}
+	}
+
+	if data.SchedTask.Freq == "Custom Rule" {
+		if _, _, err := parseRecurrence(data.SchedTask.RRule); err != nil {
+			log.Println("Task.InsertSched:", err.Error())
+			return err
+		}
+	}
+
 	if data.SchedTask.DurationDays < 1 {
 		data.SchedTask.DurationDays = 1
 	}
@@ -394,16 +443,20 @@ func (t *TaskRPC) InsertSched(data shared.SchedTaskRPCData, id *int) error {
 	// Default the schedule to paused, so we can fine tune it before starting
 	// the first generation
 	data.SchedTask.Paused = true
+	setSchedStart(data.SchedTask, shared.SchedTask{})
 
 	DB.InsertInto("sched_task").
 		Whitelist("machine_id", "comp_type", "tool_id",
 			"component", "descr", "startdate", "oneoffdate",
-			"freq", "days", "months", "week", "weekday", "count", "user_id",
+			"freq", "days", "months", "week", "weekday", "count", "hours", "rrule", "user_id",
 			"labour_cost", "material_cost", "duration_days", "paused").
 		Record(data.SchedTask).
 		Returning("id").
 		QueryScalar(id)
 
+	data.SchedTask.ID = *id
+	setSchedBaseline(*data.SchedTask)
+
 	logger(start, "Task.InsertSched",
 		fmt.Sprintf("Channel %d, User %d %s %s",
 			data.Channel, conn.UserID, conn.Username, conn.UserRole),
@@ -523,24 +576,12 @@ func schedTaskScan(channel int, user_id int, runDate time.Time, count *int) erro
 	log.Printf("»»» SchedTask Generate run for %s", runDate.Format(rfc3339DateLayout))
 
 	// work out which week of the month we are in
-	firstOfTheMonth := time.Date(year, month, 1, 0, 0, 0, 0, time.Local)
-	firstday := firstOfTheMonth.Weekday()
-	firstWeek := firstOfTheMonth
-
-	dd := (int)(firstday)
-	switch dd {
-	case 0:
-		firstWeek = firstWeek.AddDate(0, 0, 1)
-	case 1:
-		// already set
-	default:
-		firstWeek = firstWeek.AddDate(0, 0, 8-dd)
-	}
-
-	secondWeek := firstWeek.AddDate(0, 0, 7)
-	thirdWeek := firstWeek.AddDate(0, 0, 14)
-	fourthWeek := firstWeek.AddDate(0, 0, 21)
-	// fifthWeek := firstWeek.AddDate(0, 0, 28)
+	firstday := time.Date(year, month, 1, 0, 0, 0, 0, time.Local).Weekday()
+	weeks := monthWeeks(year, month)
+	firstWeek := weeks[0]
+	secondWeek := weeks[1]
+	thirdWeek := weeks[2]
+	fourthWeek := weeks[3]
 
 	log.Printf(".. first of the month falls on a %s", firstday)
 	log.Printf(".. 1st Week is %s", firstWeek.Format(rfc3339DateLayout))
@@ -551,6 +592,15 @@ func schedTaskScan(channel int, user_id int, runDate time.Time, count *int) erro
 	log.Printf(".. Prior Week = %s", priorWeek.Format(rfc3339DateLayout))
 	log.Printf(".. Tomorrow = %s", tommorow.Format(rfc3339DateLayout))
 
+	// Catch up on anything missed since the last run, if the server has been down
+	// for longer than the window. Runs for earlier dates are left alone.
+	var lastRun *time.Time
+	DB.SQL(`select last_run from sched_control where id=1`).QueryScalar(&lastRun)
+	moveForward := lastRun == nil || !runDate.Before(*lastRun)
+	if lastRun != nil && moveForward {
+		numTasks += schedCatchUp(*lastRun, priorWeek)
+	}
+
 	// Go through each scheduled task in turn
 	scheds := []shared.SchedTask{}
 	newTask := shared.Task{}
@@ -567,59 +617,20 @@ func schedTaskScan(channel int, user_id int, runDate time.Time, count *int) erro
 		// }
 
 		switch st.Freq {
-		case "Monthly":
-			dueDate := firstWeek
-			lastDate := secondWeek
-			if st.Week == nil {
-				log.Printf("Error - Monthly Task %d has null week", st.ID)
+		case "Monthly", "Nth Weekday", "Every N Days", "Every N Months", "Custom Rule":
+			if _, _, _, err := schedRecurrence(st); err != nil {
+				log.Println("Error -", err.Error())
 				break
 			}
-			if st.WeekDay == nil {
-				log.Printf("Error - Monthly Task %d has null weekday", st.ID)
-			}
-			if *st.WeekDay < 1 {
-				*st.WeekDay = 1
-			}
-			if *st.WeekDay > 5 {
-				*st.WeekDay = 5
-			}
-			switch *st.Week {
-			case 1:
-				dueDate = firstWeek
-			case 2:
-				dueDate = secondWeek
-			case 3:
-				dueDate = thirdWeek
-			case 4:
-				dueDate = fourthWeek
-			}
-
-			lastDate = dueDate.AddDate(0, 0, 4) // the friday of the week
-			realDueDate := dueDate.AddDate(0, 0, *st.WeekDay-1)
-
-			// now that we knov the duedate, check that we havent already generated this task
-			if st.LastGenerated != nil {
-				if st.LastGenerated.Format(rfc3339DateLayout) == realDueDate.Format(rfc3339DateLayout) {
-					// log.Printf("Task %d has already been generated for %s", st.ID, dueDate.Format(rfc3339DateLayout))
-					doit = false
-				}
-			}
-
-			if doit {
-				if dueDate.After(priorWeek) && dueDate.Before(tommorow) {
-
-					// Excellent - the Week that the task belongs to falls inside the window
-					// so its now safe to increment the actual start date by day of the week
-
-					log.Printf("»»» Task %d On Week %d Next Due on %s last date %s",
-						st.ID, *st.Week,
-						realDueDate.Format(rfc3339DateLayout),
-						lastDate.Format(rfc3339DateLayout))
-
-					// Generate a new Task record
-					genTask(st, &newTask, realDueDate, lastDate)
-					numTasks++
-				}
+			for _, o := range schedScanOccurrences(st, runDate) {
+				log.Printf("»»» Task %d %s due on %s to %s",
+					st.ID, st.ShowFrequency(),
+					o.StartDate.Format(rfc3339DateLayout),
+					o.DueDate.Format(rfc3339DateLayout))
+
+				// Generate a new Task record
+				genTask(st, &newTask, o.StartDate, o.DueDate)
+				numTasks++
 			}
 		case "Yearly":
 			// If the one off date is within the window
@@ -674,55 +685,51 @@ func schedTaskScan(channel int, user_id int, runDate time.Time, count *int) erro
 					}
 				}
 			}
-		case "Every N Months":
-			if st.Months == nil {
-				log.Printf("Error - Task %d on every N months has no month specified", st.ID)
-			} else {
-
-				// Get the last one generated
-				// If there is none, then create the first one
-				if st.LastGenerated == nil {
-					log.Printf("»»» Task %d Every %d months, first entry - start now",
-						st.ID,
-						*st.Days)
-
-					// Generate a new Task record
-					genTask(st, &newTask, today, today.AddDate(0, 0, st.DurationDays))
-					numTasks++
-					st.LastGenerated = &today
-
-				}
-				// Else, calculate the next date, and check if its in the window
-				allDone := false
-				nextDate := st.LastGenerated.AddDate(0, *st.Months, 0)
-
-				for !allDone {
-					if nextDate.After(priorWeek) && nextDate.Before(nextWeek) {
-						log.Printf("»»» Task %d Every %d months, next due at %s is within %s - %s",
-							st.ID,
-							*st.Months,
-							nextDate.Format(rfc3339DateLayout),
-							priorWeek.Format(rfc3339DateLayout),
-							nextWeek.Format(rfc3339DateLayout))
+		case "Job Count":
+			if st.Count == nil || *st.Count < 1 {
+				log.Printf("Error - Task %d on job count has no count specified", st.ID)
+				break
+			}
 
-						// Generate a new Task record
-						genTask(st, &newTask, nextDate, nextDate.AddDate(0, 0, st.DurationDays))
-						numTasks++
+			// Check if the jobs run since the last one have exceeded the count
+			jobsRun, jobCount := schedJobsRun(&st)
+			if jobsRun > *st.Count {
+				log.Printf("»»» Task %d Job Count %d, machine has run %d jobs since the last task",
+					st.ID,
+					*st.Count,
+					jobsRun)
+
+				// Generate a new Task record
+				genTask(st, &newTask, today, today.AddDate(0, 0, st.DurationDays))
+				numTasks++
+				setSchedControl(st.ID, today, "last_jobcount", jobCount)
+			}
+		case "Every N Hours":
+			if st.Hours == nil || *st.Hours < 1 {
+				log.Printf("Error - Task %d on every N hours has no hours specified", st.ID)
+				break
+			}
 
-						// keep looping, looking at the next date
-						nextDate = nextDate.AddDate(0, *st.Months, 0)
-					} else {
-						allDone = true
-					}
-				}
+			// Check if the running hours since the last one have crossed the threshold
+			hoursRun, hours := schedHoursRun(&st)
+			if hoursRun >= float64(*st.Hours) {
+				log.Printf("»»» Task %d Every %d hours, machine has run %.1f hours since the last task",
+					st.ID,
+					*st.Hours,
+					hoursRun)
+
+				// Generate a new Task record
+				genTask(st, &newTask, today, today.AddDate(0, 0, st.DurationDays))
+				numTasks++
+				setSchedControl(st.ID, today, "last_hours", hours)
 			}
-		case "Job Count":
-			// Get the last generated job count
-			// If there is none, then create the first one
-			// Else, calculate the next count, and check if it has been exceeded
 		}
 	}
 
+	if moveForward {
+		setLastRun(runDate)
+	}
+
 	*count = numTasks
 	logger(start, "Task.Generate",
 		fmt.Sprintf("As of date %s", runDate.Format(rfc3339DateLayout)),
@@ -732,13 +739,220 @@ func schedTaskScan(channel int, user_id int, runDate time.Time, count *int) erro
 	return nil
 }
 
+// Get the jobs run since the sched task last generated a task, and the current machine job count.
+// If there is no last generated job count, then count from when the machine started counting.
+func schedJobsRun(st *shared.SchedTask) (int, int) {
+	jobCount := 0
+	DB.SQL(`select job_count from machine where id=$1`, st.MachineID).QueryScalar(&jobCount)
+	lastCount := 0
+	DB.SQL(`select coalesce(last_jobcount,0) 
+		from sched_control_task 
+		where task_id=$1`, st.ID).QueryScalar(&lastCount)
+	return jobCount - lastCount, jobCount
+}
+
+// Get the monday of each of the first 4 weeks of the month, where
+// the first week is the first one that starts on a monday
+func monthWeeks(year int, month time.Month) [4]time.Time {
+	firstOfTheMonth := time.Date(year, month, 1, 0, 0, 0, 0, time.Local)
+	firstWeek := firstOfTheMonth
+
+	dd := (int)(firstOfTheMonth.Weekday())
+	switch dd {
+	case 0:
+		firstWeek = firstWeek.AddDate(0, 0, 1)
+	case 1:
+		// already set
+	default:
+		firstWeek = firstWeek.AddDate(0, 0, 8-dd)
+	}
+
+	return [4]time.Time{
+		firstWeek,
+		firstWeek.AddDate(0, 0, 7),
+		firstWeek.AddDate(0, 0, 14),
+		firstWeek.AddDate(0, 0, 21),
+	}
+}
+
+// A task that should have been generated from the schedule
+type schedOccurrence struct {
+	StartDate time.Time
+	DueDate   time.Time
+}
+
+// Get the occurrences of the date based sched task that start between the 2 dates
+// inclusive, using the same rules as schedTaskScan
+func schedOccurrences(st shared.SchedTask, from time.Time, to time.Time) []schedOccurrence {
+	occurs := []schedOccurrence{}
+	inWindow := func(d time.Time) bool {
+		return !d.Before(from) && !d.After(to)
+	}
+
+	switch st.Freq {
+	case "Monthly", "Nth Weekday", "Every N Days", "Every N Months", "Custom Rule":
+		return recurOccurrences(st, from, to)
+	case "Yearly":
+		if st.StartDate != nil && inWindow(*st.StartDate) {
+			occurs = append(occurs, schedOccurrence{*st.StartDate, st.StartDate.AddDate(0, 0, st.DurationDays)})
+		}
+	case "One Off":
+		if st.OneOffDate != nil && inWindow(*st.OneOffDate) {
+			occurs = append(occurs, schedOccurrence{*st.OneOffDate, st.OneOffDate.AddDate(0, 0, st.DurationDays)})
+		}
+	}
+	return occurs
+}
+
+// Get the occurrences of the sched task that fell in the window after the
+// last run, up to and including the start of the current window. Only the
+// scheduler's own downtime is caught up, so a sched that has been paused or
+// left alone for a long time does not come back with a pile of overdue tasks.
+func missedOccurrences(st shared.SchedTask, lastRun time.Time, windowStart time.Time) []schedOccurrence {
+	return schedOccurrences(st, lastRun.AddDate(0, 0, 1), windowStart)
+}
+
+// Get the occurrences of a recurring sched task that the scan generates on the
+// run date, being those that start within a week either side of it. Monthly
+// tasks are not generated until their week has started.
+func schedScanOccurrences(st shared.SchedTask, runDate time.Time) []schedOccurrence {
+	from := dateOnly(runDate).AddDate(0, 0, -6)
+	to := dateOnly(runDate).AddDate(0, 0, 6)
+	if st.Freq == "Monthly" {
+		_, _, offset, _ := schedRecurrence(st)
+		to = dateOnly(runDate).AddDate(0, 0, offset)
+	}
+	return recurOccurrences(st, from, to)
+}
+
+// Generate the tasks for occurrences missed since the last run, flagged as overdue
+func schedCatchUp(lastRun time.Time, windowStart time.Time) int {
+	if !windowStart.After(lastRun) {
+		return 0
+	}
+
+	log.Printf("»»» SchedTask catch up from %s to %s",
+		lastRun.Format(rfc3339DateLayout),
+		windowStart.Format(rfc3339DateLayout))
+
+	scheds := []shared.SchedTask{}
+	newTask := shared.Task{}
+	DB.SQL(`select * from sched_task where paused=false order by id`).QueryStructs(&scheds)
+
+	numTasks := 0
+	for _, st := range scheds {
+		cal := machineCalendar(st.MachineID)
+		for _, o := range missedOccurrences(st, lastRun, windowStart) {
+
+			// make sure it wasnt picked up by an earlier run, which may have
+			// rolled it forward to a working day
+			workStart := cal.NextWorkday(o.StartDate)
+			generated := 0
+			DB.SQL(`select count(*) 
+				from task 
+				where sched_id=$1 and startdate::date in ($2::date,$3::date)`, st.ID, o.StartDate, workStart).
+				QueryScalar(&generated)
+			if generated > 0 {
+				continue
+			}
+
+			log.Printf("»»» Task %d %s missed on %s, generating as overdue",
+				st.ID, st.Freq, o.StartDate.Format(rfc3339DateLayout))
+
+			genTask(st, &newTask, o.StartDate, o.DueDate)
+			DB.SQL(`update task set overdue=true where id=$1`, newTask.ID).Exec()
+			numTasks++
+		}
+	}
+	return numTasks
+}
+
+// Record the date of the last successful scheduler run
+func setLastRun(runDate time.Time) {
+	res, err := DB.SQL(`update sched_control set last_run=$1 where id=1`, runDate).Exec()
+	if err != nil {
+		log.Println("setLastRun:", err.Error())
+		return
+	}
+	if res.RowsAffected == 0 {
+		DB.SQL(`insert into sched_control (id,last_run) values (1,$1)`, runDate).Exec()
+	}
+}
+
+// Record the machine job count or hours at the time the sched task last generated a task
+func setSchedControl(schedID int, genDate time.Time, column string, value interface{}) {
+	res, err := DB.SQL(`update sched_control_task 
+		set last_gen=$2, `+column+`=$3 
+		where task_id=$1`, schedID, genDate, value).Exec()
+	if err != nil {
+		log.Println("setSchedControl:", err.Error())
+		return
+	}
+	if res.RowsAffected == 0 {
+		DB.SQL(`insert into sched_control_task 
+			(task_id,last_gen,`+column+`) 
+			values ($1,$2,$3)`, schedID, genDate, value).Exec()
+	}
+}
+
+// Set the start date that an Every N Days, Every N Months or Custom Rule sched task
+// counts from. It is today when the sched first takes on the frequency, and is kept
+// from then on, so that the occurrences stay put however the tasks get generated.
+func setSchedStart(st *shared.SchedTask, was shared.SchedTask) {
+	switch st.Freq {
+	case "Every N Days", "Every N Months", "Custom Rule":
+	default:
+		return
+	}
+	if st.StartDate != nil {
+		return
+	}
+	if was.Freq == st.Freq && was.StartDate != nil {
+		st.StartDate = was.StartDate
+		return
+	}
+	today := dateOnly(time.Now())
+	st.StartDate = &today
+}
+
+// Record the machine job count or hours that a meter based sched task counts from,
+// so that it does not fire straight away on the whole of the machine's history
+func setSchedBaseline(st shared.SchedTask) {
+	column := ""
+	var value interface{}
+	switch st.Freq {
+	case "Job Count":
+		jobCount := 0
+		DB.SQL(`select job_count from machine where id=$1`, st.MachineID).QueryScalar(&jobCount)
+		column, value = "last_jobcount", jobCount
+	case "Every N Hours":
+		column, value = "last_hours", machineHours(st.MachineID, time.Now())
+	default:
+		return
+	}
+
+	res, err := DB.SQL(`update sched_control_task 
+		set `+column+`=$2 
+		where task_id=$1`, st.ID, value).Exec()
+	if err != nil {
+		log.Println("setSchedBaseline:", err.Error())
+		return
+	}
+	if res.RowsAffected == 0 {
+		DB.SQL(`insert into sched_control_task 
+			(task_id,`+column+`) 
+			values ($1,$2)`, st.ID, value).Exec()
+	}
+}
+
 type machineLookup struct {
 	MachineUser int `db:"machine_user"`
 	SiteUser    int `db:"site_user"`
 }
 
-func genTask(st shared.SchedTask, task *shared.Task, startDate time.Time, dueDate time.Time) error {
-
+// Get the user that tasks from this schedule go to, which is the one on the
+// schedule if set, else the machine tasks_to, else the site tasks_to
+func schedUser(st shared.SchedTask) int {
 	userIDs := machineLookup{}
 	DB.SQL(`select 
 		m.tasks_to as machine_user,s.tasks_to as site_user
@@ -755,8 +969,22 @@ func genTask(st shared.SchedTask, task *shared.Task, startDate time.Time, dueDat
 	if st.UserID != 0 {
 		userID = st.UserID
 	}
+	return userID
+}
 
-	escDate := startDate.AddDate(0, 1, 0)
+func genTask(st shared.SchedTask, task *shared.Task, startDate time.Time, dueDate time.Time) error {
+
+	userID := schedUser(st)
+
+	// keep the task off weekends and holidays at the machine's site
+	cal := machineCalendar(st.MachineID)
+	workStart := cal.NextWorkday(startDate)
+	workDue := cal.NextWorkday(dueDate)
+	if workDue.Before(workStart) {
+		workDue = workStart
+	}
+
+	escDate := workStart.AddDate(0, 1, 0)
 
 	task.MachineID = st.MachineID
 	task.SchedID = st.ID
@@ -764,13 +992,17 @@ func genTask(st shared.SchedTask, task *shared.Task, startDate time.Time, dueDat
 	task.ToolID = st.ToolID
 	task.Component = st.Component
 	task.Descr = st.Descr
-	task.StartDate = &startDate
-	task.DueDate = &dueDate
+	task.StartDate = &workStart
+	task.DueDate = &workDue
 	task.EscalateDate = &escDate
 	task.AssignedTo = &userID
-	task.AssignedDate = &startDate
+	task.AssignedDate = &workStart
 	task.LabourEst = st.LabourCost
 	task.MaterialEst = st.MaterialCost
+	task.Status = shared.StatusOpen
+	if userID != 0 {
+		task.Status = shared.StatusAssigned
+	}
 
 	// expand out the hashtags of the SM before we do anything else
 
@@ -798,15 +1030,63 @@ func genTask(st shared.SchedTask, task *shared.Task, startDate time.Time, dueDat
 	println("HashExpand", task.Descr, "to", desc)
 	task.Descr = desc
 
-	DB.InsertInto("task").
+	// The task, its first status and the sched's last_generated go in together
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("genTask:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	err = tx.InsertInto("task").
 		Whitelist("machine_id", "sched_id", "comp_type", "tool_id", "component",
 			"descr", "startdate", "due_date", "escalate_date",
-			"assigned_to", "assigned_date", "labour_est", "material_est").
+			"assigned_to", "assigned_date", "labour_est", "material_est", "status").
 		Record(task).
 		Returning("id").
 		QueryScalar(&task.ID)
+	if err != nil {
+		log.Println("genTask:", err.Error())
+		return err
+	}
+	if err = recordStatus(tx, "task", task.ID, "", task.Status, 0, fmt.Sprintf("Sched %d", st.ID)); err != nil {
+		log.Println("genTask:", err.Error())
+		return err
+	}
+	if userID != 0 {
+		if err = addTaskTech(tx, task.ID, userID, false); err != nil {
+			log.Println("genTask:", err.Error())
+			return err
+		}
+	}
+	_, err = tx.SQL(`insert into task_skill (task_id,skill_id,level)
+		select $1,skill_id,level from sched_skill where sched_id=$2`, task.ID, st.ID).Exec()
+	if err != nil {
+		log.Println("genTask:", err.Error())
+		return err
+	}
+
+	// reserve the parts that the sched needs
+	_, err = tx.SQL(`insert into task_part (task_id,part_id,qty,notes)
+		select $1,part_id,qty,notes from sched_task_part where task_id=$2 and qty>0`, task.ID, st.ID).Exec()
+	if err != nil {
+		log.Println("genTask:", err.Error())
+		return err
+	}
+
+	// last_generated stays on the scheduled date rather than the working day it
+	// was rolled to, as that is what the scan compares against
+	_, err = tx.SQL(`update sched_task set last_generated=$2 where id=$1`, st.ID, startDate).Exec()
+	if err != nil {
+		log.Println("genTask:", err.Error())
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		log.Println("genTask:", err.Error())
+		return err
+	}
+	checkReorder(taskParts(task.ID)...)
 
-	DB.SQL(`update sched_task set last_generated=$2 where id=$1`, st.ID, startDate).Exec()
 	lines := strings.Split(desc, "\n")
 	println("lines =", lines)
 
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Session tokens are issued on login, and stored in the user_session table
+// so that a dropped websocket can be rebound to the same user without having
+// to log in again. Tokens are of the form id.nonce.signature, where the
+// signature is an HMAC of id.nonce using the key held in session_key
+
+const (
+	SessionShortLife    = 12 * time.Hour
+	SessionRememberLife = 30 * 24 * time.Hour
+)
+
+var errSession = errors.New("Invalid or expired session")
+
+var sessionKey []byte
+
+type dbSession struct {
+	ID       int       `db:"id"`
+	Token    string    `db:"token"`
+	UserID   int       `db:"user_id"`
+	Remember bool      `db:"remember"`
+	Expires  time.Time `db:"expires"`
+	Route    string    `db:"route"`
+	Routes   string    `db:"routes"`
+}
+
+// Load the signing key from the DB, creating one if none exists yet
+func getSessionKey() []byte {
+	if sessionKey != nil {
+		return sessionKey
+	}
+
+	key := ""
+	DB.SQL(`select key from session_key limit 1`).QueryScalar(&key)
+	if key == "" {
+		key = randomHex(32)
+		DB.SQL(`insert into session_key (key) values ($1)`, key).Exec()
+	}
+	sessionKey = []byte(key)
+	return sessionKey
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Println("Random:", err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+func signSession(payload string) string {
+	mac := hmac.New(sha256.New, getSessionKey())
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Create a new session for the user, and return the signed token
+func newSession(userID int, remember bool) (string, int) {
+
+	// Clear out any sessions that have already expired
+	DB.SQL(`delete from user_session where expires < localtimestamp`).Exec()
+	pruneAPIConns()
+
+	life := SessionShortLife
+	if remember {
+		life = SessionRememberLife
+	}
+
+	id := 0
+	DB.SQL(`insert into user_session (user_id,remember,expires)
+		values ($1,$2,$3) returning id`,
+		userID, remember, time.Now().Add(life)).QueryScalar(&id)
+	if id == 0 {
+		return "", 0
+	}
+
+	payload := fmt.Sprintf("%d.%s", id, randomHex(16))
+	token := payload + "." + signSession(payload)
+	DB.SQL(`update user_session set token=$2 where id=$1`, id, token).Exec()
+	return token, id
+}
+
+// Validate the token and return the matching session record
+func getSession(token string) (*dbSession, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errSession
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(signSession(payload))) {
+		return nil, errSession
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, errSession
+	}
+
+	s := &dbSession{}
+	err = DB.SQL(`select id,token,user_id,remember,expires,route,routes
+		from user_session
+		where id=$1 and token=$2 and expires > localtimestamp`, id, token).
+		QueryStruct(s)
+	if err != nil {
+		return nil, errSession
+	}
+
+	// Remembered sessions slide forward each time they are used
+	if s.Remember {
+		DB.SQL(`update user_session set expires=$2 where id=$1`,
+			s.ID, time.Now().Add(SessionRememberLife)).Exec()
+	}
+	return s, nil
+}
+
+// Record the route history against the session
+func saveSessionRoutes(c *Connection) {
+	if c.SessionID == 0 {
+		return
+	}
+	DB.SQL(`update user_session set route=$2,routes=$3 where id=$1`,
+		c.SessionID, c.Route, strings.Join(c.Routes, "\n")).Exec()
+}
+
+func dropSession(id int) {
+	DB.SQL(`delete from user_session where id=$1`, id).Exec()
+	dropAPIConn(id)
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"itrak-cmms/shared"
@@ -149,7 +151,8 @@ func (s *SiteRPC) Update(data shared.SiteRPCData, retval *bool) error {
 
 	DB.Update("site").
 		SetWhitelist(data.Site, "name", "address", "phone", "fax",
-			"parent_site", "stock_site", "notes", "alerts_to", "tasks_to", "manager").
+			"parent_site", "stock_site", "notes", "alerts_to", "tasks_to", "manager",
+			"map_label", "x", "y", "calendar_id").
 		Where("id = $1", data.Site.ID).
 		Exec()
 
@@ -172,7 +175,8 @@ func (s *SiteRPC) Insert(data shared.SiteRPCData, id *int) error {
 	*id = 0
 	DB.InsertInto("site").
 		Columns("name", "address", "phone", "fax",
-			"parent_site", "stock_site", "notes", "alerts_to", "tasks_to", "manager").
+			"parent_site", "stock_site", "notes", "alerts_to", "tasks_to", "manager",
+			"map_label", "x", "y", "calendar_id").
 		Record(data.Site).
 		Returning("id").
 		QueryScalar(id)
@@ -256,11 +260,14 @@ func (s *SiteRPC) MachineList(data shared.SiteRPCData, machines *[]shared.Machin
 			QueryStructs(&(*machines)[k].Components)
 
 		// and the machine type info as well
-		DB.Select(`name,photo_thumbnail,electrical,hydraulic,pnuematic,lube,printer,console,uncoiler,rollbed,conveyor,encoder,strip_guide`).
+		DB.Select(`name,photo_thumbnail`).
 			From(`machine_type`).
 			Where(`id=$1`, m.MachineType).
 			QueryStruct(&(*machines)[k].MachineTypeData)
 
+		// and the status of each subsystem
+		machineSubsystems(&(*machines)[k])
+
 	}
 
 	logger(start, "Site.MachineList",
@@ -272,32 +279,31 @@ func (s *SiteRPC) MachineList(data shared.SiteRPCData, machines *[]shared.Machin
 	return nil
 }
 
-// For the given site name, return a slice of IDs for sites in that area
-func getSites(theSite string) []int {
+// Top level sites and every site below them, as (group_id, site_id) pairs
+const SiteGroupTree = `with recursive tree(group_id, site_id) as (
+	select id,id from site where parent_site=0
+	union all
+	select t.group_id,s.id from site s join tree t on (s.parent_site=t.site_id)
+)`
 
-	// println("getSites", theSite)
+// For the given site group, return a slice of IDs for all sites in that group
+func getSites(theSite string) []int {
 
 	retval := []int{}
 
-	switch theSite {
-	case "edinburgh", "sa":
-		DB.SQL(`select id from site where name like 'Edinburgh%'`).QuerySlice(&retval)
-	case "minto":
-		DB.SQL(`select id from site where name like 'Minto%'`).QuerySlice(&retval)
-	case "tomago":
-		DB.SQL(`select id from site where name like 'Tomago%'`).QuerySlice(&retval)
-	case "chinderah":
-		DB.SQL(`select id from site where name like 'Chinderah%'`).QuerySlice(&retval)
-	case "usa":
-		DB.SQL(`select id from site where name like 'Connecticut%'`).QuerySlice(&retval)
+	groupID, err := strconv.Atoi(theSite)
+	if err != nil {
+		log.Println("getSites: not a site group", theSite)
+		return retval
 	}
 
+	DB.SQL(SiteGroupTree+`
+		select site_id from tree where group_id=$1`, groupID).QuerySlice(&retval)
 	return retval
 
 }
 
-// Get all machines for the given site, where the site name is given as the general area which covers multiple
-// factories
+// Get all machines for the given site group, which covers multiple factories
 
 func (s *SiteRPC) MachineListAll(data shared.EventRPCData, machines *[]shared.Machine) error {
 	start := time.Now()
@@ -320,11 +326,14 @@ func (s *SiteRPC) MachineListAll(data shared.EventRPCData, machines *[]shared.Ma
 			QueryStructs(&(*machines)[k].Components)
 
 		// and the machine type info as well
-		DB.Select(`name,photo_thumbnail,electrical,hydraulic,pnuematic,lube,printer,console,uncoiler,rollbed,conveyor,encoder,strip_guide`).
+		DB.Select(`name,photo_thumbnail`).
 			From(`machine_type`).
 			Where(`id=$1`, m.MachineType).
 			QueryStruct(&(*machines)[k].MachineTypeData)
 
+		// and the status of each subsystem
+		machineSubsystems(&(*machines)[k])
+
 	}
 
 	logger(start, "Site.MachineListAll",
@@ -360,6 +369,8 @@ func (s *SiteRPC) HomeMachineList(channel int, machines *[]shared.Machine) error
 			Where("machine_id = $1", m.ID).
 			OrderBy("position,zindex,lower(name)").
 			QueryStructs(&(*machines)[k].Components)
+
+		machineSubsystems(&(*machines)[k])
 	}
 
 	logger(start, "Site.HomeMachineList",
@@ -371,127 +382,39 @@ func (s *SiteRPC) HomeMachineList(channel int, machines *[]shared.Machine) error
 	return nil
 }
 
-// Get a SiteStatus Report
+// Get a SiteStatus Report, with the status of each site group rolled up from its machines
 func (s *SiteRPC) StatusReport(channel int, retval *shared.SiteStatusReport) error {
 	start := time.Now()
 
 	conn := Connections.Get(channel)
 
-	retval.Edinburgh = "Running"
-	retval.Minto = "Running"
-	retval.Tomago = "Running"
-	retval.Chinderah = "Running"
-	retval.USA = "Running"
-
-	i := 0
-
-	// Get the overall status for Edinburgh
-	DB.SQL(`select count(m.*) 
-		from machine m
-		left join site s on (s.id = m.site_id)
-		where m.status = 'Stopped' 
-		and s.name like 'Edinburgh%'`).QueryScalar(&i)
-	if i > 0 {
-		retval.Edinburgh = "Stopped"
-	} else {
-		DB.SQL(`select count(m.*) 
-			from machine m
-			left join site s on (s.id = m.site_id)
-			where m.status = 'Needs Attention' 
-			and s.name like 'Edinburgh%'`).QueryScalar(&i)
-		if i > 0 {
-			retval.Edinburgh = "Needs Attention"
-		}
-	}
+	err := DB.SQL(SiteGroupTree + `
+		select g.id,g.name,g.map_label,g.x,g.y,
+		case
+			when sum(case when m.status='Stopped' then 1 else 0 end) > 0 then 'Stopped'
+			when sum(case when m.status='Needs Attention' then 1 else 0 end) > 0 then 'Needs Attention'
+			else 'Running'
+		end as status
+		from site g
+		left join tree t on (t.group_id=g.id)
+		left join machine m on (m.site_id=t.site_id)
+		where g.parent_site=0
+		group by g.id
+		order by lower(g.name)`).QueryStructs(&retval.Groups)
 
-	// Get the overall status for Minto
-	i = 0
-	DB.SQL(`select count(m.*) 
-		from machine m
-		left join site s on (s.id = m.site_id)
-		where m.status = 'Stopped' 
-		and s.name like 'Minto%'`).QueryScalar(&i)
-	if i > 0 {
-		retval.Minto = "Stopped"
-	} else {
-		DB.SQL(`select count(m.*) 
-			from machine m
-			left join site s on (s.id = m.site_id)
-			where m.status = 'Needs Attention' 
-			and s.name like 'Minto%'`).QueryScalar(&i)
-		if i > 0 {
-			retval.Minto = "Needs Attention"
-		}
-	}
-
-	// Get the overall status for Tomago
-	i = 0
-	DB.SQL(`select count(m.*) 
-		from machine m
-		left join site s on (s.id = m.site_id)
-		where m.status = 'Stopped' 
-		and s.name like 'Tomago%'`).QueryScalar(&i)
-	if i > 0 {
-		retval.Tomago = "Stopped"
-	} else {
-		DB.SQL(`select count(m.*) 
-			from machine m
-			left join site s on (s.id = m.site_id)
-			where m.status = 'Needs Attention' 
-			and s.name like 'Tomago%'`).QueryScalar(&i)
-		if i > 0 {
-			retval.Tomago = "Needs Attention"
-		}
+	if err != nil {
+		log.Println(err.Error())
 	}
 
-	// Get the overall status for Chinderah
-	i = 0
-	DB.SQL(`select count(m.*) 
-		from machine m
-		left join site s on (s.id = m.site_id)
-		where m.status = 'Stopped' 
-		and s.name like 'Chinderah%'`).QueryScalar(&i)
-	if i > 0 {
-		retval.Chinderah = "Stopped"
-	} else {
-		DB.SQL(`select count(m.*) 
-			from machine m
-			left join site s on (s.id = m.site_id)
-			where m.status = 'Needs Attention' 
-			and s.name like 'Chinderah%'`).QueryScalar(&i)
-		if i > 0 {
-			retval.Chinderah = "Needs Attention"
-		}
+	status := []string{}
+	for _, v := range retval.Groups {
+		status = append(status, fmt.Sprintf("%s: %s", v.Name, v.Status))
 	}
 
-	// Get the overall status for USA
-	i = 0
-	DB.SQL(`select count(m.*) 
-		from machine m
-		left join site s on (s.id = m.site_id)
-		where m.status = 'Stopped' 
-		and s.name like 'Connecticut%'`).QueryScalar(&i)
-	if i > 0 {
-		retval.USA = "Stopped"
-	} else {
-		DB.SQL(`select count(m.*) 
-			from machine m
-			left join site s on (s.id = m.site_id)
-			where m.status = 'Needs Attention' 
-			and s.name like 'Connecticut%'`).QueryScalar(&i)
-		if i > 0 {
-			retval.USA = "Needs Attention"
-		}
-	}
 	logger(start, "Site.StatusReport",
 		fmt.Sprintf("Channel %d, User %d %s %s",
 			channel, conn.UserID, conn.Username, conn.UserRole),
-		fmt.Sprintf("E: %s M: %s T: %s C: %s U: %s",
-			retval.Edinburgh,
-			retval.Minto,
-			retval.Tomago,
-			retval.Chinderah,
-			retval.USA),
+		strings.Join(status, ", "),
 		channel, conn.UserID, "site", 0, false)
 
 	return nil
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"itrak-cmms/shared"
+)
+
+// Skills matrix.
+//
+// Skills are held by technicians at a level of proficiency, optionally with a
+// certification that runs out. Sched tasks and tasks list the skills they need,
+// and technicians are ranked for a job by how many of those skills they have.
+
+type SkillRPC struct{}
+
+func (s *SkillRPC) List(channel int, skills *[]shared.Skill) error {
+	start := time.Now()
+
+	conn := Connections.Get(channel)
+
+	DB.SQL(`select * from skill order by name`).QueryStructs(skills)
+
+	logger(start, "Skill.List",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d skills", len(*skills)),
+		channel, conn.UserID, "skill", 0, false)
+
+	return nil
+}
+
+func (s *SkillRPC) Get(data shared.SkillRPCData, skill *shared.Skill) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(`select * from skill where id=$1`, data.ID).QueryStruct(skill)
+
+	logger(start, "Skill.Get",
+		fmt.Sprintf("Channel %d, ID %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		skill.Name,
+		data.Channel, conn.UserID, "skill", data.ID, false)
+
+	return nil
+}
+
+func (s *SkillRPC) Insert(data shared.SkillRPCData, id *int) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if data.Skill.Name == "" {
+		return fmt.Errorf("The skill needs a name")
+	}
+
+	err := DB.InsertInto("skill").
+		Whitelist("name", "notes").
+		Record(data.Skill).
+		Returning("id").
+		QueryScalar(id)
+	if err != nil {
+		log.Println("Skill.Insert:", err.Error())
+		return err
+	}
+
+	logger(start, "Skill.Insert",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			data.Channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("ID %d %s", *id, data.Skill.Name),
+		data.Channel, conn.UserID, "skill", *id, true)
+
+	conn.BroadcastAdmin("skill", "insert", *id)
+	return nil
+}
+
+func (s *SkillRPC) Update(data shared.SkillRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if data.Skill.Name == "" {
+		return fmt.Errorf("The skill needs a name")
+	}
+
+	_, err := DB.Update("skill").
+		SetWhitelist(data.Skill, "name", "notes").
+		Where("id = $1", data.ID).
+		Exec()
+	if err != nil {
+		log.Println("Skill.Update:", err.Error())
+		return err
+	}
+
+	logger(start, "Skill.Update",
+		fmt.Sprintf("Channel %d, ID %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		data.Skill.Name,
+		data.Channel, conn.UserID, "skill", data.ID, true)
+
+	conn.BroadcastAdmin("skill", "update", data.ID)
+	*done = true
+	return nil
+}
+
+// Delete a skill, and take it off everyone that has it or needs it
+func (s *SkillRPC) Delete(data shared.SkillRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Skill.Delete:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	for _, table := range []string{"user_skill", "sched_skill", "task_skill"} {
+		_, err = tx.SQL(`delete from `+table+` where skill_id=$1`, data.ID).Exec()
+		if err != nil {
+			log.Println("Skill.Delete:", err.Error())
+			return err
+		}
+	}
+	_, err = tx.SQL(`delete from skill where id=$1`, data.ID).Exec()
+	if err != nil {
+		log.Println("Skill.Delete:", err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Skill.Delete:", err.Error())
+		return err
+	}
+
+	logger(start, "Skill.Delete",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			data.Channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("ID %d", data.ID),
+		data.Channel, conn.UserID, "skill", data.ID, true)
+
+	conn.BroadcastAdmin("skill", "delete", data.ID)
+	*done = true
+	return nil
+}
+
+// Get the skills held by a user
+func (s *SkillRPC) UserSkills(data shared.UserSkillRPCData, skills *[]shared.UserSkill) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(`select x.*,x.skill_id as id,s.name as skill_name,u.username as username
+		from user_skill x
+		left join skill s on s.id=x.skill_id
+		left join users u on u.id=x.user_id
+		where x.user_id=$1
+		order by s.name`, data.ID).QueryStructs(skills)
+
+	logger(start, "Skill.UserSkills",
+		fmt.Sprintf("Channel %d, User %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d skills", len(*skills)),
+		data.Channel, conn.UserID, "users", data.ID, false)
+
+	return nil
+}
+
+// Get everyone that has a skill
+func (s *SkillRPC) SkillUsers(data shared.SkillRPCData, skills *[]shared.UserSkill) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(`select x.*,x.user_id as id,s.name as skill_name,u.username as username
+		from user_skill x
+		left join skill s on s.id=x.skill_id
+		left join users u on u.id=x.user_id
+		where x.skill_id=$1
+		order by x.level desc,u.username`, data.ID).QueryStructs(skills)
+
+	logger(start, "Skill.SkillUsers",
+		fmt.Sprintf("Channel %d, Skill %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d users", len(*skills)),
+		data.Channel, conn.UserID, "skill", data.ID, false)
+
+	return nil
+}
+
+// Add a skill to a user, or update their level and certification if they have it already
+func (s *SkillRPC) SetUserSkill(data shared.UserSkillRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	us := data.UserSkill
+	if us.Level < 1 || us.Level >= len(shared.SkillLevels) {
+		return fmt.Errorf("Invalid skill level %d", us.Level)
+	}
+	if us.Certified != nil && us.Expires != nil && us.Expires.Before(*us.Certified) {
+		return fmt.Errorf("The certification expires before it was issued")
+	}
+
+	res, err := DB.SQL(`update user_skill
+		set level=$3, certified=$4, expires=$5
+		where user_id=$1 and skill_id=$2`,
+		us.UserID, us.SkillID, us.Level, us.Certified, us.Expires).Exec()
+	if err == nil && res.RowsAffected == 0 {
+		_, err = DB.SQL(`insert into user_skill (user_id,skill_id,level,certified,expires)
+			values ($1,$2,$3,$4,$5)`,
+			us.UserID, us.SkillID, us.Level, us.Certified, us.Expires).Exec()
+	}
+	if err != nil {
+		log.Println("Skill.SetUserSkill:", err.Error())
+		return err
+	}
+
+	logger(start, "Skill.SetUserSkill",
+		fmt.Sprintf("Channel %d, User %d, User %d %s %s",
+			data.Channel, us.UserID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Skill %d %s", us.SkillID, shared.SkillLevelName(us.Level)),
+		data.Channel, conn.UserID, "users", us.UserID, true)
+
+	conn.BroadcastAdmin("userskill", "update", us.UserID)
+	*done = true
+	return nil
+}
+
+func (s *SkillRPC) DeleteUserSkill(data shared.UserSkillRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	us := data.UserSkill
+	DB.SQL(`delete from user_skill where user_id=$1 and skill_id=$2`, us.UserID, us.SkillID).Exec()
+
+	logger(start, "Skill.DeleteUserSkill",
+		fmt.Sprintf("Channel %d, User %d, User %d %s %s",
+			data.Channel, us.UserID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Skill %d", us.SkillID),
+		data.Channel, conn.UserID, "users", us.UserID, true)
+
+	conn.BroadcastAdmin("userskill", "delete", us.UserID)
+	*done = true
+	return nil
+}
+
+// Get the skills needed by a sched task
+func (s *SkillRPC) SchedSkills(data shared.SchedTaskRPCData, skills *[]shared.RequiredSkill) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(`select x.skill_id as id,x.skill_id,x.level,s.name as skill_name
+		from sched_skill x
+		left join skill s on s.id=x.skill_id
+		where x.sched_id=$1
+		order by s.name`, data.ID).QueryStructs(skills)
+
+	logger(start, "Skill.SchedSkills",
+		fmt.Sprintf("Channel %d, Sched %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d skills", len(*skills)),
+		data.Channel, conn.UserID, "sched_task", data.ID, false)
+
+	return nil
+}
+
+// Get the skills needed by a task
+func (s *SkillRPC) TaskSkills(data shared.TaskRPCData, skills *[]shared.RequiredSkill) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(`select x.skill_id as id,x.skill_id,x.level,s.name as skill_name
+		from task_skill x
+		left join skill s on s.id=x.skill_id
+		where x.task_id=$1
+		order by s.name`, data.ID).QueryStructs(skills)
+
+	logger(start, "Skill.TaskSkills",
+		fmt.Sprintf("Channel %d, Task %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d skills", len(*skills)),
+		data.Channel, conn.UserID, "task", data.ID, false)
+
+	return nil
+}
+
+// Get the table, key and ID for the sched task or task that needs the skill
+func requiredSkillTarget(data shared.RequiredSkillRPCData) (string, string, string, int, error) {
+	switch {
+	case data.SchedID != 0:
+		return "sched_skill", "sched_id", "sched_task", data.SchedID, nil
+	case data.TaskID != 0:
+		return "task_skill", "task_id", "task", data.TaskID, nil
+	}
+	return "", "", "", 0, fmt.Errorf("No sched task or task given")
+}
+
+// Add a skill needed by a sched task or task, or change the level needed
+func (s *SkillRPC) SetRequiredSkill(data shared.RequiredSkillRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	table, key, entity, id, err := requiredSkillTarget(data)
+	if err != nil {
+		return err
+	}
+	rs := data.RequiredSkill
+	if rs.Level < 1 || rs.Level >= len(shared.SkillLevels) {
+		return fmt.Errorf("Invalid skill level %d", rs.Level)
+	}
+
+	res, err := DB.SQL(`update `+table+` set level=$3
+		where `+key+`=$1 and skill_id=$2`, id, rs.SkillID, rs.Level).Exec()
+	if err == nil && res.RowsAffected == 0 {
+		_, err = DB.SQL(`insert into `+table+` (`+key+`,skill_id,level)
+			values ($1,$2,$3)`, id, rs.SkillID, rs.Level).Exec()
+	}
+	if err != nil {
+		log.Println("Skill.SetRequiredSkill:", err.Error())
+		return err
+	}
+
+	logger(start, "Skill.SetRequiredSkill",
+		fmt.Sprintf("Channel %d, %s %d, User %d %s %s",
+			data.Channel, entity, id, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Skill %d %s", rs.SkillID, shared.SkillLevelName(rs.Level)),
+		data.Channel, conn.UserID, entity, id, true)
+
+	conn.Broadcast(entity, "update", id)
+	*done = true
+	return nil
+}
+
+func (s *SkillRPC) DeleteRequiredSkill(data shared.RequiredSkillRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	table, key, entity, id, err := requiredSkillTarget(data)
+	if err != nil {
+		return err
+	}
+
+	DB.SQL(`delete from `+table+` where `+key+`=$1 and skill_id=$2`,
+		id, data.RequiredSkill.SkillID).Exec()
+
+	logger(start, "Skill.DeleteRequiredSkill",
+		fmt.Sprintf("Channel %d, %s %d, User %d %s %s",
+			data.Channel, entity, id, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Skill %d", data.RequiredSkill.SkillID),
+		data.Channel, conn.UserID, entity, id, true)
+
+	conn.Broadcast(entity, "update", id)
+	*done = true
+	return nil
+}
+
+// Technicians in order of how well they suit a job - at the site first, then by
+// the number of skills they have for it, then by the least open work
+type techRanking []shared.User
+
+func (t techRanking) Len() int      { return len(t) }
+func (t techRanking) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t techRanking) Less(i, j int) bool {
+	if t[i].OnSite != t[j].OnSite {
+		return t[i].OnSite
+	}
+	if t[i].SkillMatch != t[j].SkillMatch {
+		return t[i].SkillMatch > t[j].SkillMatch
+	}
+	if t[i].OpenTasks != t[j].OpenTasks {
+		return t[i].OpenTasks < t[j].OpenTasks
+	}
+	return t[i].Username < t[j].Username
+}
+
+// Get every technician, ranked for the job described by the query
+func rankTechnicians(data shared.TechnicianQuery) ([]shared.User, error) {
+
+	// Work out the site and the skills needed from the event or task
+	siteID := data.ID
+	needed := []shared.RequiredSkill{}
+	if data.TaskID != 0 {
+		DB.SQL(`select skill_id,level from task_skill where task_id=$1`, data.TaskID).QueryStructs(&needed)
+		if siteID == 0 {
+			DB.SQL(`select m.site_id from task t
+				left join machine m on m.id=t.machine_id
+				where t.id=$1`, data.TaskID).QueryScalar(&siteID)
+		}
+	}
+	if data.EventID != 0 && siteID == 0 {
+		DB.SQL(`select site_id from event where id=$1`, data.EventID).QueryScalar(&siteID)
+	}
+	if data.SkillID != 0 {
+		needed = append(needed, shared.RequiredSkill{SkillID: data.SkillID, Level: 1})
+	}
+
+	users := []shared.User{}
+	err := DB.SQL(`select
+		u.id,u.username,u.email,u.role,u.sms,u.name,u.hourly_rate,u.use_mobile,u.local,u.is_tech,u.can_allocate,
+		exists (select 1 from user_site x where x.user_id=u.id and x.site_id=$1) as on_site,
+		(select count(*) from task t
+			where t.completed_date is null and t.status!='Cancelled'
+			and (t.assigned_to=u.id or exists (select 1 from task_tech x where x.task_id=t.id and x.user_id=u.id))
+		) as open_tasks
+		from users u
+		where u.is_tech = true`, siteID).QueryStructs(&users)
+	if err != nil {
+		return users, err
+	}
+
+	// Count up the skills each technician has at the level needed, leaving out
+	// any where the certification has run out
+	ranked := []shared.User{}
+	for _, u := range users {
+		held := []shared.UserSkill{}
+		DB.SQL(`select skill_id,level,expires from user_skill
+			where user_id=$1 and (expires is null or expires >= current_date)`, u.ID).QueryStructs(&held)
+		for _, n := range needed {
+			for _, h := range held {
+				if h.SkillID == n.SkillID && h.Level >= n.Level {
+					u.SkillMatch++
+				}
+			}
+		}
+		if data.MatchOnly && u.SkillMatch < len(needed) {
+			continue
+		}
+
+		u.Ranking = fmt.Sprintf("%s - %d open", u.Username, u.OpenTasks)
+		if len(needed) > 0 {
+			u.Ranking = fmt.Sprintf("%s - %d/%d skills, %d open", u.Username, u.SkillMatch, len(needed), u.OpenTasks)
+		}
+		if !u.OnSite {
+			u.Ranking += ", off site"
+		}
+		ranked = append(ranked, u)
+	}
+	sort.Sort(techRanking(ranked))
+	return ranked, nil
+}
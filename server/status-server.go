@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"itrak-cmms/shared"
+
+	runner "gopkg.in/mgutz/dat.v1/sqlx-runner"
+)
+
+// Task and event statuses.
+//
+// Every task and event carries one of the statuses in shared.Statuses, and can
+// only move between them as allowed by shared.StatusTransitions. All moves go
+// through setStatus, which stamps them into status_history, so that response
+// times and MTTR can be reported on later.
+
+// Record the status of a newly created task or event
+func recordStatus(tx *runner.Tx, entity string, id int, from string, to string, userID int, notes string) error {
+	_, err := tx.SQL(`insert into status_history
+		(entity,entity_id,from_status,to_status,user_id,notes)
+		values ($1,$2,$3,$4,$5,$6)`,
+		entity, id, from, to, userID, notes).Exec()
+	return err
+}
+
+// Move a task or event on to a new status, if that move is allowed from where it is now
+func setStatus(tx *runner.Tx, entity string, id int, to string, userID int, notes string) (string, error) {
+	from := ""
+	var err error
+	switch entity {
+	case "task":
+		err = tx.SQL(`select status from task where id=$1 for update`, id).QueryScalar(&from)
+	case "event":
+		err = tx.SQL(`select status from event where id=$1 for update`, id).QueryScalar(&from)
+	default:
+		return "", fmt.Errorf("Unknown entity %s", entity)
+	}
+	if err != nil {
+		return "", err
+	}
+	if from == "" {
+		from = shared.StatusOpen
+	}
+	ok := shared.CanTransition(from, to)
+	if entity == "event" {
+		ok = shared.CanTransitionEvent(from, to)
+	}
+	if !ok {
+		return from, fmt.Errorf("Cannot move %s %d from %s to %s", entity, id, from, to)
+	}
+
+	switch entity {
+	case "task":
+		_, err = tx.SQL(`update task set status=$2 where id=$1`, id, to).Exec()
+	case "event":
+		_, err = tx.SQL(`update event set status=$2 where id=$1`, id, to).Exec()
+	}
+	if err != nil {
+		return from, err
+	}
+	return from, recordStatus(tx, entity, id, from, to, userID, notes)
+}
+
+// Check that the user is allowed to make this move, over and above it being a valid one.
+// Completing has to go through Complete, so the parts and machine get looked after, and
+// only a manager can sign off the work as Verified. Cancelling is up to a manager, or
+// to the crew on the task.
+func checkStatusChange(conn *Connection, entity string, id int, to string) error {
+	switch to {
+	case shared.StatusCompleted:
+		return fmt.Errorf("Use Complete to mark the work as %s", to)
+	case shared.StatusVerified:
+		switch conn.UserRole {
+		case "Admin", "Site Manager":
+		default:
+			return fmt.Errorf("Only a manager can mark the work as %s", to)
+		}
+	case shared.StatusCancelled:
+		switch conn.UserRole {
+		case "Admin", "Site Manager":
+		default:
+			if entity != "task" || !onTask(id, conn.UserID) {
+				return fmt.Errorf("Only a manager or the crew on the task can mark the work as %s", to)
+			}
+		}
+	}
+	for _, s := range shared.Statuses {
+		if s == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid status %s", to)
+}
+
+// Is the user assigned to the task, or on its crew
+func onTask(taskID int, userID int) bool {
+	if isCrew(taskID, userID) {
+		return true
+	}
+	count := 0
+	DB.SQL(`select count(*) from task
+		where id=$1 and assigned_to=$2`, taskID, userID).QueryScalar(&count)
+	return count > 0
+}
+
+// Clock everyone off a task that is being cancelled, and work out the labour from their time
+func stopTask(tx *runner.Tx, taskID int) error {
+	if _, err := clockOff(tx, taskID, 0, ""); err != nil {
+		return err
+	}
+	return updateTaskLabour(tx, taskID)
+}
+
+// Cancel the tasks still open against an event, returning their IDs
+func cancelEventTasks(tx *runner.Tx, eventID int, userID int, notes string) ([]int, error) {
+	tasks := []int{}
+	err := tx.SQL(`select id from task
+		where event_id=$1 and completed_date is null and status!='Cancelled'
+		order by id`, eventID).QuerySlice(&tasks)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range tasks {
+		if _, err = setStatus(tx, "task", id, shared.StatusCancelled, userID, notes); err != nil {
+			return nil, err
+		}
+		if err = stopTask(tx, id); err != nil {
+			return nil, err
+		}
+	}
+	return tasks, nil
+}
+
+// Complete the event once there are no incomplete tasks left against it, and
+// clear the stoppage off the machine. Returns whether the event and the machine
+// were cleared, with the event read into the given record.
+func closeEventIfDone(tx *runner.Tx, eventID int, userID int, notes string, event *shared.Event) (bool, bool, error) {
+	if eventID == 0 {
+		return false, false, nil
+	}
+
+	// are there any incomplete tasks still attached to this event ?
+	numTasks := 0
+	err := tx.SQL(`select count(*) 
+		from task 
+		where event_id=$1 and completed_date is null and status!='Cancelled'`, eventID).
+		QueryScalar(&numTasks)
+	if err != nil || numTasks > 0 {
+		return false, false, err
+	}
+
+	// Mark the event as complete
+	_, err = tx.SQL(`update event 
+		set completed=now()
+		where id=$1`, eventID).Exec()
+	if err != nil {
+		return false, false, err
+	}
+	if _, err = setStatus(tx, "event", eventID, shared.StatusCompleted, userID, notes); err != nil {
+		return false, false, err
+	}
+
+	if err = tx.SQL(`select * from event where id=$1`, eventID).QueryStruct(event); err != nil {
+		return true, false, err
+	}
+	machineCleared, err := clearEventMachine(tx, event)
+	return true, machineCleared, err
+}
+
+func statusHistory(entity string, id int, history *[]shared.StatusChange) error {
+	return DB.SQL(`select h.*,u.username as username
+		from status_history h
+		left join users u on u.id=h.user_id
+		where h.entity=$1 and h.entity_id=$2
+		order by h.changed,h.id`, entity, id).QueryStructs(history)
+}
+
+func (t *TaskRPC) SetStatus(data shared.TaskStatusRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if err := checkStatusChange(conn, "task", data.ID, data.Status); err != nil {
+		return err
+	}
+	if shared.IsClockStatus(data.Status) {
+		return fmt.Errorf("Use Acknowledge, Start and Pause to mark the task as %s", data.Status)
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Task.SetStatus:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	from, err := setStatus(tx, "task", data.ID, data.Status, conn.UserID, data.Notes)
+	if err != nil {
+		log.Println("Task.SetStatus:", err.Error())
+		return err
+	}
+
+	// A cancelled task can only be reopened while its event is still open
+	if from == shared.StatusCancelled {
+		eventStatus := ""
+		err = tx.SQL(`select coalesce(e.status,'')
+			from task t
+			left join event e on e.id=t.event_id
+			where t.id=$1`, data.ID).QueryScalar(&eventStatus)
+		if err != nil {
+			log.Println("Task.SetStatus:", err.Error())
+			return err
+		}
+		switch eventStatus {
+		case shared.StatusCompleted, shared.StatusVerified, shared.StatusCancelled:
+			return fmt.Errorf("Task %06d cannot be reopened, as its event is %s", data.ID, eventStatus)
+		}
+	}
+
+	// Cancelling the last open task on an event clears the event, the same as completing it
+	eventID := 0
+	eventCleared := false
+	machineCleared := false
+	event := shared.Event{}
+	if data.Status == shared.StatusCancelled {
+		if err = stopTask(tx, data.ID); err != nil {
+			log.Println("Task.SetStatus:", err.Error())
+			return err
+		}
+		err = tx.SQL(`select coalesce(event_id,0) from task where id=$1`, data.ID).QueryScalar(&eventID)
+		if err != nil {
+			log.Println("Task.SetStatus:", err.Error())
+			return err
+		}
+		eventCleared, machineCleared, err = closeEventIfDone(tx, eventID, conn.UserID,
+			fmt.Sprintf("Task %06d Cancelled", data.ID), &event)
+		if err != nil {
+			log.Println("Task.SetStatus:", err.Error())
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Task.SetStatus:", err.Error())
+		return err
+	}
+
+	logger(start, "Task.SetStatus",
+		fmt.Sprintf("Channel %d, Task %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%s to %s %s", from, data.Status, data.Notes),
+		data.Channel, conn.UserID, "task", data.ID, true)
+
+	// cancelling the task frees up its reserved parts, and reopening it takes them back
+	if data.Status == shared.StatusCancelled || from == shared.StatusCancelled {
+		checkReorder(taskParts(data.ID)...)
+	}
+
+	conn.Broadcast("task", "update", data.ID)
+	if eventCleared {
+		conn.Broadcast("event", "update", eventID)
+	}
+	if machineCleared {
+		conn.Broadcast("machine", "update", event.MachineID)
+		conn.Broadcast("sitestatus", "update", 1)
+	}
+	*done = true
+	return nil
+}
+
+func (t *TaskRPC) StatusHistory(data shared.TaskRPCData, history *[]shared.StatusChange) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if err := statusHistory("task", data.ID, history); err != nil {
+		log.Println("Task.StatusHistory:", err.Error())
+		return err
+	}
+
+	logger(start, "Task.StatusHistory",
+		fmt.Sprintf("Channel %d, Task %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d changes", len(*history)),
+		data.Channel, conn.UserID, "task", data.ID, false)
+
+	return nil
+}
+
+func (e *EventRPC) SetStatus(data shared.EventStatusRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if err := checkStatusChange(conn, "event", data.ID, data.Status); err != nil {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Event.SetStatus:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	from, err := setStatus(tx, "event", data.ID, data.Status, conn.UserID, data.Notes)
+	if err != nil {
+		log.Println("Event.SetStatus:", err.Error())
+		return err
+	}
+
+	// Cancelling the event cancels its open tasks, and takes the stoppage off the
+	// machine, the same as completing it
+	tasks := []int{}
+	machineCleared := false
+	event := shared.Event{}
+	if data.Status == shared.StatusCancelled {
+		tasks, err = cancelEventTasks(tx, data.ID, conn.UserID, fmt.Sprintf("Event %d Cancelled", data.ID))
+		if err != nil {
+			log.Println("Event.SetStatus:", err.Error())
+			return err
+		}
+		if err = tx.SQL(`select * from event where id=$1`, data.ID).QueryStruct(&event); err != nil {
+			log.Println("Event.SetStatus:", err.Error())
+			return err
+		}
+		if machineCleared, err = clearEventMachine(tx, &event); err != nil {
+			log.Println("Event.SetStatus:", err.Error())
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Event.SetStatus:", err.Error())
+		return err
+	}
+
+	logger(start, "Event.SetStatus",
+		fmt.Sprintf("Channel %d, Event %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%s to %s %s, %d tasks cancelled", from, data.Status, data.Notes, len(tasks)),
+		data.Channel, conn.UserID, "event", data.ID, true)
+
+	for _, id := range tasks {
+		checkReorder(taskParts(id)...)
+		conn.Broadcast("task", "update", id)
+	}
+	conn.Broadcast("event", "update", data.ID)
+	if machineCleared {
+		conn.Broadcast("machine", "update", event.MachineID)
+		conn.Broadcast("sitestatus", "update", 1)
+	}
+	*done = true
+	return nil
+}
+
+func (e *EventRPC) StatusHistory(data shared.EventRPCData, history *[]shared.StatusChange) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if err := statusHistory("event", data.ID, history); err != nil {
+		log.Println("Event.StatusHistory:", err.Error())
+		return err
+	}
+
+	logger(start, "Event.StatusHistory",
+		fmt.Sprintf("Channel %d, Event %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d changes", len(*history)),
+		data.Channel, conn.UserID, "event", data.ID, false)
+
+	return nil
+}
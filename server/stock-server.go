@@ -0,0 +1,486 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"itrak-cmms/shared"
+
+	runner "gopkg.in/mgutz/dat.v1/sqlx-runner"
+)
+
+// Stock movements and prices.
+//
+// Every change to a part's current_stock goes through moveStock, so that there
+// is a part_stock row for it, and every new price goes through setPartPrice, so
+// that there is a part_price row for it. Stock coming in or going out also goes
+// through the part's cost layers (see cost-server.go).
+//
+// Stock is held at stores. Each site draws its stock from its stock_site, or
+// keeps its own store if that is 0. stock_level holds the stock at each store,
+// with site 0 holding any stock that has not been put into a store yet, and
+// part.current_stock is the total across all stores, plus whatever is in transit
+// between them.
+//
+// Parts are reserved by the open tasks that need them, which is the task_part qty
+// less what has been used so far. Generated tasks reserve the parts in their
+// sched's sched_task_part. The stock available is the stock on hand less what is
+// reserved.
+
+// The qty of the part p reserved by open tasks
+const partReserved = `coalesce((select sum(greatest(r.qty-r.qty_used,0))
+	from task_part r
+	left join task t on t.id=r.task_id
+	where r.part_id=p.id and t.completed_date is null and t.status!='Cancelled'),0)`
+
+// Add delta to the stock on hand for the part at the store (negative to take stock
+// out), and return the new stock on hand at that store
+func moveStock(tx *runner.Tx, partID int, siteID int, delta float64, descr string) (float64, error) {
+	_, err := tx.SQL(`update part set current_stock=current_stock+$2 where id=$1`,
+		partID, delta).Exec()
+	if err != nil {
+		return 0, err
+	}
+	return moveStoreStock(tx, partID, siteID, delta, descr)
+}
+
+// Add delta to the stock at the store only, leaving the part's total alone, as when
+// stock goes into or out of transit between stores
+func moveStoreStock(tx *runner.Tx, partID int, siteID int, delta float64, descr string) (float64, error) {
+	res, err := tx.SQL(`update stock_level
+		set qty=qty+$3, datefrom=now(), notes=$4
+		where part_id=$1 and site_id=$2`, partID, siteID, delta, descr).Exec()
+	if err != nil {
+		return 0, err
+	}
+	if res.RowsAffected == 0 {
+		_, err = tx.SQL(`insert into stock_level (part_id,site_id,qty,notes)
+			values ($1,$2,$3,$4)`, partID, siteID, delta, descr).Exec()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	stock := 0.0
+	err = tx.SQL(`select qty from stock_level where part_id=$1 and site_id=$2`,
+		partID, siteID).QueryScalar(&stock)
+	if err != nil {
+		return stock, err
+	}
+	_, err = tx.InsertInto("part_stock").
+		Columns("part_id", "site_id", "stock_level", "descr").
+		Record(shared.PartStock{
+			PartID:     partID,
+			SiteID:     siteID,
+			StockLevel: stock,
+			Descr:      descr,
+		}).
+		Exec()
+	return stock, err
+}
+
+// Get the store that the task's site draws its stock from
+func taskStore(tx *runner.Tx, taskID int) int {
+	siteID := 0
+	tx.SQL(`select coalesce(case when s.stock_site=0 then s.id else s.stock_site end,0)
+		from task t
+		left join machine m on m.id=t.machine_id
+		left join site s on s.id=m.site_id
+		where t.id=$1`, taskID).QueryScalar(&siteID)
+	return siteID
+}
+
+// Get the parts that the task uses or has reserved
+func taskParts(taskID int) []int {
+	partIDs := []int{}
+	DB.SQL(`select part_id from task_part where task_id=$1`, taskID).QuerySlice(&partIDs)
+	return partIDs
+}
+
+// Get the name of the store, for the stock history
+func storeName(siteID int) string {
+	if siteID == 0 {
+		return "Unallocated"
+	}
+	name := ""
+	DB.SQL(`select name from site where id=$1`, siteID).QueryScalar(&name)
+	return name
+}
+
+// Make price the latest price for the part, if it has changed
+func setPartPrice(tx *runner.Tx, partID int, price float64, descr string, supplierInfo string) error {
+	latest := 0.0
+	err := tx.SQL(`select latest_price from part where id=$1`, partID).QueryScalar(&latest)
+	if err != nil {
+		return err
+	}
+	if latest == price {
+		return nil
+	}
+	_, err = tx.SQL(`update part set latest_price=$2, last_price_date=now() where id=$1`,
+		partID, price).Exec()
+	if err != nil {
+		return err
+	}
+	_, err = tx.InsertInto("part_price").
+		Columns("part_id", "price", "descr", "supplier_info").
+		Record(shared.PartPrice{
+			PartID:       partID,
+			Price:        price,
+			Descr:        descr,
+			SupplierInfo: supplierInfo,
+		}).
+		Exec()
+	return err
+}
+
+// Find the part with the given stock code
+func partByStockCode(stockCode string) (shared.Part, error) {
+	part := shared.Part{}
+	err := DB.SQL(`select * from part where stock_code=$1 limit 1`, stockCode).QueryStruct(&part)
+	return part, err
+}
+
+// Get the sites that hold stock
+func (p *PartRPC) Stores(channel int, sites *[]shared.Site) error {
+	start := time.Now()
+
+	conn := Connections.Get(channel)
+
+	DB.SQL(`select * from site
+		where id in (select case when stock_site=0 then id else stock_site end from site)
+		order by name`).QueryStructs(sites)
+
+	logger(start, "Part.Stores",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d stores", len(*sites)),
+		channel, conn.UserID, "site", 0, false)
+
+	return nil
+}
+
+// Get the stock of a part at each store, how much is on its way there, and how
+// much is reserved by open tasks at the sites that draw from it
+func (p *PartRPC) SiteStock(data shared.PartRPCData, stocks *[]shared.StockLevel) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(`select l.site_id as id,l.part_id,l.site_id,s.name as site_name,l.qty,l.datefrom,l.notes,
+		coalesce((select sum(t.qty) from stock_transfer t
+			where t.part_id=l.part_id and t.to_site=l.site_id and t.status=$2),0) as in_transit,
+		coalesce((select sum(greatest(r.qty-r.qty_used,0))
+			from task_part r
+			left join task t on t.id=r.task_id
+			left join machine m on m.id=t.machine_id
+			left join site x on x.id=m.site_id
+			where r.part_id=l.part_id and t.completed_date is null and t.status!='Cancelled'
+			and case when x.stock_site=0 then x.id else x.stock_site end=l.site_id),0) as reserved
+		from stock_level l
+		left join site s on s.id=l.site_id
+		where l.part_id=$1
+		order by l.site_id=0,s.name`, data.ID, shared.TransferInTransit).QueryStructs(stocks)
+
+	logger(start, "Part.SiteStock",
+		fmt.Sprintf("Channel %d, Part %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d stores", len(*stocks)),
+		data.Channel, conn.UserID, "stock_level", data.ID, false)
+
+	return nil
+}
+
+// Set the stock of a part at a store to what was counted there
+func (p *PartRPC) AdjustStock(data shared.StockAdjustRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Part.AdjustStock:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	current := 0.0
+	tx.SQL(`select qty from stock_level where part_id=$1 and site_id=$2 for update`,
+		data.PartID, data.SiteID).QueryScalar(&current)
+	delta := data.Qty - current
+
+	descr := fmt.Sprintf("Counted %g by %s %s", data.Qty, conn.Username, data.Notes)
+	if _, err = moveStock(tx, data.PartID, data.SiteID, delta, strings.TrimSpace(descr)); err != nil {
+		log.Println("Part.AdjustStock:", err.Error())
+		return err
+	}
+	if _, err = adjustCost(tx, data.PartID, delta, strings.TrimSpace(descr)); err != nil {
+		log.Println("Part.AdjustStock:", err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Part.AdjustStock:", err.Error())
+		return err
+	}
+	checkReorder(data.PartID)
+
+	logger(start, "Part.AdjustStock",
+		fmt.Sprintf("Channel %d, Part %d, Site %d, User %d %s %s",
+			data.Channel, data.PartID, data.SiteID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%g -> %g", current, data.Qty),
+		data.Channel, conn.UserID, "stock_level", data.PartID, true)
+
+	conn.Broadcast("part", "update", data.PartID)
+	*done = true
+	return nil
+}
+
+const stockTransferQuery = `select t.*,
+	p.name as part_name,p.stock_code,p.qty_type,
+	f.name as from_site_name,s.name as to_site_name,u.username as sent_by_name
+	from stock_transfer t
+	left join part p on p.id=t.part_id
+	left join site f on f.id=t.from_site
+	left join site s on s.id=t.to_site
+	left join users u on u.id=t.sent_by`
+
+// Get the transfers of a part, or with an ID of 0, everything still in transit
+func (p *PartRPC) Transfers(data shared.PartRPCData, transfers *[]shared.StockTransfer) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if data.ID == 0 {
+		DB.SQL(stockTransferQuery+`
+			where t.status=$1
+			order by t.sent`, shared.TransferInTransit).QueryStructs(transfers)
+	} else {
+		DB.SQL(stockTransferQuery+`
+			where t.part_id=$1
+			order by t.sent desc
+			limit 20`, data.ID).QueryStructs(transfers)
+	}
+
+	logger(start, "Part.Transfers",
+		fmt.Sprintf("Channel %d, Part %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d transfers", len(*transfers)),
+		data.Channel, conn.UserID, "stock_transfer", data.ID, false)
+
+	return nil
+}
+
+func (p *PartRPC) GetTransfer(data shared.StockTransferRPCData, transfer *shared.StockTransfer) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	err := DB.SQL(stockTransferQuery+`
+		where t.id=$1`, data.ID).QueryStruct(transfer)
+	if err != nil {
+		log.Println("Part.GetTransfer:", err.Error())
+		return err
+	}
+
+	logger(start, "Part.GetTransfer",
+		fmt.Sprintf("Channel %d, Transfer %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Part %d %s", transfer.PartID, transfer.Status),
+		data.Channel, conn.UserID, "stock_transfer", data.ID, false)
+
+	return nil
+}
+
+// Send stock from one store to another, where it is in transit until received.
+// Stock that has not been put into a store yet goes straight into the store.
+func (p *PartRPC) Transfer(data shared.StockTransferRPCData, id *int) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	t := data.StockTransfer
+	if t.PartID == 0 {
+		part, err := partByStockCode(t.StockCode)
+		if err != nil {
+			return fmt.Errorf("No part with stock code %s", t.StockCode)
+		}
+		t.PartID = part.ID
+	}
+	if t.Qty <= 0 {
+		return fmt.Errorf("The quantity to send must be more than 0")
+	}
+	if t.ToSite == 0 || t.ToSite == t.FromSite {
+		return fmt.Errorf("Pick another store to send the stock to")
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Part.Transfer:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	onHand := 0.0
+	tx.SQL(`select qty from stock_level where part_id=$1 and site_id=$2 for update`,
+		t.PartID, t.FromSite).QueryScalar(&onHand)
+	if onHand < t.Qty {
+		return fmt.Errorf("There is only %g at %s", onHand, storeName(t.FromSite))
+	}
+
+	status := shared.TransferInTransit
+	if t.FromSite == 0 {
+		status = shared.TransferReceived
+	}
+	err = tx.SQL(`insert into stock_transfer (part_id,from_site,to_site,qty,status,notes,sent_by)
+		values ($1,$2,$3,$4,$5,$6,$7)
+		returning id`,
+		t.PartID, t.FromSite, t.ToSite, t.Qty, status, t.Notes, conn.UserID).QueryScalar(id)
+	if err != nil {
+		log.Println("Part.Transfer:", err.Error())
+		return err
+	}
+
+	_, err = moveStoreStock(tx, t.PartID, t.FromSite, -t.Qty,
+		fmt.Sprintf("Sent %g to %s, transfer %06d", t.Qty, storeName(t.ToSite), *id))
+	if err != nil {
+		log.Println("Part.Transfer:", err.Error())
+		return err
+	}
+	if status == shared.TransferReceived {
+		if err = receiveTransfer(tx, *id, conn.UserID); err != nil {
+			log.Println("Part.Transfer:", err.Error())
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Part.Transfer:", err.Error())
+		return err
+	}
+
+	logger(start, "Part.Transfer",
+		fmt.Sprintf("Channel %d, Part %d, User %d %s %s",
+			data.Channel, t.PartID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Transfer %d, %g from %d to %d, %s", *id, t.Qty, t.FromSite, t.ToSite, status),
+		data.Channel, conn.UserID, "stock_transfer", *id, true)
+
+	conn.Broadcast("transfer", "insert", *id)
+	conn.Broadcast("part", "update", t.PartID)
+	return nil
+}
+
+// Put the stock from a transfer into the store at the other end
+func receiveTransfer(tx *runner.Tx, id int, userID int) error {
+	t := shared.StockTransfer{}
+	err := tx.SQL(`select * from stock_transfer where id=$1 for update`, id).QueryStruct(&t)
+	if err != nil {
+		return err
+	}
+	if t.Status == shared.TransferCancelled {
+		return fmt.Errorf("Transfer %06d has been cancelled", id)
+	}
+
+	_, err = moveStoreStock(tx, t.PartID, t.ToSite, t.Qty,
+		fmt.Sprintf("Received %g from %s, transfer %06d", t.Qty, storeName(t.FromSite), id))
+	if err != nil {
+		return err
+	}
+	_, err = tx.SQL(`update stock_transfer
+		set status=$2, received=now(), received_by=$3
+		where id=$1`, id, shared.TransferReceived, userID).Exec()
+	return err
+}
+
+// The stock has arrived at the store it was sent to
+func (p *PartRPC) ReceiveTransfer(data shared.StockTransferRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Part.ReceiveTransfer:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	status := ""
+	tx.SQL(`select status from stock_transfer where id=$1 for update`, data.ID).QueryScalar(&status)
+	if status != shared.TransferInTransit {
+		return fmt.Errorf("Transfer %06d is not in transit", data.ID)
+	}
+	if err = receiveTransfer(tx, data.ID, conn.UserID); err != nil {
+		log.Println("Part.ReceiveTransfer:", err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Part.ReceiveTransfer:", err.Error())
+		return err
+	}
+
+	logger(start, "Part.ReceiveTransfer",
+		fmt.Sprintf("Channel %d, Transfer %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		"Received",
+		data.Channel, conn.UserID, "stock_transfer", data.ID, true)
+
+	conn.Broadcast("transfer", "update", data.ID)
+	*done = true
+	return nil
+}
+
+// Cancel a transfer that is still in transit, and put the stock back where it came from
+func (p *PartRPC) CancelTransfer(data shared.StockTransferRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Part.CancelTransfer:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	t := shared.StockTransfer{}
+	err = tx.SQL(`select * from stock_transfer where id=$1 for update`, data.ID).QueryStruct(&t)
+	if err != nil {
+		log.Println("Part.CancelTransfer:", err.Error())
+		return err
+	}
+	if !t.IsInTransit() {
+		return fmt.Errorf("Transfer %06d is not in transit", data.ID)
+	}
+
+	_, err = moveStoreStock(tx, t.PartID, t.FromSite, t.Qty,
+		fmt.Sprintf("Cancelled transfer %06d to %s", t.ID, storeName(t.ToSite)))
+	if err != nil {
+		log.Println("Part.CancelTransfer:", err.Error())
+		return err
+	}
+	_, err = tx.SQL(`update stock_transfer set status=$2 where id=$1`,
+		data.ID, shared.TransferCancelled).Exec()
+	if err != nil {
+		log.Println("Part.CancelTransfer:", err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Part.CancelTransfer:", err.Error())
+		return err
+	}
+
+	logger(start, "Part.CancelTransfer",
+		fmt.Sprintf("Channel %d, Transfer %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		"Cancelled",
+		data.Channel, conn.UserID, "stock_transfer", data.ID, true)
+
+	conn.Broadcast("transfer", "update", data.ID)
+	*done = true
+	return nil
+}
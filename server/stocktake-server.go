@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"itrak-cmms/shared"
+)
+
+// Stock-takes.
+//
+// A stock-take is a count sheet for the parts in a category, and its subcategories,
+// at one store. The sheet is made with the stock expected at the store at that time,
+// and the expected stock is read again as each count is entered, as that is when the
+// shelf was counted. Posting the sheet moves the stock of each part counted by its
+// variance, being what was counted less what was expected, so that stock moving in
+// or out after a part was counted is left alone. Each
+// variance goes into the stock history, and through the cost layers, with the
+// value of the stock gained or lost kept on the sheet.
+
+const stockTakeQuery = `select k.*,
+	s.name as site_name,c.name as category_name,u.username as created_name,
+	(select count(*) from stock_take_line l where l.stock_take_id=k.id) as num_lines,
+	(select count(*) from stock_take_line l where l.stock_take_id=k.id and l.is_counted) as num_counted,
+	coalesce((select sum(l.variance_cost) from stock_take_line l where l.stock_take_id=k.id),0) as variance_cost
+	from stock_take k
+	left join site s on s.id=k.site_id
+	left join category c on c.id=k.category_id
+	left join users u on u.id=k.created_by`
+
+const stockTakeLineQuery = `select l.*,
+	p.name as part_name,p.stock_code,p.qty_type
+	from stock_take_line l
+	left join part p on p.id=l.part_id`
+
+// Get all the part categories, named with their parent categories
+func (p *PartRPC) Categories(channel int, cats *[]shared.Category) error {
+	start := time.Now()
+
+	conn := Connections.Get(channel)
+
+	DB.SQL(`with recursive c(id,parent_id,name) as (
+			select id,parent_id,name from category where parent_id=0
+			union all
+			select x.id,x.parent_id,c.name||' / '||x.name
+			from category x
+			join c on c.id=x.parent_id
+		)
+		select id,parent_id,name from c order by name`).QueryStructs(cats)
+
+	logger(start, "Part.Categories",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d categories", len(*cats)),
+		channel, conn.UserID, "category", 0, false)
+
+	return nil
+}
+
+// Get the stock-takes, newest first
+func (p *PartRPC) StockTakes(channel int, takes *[]shared.StockTake) error {
+	start := time.Now()
+
+	conn := Connections.Get(channel)
+
+	DB.SQL(stockTakeQuery + `
+		order by k.created desc
+		limit 50`).QueryStructs(takes)
+
+	logger(start, "Part.StockTakes",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d stock-takes", len(*takes)),
+		channel, conn.UserID, "stock_take", 0, false)
+
+	return nil
+}
+
+func (p *PartRPC) GetStockTake(data shared.StockTakeRPCData, take *shared.StockTake) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	err := DB.SQL(stockTakeQuery+`
+		where k.id=$1`, data.ID).QueryStruct(take)
+	if err != nil {
+		log.Println("Part.GetStockTake:", err.Error())
+		return err
+	}
+
+	logger(start, "Part.GetStockTake",
+		fmt.Sprintf("Channel %d, StockTake %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Site %d Category %d %s", take.SiteID, take.CategoryID, take.Status),
+		data.Channel, conn.UserID, "stock_take", data.ID, false)
+
+	return nil
+}
+
+// Make a new count sheet for the store and category, with the stock expected of
+// each part at the store now
+func (p *PartRPC) NewStockTake(data shared.StockTakeRPCData, id *int) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if data.StockTake == nil {
+		return fmt.Errorf("Nothing to count")
+	}
+	k := data.StockTake
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Part.NewStockTake:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	err = tx.SQL(`insert into stock_take (site_id,category_id,notes,created_by)
+		values ($1,$2,$3,$4)
+		returning id`, k.SiteID, k.CategoryID, k.Notes, conn.UserID).QueryScalar(id)
+	if err != nil {
+		log.Println("Part.NewStockTake:", err.Error())
+		return err
+	}
+
+	res, err := tx.SQL(`with recursive c(id) as (
+			select $3::int
+			union all
+			select x.id from category x join c on c.id=x.parent_id
+		)
+		insert into stock_take_line (stock_take_id,part_id,expected)
+		select $1,p.id,coalesce(l.qty,0)
+		from part p
+		left join stock_level l on l.part_id=p.id and l.site_id=$2
+		where $3=0 or p.category in (select id from c)
+		order by p.stock_code`, *id, k.SiteID, k.CategoryID).Exec()
+	if err != nil {
+		log.Println("Part.NewStockTake:", err.Error())
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("There are no parts in that category to count")
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Part.NewStockTake:", err.Error())
+		return err
+	}
+
+	logger(start, "Part.NewStockTake",
+		fmt.Sprintf("Channel %d, Site %d, Category %d, User %d %s %s",
+			data.Channel, k.SiteID, k.CategoryID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("StockTake %d, %d parts", *id, res.RowsAffected),
+		data.Channel, conn.UserID, "stock_take", *id, true)
+
+	conn.Broadcast("stocktake", "insert", *id)
+	return nil
+}
+
+// Get the count sheet for the stock-take
+func (p *PartRPC) StockTakeLines(data shared.StockTakeRPCData, lines *[]shared.StockTakeLine) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(stockTakeLineQuery+`
+		where l.stock_take_id=$1
+		order by p.stock_code,p.name`, data.ID).QueryStructs(lines)
+
+	logger(start, "Part.StockTakeLines",
+		fmt.Sprintf("Channel %d, StockTake %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d lines", len(*lines)),
+		data.Channel, conn.UserID, "stock_take_line", data.ID, false)
+
+	return nil
+}
+
+func (p *PartRPC) GetStockTakeLine(data shared.StockTakeLineRPCData, line *shared.StockTakeLine) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	err := DB.SQL(stockTakeLineQuery+`
+		where l.id=$1`, data.ID).QueryStruct(line)
+	if err != nil {
+		log.Println("Part.GetStockTakeLine:", err.Error())
+		return err
+	}
+
+	logger(start, "Part.GetStockTakeLine",
+		fmt.Sprintf("Channel %d, Line %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("StockTake %d Part %d", line.StockTakeID, line.PartID),
+		data.Channel, conn.UserID, "stock_take_line", data.ID, false)
+
+	return nil
+}
+
+// Enter the qty counted for a part on an open count sheet
+func (p *PartRPC) CountStockTake(data shared.StockTakeLineRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if data.StockTakeLine == nil {
+		return fmt.Errorf("Nothing counted")
+	}
+	if data.StockTakeLine.Counted < 0 {
+		return fmt.Errorf("The qty counted cannot be negative")
+	}
+
+	takeID := 0
+	status := ""
+	DB.SQL(`select k.id,k.status
+		from stock_take_line l
+		left join stock_take k on k.id=l.stock_take_id
+		where l.id=$1`, data.ID).QueryScalar(&takeID, &status)
+	if status != shared.StockTakeOpen {
+		return fmt.Errorf("Stock-take %06d is not open for counting", takeID)
+	}
+
+	// the count already takes in anything that moved since the sheet was made,
+	// so expect the stock as it is now
+	_, err := DB.SQL(`update stock_take_line l
+		set counted=$2, is_counted=true, notes=$3,
+		expected=coalesce((select s.qty
+			from stock_take k
+			left join stock_level s on s.site_id=k.site_id and s.part_id=l.part_id
+			where k.id=l.stock_take_id),0)
+		where l.id=$1`, data.ID, data.StockTakeLine.Counted, data.StockTakeLine.Notes).Exec()
+	if err != nil {
+		log.Println("Part.CountStockTake:", err.Error())
+		return err
+	}
+
+	logger(start, "Part.CountStockTake",
+		fmt.Sprintf("Channel %d, Line %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("StockTake %d counted %g", takeID, data.StockTakeLine.Counted),
+		data.Channel, conn.UserID, "stock_take_line", data.ID, true)
+
+	conn.Broadcast("stocktake", "update", takeID)
+	*done = true
+	return nil
+}
+
+// Post the variances on the count sheet to the stock at the store. Parts that
+// have not been counted are left as they are.
+func (p *PartRPC) PostStockTake(data shared.StockTakeRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Part.PostStockTake:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	k := shared.StockTake{}
+	err = tx.SQL(`select * from stock_take where id=$1 for update`, data.ID).QueryStruct(&k)
+	if err != nil {
+		log.Println("Part.PostStockTake:", err.Error())
+		return err
+	}
+	if !k.IsOpen() {
+		return fmt.Errorf("Stock-take %06d is %s, and cannot be posted", k.ID, k.Status)
+	}
+
+	lines := []shared.StockTakeLine{}
+	err = tx.SQL(`select * from stock_take_line
+		where stock_take_id=$1 and is_counted
+		order by id`, data.ID).QueryStructs(&lines)
+	if err != nil {
+		log.Println("Part.PostStockTake:", err.Error())
+		return err
+	}
+	if len(lines) == 0 {
+		return fmt.Errorf("Nothing has been counted on stock-take %06d", k.ID)
+	}
+
+	partIDs := []int{}
+	total := 0.0
+	for _, l := range lines {
+		delta := l.Variance()
+		if delta == 0 {
+			continue
+		}
+		descr := strings.TrimSpace(fmt.Sprintf("Stock-take %06d counted %g, expected %g by %s %s",
+			k.ID, l.Counted, l.Expected, conn.Username, l.Notes))
+		if _, err = moveStock(tx, l.PartID, k.SiteID, delta, descr); err != nil {
+			log.Println("Part.PostStockTake:", err.Error())
+			return err
+		}
+		value, err := adjustCost(tx, l.PartID, delta, descr)
+		if err != nil {
+			log.Println("Part.PostStockTake:", err.Error())
+			return err
+		}
+		_, err = tx.SQL(`update stock_take_line set variance_cost=$2 where id=$1`, l.ID, value).Exec()
+		if err != nil {
+			log.Println("Part.PostStockTake:", err.Error())
+			return err
+		}
+		partIDs = append(partIDs, l.PartID)
+		total += value
+	}
+
+	_, err = tx.SQL(`update stock_take
+		set status=$2, posted=now(), posted_by=$3
+		where id=$1`, k.ID, shared.StockTakePosted, conn.UserID).Exec()
+	if err != nil {
+		log.Println("Part.PostStockTake:", err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Part.PostStockTake:", err.Error())
+		return err
+	}
+	checkReorder(partIDs...)
+
+	logger(start, "Part.PostStockTake",
+		fmt.Sprintf("Channel %d, StockTake %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d counted, %d variances, $ %.2f", len(lines), len(partIDs), total),
+		data.Channel, conn.UserID, "stock_take", data.ID, true)
+
+	conn.Broadcast("stocktake", "update", data.ID)
+	for _, partID := range partIDs {
+		conn.Broadcast("part", "update", partID)
+	}
+	*done = true
+	return nil
+}
+
+// Abandon an open stock-take, leaving the stock alone
+func (p *PartRPC) CancelStockTake(data shared.StockTakeRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	res, err := DB.SQL(`update stock_take set status=$2
+		where id=$1 and status=$3`,
+		data.ID, shared.StockTakeCancelled, shared.StockTakeOpen).Exec()
+	if err != nil {
+		log.Println("Part.CancelStockTake:", err.Error())
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("Stock-take %06d is not open", data.ID)
+	}
+
+	logger(start, "Part.CancelStockTake",
+		fmt.Sprintf("Channel %d, StockTake %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		"Cancelled",
+		data.Channel, conn.UserID, "stock_take", data.ID, true)
+
+	conn.Broadcast("stocktake", "update", data.ID)
+	*done = true
+	return nil
+}
@@ -39,13 +39,72 @@ func (t *TaskRPC) Update(data shared.TaskRPCData, updatedTask *shared.Task) erro
 
 	if useRole == "Admin" {
 
-		// Admin can re-assign the task to another user
-		DB.Update("task").
+		// Admin can re-assign the task to another user, handing over their place on
+		// the crew in the same transaction
+		tx, err := DB.Begin()
+		if err != nil {
+			log.Println("Task.Update:", err.Error())
+			return err
+		}
+		defer tx.AutoRollback()
+
+		_, err = tx.Update("task").
 			SetWhitelist(data.Task,
 				"log", "assigned_to",
 				"labour_cost", "material_cost", "labour_hrs").
 			Where("id = $1", data.Task.ID).
 			Exec()
+		if err != nil {
+			log.Println("Task.Update:", err.Error())
+			return err
+		}
+
+		oldUser := 0
+		if oldTask.AssignedTo != nil {
+			oldUser = *oldTask.AssignedTo
+		}
+		newUser := 0
+		if data.Task.AssignedTo != nil {
+			newUser = *data.Task.AssignedTo
+		}
+
+		// Giving an open task to someone makes it assigned
+		if oldTask.Status == shared.StatusOpen && oldUser == 0 && newUser != 0 {
+			if _, err = setStatus(tx, "task", data.Task.ID, shared.StatusAssigned, conn.UserID, ""); err != nil {
+				log.Println("Task.Update:", err.Error())
+				return err
+			}
+		}
+
+		// whoever it was taken off comes off the crew, unless they have already
+		// put time into it, so that they are not left holding up the sign-off
+		if oldUser != 0 && oldUser != newUser {
+			_, err = tx.SQL(`delete from task_tech
+				where task_id=$1 and user_id=$2
+				and not exists (select 1 from task_time where task_id=$1 and user_id=$2)`,
+				data.Task.ID, oldUser).Exec()
+			if err != nil {
+				log.Println("Task.Update:", err.Error())
+				return err
+			}
+		}
+
+		// and whoever it is given to goes on the crew
+		if newUser != 0 {
+			_, err = tx.SQL(`insert into task_tech (task_id,user_id)
+				select $1,$2
+				where not exists (select 1 from task_tech where task_id=$1 and user_id=$2)`,
+				data.Task.ID, newUser).Exec()
+			if err != nil {
+				log.Println("Task.Update:", err.Error())
+				return err
+			}
+		}
+
+		if err = tx.Commit(); err != nil {
+			log.Println("Task.Update:", err.Error())
+			return err
+		}
 
 	} else {
 		DB.Update("task").
@@ -218,9 +277,11 @@ func (t *TaskRPC) Delete(data shared.TaskRPCData, done *bool) error {
 
 	conn := Connections.Get(data.Channel)
 
+	partIDs := taskParts(data.Task.ID)
 	DB.DeleteFrom("task").
 		Where("id=$1", data.Task.ID).
 		Exec()
+	checkReorder(partIDs...)
 
 	logger(start, "Task.Delete",
 		fmt.Sprintf("Channel %d, Task %d, User %d %s %s",
@@ -246,7 +307,8 @@ func (t *TaskRPC) List(channel int, tasks *[]shared.Task) error {
 			left join machine m on m.id=t.machine_id
 			left join site s on s.id=m.site_id
 			left join users u on u.id=t.assigned_to
-		where t.assigned_to=$1 and completed_date is null
+		where (t.assigned_to=$1 or exists (select 1 from task_tech x where x.task_id=t.id and x.user_id=$1))
+		and completed_date is null and t.status!='Cancelled'
 		order by t.startdate desc, id desc`, conn.UserID).
 			QueryStructs(tasks)
 		if err != nil {
@@ -265,7 +327,7 @@ func (t *TaskRPC) List(channel int, tasks *[]shared.Task) error {
 			left join site s on s.id=m.site_id
 			left join users u on u.id=t.assigned_to
 			left join user_site x on x.user_id=$2 and x.site_id=m.site_id
-		where m.site_id in $1 and completed_date is null
+		where m.site_id in $1 and completed_date is null and t.status!='Cancelled'
 		order by t.startdate desc, id desc`, sites, conn.UserID).
 			QueryStructs(tasks)
 		if err != nil {
@@ -280,7 +342,7 @@ func (t *TaskRPC) List(channel int, tasks *[]shared.Task) error {
 			left join site s on s.id=m.site_id
 			left join users u on u.id=t.assigned_to
 			left join user_site x on x.user_id=$1 and x.site_id=m.site_id
-		where completed_date is null
+		where completed_date is null and t.status!='Cancelled'
 		order by t.startdate desc, id desc`, conn.UserID).
 			QueryStructs(tasks)
 		if err != nil {
@@ -495,6 +557,10 @@ func (t *TaskRPC) Get(data shared.TaskRPCData, task *shared.Task) error {
 		QueryStructs(&photos)
 	task.Photos = photos
 
+	task.ClockedOn = isClockedOn(data.ID, conn.UserID)
+	task.OnCrew = isCrew(data.ID, conn.UserID)
+	task.SignedOff = isSignedOff(data.ID, conn.UserID)
+
 	// Now, if the user requesting this read is the person assigned to, then
 	// stamp the task as having been read
 	if !task.IsRead && task.AssignedTo != nil && conn.UserID == *task.AssignedTo {
@@ -645,141 +711,83 @@ func (t *TaskRPC) Complete(data shared.TaskRPCData, done *bool) error {
 
 	conn := Connections.Get(data.Channel)
 
+	// Completing the task, signing off the crew and clearing the stoppage are one
+	// transaction. The parts were taken out of stock as they were used, by AddParts.
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Task.Complete:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
 	// Mark the task as complete
-	DB.SQL(`update task 
+	_, err = tx.SQL(`update task 
 		set completed_date=now()
 		where id=$1`, data.Task.ID).Exec()
+	if err != nil {
+		log.Println("Task.Complete:", err.Error())
+		return err
+	}
+	if _, err = setStatus(tx, "task", data.Task.ID, shared.StatusCompleted, conn.UserID, ""); err != nil {
+		log.Println("Task.Complete:", err.Error())
+		return err
+	}
 
-	// Decrement the stock values for any parts used
-	for _, v := range data.Task.Parts {
-		if v.QtyUsed != 0 {
-			thePart := shared.Part{}
-			DB.SQL(`select * from part where id=$1`, v.PartID).QueryStruct(&thePart)
-			thePart.CurrentStock -= v.QtyUsed
-			DB.SQL(`update part set current_stock=$2 where id=$1`, thePart.ID, thePart.CurrentStock).Exec()
-			DB.SQL(`insert into part_stock (part_id, stock_level, descr) values ($1, $2, $3)`,
-				thePart.ID,
-				thePart.CurrentStock,
-				fmt.Sprintf("Used %.02f on task %06d : %s", v.QtyUsed, data.Task.ID, v.Notes)).
-				Exec()
+	// Completing the task signs off the user's own part of it, and the rest of
+	// the crew has to have signed off already, unless a manager is closing it out
+	if err = signOff(tx, data.Task.ID, conn.UserID); err != nil {
+		log.Println("Task.Complete:", err.Error())
+		return err
+	}
+	waiting, err := crewNotSignedOff(tx, data.Task.ID)
+	if err != nil {
+		log.Println("Task.Complete:", err.Error())
+		return err
+	}
+	switch conn.UserRole {
+	case "Admin", "Site Manager":
+	default:
+		if len(waiting) > 0 {
+			return fmt.Errorf("Task %06d is still waiting on sign-off from %s",
+				data.Task.ID, strings.Join(waiting, ", "))
 		}
-
 	}
 
-	conn.Broadcast("task", "update", data.Task.ID)
+	// Anyone still on the job is clocked off, and the labour worked out from their time
+	if _, err = clockOff(tx, data.Task.ID, 0, ""); err != nil {
+		log.Println("Task.Complete:", err.Error())
+		return err
+	}
+	if err = updateTaskLabour(tx, data.Task.ID); err != nil {
+		log.Println("Task.Complete:", err.Error())
+		return err
+	}
 
 	// If the task has a parent event, then clear the event IF there are
 	// no incomplete tasks left against that event.
+	event := shared.Event{}
+	eventCleared, machineCleared, err := closeEventIfDone(tx, data.Task.EventID, conn.UserID,
+		fmt.Sprintf("Task %06d", data.Task.ID), &event)
+	if err != nil {
+		log.Println("Task.Complete:", err.Error())
+		return err
+	}
 
-	if data.Task.EventID != 0 {
-
-		// are there any incomplete tasks still attached to this event ?
-		numTasks := 0
-		DB.SQL(`select count(*) 
-			from task 
-			where event_id=$1 and completed_date is null`, data.Task.EventID).
-			QueryScalar(&numTasks)
-
-		if numTasks == 0 {
-			// Mark the event as complete
-			DB.SQL(`update event 
-				set completed=now(), status='Complete'
-				where id=$1`, data.Task.EventID).Exec()
-
-			conn.Broadcast("event", "update", data.Task.EventID)
-
-			event := &shared.Event{}
-			id := data.Task.EventID
-			DB.SQL(`select
-				e.*,m.name as machine_name,s.name as site_name,u.username as username
-				from event e
-					left join machine m on m.id=e.machine_id
-					left join site s on s.id=m.site_id
-					left join users u on u.id=e.created_by
-				where e.id=$1`, id).QueryStruct(event)
-
-			// fetch all assignments
-			DB.SQL(`select u.username
-				from task t
-				left join users u on u.id=t.assigned_to
-				where t.event_id=$1`, id).
-				QueryStructs(&event.AssignedTo)
-
-			// Reset the affected component - this code is the reverse of
-			// the code in the RaiseEvent function above
-			if event.ToolID == 0 {
-				// Reset the status of the basic component on this machine
-				fieldName := ""
-				switch event.ToolType {
-				case "Electrical":
-					fieldName = "electrical"
-				case "Hydraulic":
-					fieldName = "hydraulic"
-				case "Lube":
-					fieldName = "lube"
-				case "Printer":
-					fieldName = "printer"
-				case "Console":
-					fieldName = "console"
-				case "Uncoiler":
-					fieldName = "uncoiler"
-				case "Rollbed":
-					fieldName = "rollbed"
-				case "Pnuematic":
-					fieldName = "pnuematic"
-				case "Conveyor":
-					fieldName = "conveyor"
-				case "Encoder":
-					fieldName = "encoder"
-				case "StripGuide":
-					fieldName = "strip_guide"
-
-				}
-				if fieldName != "" {
-					DB.SQL(fmt.Sprintf("update machine set %s='Running' where id=$1", fieldName), event.MachineID).Exec()
-				}
-			} else {
-				// is a tool
-				DB.SQL(`update component
-					set status='Running'
-					where id=$1`, event.ToolID).
-					Exec()
-			}
-
-			// Reset the whole machine if clear
-			machineIsClear := true
-			machine := shared.Machine{}
-			DB.SQL(`select * from machine where id=$1`, event.MachineID).QueryStruct(&machine)
-
-			if machine.Electrical != "Running" ||
-				machine.Hydraulic != "Running" ||
-				machine.Printer != "Running" ||
-				machine.Console != "Running" ||
-				machine.Rollbed != "Running" ||
-				machine.Uncoiler != "Running" ||
-				machine.Pnuematic != "Running" ||
-				machine.Conveyor != "Running" ||
-				machine.Encoder != "Running" ||
-				machine.StripGuide != "Running" ||
-				machine.Lube != "Running" {
-				machineIsClear = false
-			}
-
-			if machineIsClear {
-				badComps := 0
-				DB.SQL(`select count(*) 
-					from component 
-					where status != 'Running' and machine_id=$1`, machine.ID).
-					QueryScalar(&badComps)
-
-				if badComps == 0 {
-					DB.SQL("update machine set status='Running' where id=$1", event.MachineID).Exec()
-					conn.Broadcast("machine", "update", event.MachineID)
-				}
-			}
+	if err = tx.Commit(); err != nil {
+		log.Println("Task.Complete:", err.Error())
+		return err
+	}
+	// the task no longer holds its reservations, so check all of its parts
+	checkReorder(taskParts(data.Task.ID)...)
 
-		} // after clearing this task, there are no more tasks attached to the stoppage
-	} // Task is linked to a stoppage
+	conn.Broadcast("task", "update", data.Task.ID)
+	if eventCleared {
+		conn.Broadcast("event", "update", data.Task.EventID)
+	}
+	if machineCleared {
+		conn.Broadcast("machine", "update", event.MachineID)
+		conn.Broadcast("sitestatus", "update", 1)
+	}
 
 	// 2 SMS's to generate :
 	// - 1 to the person that allocated the task to the tech
@@ -909,48 +917,106 @@ func (t *TaskRPC) AddParts(data shared.TaskRPCPartData, partsUsedUpdate *shared.
 
 	conn := Connections.Get(data.Channel)
 
-	// get the existing task_part, then remove it
-	oldTaskPart := shared.TaskPart{}
-	DB.SQL(`select * from task_part where task_id=$1 and part_id=$2`, data.ID, data.Part).QueryStruct(&oldTaskPart)
-	DB.SQL(`delete from task_part where task_id=$1 and part_id=$2`, data.ID, data.Part).Exec()
-
-	// insert a new task_part if the qut is not zero
-	if data.Qty != 0.0 {
-		DB.SQL(`insert into task_part
-		(task_id,part_id,qty_used,qty)
-		values ($1,$2,$3,0)`,
-			data.ID, data.Part, data.Qty).Exec()
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Task.AddParts:", err.Error())
+		return err
 	}
+	defer tx.AutoRollback()
 
-	// Calculate the stock difference
+	// get the existing task_part, and calculate the stock difference
+	oldTaskPart := shared.TaskPart{}
+	tx.SQL(`select * from task_part where task_id=$1 and part_id=$2`, data.ID, data.Part).QueryStruct(&oldTaskPart)
 	delta := data.Qty - oldTaskPart.QtyUsed
 
 	// println("OldQty", oldTaskPart.QtyUsed, "NewQty", data.Qty, "delta", delta)
 
-	// Update the stock on hand value on the part
-	oldStockOnHand := 0.0
-	DB.SQL(`select current_stock from part where id=$1`, data.Part).QueryScalar(&oldStockOnHand)
-	newStockOnHand := oldStockOnHand - delta
+	// Cost the parts used out of the cost layers, and put any handed back into them
+	// at what they cost the task, so that the cost to the task is fixed when used
+	cost := oldTaskPart.Cost
+	if delta > 0 {
+		used, err := takeCostLayers(tx, data.Part, delta)
+		if err != nil {
+			log.Println("Task.AddParts:", err.Error())
+			return err
+		}
+		cost += used
+	}
+	if delta < 0 {
+		unit := unitCost(tx, data.Part)
+		if oldTaskPart.QtyUsed > 0 {
+			unit = oldTaskPart.Cost / oldTaskPart.QtyUsed
+		}
+		err = addCostLayer(tx, data.Part, -delta, unit, fmt.Sprintf("Returned %.1f from Task %06d", -delta, data.ID))
+		if err != nil {
+			log.Println("Task.AddParts:", err.Error())
+			return err
+		}
+		cost += delta * unit
+	}
+	if data.Qty == 0.0 {
+		cost = 0
+	}
+
+	// update the qty used, keeping the qty reserved for the task as it was
+	res, err := tx.SQL(`update task_part set qty_used=$3, cost=$4 where task_id=$1 and part_id=$2`,
+		data.ID, data.Part, data.Qty, cost).Exec()
+	if err != nil {
+		log.Println("Task.AddParts:", err.Error())
+		return err
+	}
 
-	DB.SQL(`update part set current_stock=$2 where id=$1`, data.Part, newStockOnHand).Exec()
+	// insert a new task_part if there is none, and drop it again if there is
+	// nothing reserved or used
+	if res.RowsAffected == 0 && data.Qty != 0.0 {
+		_, err = tx.SQL(`insert into task_part
+		(task_id,part_id,qty_used,qty,cost)
+		values ($1,$2,$3,0,$4)`,
+			data.ID, data.Part, data.Qty, cost).Exec()
+		if err != nil {
+			log.Println("Task.AddParts:", err.Error())
+			return err
+		}
+	}
+	_, err = tx.SQL(`delete from task_part where task_id=$1 and part_id=$2 and qty=0 and qty_used=0`,
+		data.ID, data.Part).Exec()
+	if err != nil {
+		log.Println("Task.AddParts:", err.Error())
+		return err
+	}
 
-	// Insert a stock audit record against the part
-	DB.SQL(`insert into part_stock
-		(part_id,stock_level,descr)
-		values ($1,$2,$3)`,
-		data.Part,
-		newStockOnHand,
-		fmt.Sprintf("Used %.1f on Task %06d", delta, data.ID)).Exec()
+	// Update the stock on hand at the store for the task's site, with a stock audit
+	// record against the part
+	newStockOnHand, err := moveStock(tx, data.Part, taskStore(tx, data.ID), -delta,
+		fmt.Sprintf("Used %.1f on Task %06d", delta, data.ID))
+	if err != nil {
+		log.Println("Task.AddParts:", err.Error())
+		return err
+	}
 
-	// Get the new total material cost for this whole task
+	// Get the new total material cost for this whole task, from the cost of the
+	// parts when they were used
 
 	totalMaterialCost := 0.0
-	DB.SQL(`select 
-		sum(t.qty_used * p.latest_price) as totalm 
+	err = tx.SQL(`select 
+		coalesce(sum(t.cost),0) as totalm 
 		from task_part t 
-		left join part p on p.id=t.part_id 
 		where t.task_id=$1`, data.ID).QueryScalar(&totalMaterialCost)
-	DB.SQL(`update task set material_cost=$2 where id=$1`, data.ID, totalMaterialCost).Exec()
+	if err != nil {
+		log.Println("Task.AddParts:", err.Error())
+		return err
+	}
+	_, err = tx.SQL(`update task set material_cost=$2 where id=$1`, data.ID, totalMaterialCost).Exec()
+	if err != nil {
+		log.Println("Task.AddParts:", err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Task.AddParts:", err.Error())
+		return err
+	}
+	checkReorder(data.Part)
 
 	logger(start, "Task.AddParts",
 		fmt.Sprintf("Channel %d, Task %d Part %d Qty %.2f User %d %s %s",
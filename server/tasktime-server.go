@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"itrak-cmms/shared"
+
+	runner "gopkg.in/mgutz/dat.v1/sqlx-runner"
+)
+
+// Technician time on tasks.
+//
+// Technicians acknowledge a task, then clock on and off it with Start, Pause and
+// Resume. Each stretch of work is a task_time row against the technician, and any
+// number of technicians can be on the one task. Whenever time is clocked off, the
+// task's LabourHrs and LabourCost are worked out again from the time entries and
+// each technician's hourly rate.
+
+// Add the user to the technicians on the task, if they are not there already
+func addTaskTech(tx *runner.Tx, taskID int, userID int, ack bool) error {
+	res, err := tx.SQL(`update task_tech
+		set acknowledged=case when $3 then coalesce(acknowledged,now()) else acknowledged end
+		where task_id=$1 and user_id=$2`, taskID, userID, ack).Exec()
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected > 0 {
+		return nil
+	}
+	_, err = tx.SQL(`insert into task_tech (task_id,user_id,acknowledged)
+		values ($1,$2,case when $3 then now() end)`, taskID, userID, ack).Exec()
+	return err
+}
+
+// Clock the user off the task, or everyone if userID is 0, and return how many entries were closed
+func clockOff(tx *runner.Tx, taskID int, userID int, notes string) (int64, error) {
+	res, err := tx.SQL(`update task_time
+		set stopped=now(),
+		hours=round(cast(extract(epoch from now()-started)/3600 as numeric),2),
+		notes=case when $3='' then notes else $3 end
+		where task_id=$1 and (user_id=$2 or $2=0) and stopped is null`,
+		taskID, userID, notes).Exec()
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected, nil
+}
+
+// Work out the labour hours and cost on the task from its time entries. Tasks
+// with no time entries keep the hours that were typed in by hand.
+func updateTaskLabour(tx *runner.Tx, taskID int) error {
+	_, err := tx.SQL(`update task
+		set labour_hrs=x.hrs, labour_cost=x.cost
+		from (select coalesce(sum(t.hours),0) as hrs,
+			coalesce(sum(t.hours*coalesce(u.hourly_rate,0)),0) as cost
+			from task_time t
+			left join users u on u.id=t.user_id
+			where t.task_id=$1 and t.stopped is not null) x
+		where task.id=$1
+		and exists (select 1 from task_time where task_id=$1)`, taskID).Exec()
+	return err
+}
+
+func isClockedOn(taskID int, userID int) bool {
+	count := 0
+	DB.SQL(`select count(*) from task_time
+		where task_id=$1 and user_id=$2 and stopped is null`, taskID, userID).QueryScalar(&count)
+	return count > 0
+}
+
+func (t *TaskRPC) Acknowledge(data shared.TaskTimeRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Task.Acknowledge:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	task := shared.Task{}
+	err = tx.SQL(`select * from task where id=$1`, data.ID).QueryStruct(&task)
+	if err != nil {
+		log.Println("Task.Acknowledge:", err.Error())
+		return err
+	}
+
+	switch task.Status {
+	case shared.StatusAssigned:
+		_, err = setStatus(tx, "task", data.ID, shared.StatusAcknowledged, conn.UserID, data.Notes)
+		if err != nil {
+			log.Println("Task.Acknowledge:", err.Error())
+			return err
+		}
+	case shared.StatusAcknowledged, shared.StatusInProgress, shared.StatusOnHoldParts:
+		// already accepted by someone, so this user is just joining in
+	default:
+		return fmt.Errorf("Task %06d is %s, and cannot be acknowledged", data.ID, task.Status)
+	}
+
+	if err = addTaskTech(tx, data.ID, conn.UserID, true); err != nil {
+		log.Println("Task.Acknowledge:", err.Error())
+		return err
+	}
+
+	if task.AssignedTo != nil && *task.AssignedTo == conn.UserID && !task.IsRead {
+		_, err = tx.SQL(`update task set is_read=true, read_date=now() where id=$1`, data.ID).Exec()
+		if err != nil {
+			log.Println("Task.Acknowledge:", err.Error())
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Task.Acknowledge:", err.Error())
+		return err
+	}
+
+	logger(start, "Task.Acknowledge",
+		fmt.Sprintf("Channel %d, Task %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		data.Notes,
+		data.Channel, conn.UserID, "task", data.ID, true)
+
+	conn.Broadcast("task", "update", data.ID)
+	*done = true
+	return nil
+}
+
+// Clock the user on to the task, moving it to In Progress if it is not there already.
+// from lists the statuses that the task can be clocked on from.
+func clockOn(method string, data shared.TaskTimeRPCData, from []string) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if isClockedOn(data.ID, conn.UserID) {
+		return fmt.Errorf("You are already on the job for task %06d", data.ID)
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println(method+":", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	status := ""
+	err = tx.SQL(`select status from task where id=$1`, data.ID).QueryScalar(&status)
+	if err != nil {
+		log.Println(method+":", err.Error())
+		return err
+	}
+
+	allowed := false
+	for _, s := range from {
+		if s == status {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("Task %06d is %s, and cannot be clocked on to", data.ID, status)
+	}
+
+	if status != shared.StatusInProgress {
+		_, err = setStatus(tx, "task", data.ID, shared.StatusInProgress, conn.UserID, data.Notes)
+		if err != nil {
+			log.Println(method+":", err.Error())
+			return err
+		}
+	}
+
+	_, err = tx.SQL(`insert into task_time (task_id,user_id,started,notes)
+		values ($1,$2,now(),$3)`, data.ID, conn.UserID, data.Notes).Exec()
+	if err != nil {
+		log.Println(method+":", err.Error())
+		return err
+	}
+
+	// Starting work on a task is as good as accepting it
+	if err = addTaskTech(tx, data.ID, conn.UserID, true); err != nil {
+		log.Println(method+":", err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println(method+":", err.Error())
+		return err
+	}
+
+	logger(start, method,
+		fmt.Sprintf("Channel %d, Task %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%s to %s %s", status, shared.StatusInProgress, data.Notes),
+		data.Channel, conn.UserID, "task", data.ID, true)
+
+	conn.Broadcast("task", "update", data.ID)
+	return nil
+}
+
+// Clock on to a task that has not been started, or join others already working on it
+func (t *TaskRPC) Start(data shared.TaskTimeRPCData, done *bool) error {
+	err := clockOn("Task.Start", data, []string{
+		shared.StatusAssigned,
+		shared.StatusAcknowledged,
+		shared.StatusInProgress,
+	})
+	*done = err == nil
+	return err
+}
+
+// Clock back on to a task that was paused or put on hold
+func (t *TaskRPC) Resume(data shared.TaskTimeRPCData, done *bool) error {
+	err := clockOn("Task.Resume", data, []string{
+		shared.StatusInProgress,
+		shared.StatusOnHoldParts,
+	})
+	*done = err == nil
+	return err
+}
+
+// Clock off the task. With Hold set, the task goes on hold waiting for parts, and
+// everyone on it is clocked off.
+func (t *TaskRPC) Pause(data shared.TaskTimeRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Task.Pause:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	count, err := clockOff(tx, data.ID, conn.UserID, data.Notes)
+	if err != nil {
+		log.Println("Task.Pause:", err.Error())
+		return err
+	}
+	if count == 0 && !data.Hold {
+		return fmt.Errorf("You are not on the job for task %06d", data.ID)
+	}
+
+	if data.Hold {
+		if _, err = clockOff(tx, data.ID, 0, ""); err != nil {
+			log.Println("Task.Pause:", err.Error())
+			return err
+		}
+		_, err = setStatus(tx, "task", data.ID, shared.StatusOnHoldParts, conn.UserID, data.Notes)
+		if err != nil {
+			log.Println("Task.Pause:", err.Error())
+			return err
+		}
+	}
+
+	if err = updateTaskLabour(tx, data.ID); err != nil {
+		log.Println("Task.Pause:", err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Task.Pause:", err.Error())
+		return err
+	}
+
+	logger(start, "Task.Pause",
+		fmt.Sprintf("Channel %d, Task %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Hold %t %s", data.Hold, data.Notes),
+		data.Channel, conn.UserID, "task", data.ID, true)
+
+	conn.Broadcast("task", "update", data.ID)
+	*done = true
+	return nil
+}
+
+func (t *TaskRPC) TimeEntries(data shared.TaskRPCData, entries *[]shared.TaskTime) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	err := DB.SQL(`select t.*,u.username as username
+		from task_time t
+		left join users u on u.id=t.user_id
+		where t.task_id=$1
+		order by t.started,t.id`, data.ID).QueryStructs(entries)
+	if err != nil {
+		log.Println("Task.TimeEntries:", err.Error())
+		return err
+	}
+
+	logger(start, "Task.TimeEntries",
+		fmt.Sprintf("Channel %d, Task %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d entries", len(*entries)),
+		data.Channel, conn.UserID, "task", data.ID, false)
+
+	return nil
+}
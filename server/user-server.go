@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -13,43 +14,43 @@ type UserRPC struct{}
 ///////////////////////////////////////////////////////////
 // SQL
 const UserGetQuery = `select 
-u.id,u.username,u.passwd,u.email,u.role,u.sms,u.name,u.hourly_rate,u.use_mobile,u.local,u.is_tech,u.can_allocate
+u.id,u.username,u.email,u.role,u.sms,u.name,u.hourly_rate,u.use_mobile,u.local,u.is_tech,u.can_allocate
 	from users u
 	where id=$1`
 
 const UserListQuery = `select 
-u.id,u.username,u.passwd,u.email,u.role,u.sms,u.name,u.hourly_rate,u.use_mobile,u.local,u.is_tech,u.can_allocate
+u.id,u.username,u.email,u.role,u.sms,u.name,u.hourly_rate,u.use_mobile,u.local,u.is_tech,u.can_allocate
 	from users u
 	order by u.username`
 
 const TechniciansListQuery = `select 
-u.id,u.username,u.passwd,u.email,u.role,u.sms,u.name,u.hourly_rate,u.use_mobile,u.local,u.is_tech,u.can_allocate
+u.id,u.username,u.email,u.role,u.sms,u.name,u.hourly_rate,u.use_mobile,u.local,u.is_tech,u.can_allocate
 	from users u
 	left join user_site x on x.user_id=u.id and x.site_id=$1
 	where u.is_tech = true and x.site_id=$1
 	order by u.username`
 
 const ManagersListQuery = `select 
-u.id,u.username,u.passwd,u.email,u.role,u.sms,u.name,u.hourly_rate,u.use_mobile,u.local,u.is_tech,u.can_allocate
+u.id,u.username,u.email,u.role,u.sms,u.name,u.hourly_rate,u.use_mobile,u.local,u.is_tech,u.can_allocate
 	from users u
 	left join user_site x on x.user_id=u.id and x.site_id=$1
 	where u.role='Site Manager' and x.site_id=$1
 	order by u.username`
 
 const ManagersAllQuery = `select 
-u.id,u.username,u.passwd,u.email,u.role,u.sms,u.name,u.hourly_rate,u.use_mobile,u.local,u.is_tech,u.can_allocate
+u.id,u.username,u.email,u.role,u.sms,u.name,u.hourly_rate,u.use_mobile,u.local,u.is_tech,u.can_allocate
 	from users u
 	where u.role='Site Manager'
 	order by u.username`
 
 const TechniciansAllQuery = `select 
-u.id,u.username,u.passwd,u.email,u.role,u.sms,u.name,u.hourly_rate,u.use_mobile,u.local,u.is_tech,u.can_allocate
+u.id,u.username,u.email,u.role,u.sms,u.name,u.hourly_rate,u.use_mobile,u.local,u.is_tech,u.can_allocate
 	from users u
 	where u.is_tech = true
 	order by u.username`
 
 const AdminsListQuery = `select 
-u.id,u.username,u.passwd,u.email,u.role,u.sms,u.name,u.hourly_rate,u.use_mobile,u.local,u.is_tech,u.can_allocate
+u.id,u.username,u.email,u.role,u.sms,u.name,u.hourly_rate,u.use_mobile,u.local,u.is_tech,u.can_allocate
 	from users u
 	where u.role='Admin'
 	order by u.username`
@@ -108,20 +109,46 @@ func (u *UserRPC) Get(data shared.UserRPCData, prof *shared.User) error {
 }
 
 // Set the user profile from the popdown list at the top
+// Changing the password requires the old password to match
 func (u *UserRPC) Set(req shared.UserUpdate, done *bool) error {
 	start := time.Now()
 
 	conn := Connections.Get(req.Channel)
 
+	// users can only set their own profile
+	req.ID = conn.UserID
+
+	fields := []string{"name", "email", "sms"}
+	if req.Passwd != "" {
+		hash := ""
+		DB.SQL(`select passwd from users where id=$1`, req.ID).QueryScalar(&hash)
+		if !checkPasswd(hash, req.OldPasswd) {
+			logger(start, "User.Set",
+				fmt.Sprintf("Channel %d, User %d %s %s",
+					req.Channel, conn.UserID, conn.Username, conn.UserRole),
+				"Old password does not match",
+				req.Channel, conn.UserID, "users", req.ID, false)
+			return errors.New("Old password does not match")
+		}
+
+		newHash, err := hashPasswd(req.Passwd)
+		if err != nil {
+			log.Println(err.Error())
+			return err
+		}
+		req.Passwd = newHash
+		fields = append(fields, "passwd")
+	}
+
 	DB.Update("users").
-		SetWhitelist(req, "name", "passwd", "email", "sms").
+		SetWhitelist(req, fields...).
 		Where("id = $1", req.ID).
 		Exec()
 
 	logger(start, "User.Set",
 		fmt.Sprintf("Channel %d, User %d %s %s",
 			req.Channel, conn.UserID, conn.Username, conn.UserRole),
-		fmt.Sprintf("%s %s %s %s", req.Email, req.SMS, req.Name, req.Passwd),
+		fmt.Sprintf("%s %s %s %t", req.Email, req.SMS, req.Name, req.Passwd != ""),
 		req.Channel, conn.UserID, "users", req.ID, true)
 
 	// *done = true
@@ -130,17 +157,31 @@ func (u *UserRPC) Set(req shared.UserUpdate, done *bool) error {
 }
 
 // Full update of user record, including username
+// The password is only changed if a new one is supplied
 func (u *UserRPC) Update(data shared.UserRPCData, done *bool) error {
 	start := time.Now()
 
 	conn := Connections.Get(data.Channel)
 
+	fields := []string{"username", "name", "email", "sms",
+		"role", "hourly_rate", "use_mobile", "local", "is_tech", "can_allocate"}
+	if data.User.Passwd != "" {
+		hash, err := hashPasswd(data.User.Passwd)
+		if err != nil {
+			log.Println(err.Error())
+			return err
+		}
+		data.User.Passwd = hash
+		fields = append(fields, "passwd")
+	}
+
 	DB.Update("users").
-		SetWhitelist(data.User, "username", "name", "passwd", "email", "sms",
-			"role", "hourly_rate", "use_mobile", "local", "is_tech", "can_allocate").
+		SetWhitelist(data.User, fields...).
 		Where("id = $1", data.User.ID).
 		Exec()
 
+	data.User.Passwd = ""
+
 	logger(start, "User.Update",
 		fmt.Sprintf("Channel %d, User %d %s %s",
 			data.Channel, conn.UserID, conn.Username, conn.UserRole),
@@ -158,12 +199,21 @@ func (u *UserRPC) Insert(data shared.UserRPCData, id *int) error {
 
 	conn := Connections.Get(data.Channel)
 
+	hash, err := hashPasswd(data.User.Passwd)
+	if err != nil {
+		log.Println(err.Error())
+		return err
+	}
+	data.User.Passwd = hash
+
 	DB.InsertInto("users").
 		Whitelist("username", "name", "passwd", "email", "sms", "hourly_rate", "use_mobile", "local", "is_tech", "can_allocate").
 		Record(data.User).
 		Returning("id").
 		QueryScalar(id)
 
+	data.User.Passwd = ""
+
 	logger(start, "User.Insert",
 		fmt.Sprintf("Channel %d, User %d %s %s",
 			data.Channel, conn.UserID, conn.Username, conn.UserRole),
@@ -188,8 +238,8 @@ func (u *UserRPC) Delete(data shared.UserRPCData, ok *bool) error {
 	logger(start, "User.Delete",
 		fmt.Sprintf("Channel %d, User %d %s %s",
 			data.Channel, conn.UserID, conn.Username, conn.UserRole),
-		fmt.Sprintf("%d %s %s %s %s %s",
-			id, data.User.Username, data.User.Email, data.User.SMS, data.User.Name, data.User.Passwd),
+		fmt.Sprintf("%d %s %s %s %s",
+			id, data.User.Username, data.User.Email, data.User.SMS, data.User.Name),
 		data.Channel, conn.UserID, "users", id, true)
 
 	return nil
@@ -321,15 +371,23 @@ func (u *UserRPC) SetHighlight(data shared.UserSiteSetRequest, done *bool) error
 	return nil
 }
 
-// Get a list of technicians by Site
-func (u *UserRPC) GetTechnicians(data shared.SiteRPCData, users *[]shared.User) error {
+// Get a list of technicians by Site, or ranked for a job if given an event, task or skill
+func (u *UserRPC) GetTechnicians(data shared.TechnicianQuery, users *[]shared.User) error {
 	start := time.Now()
 
 	conn := Connections.Get(data.Channel)
 
 	// log.Println(TechniciansListQuery)
 	site_id := data.ID
-	if site_id == 0 {
+	if data.EventID != 0 || data.TaskID != 0 || data.SkillID != 0 {
+		ranked, err := rankTechnicians(data)
+		if err != nil {
+			log.Println("User.GetTechnicians:", err.Error())
+			return err
+		}
+		*users = ranked
+
+	} else if site_id == 0 {
 		DB.SQL(TechniciansAllQuery).QueryStructs(users)
 
 	} else {
@@ -338,7 +396,7 @@ func (u *UserRPC) GetTechnicians(data shared.SiteRPCData, users *[]shared.User)
 	}
 
 	logger(start, "User.GetTechnicians",
-		fmt.Sprintf("Site %d", site_id),
+		fmt.Sprintf("Site %d Event %d Task %d Skill %d", site_id, data.EventID, data.TaskID, data.SkillID),
 		fmt.Sprintf("%d Techs", len(*users)),
 		data.Channel, conn.UserID, "users", 0, false)
 
@@ -90,14 +90,14 @@ func (u *UtilRPC) Logs(channel int, result *string) error {
 	return nil
 }
 
-// Patch up tha machine PartClass - Steve only
+// Patch up tha machine PartClass
 func (u *UtilRPC) Machine(channel int, result *string) error {
 	start := time.Now()
 
 	conn := Connections.Get(channel)
 	*result = ""
 
-	if conn.UserRole == "Admin" && conn.Username == "steve" {
+	if conn.UserRole == "Admin" {
 
 		// For each machine, set the class the same as the name, and report any errors
 		r := "Processing Machines\n"
@@ -155,7 +155,7 @@ func (u *UtilRPC) Parts(channel int, result *string) error {
 
 	conn := Connections.Get(channel)
 
-	if conn.UserRole == "Admin" && conn.Username == "steve" {
+	if conn.UserRole == "Admin" {
 		// For each part, get the 1st component that its associated
 		// with (under the old scheme), and from there get the machine.
 		//
@@ -216,7 +216,7 @@ func (u *UtilRPC) MTT(channel int, result *string) error {
 	conn := Connections.Get(channel)
 	*result = ""
 
-	if conn.UserRole == "Admin" && conn.Username == "steve" {
+	if conn.UserRole == "Admin" {
 		r := "Processing Machine Type Tools\n"
 
 		components := []shared.Component{}
@@ -259,7 +259,7 @@ func (u *UtilRPC) Thumbnails(channel int, result *string) error {
 	conn := Connections.Get(channel)
 	*result = ""
 
-	if conn.UserRole == "Admin" && conn.Username == "steve" {
+	if conn.UserRole == "Admin" {
 		r := "Generating Thumbnails\n"
 
 		photos := []shared.Photo{}
@@ -313,7 +313,7 @@ func (u *UtilRPC) Thumbnails(channel int, result *string) error {
 // 	conn := Connections.Get(channel)
 // 	*result = ""
 
-// 	if conn.UserRole == "Admin" && conn.Username == "steve" {
+// 	if conn.UserRole == "Admin" {
 // 		r := "Processing Photos into their own tables\n"
 
 // 		println("events")
@@ -423,7 +423,7 @@ func (u *UtilRPC) Cats(channel int, result *string) error {
 
 	conn := Connections.Get(channel)
 
-	if conn.UserRole == "Admin" && conn.Username == "steve" {
+	if conn.UserRole == "Admin" {
 		// For each part, get the 1st component that its associated
 		// with (under the old scheme), and from there get the machine.
 		//
@@ -504,13 +504,15 @@ func (u *UtilRPC) Cats(channel int, result *string) error {
 	return nil
 }
 
-// Generate all the derived accumulated dollars correctly on all tasks
+// Generate all the derived accumulated dollars correctly on all tasks. The material
+// cost comes from the cost of each part when it was used, so repricing parts since
+// does not change it.
 func (u *UtilRPC) TaskFigs(channel int, result *string) error {
 	start := time.Now()
 
 	conn := Connections.Get(channel)
 
-	if conn.UserRole == "Admin" && conn.Username == "steve" {
+	if conn.UserRole == "Admin" {
 		// For each part, get the 1st component that its associated
 		// with (under the old scheme), and from there get the machine.
 		//
@@ -525,9 +527,8 @@ func (u *UtilRPC) TaskFigs(channel int, result *string) error {
 			totalMaterialCost := 0.0
 			tmcPtr := &totalMaterialCost
 			DB.SQL(`select 
-				sum(t.qty_used * p.latest_price) as totalm 
+				sum(t.cost) as totalm 
 				from task_part t 
-				left join part p on p.id=t.part_id 
 				where t.task_id=$1`, v.ID).QueryScalar(&tmcPtr)
 			if tmcPtr != nil {
 				r += fmt.Sprintf("Task %06d  %.2f hrs $%.2f Original Mat Cost %.2f --> %.2f\n",
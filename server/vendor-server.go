@@ -0,0 +1,873 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"itrak-cmms/shared"
+
+	runner "gopkg.in/mgutz/dat.v1/sqlx-runner"
+)
+
+// Vendors, their prices for parts, and purchase orders.
+//
+// Each vendor price is kept in vendor_price as history, and in part_vendor as the
+// current price, which also becomes the latest price on the part itself.
+// Purchase orders are raised as a Draft, Sent to the vendor, then received into
+// stock, in one go or a line at a time.
+
+type VendorRPC struct{}
+
+func (v *VendorRPC) List(channel int, vendors *[]shared.Vendor) error {
+	start := time.Now()
+
+	conn := Connections.Get(channel)
+
+	DB.SQL(`select * from vendor order by name`).QueryStructs(vendors)
+
+	logger(start, "Vendor.List",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d vendors", len(*vendors)),
+		channel, conn.UserID, "vendor", 0, false)
+
+	return nil
+}
+
+func (v *VendorRPC) Get(data shared.VendorRPCData, vendor *shared.Vendor) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(`select * from vendor where id=$1`, data.ID).QueryStruct(vendor)
+
+	logger(start, "Vendor.Get",
+		fmt.Sprintf("Channel %d, ID %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		vendor.Name,
+		data.Channel, conn.UserID, "vendor", data.ID, false)
+
+	return nil
+}
+
+var vendorFields = []string{"name", "descr", "address", "phone", "fax",
+	"contact_name", "contact_email", "orders_email", "rating", "notes"}
+
+func (v *VendorRPC) Insert(data shared.VendorRPCData, id *int) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if data.Vendor.Name == "" {
+		return fmt.Errorf("The vendor needs a name")
+	}
+
+	err := DB.InsertInto("vendor").
+		Whitelist(vendorFields...).
+		Record(data.Vendor).
+		Returning("id").
+		QueryScalar(id)
+	if err != nil {
+		log.Println("Vendor.Insert:", err.Error())
+		return err
+	}
+
+	logger(start, "Vendor.Insert",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			data.Channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("ID %d %s", *id, data.Vendor.Name),
+		data.Channel, conn.UserID, "vendor", *id, true)
+
+	conn.BroadcastAdmin("vendor", "insert", *id)
+	return nil
+}
+
+func (v *VendorRPC) Update(data shared.VendorRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if data.Vendor.Name == "" {
+		return fmt.Errorf("The vendor needs a name")
+	}
+
+	_, err := DB.Update("vendor").
+		SetWhitelist(data.Vendor, vendorFields...).
+		Where("id = $1", data.ID).
+		Exec()
+	if err != nil {
+		log.Println("Vendor.Update:", err.Error())
+		return err
+	}
+
+	logger(start, "Vendor.Update",
+		fmt.Sprintf("Channel %d, ID %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		data.Vendor.Name,
+		data.Channel, conn.UserID, "vendor", data.ID, true)
+
+	conn.BroadcastAdmin("vendor", "update", data.ID)
+	*done = true
+	return nil
+}
+
+// Delete a vendor, as long as there are no purchase orders against them
+func (v *VendorRPC) Delete(data shared.VendorRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	count := 0
+	DB.SQL(`select count(*) from purchase_order where vendor_id=$1`, data.ID).QueryScalar(&count)
+	if count > 0 {
+		return fmt.Errorf("Cannot delete a vendor that has %d purchase orders", count)
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Vendor.Delete:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	for _, table := range []string{"part_vendor", "vendor_price"} {
+		_, err = tx.SQL(`delete from `+table+` where vendor_id=$1`, data.ID).Exec()
+		if err != nil {
+			log.Println("Vendor.Delete:", err.Error())
+			return err
+		}
+	}
+	_, err = tx.SQL(`delete from vendor where id=$1`, data.ID).Exec()
+	if err != nil {
+		log.Println("Vendor.Delete:", err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Vendor.Delete:", err.Error())
+		return err
+	}
+
+	logger(start, "Vendor.Delete",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			data.Channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("ID %d", data.ID),
+		data.Channel, conn.UserID, "vendor", data.ID, true)
+
+	conn.BroadcastAdmin("vendor", "delete", data.ID)
+	*done = true
+	return nil
+}
+
+const vendorPriceQuery = `select
+	v.part_id as id,v.part_id,v.vendor_id,v.vendor_code,v.latest_price,
+	p.name as part_name,p.stock_code,p.qty_type,
+	coalesce((select h.min_qty from vendor_price h
+		where h.part_id=v.part_id and h.vendor_id=v.vendor_id
+		order by h.datefrom desc limit 1),0) as min_qty,
+	(select max(h.datefrom) from vendor_price h
+		where h.part_id=v.part_id and h.vendor_id=v.vendor_id) as datefrom
+	from part_vendor v
+	left join part p on p.id=v.part_id`
+
+// Get the current prices for all the parts from a vendor
+func (v *VendorRPC) Prices(data shared.VendorRPCData, prices *[]shared.VendorPrice) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(vendorPriceQuery+`
+		where v.vendor_id=$1
+		order by p.stock_code`, data.ID).QueryStructs(prices)
+
+	logger(start, "Vendor.Prices",
+		fmt.Sprintf("Channel %d, Vendor %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d prices", len(*prices)),
+		data.Channel, conn.UserID, "vendor", data.ID, false)
+
+	return nil
+}
+
+// Get the vendor's current price for one part
+func (v *VendorRPC) GetPrice(data shared.VendorPriceRPCData, price *shared.VendorPrice) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(vendorPriceQuery+`
+		where v.vendor_id=$1 and v.part_id=$2`, data.ID, data.VendorPrice.PartID).QueryStruct(price)
+
+	logger(start, "Vendor.GetPrice",
+		fmt.Sprintf("Channel %d, Vendor %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Part %d %.2f", data.VendorPrice.PartID, price.LatestPrice),
+		data.Channel, conn.UserID, "vendor", data.ID, false)
+
+	return nil
+}
+
+// Get the vendors that supply a part, and their prices
+func (v *VendorRPC) PartVendors(data shared.PartRPCData, vendors *[]shared.PartVendors) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(`select x.vendor_id,x.vendor_code,x.latest_price,v.name,v.descr,v.address
+		from part_vendor x
+		left join vendor v on v.id=x.vendor_id
+		where x.part_id=$1
+		order by x.latest_price,v.name`, data.ID).QueryStructs(vendors)
+
+	logger(start, "Vendor.PartVendors",
+		fmt.Sprintf("Channel %d, Part %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d vendors", len(*vendors)),
+		data.Channel, conn.UserID, "part", data.ID, false)
+
+	return nil
+}
+
+// Set the vendor's price for a part. The old price stays in vendor_price, and the
+// new one becomes the latest price on the part.
+func (v *VendorRPC) SetPrice(data shared.VendorPriceRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	price := data.VendorPrice
+	if price.PartID == 0 {
+		part, err := partByStockCode(price.StockCode)
+		if err != nil {
+			return fmt.Errorf("No part with stock code %s", price.StockCode)
+		}
+		price.PartID = part.ID
+	}
+	if price.LatestPrice < 0 {
+		return fmt.Errorf("The price cannot be negative")
+	}
+
+	vendor := shared.Vendor{}
+	DB.SQL(`select * from vendor where id=$1`, data.ID).QueryStruct(&vendor)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Vendor.SetPrice:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	res, err := tx.SQL(`update part_vendor set vendor_code=$3, latest_price=$4
+		where part_id=$1 and vendor_id=$2`,
+		price.PartID, data.ID, price.VendorCode, price.LatestPrice).Exec()
+	if err == nil && res.RowsAffected == 0 {
+		_, err = tx.SQL(`insert into part_vendor (part_id,vendor_id,vendor_code,latest_price)
+			values ($1,$2,$3,$4)`,
+			price.PartID, data.ID, price.VendorCode, price.LatestPrice).Exec()
+	}
+	if err != nil {
+		log.Println("Vendor.SetPrice:", err.Error())
+		return err
+	}
+
+	_, err = tx.SQL(`insert into vendor_price (part_id,vendor_id,price,min_qty,notes)
+		values ($1,$2,$3,$4,$5)`,
+		price.PartID, data.ID, price.LatestPrice, price.MinQty, price.Notes).Exec()
+	if err != nil {
+		log.Println("Vendor.SetPrice:", err.Error())
+		return err
+	}
+
+	err = setPartPrice(tx, price.PartID, price.LatestPrice,
+		fmt.Sprintf("Price from %s, updated by %s", vendor.Name, conn.Username),
+		strings.TrimSpace(vendor.Name+" "+price.VendorCode))
+	if err != nil {
+		log.Println("Vendor.SetPrice:", err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Vendor.SetPrice:", err.Error())
+		return err
+	}
+
+	logger(start, "Vendor.SetPrice",
+		fmt.Sprintf("Channel %d, Vendor %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Part %d %.2f", price.PartID, price.LatestPrice),
+		data.Channel, conn.UserID, "vendor", data.ID, true)
+
+	conn.BroadcastAdmin("vendorprice", "update", data.ID)
+	*done = true
+	return nil
+}
+
+// Stop getting the part from the vendor. The price history is kept.
+func (v *VendorRPC) DeletePrice(data shared.VendorPriceRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(`delete from part_vendor where vendor_id=$1 and part_id=$2`,
+		data.ID, data.VendorPrice.PartID).Exec()
+
+	logger(start, "Vendor.DeletePrice",
+		fmt.Sprintf("Channel %d, Vendor %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Part %d", data.VendorPrice.PartID),
+		data.Channel, conn.UserID, "vendor", data.ID, true)
+
+	conn.BroadcastAdmin("vendorprice", "delete", data.ID)
+	*done = true
+	return nil
+}
+
+const purchaseOrderQuery = `select o.*,v.name as vendor_name,u.username as username,s.name as site_name,
+	coalesce((select sum(l.qty*l.price) from purchase_order_line l where l.po_id=o.id),0) as total
+	from purchase_order o
+	left join vendor v on v.id=o.vendor_id
+	left join users u on u.id=o.created_by
+	left join site s on s.id=o.site_id`
+
+// Get the purchase orders, with the ones still open first. A vendor ID lists just theirs.
+func (v *VendorRPC) Orders(data shared.VendorRPCData, orders *[]shared.PurchaseOrder) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	DB.SQL(purchaseOrderQuery+`
+		where o.vendor_id=$1 or $1=0
+		order by o.status in ('Received','Cancelled'),o.created desc`, data.ID).QueryStructs(orders)
+
+	logger(start, "Vendor.Orders",
+		fmt.Sprintf("Channel %d, Vendor %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d orders", len(*orders)),
+		data.Channel, conn.UserID, "purchase_order", 0, false)
+
+	return nil
+}
+
+func getPurchaseOrder(id int, po *shared.PurchaseOrder) error {
+	err := DB.SQL(purchaseOrderQuery+`
+		where o.id=$1`, id).QueryStruct(po)
+	if err != nil {
+		return err
+	}
+	return DB.SQL(`select l.*,p.name as part_name,p.stock_code,p.qty_type
+		from purchase_order_line l
+		left join part p on p.id=l.part_id
+		where l.po_id=$1
+		order by l.id`, id).QueryStructs(&po.Lines)
+}
+
+func (v *VendorRPC) GetOrder(data shared.PurchaseOrderRPCData, po *shared.PurchaseOrder) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if err := getPurchaseOrder(data.ID, po); err != nil {
+		log.Println("Vendor.GetOrder:", err.Error())
+		return err
+	}
+
+	logger(start, "Vendor.GetOrder",
+		fmt.Sprintf("Channel %d, PO %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%s %s %d lines", po.VendorName, po.Status, len(po.Lines)),
+		data.Channel, conn.UserID, "purchase_order", data.ID, false)
+
+	return nil
+}
+
+// Raise a new purchase order as a Draft
+func (v *VendorRPC) InsertOrder(data shared.PurchaseOrderRPCData, id *int) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	if data.PurchaseOrder.VendorID == 0 {
+		return fmt.Errorf("The purchase order needs a vendor")
+	}
+
+	err := DB.SQL(`insert into purchase_order (vendor_id,site_id,ref,notes,created_by,status)
+		values ($1,$2,$3,$4,$5,$6)
+		returning id`,
+		data.PurchaseOrder.VendorID, data.PurchaseOrder.SiteID, data.PurchaseOrder.Ref,
+		data.PurchaseOrder.Notes, conn.UserID, shared.PODraft).QueryScalar(id)
+	if err != nil {
+		log.Println("Vendor.InsertOrder:", err.Error())
+		return err
+	}
+
+	logger(start, "Vendor.InsertOrder",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			data.Channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("PO %d Vendor %d", *id, data.PurchaseOrder.VendorID),
+		data.Channel, conn.UserID, "purchase_order", *id, true)
+
+	conn.BroadcastAdmin("purchaseorder", "insert", *id)
+	return nil
+}
+
+// Update the reference and notes on a purchase order. The vendor and the store
+// it is delivered to can only be changed while it is still a draft.
+func (v *VendorRPC) UpdateOrder(data shared.PurchaseOrderRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	_, err := DB.SQL(`update purchase_order
+		set ref=$2, notes=$3,
+		vendor_id=case when status=$5 and $4!=0 then $4 else vendor_id end,
+		site_id=case when status=$5 then $6 else site_id end
+		where id=$1`,
+		data.ID, data.PurchaseOrder.Ref, data.PurchaseOrder.Notes,
+		data.PurchaseOrder.VendorID, shared.PODraft, data.PurchaseOrder.SiteID).Exec()
+	if err != nil {
+		log.Println("Vendor.UpdateOrder:", err.Error())
+		return err
+	}
+
+	logger(start, "Vendor.UpdateOrder",
+		fmt.Sprintf("Channel %d, PO %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		data.PurchaseOrder.Ref,
+		data.Channel, conn.UserID, "purchase_order", data.ID, true)
+
+	conn.BroadcastAdmin("purchaseorder", "update", data.ID)
+	*done = true
+	return nil
+}
+
+// Delete a purchase order that is still a draft
+func (v *VendorRPC) DeleteOrder(data shared.PurchaseOrderRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	po := shared.PurchaseOrder{}
+	DB.SQL(`select * from purchase_order where id=$1`, data.ID).QueryStruct(&po)
+	if !po.IsDraft() {
+		return fmt.Errorf("PO %06d is %s, and can only be cancelled", data.ID, po.Status)
+	}
+
+	partIDs := orderParts(data.ID)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Vendor.DeleteOrder:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	_, err = tx.SQL(`delete from purchase_order_line where po_id=$1`, data.ID).Exec()
+	if err != nil {
+		log.Println("Vendor.DeleteOrder:", err.Error())
+		return err
+	}
+	_, err = tx.SQL(`delete from purchase_order where id=$1`, data.ID).Exec()
+	if err != nil {
+		log.Println("Vendor.DeleteOrder:", err.Error())
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		log.Println("Vendor.DeleteOrder:", err.Error())
+		return err
+	}
+	checkReorder(partIDs...)
+
+	logger(start, "Vendor.DeleteOrder",
+		fmt.Sprintf("Channel %d, User %d %s %s",
+			data.Channel, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("PO %d", data.ID),
+		data.Channel, conn.UserID, "purchase_order", data.ID, true)
+
+	conn.BroadcastAdmin("purchaseorder", "delete", data.ID)
+	*done = true
+	return nil
+}
+
+// Get the parts on a purchase order, to recheck their reorder levels after
+// the order changes
+func orderParts(id int) []int {
+	partIDs := []int{}
+	DB.SQL(`select distinct part_id from purchase_order_line where po_id=$1`, id).QuerySlice(&partIDs)
+	return partIDs
+}
+
+// Lock the purchase order against changes, returning an error if it is not a draft
+func lockDraftOrder(tx *runner.Tx, id int) error {
+	status := ""
+	err := tx.SQL(`select status from purchase_order where id=$1 for update`, id).QueryScalar(&status)
+	if err != nil {
+		return err
+	}
+	if status != shared.PODraft {
+		return fmt.Errorf("PO %06d is %s, and can no longer be changed", id, status)
+	}
+	return nil
+}
+
+// Add a part to a draft purchase order, at the vendor's price unless one is given
+func (v *VendorRPC) AddLine(data shared.PurchaseOrderLineRPCData, newID *int) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	line := data.Line
+	if line.PartID == 0 {
+		part, err := partByStockCode(line.StockCode)
+		if err != nil {
+			return fmt.Errorf("No part with stock code %s", line.StockCode)
+		}
+		line.PartID = part.ID
+	}
+	if line.Qty <= 0 {
+		return fmt.Errorf("The quantity ordered must be more than 0")
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Vendor.AddLine:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	if err = lockDraftOrder(tx, data.ID); err != nil {
+		return err
+	}
+
+	// Fill in the vendor's code and price for the part
+	vp := shared.VendorPrice{}
+	tx.SQL(`select v.vendor_code,v.latest_price
+		from part_vendor v
+		left join purchase_order o on o.vendor_id=v.vendor_id
+		where o.id=$1 and v.part_id=$2`, data.ID, line.PartID).QueryStruct(&vp)
+	if line.VendorCode == "" {
+		line.VendorCode = vp.VendorCode
+	}
+	if line.Price == 0 {
+		line.Price = vp.LatestPrice
+	}
+	if line.Price == 0 {
+		tx.SQL(`select latest_price from part where id=$1`, line.PartID).QueryScalar(&line.Price)
+	}
+
+	err = tx.SQL(`insert into purchase_order_line (po_id,part_id,vendor_code,qty,price,notes)
+		values ($1,$2,$3,$4,$5,$6)
+		returning id`,
+		data.ID, line.PartID, line.VendorCode, line.Qty, line.Price, line.Notes).QueryScalar(newID)
+	if err != nil {
+		log.Println("Vendor.AddLine:", err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Vendor.AddLine:", err.Error())
+		return err
+	}
+
+	logger(start, "Vendor.AddLine",
+		fmt.Sprintf("Channel %d, PO %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Part %d Qty %.2f @ %.2f", line.PartID, line.Qty, line.Price),
+		data.Channel, conn.UserID, "purchase_order", data.ID, true)
+
+	conn.BroadcastAdmin("purchaseorder", "update", data.ID)
+	return nil
+}
+
+func (v *VendorRPC) UpdateLine(data shared.PurchaseOrderLineRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	line := data.Line
+	if line.Qty <= 0 {
+		return fmt.Errorf("The quantity ordered must be more than 0")
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Vendor.UpdateLine:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	if err = lockDraftOrder(tx, data.ID); err != nil {
+		return err
+	}
+	_, err = tx.SQL(`update purchase_order_line
+		set vendor_code=$3, qty=$4, price=$5, notes=$6
+		where id=$2 and po_id=$1`,
+		data.ID, line.ID, line.VendorCode, line.Qty, line.Price, line.Notes).Exec()
+	if err != nil {
+		log.Println("Vendor.UpdateLine:", err.Error())
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		log.Println("Vendor.UpdateLine:", err.Error())
+		return err
+	}
+
+	logger(start, "Vendor.UpdateLine",
+		fmt.Sprintf("Channel %d, PO %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Line %d Qty %.2f @ %.2f", line.ID, line.Qty, line.Price),
+		data.Channel, conn.UserID, "purchase_order", data.ID, true)
+
+	conn.BroadcastAdmin("purchaseorder", "update", data.ID)
+	*done = true
+	return nil
+}
+
+func (v *VendorRPC) DeleteLine(data shared.PurchaseOrderLineRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Vendor.DeleteLine:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	if err = lockDraftOrder(tx, data.ID); err != nil {
+		return err
+	}
+	_, err = tx.SQL(`delete from purchase_order_line where id=$2 and po_id=$1`,
+		data.ID, data.Line.ID).Exec()
+	if err != nil {
+		log.Println("Vendor.DeleteLine:", err.Error())
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		log.Println("Vendor.DeleteLine:", err.Error())
+		return err
+	}
+
+	logger(start, "Vendor.DeleteLine",
+		fmt.Sprintf("Channel %d, PO %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("Line %d", data.Line.ID),
+		data.Channel, conn.UserID, "purchase_order", data.ID, true)
+
+	conn.BroadcastAdmin("purchaseorder", "update", data.ID)
+	*done = true
+	return nil
+}
+
+// Send a draft purchase order to the vendor, by email if they have an orders address
+func (v *VendorRPC) SendOrder(data shared.PurchaseOrderRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	po := shared.PurchaseOrder{}
+	if err := getPurchaseOrder(data.ID, &po); err != nil {
+		log.Println("Vendor.SendOrder:", err.Error())
+		return err
+	}
+	if !po.IsDraft() {
+		return fmt.Errorf("PO %06d has already been sent", data.ID)
+	}
+	if len(po.Lines) == 0 {
+		return fmt.Errorf("PO %06d has nothing on it to order", data.ID)
+	}
+	if po.SiteID == 0 {
+		return fmt.Errorf("PO %06d needs a store to deliver to", data.ID)
+	}
+
+	res, err := DB.SQL(`update purchase_order
+		set status=$2, sent_date=now()
+		where id=$1 and status=$3`, data.ID, shared.POSent, shared.PODraft).Exec()
+	if err != nil {
+		log.Println("Vendor.SendOrder:", err.Error())
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("PO %06d has already been sent", data.ID)
+	}
+
+	vendor := shared.Vendor{}
+	DB.SQL(`select * from vendor where id=$1`, po.VendorID).QueryStruct(&vendor)
+	sentTo := "not emailed"
+	if vendor.OrdersEmail != "" {
+		site := shared.Site{}
+		DB.SQL(`select * from site where id=$1`, po.SiteID).QueryStruct(&site)
+		lines := []string{
+			fmt.Sprintf("Purchase Order %06d %s", po.ID, po.Ref),
+			fmt.Sprintf("Deliver to %s, %s", site.Name, site.Address),
+			"",
+		}
+		for _, l := range po.Lines {
+			code := l.VendorCode
+			if code == "" {
+				code = l.StockCode
+			}
+			lines = append(lines, fmt.Sprintf("%-16s %-40s %8g %s @ $%.2f",
+				code, l.PartName, l.Qty, l.QtyType, l.Price))
+		}
+		lines = append(lines, "", fmt.Sprintf("Total $%.2f", po.Total), "", po.Notes)
+		if err := SendEmail(vendor.OrdersEmail,
+			fmt.Sprintf("Purchase Order %06d", po.ID),
+			strings.Join(lines, "\n")); err != nil {
+			log.Println("Vendor.SendOrder:", err.Error())
+		} else {
+			sentTo = vendor.OrdersEmail
+		}
+	}
+
+	logger(start, "Vendor.SendOrder",
+		fmt.Sprintf("Channel %d, PO %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%s, %s", vendor.Name, sentTo),
+		data.Channel, conn.UserID, "purchase_order", data.ID, true)
+
+	conn.BroadcastAdmin("purchaseorder", "update", data.ID)
+	*done = true
+	return nil
+}
+
+// Cancel a purchase order that has not had anything received against it
+func (v *VendorRPC) CancelOrder(data shared.PurchaseOrderRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	res, err := DB.SQL(`update purchase_order
+		set status=$2
+		where id=$1 and status in ($3,$4)`,
+		data.ID, shared.POCancelled, shared.PODraft, shared.POSent).Exec()
+	if err != nil {
+		log.Println("Vendor.CancelOrder:", err.Error())
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("PO %06d has had stock received, and cannot be cancelled", data.ID)
+	}
+	checkReorder(orderParts(data.ID)...)
+
+	logger(start, "Vendor.CancelOrder",
+		fmt.Sprintf("Channel %d, PO %d, User %d %s %s",
+			data.Channel, data.ID, conn.UserID, conn.Username, conn.UserRole),
+		"Cancelled",
+		data.Channel, conn.UserID, "purchase_order", data.ID, true)
+
+	conn.BroadcastAdmin("purchaseorder", "update", data.ID)
+	*done = true
+	return nil
+}
+
+// Receive stock against a sent purchase order, and add it to the stock on hand
+// at the store it was delivered to
+func (v *VendorRPC) Receive(data shared.POReceiveRPCData, done *bool) error {
+	start := time.Now()
+
+	conn := Connections.Get(data.Channel)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.Println("Vendor.Receive:", err.Error())
+		return err
+	}
+	defer tx.AutoRollback()
+
+	po := shared.PurchaseOrder{}
+	err = tx.SQL(`select * from purchase_order where id=$1 for update`, data.ID).QueryStruct(&po)
+	if err != nil {
+		log.Println("Vendor.Receive:", err.Error())
+		return err
+	}
+	if !po.IsOpen() {
+		return fmt.Errorf("PO %06d is %s, and cannot be received against", data.ID, po.Status)
+	}
+
+	lines := []shared.PurchaseOrderLine{}
+	err = tx.SQL(`select * from purchase_order_line
+		where po_id=$1 and (id=$2 or $2=0)
+		order by id`, data.ID, data.LineID).QueryStructs(&lines)
+	if err != nil {
+		log.Println("Vendor.Receive:", err.Error())
+		return err
+	}
+
+	received := 0
+	for _, l := range lines {
+		qty := l.GetOutstanding()
+		if data.LineID != 0 {
+			if data.Qty <= 0 || data.Qty > qty {
+				return fmt.Errorf("Can receive up to %g on this line", qty)
+			}
+			qty = data.Qty
+		}
+		if qty == 0 {
+			continue
+		}
+
+		_, err = tx.SQL(`update purchase_order_line set qty_received=qty_received+$2 where id=$1`,
+			l.ID, qty).Exec()
+		if err != nil {
+			log.Println("Vendor.Receive:", err.Error())
+			return err
+		}
+		descr := fmt.Sprintf("Received %g on PO %06d %s", qty, po.ID, data.Notes)
+		if _, err = moveStock(tx, l.PartID, po.SiteID, qty, strings.TrimSpace(descr)); err != nil {
+			log.Println("Vendor.Receive:", err.Error())
+			return err
+		}
+		// a line without a price comes in at the part's unit cost
+		cost := l.Price
+		if cost == 0 {
+			cost = unitCost(tx, l.PartID)
+		}
+		if err = addCostLayer(tx, l.PartID, qty, cost, strings.TrimSpace(descr)); err != nil {
+			log.Println("Vendor.Receive:", err.Error())
+			return err
+		}
+		received++
+	}
+	if received == 0 {
+		return fmt.Errorf("Nothing left to receive on PO %06d", data.ID)
+	}
+
+	// The order is Received once every line is in full
+	outstanding := 0
+	err = tx.SQL(`select count(*) from purchase_order_line
+		where po_id=$1 and qty_received<qty`, data.ID).QueryScalar(&outstanding)
+	if err != nil {
+		log.Println("Vendor.Receive:", err.Error())
+		return err
+	}
+	status := shared.POPartial
+	if outstanding == 0 {
+		status = shared.POReceived
+	}
+	_, err = tx.SQL(`update purchase_order
+		set status=$2, received_date=case when $2=$3 then now() else received_date end
+		where id=$1`, data.ID, status, shared.POReceived).Exec()
+	if err != nil {
+		log.Println("Vendor.Receive:", err.Error())
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Vendor.Receive:", err.Error())
+		return err
+	}
+	checkReorder(orderParts(data.ID)...)
+
+	logger(start, "Vendor.Receive",
+		fmt.Sprintf("Channel %d, PO %d, Line %d, User %d %s %s",
+			data.Channel, data.ID, data.LineID, conn.UserID, conn.Username, conn.UserRole),
+		fmt.Sprintf("%d lines received, now %s", received, status),
+		data.Channel, conn.UserID, "purchase_order", data.ID, true)
+
+	conn.BroadcastAdmin("purchaseorder", "update", data.ID)
+	*done = true
+	return nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -18,26 +19,50 @@ import (
 // Wrapper for socket, which has a controlling mutex to be shared with the RPC server
 // and session data once the user logs in on this connection
 type Connection struct {
-	ID       int
-	Active   bool
-	Socket   *websocket.Conn
-	Mutex    *sync.Mutex
-	Username string
-	UserID   int
-	UserRole string
-	Time     time.Time
-	ticker   *time.Ticker
-	enc      *gob.Encoder
-	r        rpc.Response
-	Route    string
-	Routes   []string
+	ID        int
+	Active    bool
+	Socket    *websocket.Conn
+	Mutex     *sync.Mutex
+	Username  string
+	UserID    int
+	UserRole  string
+	Time      time.Time
+	ticker    *time.Ticker
+	enc       *gob.Encoder
+	jenc      *json.Encoder
+	r         rpc.Response
+	Route     string
+	Routes    []string
+	SessionID int
+	Sites     map[int]bool
+	subs      map[string]Subscription
+	subMutex  sync.Mutex
+	out       chan outMessage
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 // Safely send unsolicited RPC response to a connection
 func (c *Connection) Send(name string, payload interface{}) error {
+	if c.Socket == nil {
+		// REST API connections have nowhere to push to
+		return nil
+	}
 	c.Mutex.Lock()
 	defer c.Mutex.Unlock()
 
+	if c.jenc != nil {
+		if err := c.jenc.Encode(jsonNotification{
+			Version: "2.0",
+			Method:  name,
+			Params:  payload,
+		}); err != nil {
+			log.Println("Notification", name, err.Error())
+			return err
+		}
+		return nil
+	}
+
 	c.r.ServiceMethod = name
 	c.r.Seq = 0
 
@@ -60,6 +85,11 @@ func (c *Connection) Login(username string, id int, role string) {
 	c.UserRole = role
 	c.Route = ""
 	c.Time = time.Now()
+	c.loadSites()
+
+	c.subMutex.Lock()
+	c.subs = nil
+	c.subMutex.Unlock()
 }
 
 // Constantly Ping the Backend
@@ -72,49 +102,31 @@ func (c *Connection) KeepAlive(sec time.Duration) {
 	}
 	c.Send("Ping", data)
 	c.ticker = time.NewTicker(time.Second * sec)
-	for range c.ticker.C {
-		// log.Println("sending ping to client", c.ID)
-		err := c.Send("Ping", data)
-		if err != nil {
-			log.Println("Send error on", c.ID, err.Error())
+	defer c.ticker.Stop()
+	for {
+		select {
+		case <-c.ticker.C:
+			// log.Println("sending ping to client", c.ID)
+			c.Queue("Ping", data.Action, data.ID)
+		case <-c.done:
+			return
 		}
 	}
 }
 
-// Send an async message to everyone but this connection
+// Send an async message to everyone but this connection, if they are subscribed
 func (c *Connection) Broadcast(name string, action string, id int) {
-
-	data := shared.AsyncMessage{
-		Action: action,
-		ID:     id,
-	}
-
-	for _, v := range Connections.cmap {
-		if v != c && v.UserID != 0 {
-			log.Println("broadcast", name, action, id, "»", v.ID)
-			go v.Send(name, data)
-		}
-	}
+	Connections.Publish(c, name, action, id, false)
 }
 
-// Send an async message to everyone but this connection, if they are admin
+// Send an async message to everyone but this connection, if they are admin and subscribed
 func (c *Connection) BroadcastAdmin(name string, action string, id int) {
-
-	data := shared.AsyncMessage{
-		Action: action,
-		ID:     id,
-	}
-
-	for _, v := range Connections.cmap {
-		if v != c && v.UserID != 0 && v.UserRole == "Admin" {
-			log.Println("broadcastAdmin", name, action, id, "»", v.ID)
-			go v.Send(name, data)
-		}
-	}
+	Connections.Publish(c, name, action, id, true)
 }
 
 // A collection of Connections
 type ConnectionsList struct {
+	sync.Mutex
 	// conns  []*Connection
 	cmap map[int]*Connection
 	keys []int
@@ -130,42 +142,23 @@ func (c *ConnectionsList) Keys() []int {
 	return c.keys
 }
 
-// Send an async message to everyone that is connected
+// Send an async message to everyone that is connected and subscribed
 func (c *ConnectionsList) BroadcastAll(name string, action string, id int) {
-
-	data := shared.AsyncMessage{
-		Action: action,
-		ID:     id,
-	}
-
-	for _, v := range c.cmap {
-		if v.UserID != 0 {
-			log.Println("BroadcastAll", name, action, id, "»", v.ID)
-			go v.Send(name, data)
-		}
-	}
+	c.Publish(nil, name, action, id, false)
 }
 
-// Send an async message to all admints that are connected
+// Send an async message to all admins that are connected and subscribed
 func (c *ConnectionsList) BroadcastAllAdmin(name string, action string, id int) {
-
-	data := shared.AsyncMessage{
-		Action: action,
-		ID:     id,
-	}
-
-	for _, v := range c.cmap {
-		if v.UserID != 0 && v.UserRole == "Admin" {
-			log.Println("BroadcastAllAdmin", name, action, id, "»", v.ID)
-			go v.Send(name, data)
-		}
-	}
+	c.Publish(nil, name, action, id, true)
 }
 
 var Connections *ConnectionsList
 
 // Find the connection that owns the socket, return nil if not found
 func (c *ConnectionsList) Find(ws *websocket.Conn) *Connection {
+	c.Lock()
+	defer c.Unlock()
+
 	for _, conn := range c.cmap {
 		if conn.Socket == ws {
 			return conn
@@ -176,16 +169,26 @@ func (c *ConnectionsList) Find(ws *websocket.Conn) *Connection {
 
 // Get the connection by ID
 func (c *ConnectionsList) Get(id int) *Connection {
+	c.Lock()
+	defer c.Unlock()
+
 	return c.cmap[id]
 }
 
 // Add a websocket to the list, creates a matching Mutex, and returns the meta-Connection
 func (c *ConnectionsList) Add(ws *websocket.Conn) *Connection {
+	c.Lock()
+	defer c.Unlock()
+
 	conn := &Connection{
 		ID:     c.nextID + 1,
 		Socket: ws,
 		Mutex:  new(sync.Mutex),
-		enc:    gob.NewEncoder(ws),
+	}
+	if isJSONRPC(ws) {
+		conn.jenc = json.NewEncoder(ws)
+	} else {
+		conn.enc = gob.NewEncoder(ws)
 	}
 	// c.conns = append(c.conns, conn)
 	c.nextID++
@@ -195,15 +198,37 @@ func (c *ConnectionsList) Add(ws *websocket.Conn) *Connection {
 	c.cmap[c.nextID] = conn
 	c.keys = append(c.keys, c.nextID)
 
-	// Now create a keepalive pinger for this connection
+	// Now create the outbound queue and a keepalive pinger for this connection
+	conn.startQueue()
 	go conn.KeepAlive(55)
 
 	return conn
 }
 
+// Add a socketless connection for a REST API session
+func (c *ConnectionsList) AddAPI() *Connection {
+	c.Lock()
+	defer c.Unlock()
+
+	conn := &Connection{
+		ID:    c.nextID + 1,
+		Mutex: new(sync.Mutex),
+	}
+	c.nextID++
+	if c.cmap == nil {
+		c.cmap = make(map[int]*Connection)
+	}
+	c.cmap[c.nextID] = conn
+	c.keys = append(c.keys, c.nextID)
+	return conn
+}
+
 // Remove the websocket from the list by ID
 func (c *ConnectionsList) Drop(conn *Connection) *ConnectionsList {
 	fmt.Println("Remove connection ", conn.ID)
+	conn.stopQueue()
+	c.Lock()
+	defer c.Unlock()
 
 	// theConn := c.cmap[conn.ID]
 	// println("theConn = ", theConn)
@@ -216,7 +241,7 @@ func (c *ConnectionsList) Drop(conn *Connection) *ConnectionsList {
 		}
 	}
 
-	c.BroadcastAll("login", "delete", conn.ID)
+	go c.BroadcastAll("login", "delete", conn.ID)
 	return c
 }
 
@@ -226,6 +251,10 @@ func (c *ConnectionsList) Show(header string) *ConnectionsList {
 	fmt.Println(header)
 	for _, key := range c.keys {
 		conn := c.cmap[key]
+		if conn.Socket == nil {
+			fmt.Printf("  %d:API\t\t\tUser: %s %d\n", conn.ID, conn.Username, conn.UserID)
+			continue
+		}
 		req := conn.Socket.Request()
 		theIP := ""
 		if theIP = req.Header.Get("X-Real-Ip"); theIP == "" {
@@ -248,10 +277,18 @@ func webSocket(ws *websocket.Conn) {
 
 	// ws := c.Socket()
 	ws.PayloadType = websocket.BinaryFrame
+	if isJSONRPC(ws) {
+		ws.PayloadType = websocket.TextFrame
+	}
 
 	conn := Connections.Add(ws)
 	Connections.Show("Connections Grows To:")
 
+	if conn.jenc != nil {
+		rpc.ServeCodec(newJSONServerCodec(ws, conn))
+		return
+	}
+
 	// Create a custom RPC server for this socket
 	buf := bufio.NewWriter(ws)
 	srv := &myServerCodec{
@@ -273,6 +310,7 @@ type myServerCodec struct {
 	enc    *gob.Encoder
 	encBuf *bufio.Writer
 	closed bool
+	method string
 }
 
 // On receiving a new header, lock the connection until the whole RPC call has finished
@@ -283,11 +321,21 @@ func (c *myServerCodec) ReadRequestHeader(r *rpc.Request) error {
 		Connections.Drop(c.conn)
 	}
 	c.conn.Mutex.Lock()
+	c.method = r.ServiceMethod
 	return err
 }
 
+// Decode the args, and then check the permissions table before the call is dispatched.
+// Returning an error here sends it back as the RPC response without calling the method
 func (c *myServerCodec) ReadRequestBody(body interface{}) error {
-	return c.dec.Decode(body)
+	if err := c.dec.Decode(body); err != nil {
+		return err
+	}
+	if body == nil {
+		return nil
+	}
+	pinChannel(c.conn, body)
+	return authorize(c.conn, c.method, body)
 }
 
 func (c *myServerCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
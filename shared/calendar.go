@@ -0,0 +1,99 @@
+package shared
+
+import (
+	"strings"
+	"time"
+)
+
+// A working calendar, used to keep generated tasks off weekends and holidays
+type Calendar struct {
+	ID       int       `db:"id"`
+	Name     string    `db:"name"`
+	Weekend  string    `db:"weekend"`
+	Notes    string    `db:"notes"`
+	Holidays []Holiday `db:"holidays"`
+}
+
+type Holiday struct {
+	ID         int        `db:"id"`
+	CalendarID int        `db:"calendar_id"`
+	Date       *time.Time `db:"date"`
+	Name       string     `db:"name"`
+}
+
+type CalendarRPCData struct {
+	Channel  int
+	ID       int
+	Calendar *Calendar
+}
+
+type HolidayRPCData struct {
+	Channel int
+	ID      int
+	Holiday *Holiday
+}
+
+// Holidays to load into a calendar from the text of an iCalendar (.ics) file
+type CalendarImport struct {
+	Channel    int
+	CalendarID int
+	ICS        string
+}
+
+const MaxWorkdayRoll = 60 // give up looking for a working day after this many days
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func (c *Calendar) GetKey() int {
+	return c.ID
+}
+
+func (c *Calendar) GetDisplay() string {
+	return c.Name
+}
+
+// Is the day one of the weekend days of the calendar
+func (c *Calendar) IsWeekend(t time.Time) bool {
+	day := weekdayNames[t.Weekday()]
+	for _, w := range strings.Split(c.Weekend, ",") {
+		w = strings.ToLower(strings.TrimSpace(w))
+		if len(w) >= 3 && w[:3] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Is the day a holiday in the calendar
+func (c *Calendar) IsHoliday(t time.Time) bool {
+	y, m, d := t.Date()
+	for _, h := range c.Holidays {
+		if h.Date == nil {
+			continue
+		}
+		hy, hm, hd := h.Date.Date()
+		if hy == y && hm == m && hd == d {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Calendar) IsWorkday(t time.Time) bool {
+	return !c.IsWeekend(t) && !c.IsHoliday(t)
+}
+
+// Roll the date forward to the next working day, if it is not one already
+func (c *Calendar) NextWorkday(t time.Time) time.Time {
+	for i := 0; i < MaxWorkdayRoll && !c.IsWorkday(t); i++ {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+func (h *Holiday) GetDate() string {
+	if h.Date == nil {
+		return ""
+	}
+	return h.Date.Format("Mon, Jan 2 2006")
+}
@@ -0,0 +1,52 @@
+package shared
+
+import (
+	"fmt"
+	"time"
+)
+
+// One technician on the crew for a task, with their own time on the job and sign-off.
+// The ID is the technician's user ID.
+type CrewMember struct {
+	ID           int        `db:"id"`
+	TaskID       int        `db:"task_id"`
+	Username     *string    `db:"username"`
+	Lead         bool       `db:"lead"`
+	Acknowledged *time.Time `db:"acknowledged"`
+	SignedOff    *time.Time `db:"signed_off"`
+	Hours        float64    `db:"hours"`
+}
+
+type CrewRPCData struct {
+	Channel int
+	ID      int
+	UserID  int
+}
+
+func (c *CrewMember) GetUsername() string {
+	if c.Username == nil {
+		return ""
+	}
+	if c.Lead {
+		return *c.Username + " (lead)"
+	}
+	return *c.Username
+}
+
+func (c *CrewMember) GetAcknowledged() string {
+	if c.Acknowledged == nil {
+		return ""
+	}
+	return c.Acknowledged.Format(datetimeDisplayFormat)
+}
+
+func (c *CrewMember) GetSignedOff() string {
+	if c.SignedOff == nil {
+		return ""
+	}
+	return c.SignedOff.Format(datetimeDisplayFormat)
+}
+
+func (c *CrewMember) GetHours() string {
+	return fmt.Sprintf("%.2f", c.Hours)
+}
@@ -0,0 +1,52 @@
+package shared
+
+import "fmt"
+
+// A step in escalating overdue tasks, which fires once the task is more than
+// Days past its due date or escalate date
+type EscalationTier struct {
+	ID     int    `db:"id"`
+	Level  int    `db:"level"`
+	Basis  string `db:"basis"`
+	Days   int    `db:"days"`
+	Notify string `db:"notify"`
+	SMS    bool   `db:"sms"`
+	Email  bool   `db:"email"`
+}
+
+type EscalationTierRPCData struct {
+	Channel int
+	ID      int
+	Tier    *EscalationTier
+}
+
+func (e *EscalationTier) ShowBasis() string {
+	if e.Basis == "escalate" {
+		return fmt.Sprintf("%d days past the escalate date", e.Days)
+	}
+	return fmt.Sprintf("%d days past the due date", e.Days)
+}
+
+func (e *EscalationTier) ShowNotify() string {
+	switch e.Notify {
+	case "assigner":
+		return "Assigner"
+	case "manager":
+		return "Site Manager"
+	case "admins":
+		return "Admins"
+	}
+	return e.Notify
+}
+
+func (e *EscalationTier) ShowVia() string {
+	switch {
+	case e.SMS && e.Email:
+		return "SMS and Email"
+	case e.SMS:
+		return "SMS"
+	case e.Email:
+		return "Email"
+	}
+	return "Task log only"
+}
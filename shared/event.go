@@ -63,6 +63,8 @@ type AssignEvent struct {
 	Username    string
 	Notes       string
 	Photo       Photo
+	Crew        []int
+	SkillID     int
 }
 
 type EventRPCData struct {
@@ -94,9 +96,9 @@ func (e *Event) GetUserNameID() string {
 
 func (e *Event) GetStatus() string {
 	switch e.Status {
-	case "":
-		return "Pending"
-	case "Assigned":
+	case "", StatusOpen:
+		return StatusOpen
+	case StatusAssigned:
 		status := "Assigned To: "
 		for i, j := range e.AssignedTo {
 			if i > 0 {
@@ -105,8 +107,8 @@ func (e *Event) GetStatus() string {
 			status += j
 		}
 		return status
-	case "Completed":
-		status := "Completed: "
+	case StatusCompleted, StatusVerified:
+		status := e.Status + ": "
 		for i, j := range e.AssignedTo {
 			if i > 0 {
 				status += ", "
@@ -10,14 +10,21 @@ type LoginCredentials struct {
 type LoginReply struct {
 	Result      string
 	Token       string
+	Username    string
 	Role        string
 	Site        string
 	ID          int
 	CanAllocate bool
+	Route       string
 	// Menu   []UserMenu
 	Routes []UserRoute
 }
 
+type ResumeRequest struct {
+	Channel int
+	Token   string
+}
+
 // type UserMenu struct {
 // 	Icon  string
 // 	Title string
@@ -2,6 +2,7 @@ package shared
 
 import (
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -16,38 +17,30 @@ type MachineReq struct {
 }
 
 type Machine struct {
-	ID              int         `db:"id"`
-	SiteID          int         `db:"site_id"`
-	Name            string      `db:"name"`
-	Descr           string      `db:"descr"`
-	Make            string      `db:"make"`
-	Model           string      `db:"model"`
-	Serialnum       string      `db:"serialnum"`
-	IsRunning       bool        `db:"is_running"`
-	Status          string      `db:"status"`
-	Stopped         *time.Time  `db:"stopped_at"`
-	Started         *time.Time  `db:"started_at"`
-	Alert           *time.Time  `db:"alert_at"`
-	SiteName        *string     `db:"site_name"`
-	Span            *string     `db:"span"`
-	Notes           string      `db:"notes"`
-	Electrical      string      `db:"electrical"`
-	Hydraulic       string      `db:"hydraulic"`
-	Pnuematic       string      `db:"pnuematic"`
-	Conveyor        string      `db:"conveyor"`
-	Printer         string      `db:"printer"`
-	Console         string      `db:"console"`
-	Rollbed         string      `db:"rollbed"`
-	Uncoiler        string      `db:"uncoiler"`
-	Lube            string      `db:"lube"`
-	Encoder         string      `db:"encoder"`
-	StripGuide      string      `db:"strip_guide"`
-	AlertsTo        int         `db:"alerts_to"`
-	TasksTo         int         `db:"tasks_to"`
-	Components      []Component `db:"components"`
-	PartClass       int         `db:"part_class"`
-	MachineType     int         `db:"machine_type"`
-	MachineTypeData MachineType `db:"machine_type_data"`
+	ID              int                `db:"id"`
+	SiteID          int                `db:"site_id"`
+	Name            string             `db:"name"`
+	Descr           string             `db:"descr"`
+	Make            string             `db:"make"`
+	Model           string             `db:"model"`
+	Serialnum       string             `db:"serialnum"`
+	IsRunning       bool               `db:"is_running"`
+	Status          string             `db:"status"`
+	Stopped         *time.Time         `db:"stopped_at"`
+	Started         *time.Time         `db:"started_at"`
+	Alert           *time.Time         `db:"alert_at"`
+	SiteName        *string            `db:"site_name"`
+	Span            *string            `db:"span"`
+	Notes           string             `db:"notes"`
+	AlertsTo        int                `db:"alerts_to"`
+	TasksTo         int                `db:"tasks_to"`
+	Components      []Component        `db:"components"`
+	Subsystems      []MachineSubsystem `db:"subsystems"`
+	PartClass       int                `db:"part_class"`
+	MachineType     int                `db:"machine_type"`
+	MachineTypeData MachineType        `db:"machine_type_data"`
+	JobCount        int                `db:"job_count"`
+	RunHours        float64            `db:"run_hours"`
 }
 
 type MachineRPCData struct {
@@ -56,6 +49,71 @@ type MachineRPCData struct {
 	Machine *Machine
 }
 
+// A batch of jobs run on a machine, as counted by the operator or the line counter
+type JobCount struct {
+	ID        int        `db:"id"`
+	MachineID int        `db:"machine_id"`
+	Count     int        `db:"count"`
+	Total     int        `db:"total"`
+	Logged    *time.Time `db:"logged"`
+	UserID    int        `db:"user_id"`
+	Username  *string    `db:"username"`
+	Source    string     `db:"source"`
+}
+
+type JobCountRPCData struct {
+	Channel   int
+	MachineID int
+	Count     int
+	Source    string
+}
+
+// An hour meter reading taken off the machine
+type MeterReading struct {
+	ID        int        `db:"id"`
+	MachineID int        `db:"machine_id"`
+	Hours     float64    `db:"hours"`
+	Logged    *time.Time `db:"logged"`
+	UserID    int        `db:"user_id"`
+	Username  *string    `db:"username"`
+	Notes     string     `db:"notes"`
+}
+
+type MeterReadingRPCData struct {
+	Channel   int
+	MachineID int
+	Hours     float64
+	Notes     string
+}
+
+func (j *JobCount) GetLogged() string {
+	if j.Logged == nil {
+		return ""
+	}
+	return j.Logged.Format("Mon, Jan 2 2006 15:04")
+}
+
+func (j *JobCount) GetUsername() string {
+	if j.Username == nil {
+		return ""
+	}
+	return *j.Username
+}
+
+func (r *MeterReading) GetLogged() string {
+	if r.Logged == nil {
+		return ""
+	}
+	return r.Logged.Format("Mon, Jan 2 2006 15:04")
+}
+
+func (r *MeterReading) GetUsername() string {
+	if r.Username == nil {
+		return ""
+	}
+	return *r.Username
+}
+
 func (m *Machine) GetClass(status string) string {
 	switch status {
 	case "Needs Attention":
@@ -70,28 +128,12 @@ func (m *Machine) GetClass(status string) string {
 }
 
 func (m *Machine) GetStatus(nontool string) string {
-	switch nontool {
-	case "Electrical":
-		return m.Electrical
-	case "Hydraulic":
-		return m.Hydraulic
-	case "Printer":
-		return m.Printer
-	case "Console":
-		return m.Console
-	case "Rollbed":
-		return m.Rollbed
-	case "Uncoiler":
-		return m.Uncoiler
-	case "Lube":
-		return m.Lube
-	case "Encoder":
-		return m.Encoder
-	case "StripGuide":
-		return m.StripGuide
-	default:
-		return "Running"
+	for _, v := range m.Subsystems {
+		if v.Name == nontool {
+			return v.Status
+		}
 	}
+	return "Running"
 }
 
 func (m *Machine) SVGWidth1() string {
@@ -243,22 +285,21 @@ type MachineType struct {
 	Photo          string            `db:"photo"`
 	PhotoPreview   string            `db:"photo_preview"`
 	PhotoThumbnail string            `db:"photo_thumbnail"`
-	Electrical     bool              `db:"electrical"`
-	Hydraulic      bool              `db:"hydraulic"`
-	Pnuematic      bool              `db:"pnuematic"`
-	Lube           bool              `db:"lube"`
-	Printer        bool              `db:"printer"`
-	Console        bool              `db:"console"`
-	Uncoiler       bool              `db:"uncoiler"`
-	Rollbed        bool              `db:"rollbed"`
-	Conveyor       bool              `db:"conveyor"`
-	Encoder        bool              `db:"encoder"`
-	StripGuide     bool              `db:"strip_guide"`
+	Subsystems     []Subsystem       `db:"subsystems"`
 	NumTools       int               `db:"num_tools"`
 	Tools          []MachineTypeTool `db:"tools"`
 	SelectedTool   int               `db:"selected_tool"`
 }
 
+// Comma separated list of the subsystems on this machine type
+func (m *MachineType) SubsystemNames() string {
+	names := []string{}
+	for _, v := range m.Subsystems {
+		names = append(names, v.GetLabel())
+	}
+	return strings.Join(names, ", ")
+}
+
 func (m *MachineType) SVGWidth1() string {
 	i := 300 + (m.NumTools * 50)
 	if i < 400 {
@@ -337,3 +378,87 @@ type MachineTypeToolRPCData struct {
 func (m *MachineTypeTool) GetName() string {
 	return fmt.Sprintf("%d) %s", m.Position, m.Name)
 }
+
+// A Subsystem is a non-tool part of a machine, such as Electrical or Hydraulic,
+// that can have a stoppage raised against it. Machine types pick which ones they have.
+type Subsystem struct {
+	ID       int    `db:"id"`
+	Name     string `db:"name"`
+	Label    string `db:"label"`
+	Icon     string `db:"icon"`
+	Position int    `db:"position"`
+}
+
+func (s *Subsystem) GetLabel() string {
+	if s.Label == "" {
+		return s.Name
+	}
+	return s.Label
+}
+
+func (s *Subsystem) HasIcon() bool {
+	return s.Icon != ""
+}
+
+// Subsystem buttons are laid out in rows of 6 across the top of the machine diagram
+const SubsystemsPerRow = 6
+
+func (s *Subsystem) SVGX(index int) string {
+	return fmt.Sprintf("%d", (index%SubsystemsPerRow)*50)
+}
+
+func (s *Subsystem) SVGY(index int) string {
+	return fmt.Sprintf("%d", (index/SubsystemsPerRow)*50)
+}
+
+type SubsystemRPCData struct {
+	Channel   int
+	ID        int
+	Subsystem *Subsystem
+}
+
+// The status of one subsystem on a given machine
+type MachineSubsystem struct {
+	Subsystem
+	Status string `db:"status"`
+}
+
+func (s *MachineSubsystem) SVGFill() string {
+	switch s.Status {
+	case "Needs Attention":
+		return "url(#YellowBtn)"
+	case "Maintenance Pending":
+		return "pending"
+	case "Stopped":
+		return "url(#RedBtn)"
+	default:
+		return "url(#bgrad)"
+	}
+}
+
+func (s *MachineSubsystem) GetClass() string {
+	switch s.Status {
+	case "Needs Attention":
+		return "needs_attention"
+	case "Maintenance Pending":
+		return "pending"
+	case "Stopped":
+		return "stopped"
+	default:
+		return "running"
+	}
+}
+
+// Which subsystems a machine type has, for the checkbox array on the machine type form
+type MachineTypeSubsystem struct {
+	SubsystemID int    `db:"subsystem_id"`
+	Name        string `db:"name"`
+	Count       int    `db:"count"`
+}
+
+type MachineTypeSubsystemSetRequest struct {
+	Channel       int
+	MachineTypeID int
+	SubsystemID   int
+	IsSet         bool
+}
@@ -82,6 +82,7 @@ type Part struct {
 	LastPriceDate        *time.Time `db:"last_price_date"`
 	LastPriceDateDisplay string     `db:"last_price_date_display"`
 	CurrentStock         float64    `db:"current_stock"`
+	Reserved             float64    `db:"reserved"`
 	ValuationString      string     `db:"valuation_string"`
 	Valuation            float64    `db:"valuation"`
 	QtyType              string     `db:"qty_type"`
@@ -121,6 +122,8 @@ func (p *PartPrice) PriceDisplay() string {
 type PartStock struct {
 	ID         int       `db:"id"`
 	PartID     int       `db:"part_id"`
+	SiteID     int       `db:"site_id"`
+	SiteName   *string   `db:"site_name"`
 	DateFrom   time.Time `db:"datefrom"`
 	StockLevel float64   `db:"stock_level"`
 	Descr      string    `db:"descr"`
@@ -130,16 +133,26 @@ func (p *PartStock) DateFromDisplay() string {
 	return p.DateFrom.Format("Mon, Jan 2 2006 15:04:05")
 }
 
+func (p *PartStock) GetSiteName() string {
+	return storeName(p.SiteID, p.SiteName)
+}
+
 func (p *Part) ReorderDetails() string {
 	return fmt.Sprintf("%g / %g", p.ReorderStocklevel, p.ReorderQty)
 }
 
+// The stock on hand that is not reserved by open tasks
+func (p *Part) Available() float64 {
+	return p.CurrentStock - p.Reserved
+}
+
 func (p *Part) DisplayPrice() string {
 	return fmt.Sprintf("$ %8.2f", p.LatestPrice)
 }
 
+// The value of the stock on hand from its cost layers, rather than its latest price
 func (p *Part) DisplayValuation() string {
-	return fmt.Sprintf("$ %8.2f", p.LatestPrice*p.CurrentStock)
+	return fmt.Sprintf("$ %8.2f", p.Valuation)
 }
 
 type PartComponents struct {
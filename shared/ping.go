@@ -1,6 +1,25 @@
 package shared
 
+import "strings"
+
 type AsyncMessage struct {
 	Action string
 	ID     int
 }
+
+type SubscribeRequest struct {
+	Channel int
+	Topic   string
+	Route   string
+}
+
+// Get the message name from a topic, ie "site:4/machine" and "task:12" give "machine" and "task"
+func TopicName(topic string) string {
+	if i := strings.LastIndex(topic, "/"); i >= 0 {
+		topic = topic[i+1:]
+	}
+	if i := strings.Index(topic, ":"); i >= 0 {
+		topic = topic[:i]
+	}
+	return topic
+}
@@ -0,0 +1,106 @@
+package shared
+
+import (
+	"fmt"
+	"time"
+)
+
+// Reorder suggestion statuses
+const (
+	ReorderOpen      = "Open"
+	ReorderDismissed = "Dismissed"
+	ReorderOrdered   = "Ordered"
+	ReorderCleared   = "Cleared"
+)
+
+// A part that has dropped to its reorder level at a store, and the preferred vendor to order it from
+type ReorderSuggestion struct {
+	ID           int        `db:"id"`
+	PartID       int        `db:"part_id"`
+	PartName     string     `db:"part_name"`
+	StockCode    string     `db:"stock_code"`
+	QtyType      string     `db:"qty_type"`
+	SiteID       int        `db:"site_id"`
+	SiteName     *string    `db:"site_name"`
+	VendorID     int        `db:"vendor_id"`
+	VendorName   *string    `db:"vendor_name"`
+	VendorCode   string     `db:"vendor_code"`
+	Price        float64    `db:"price"`
+	MinQty       float64    `db:"min_qty"`
+	Stock        float64    `db:"stock"`
+	OnOrder      float64    `db:"on_order"`
+	Reserved     float64    `db:"reserved"`
+	ReorderLevel float64    `db:"reorder_level"`
+	ReorderQty   float64    `db:"reorder_qty"`
+	Qty          float64    `db:"qty"`
+	Status       string     `db:"status"`
+	Raised       time.Time  `db:"raised"`
+	Closed       *time.Time `db:"closed"`
+	POID         int        `db:"po_id"`
+}
+
+// VendorID picks the vendor when raising orders, 0 for all vendors
+type ReorderRPCData struct {
+	Channel           int
+	ID                int
+	VendorID          int
+	ReorderSuggestion *ReorderSuggestion
+}
+
+func (r *ReorderSuggestion) GetVendorName() string {
+	if r.VendorName == nil {
+		return "No Vendor"
+	}
+	return *r.VendorName
+}
+
+// The store that the part is short at
+func (r *ReorderSuggestion) GetSiteName() string {
+	return storeName(r.SiteID, r.SiteName)
+}
+
+func (r *ReorderSuggestion) GetStock() string {
+	return fmt.Sprintf("%g + %g on order - %g reserved / %g", r.Stock, r.OnOrder, r.Reserved, r.ReorderLevel)
+}
+
+// The stock left once everything on order has come in, and the reservations have been used
+func (r *ReorderSuggestion) Projected() float64 {
+	return r.Stock + r.OnOrder - r.Reserved
+}
+
+// Is the part at its reorder level, or short of what is reserved
+func (r *ReorderSuggestion) NeedsOrder() bool {
+	if r.ReorderLevel > 0 {
+		return r.Projected() <= r.ReorderLevel
+	}
+	return r.Projected() < 0
+}
+
+func (r *ReorderSuggestion) GetQty() string {
+	return fmt.Sprintf("%g %s", r.Qty, r.QtyType)
+}
+
+func (r *ReorderSuggestion) GetRaised() string {
+	return r.Raised.Format(datetimeDisplayFormat)
+}
+
+func (r *ReorderSuggestion) GetTotal() string {
+	return fmt.Sprintf("$ %8.2f", r.Price*r.Qty)
+}
+
+// The quantity to order, which is the part's reorder qty, or enough to get back
+// above the reorder level if that is not set. It is never less than the shortfall
+// against the reservations, or the vendor's minimum.
+func (r *ReorderSuggestion) OrderQty() float64 {
+	qty := r.ReorderQty
+	if qty <= 0 {
+		qty = r.ReorderLevel - r.Projected() + 1
+	}
+	if short := -r.Projected(); qty < short {
+		qty = short
+	}
+	if qty < r.MinQty {
+		qty = r.MinQty
+	}
+	return qty
+}
@@ -18,6 +18,8 @@ type Site struct {
 	TasksTo        int     `db:"tasks_to"`
 	Manager        int     `db:"manager"`
 	Highlight      bool    `db:"highlight"`
+	MapLabel       string  `db:"map_label"`
+	CalendarID     int     `db:"calendar_id"`
 }
 
 func (s *Site) GetKey() int {
@@ -35,12 +37,19 @@ type SiteRPCData struct {
 	Site    *Site
 }
 
+// A SiteGroup is a top level site (parent_site = 0) together with all of
+// the sites below it, with the status rolled up across all their machines
+type SiteGroup struct {
+	ID     int    `db:"id"`
+	Name   string `db:"name"`
+	Label  string `db:"map_label"`
+	X      int    `db:"x"`
+	Y      int    `db:"y"`
+	Status string `db:"status"`
+}
+
 type SiteStatusReport struct {
-	Edinburgh string
-	Minto     string
-	Tomago    string
-	Chinderah string
-	USA       string
+	Groups []SiteGroup
 }
 
 func ButtonColor(status string) string {
@@ -55,19 +64,47 @@ func ButtonColor(status string) string {
 	return ""
 }
 
-func (s SiteStatusReport) EButton() string {
-	return ButtonColor(s.Edinburgh)
+func (g SiteGroup) Button() string {
+	return ButtonColor(g.Status)
+}
+
+// Only groups with a label and a position get shown on the map
+func (g SiteGroup) OnMap() bool {
+	return g.Label != "" && (g.X != 0 || g.Y != 0)
+}
+
+// Positions on the full size map
+func (g SiteGroup) TextX() int {
+	return g.X + 20
+}
+
+func (g SiteGroup) TextY() int {
+	return g.Y + 5
 }
 
-func (s SiteStatusReport) MButton() string {
-	return ButtonColor(s.Minto)
+// Positions on the half size map
+func (g SiteGroup) SmallX() int {
+	return g.X / 2
 }
-func (s SiteStatusReport) TButton() string {
-	return ButtonColor(s.Tomago)
+
+func (g SiteGroup) SmallY() int {
+	return g.Y / 2
 }
-func (s SiteStatusReport) CButton() string {
-	return ButtonColor(s.Chinderah)
+
+func (g SiteGroup) SmallTextX() int {
+	return g.X/2 + 10
 }
-func (s SiteStatusReport) UButton() string {
-	return ButtonColor(s.USA)
+
+func (g SiteGroup) SmallTextY() int {
+	return g.Y/2 + 3
+}
+
+// Find the group with the given ID
+func (s SiteStatusReport) Get(id int) *SiteGroup {
+	for i, v := range s.Groups {
+		if v.ID == id {
+			return &s.Groups[i]
+		}
+	}
+	return nil
 }
@@ -0,0 +1,119 @@
+package shared
+
+import (
+	"fmt"
+	"time"
+)
+
+type Skill struct {
+	ID    int    `db:"id"`
+	Name  string `db:"name"`
+	Notes string `db:"notes"`
+}
+
+type SkillRPCData struct {
+	Channel int
+	ID      int
+	Skill   *Skill
+}
+
+// Proficiency levels for a skill
+var SkillLevels = []string{"", "Trainee", "Competent", "Expert"}
+
+func SkillLevelName(level int) string {
+	if level > 0 && level < len(SkillLevels) {
+		return SkillLevels[level]
+	}
+	return fmt.Sprintf("Level %d", level)
+}
+
+// A technician's proficiency in a skill, with the dates of their certification if any.
+// ID is the skill when listing a user's skills, and the user when listing who has a skill.
+type UserSkill struct {
+	ID        int        `db:"id"`
+	UserID    int        `db:"user_id"`
+	Username  *string    `db:"username"`
+	SkillID   int        `db:"skill_id"`
+	SkillName string     `db:"skill_name"`
+	Level     int        `db:"level"`
+	Certified *time.Time `db:"certified"`
+	Expires   *time.Time `db:"expires"`
+}
+
+// ID is the user when working on a user's skills, or the skill when listing who has it
+type UserSkillRPCData struct {
+	Channel   int
+	ID        int
+	UserSkill *UserSkill
+}
+
+// A skill needed for a scheduled task or a task, at a minimum level
+type RequiredSkill struct {
+	ID        int    `db:"id"`
+	SkillID   int    `db:"skill_id"`
+	SkillName string `db:"skill_name"`
+	Level     int    `db:"level"`
+}
+
+// The skill is needed by either the sched task or the task
+type RequiredSkillRPCData struct {
+	Channel       int
+	SchedID       int
+	TaskID        int
+	RequiredSkill *RequiredSkill
+}
+
+// Which technicians to offer for a job. With no event, task or skills given, this
+// is a plain list of the technicians at the site (or all of them if ID is 0).
+// Otherwise every technician is ranked by being at the site, matching the skills
+// needed and having the least open work. MatchOnly leaves out anyone that does not
+// have all the skills.
+type TechnicianQuery struct {
+	Channel   int
+	ID        int
+	EventID   int
+	TaskID    int
+	SkillID   int
+	MatchOnly bool
+}
+
+func (s *UserSkill) GetUsername() string {
+	if s.Username == nil {
+		return ""
+	}
+	return *s.Username
+}
+
+func (s *UserSkill) GetLevel() string {
+	return SkillLevelName(s.Level)
+}
+
+func (s *UserSkill) GetCertified() string {
+	if s.Certified == nil {
+		return ""
+	}
+	return s.Certified.Format("Mon, Jan 2 2006")
+}
+
+func (s *UserSkill) GetExpires() string {
+	if s.Expires == nil {
+		return ""
+	}
+	return s.Expires.Format("Mon, Jan 2 2006")
+}
+
+// Has the certification for the skill run out
+func (s *UserSkill) IsExpired() bool {
+	return s.Expires != nil && s.Expires.Before(time.Now())
+}
+
+func (s *UserSkill) GetExpiryClass() string {
+	if s.IsExpired() {
+		return "highlight"
+	}
+	return ""
+}
+
+func (r *RequiredSkill) GetLevel() string {
+	return SkillLevelName(r.Level)
+}
@@ -0,0 +1,147 @@
+package shared
+
+import "time"
+
+// Statuses for tasks and events, which can only move between each other as
+// laid out in StatusTransitions
+const (
+	StatusOpen         = "Open"
+	StatusAssigned     = "Assigned"
+	StatusAcknowledged = "Acknowledged"
+	StatusInProgress   = "In Progress"
+	StatusOnHoldParts  = "On Hold - Parts"
+	StatusCompleted    = "Completed"
+	StatusVerified     = "Verified"
+	StatusCancelled    = "Cancelled"
+)
+
+var Statuses = []string{
+	StatusOpen,
+	StatusAssigned,
+	StatusAcknowledged,
+	StatusInProgress,
+	StatusOnHoldParts,
+	StatusCompleted,
+	StatusVerified,
+	StatusCancelled,
+}
+
+// The statuses that each status can move on to. Completed work is not reopened, as
+// completing it has already closed off the task or event, and cleared the machine.
+var StatusTransitions = map[string][]string{
+	StatusOpen:         {StatusAssigned, StatusCompleted, StatusCancelled},
+	StatusAssigned:     {StatusOpen, StatusAcknowledged, StatusInProgress, StatusCompleted, StatusCancelled},
+	StatusAcknowledged: {StatusAssigned, StatusInProgress, StatusOnHoldParts, StatusCompleted, StatusCancelled},
+	StatusInProgress:   {StatusOnHoldParts, StatusCompleted, StatusCancelled},
+	StatusOnHoldParts:  {StatusInProgress, StatusCompleted, StatusCancelled},
+	StatusCompleted:    {StatusVerified},
+	StatusVerified:     {},
+	StatusCancelled:    {StatusOpen},
+}
+
+func CanTransition(from string, to string) bool {
+	for _, s := range StatusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Events cannot be reopened once they are Cancelled either, as that has cancelled
+// their tasks and cleared the machine, so a new event has to be raised instead
+func CanTransitionEvent(from string, to string) bool {
+	if from == StatusCancelled {
+		return false
+	}
+	return CanTransition(from, to)
+}
+
+// The statuses that can be picked from here, leaving out Completed, as that
+// has its own action
+func NextStatuses(from string) []string {
+	if from == "" {
+		from = StatusOpen
+	}
+	next := []string{}
+	for _, s := range StatusTransitions[from] {
+		if s != StatusCompleted {
+			next = append(next, s)
+		}
+	}
+	return next
+}
+
+// Is this a status that a task only gets to through the technician's time entries
+func IsClockStatus(status string) bool {
+	switch status {
+	case StatusAcknowledged, StatusInProgress, StatusOnHoldParts:
+		return true
+	}
+	return false
+}
+
+// One move from one status to another, as kept in the status_history table
+type StatusChange struct {
+	ID         int       `db:"id"`
+	Entity     string    `db:"entity"`
+	EntityID   int       `db:"entity_id"`
+	FromStatus string    `db:"from_status"`
+	ToStatus   string    `db:"to_status"`
+	UserID     int       `db:"user_id"`
+	Username   *string   `db:"username"`
+	Changed    time.Time `db:"changed"`
+	Notes      string    `db:"notes"`
+}
+
+type TaskStatusRPCData struct {
+	Channel int
+	ID      int
+	Status  string
+	Notes   string
+}
+
+type EventStatusRPCData struct {
+	Channel int
+	ID      int
+	Status  string
+	Notes   string
+}
+
+// Tasks move through Acknowledged, In Progress and On Hold - Parts by the technician
+// acknowledging and clocking on and off, so those are left off too. These take the
+// value, as the action templates are given the task and event by value.
+func (t Task) NextStatuses() []string {
+	next := []string{}
+	for _, s := range NextStatuses(t.Status) {
+		if !IsClockStatus(s) {
+			next = append(next, s)
+		}
+	}
+	return next
+}
+
+func (e Event) NextStatuses() []string {
+	if e.Status == StatusCancelled {
+		return []string{}
+	}
+	return NextStatuses(e.Status)
+}
+
+func (s *StatusChange) GetChanged() string {
+	return s.Changed.Format(datetimeDisplayFormat)
+}
+
+func (s *StatusChange) GetUsername() string {
+	if s.Username == nil {
+		return "System"
+	}
+	return *s.Username
+}
+
+func (s *StatusChange) GetTransition() string {
+	if s.FromStatus == "" {
+		return s.ToStatus
+	}
+	return s.FromStatus + " → " + s.ToStatus
+}
@@ -0,0 +1,153 @@
+package shared
+
+import (
+	"fmt"
+	"time"
+)
+
+// Get the name of a store, where site 0 holds the stock not yet put into a store
+func storeName(siteID int, name *string) string {
+	if siteID == 0 {
+		return "Unallocated"
+	}
+	if name == nil {
+		return fmt.Sprintf("Site %d", siteID)
+	}
+	return *name
+}
+
+// The stock of a part at one store. The ID is the site ID.
+type StockLevel struct {
+	ID        int        `db:"id"`
+	PartID    int        `db:"part_id"`
+	SiteID    int        `db:"site_id"`
+	SiteName  *string    `db:"site_name"`
+	Qty       float64    `db:"qty"`
+	InTransit float64    `db:"in_transit"`
+	Reserved  float64    `db:"reserved"`
+	DateFrom  *time.Time `db:"datefrom"`
+	Notes     string     `db:"notes"`
+}
+
+func (s *StockLevel) GetSiteName() string {
+	return storeName(s.SiteID, s.SiteName)
+}
+
+// The stock at the store that is not reserved by open tasks at the sites it supplies
+func (s *StockLevel) Available() float64 {
+	return s.Qty - s.Reserved
+}
+
+func (s *StockLevel) GetDateFrom() string {
+	if s.DateFrom == nil {
+		return ""
+	}
+	return s.DateFrom.Format(datetimeDisplayFormat)
+}
+
+// Set the stock of a part at a store to the Qty counted
+type StockAdjustRPCData struct {
+	Channel int
+	PartID  int
+	SiteID  int
+	Qty     float64
+	Notes   string
+}
+
+// Stock transfer statuses
+const (
+	TransferInTransit = "In Transit"
+	TransferReceived  = "Received"
+	TransferCancelled = "Cancelled"
+)
+
+// Stock sent from one store to another. StockCode picks the part when sending.
+type StockTransfer struct {
+	ID           int        `db:"id"`
+	PartID       int        `db:"part_id"`
+	PartName     string     `db:"part_name"`
+	StockCode    string     `db:"stock_code"`
+	QtyType      string     `db:"qty_type"`
+	FromSite     int        `db:"from_site"`
+	FromSiteName *string    `db:"from_site_name"`
+	ToSite       int        `db:"to_site"`
+	ToSiteName   *string    `db:"to_site_name"`
+	Qty          float64    `db:"qty"`
+	Status       string     `db:"status"`
+	Notes        string     `db:"notes"`
+	Sent         time.Time  `db:"sent"`
+	SentBy       int        `db:"sent_by"`
+	SentByName   *string    `db:"sent_by_name"`
+	Received     *time.Time `db:"received"`
+	ReceivedBy   int        `db:"received_by"`
+}
+
+// With an ID of 0, the list is every transfer still in transit
+type StockTransferRPCData struct {
+	Channel       int
+	ID            int
+	StockTransfer *StockTransfer
+}
+
+func (t *StockTransfer) GetFrom() string {
+	return storeName(t.FromSite, t.FromSiteName)
+}
+
+func (t *StockTransfer) GetTo() string {
+	return storeName(t.ToSite, t.ToSiteName)
+}
+
+func (t *StockTransfer) GetQty() string {
+	return fmt.Sprintf("%g %s", t.Qty, t.QtyType)
+}
+
+func (t *StockTransfer) GetSent() string {
+	return t.Sent.Format(datetimeDisplayFormat)
+}
+
+func (t *StockTransfer) GetSentBy() string {
+	if t.SentByName == nil {
+		return ""
+	}
+	return *t.SentByName
+}
+
+func (t *StockTransfer) GetReceived() string {
+	if t.Received == nil {
+		return ""
+	}
+	return t.Received.Format(datetimeDisplayFormat)
+}
+
+func (t *StockTransfer) IsInTransit() bool {
+	return t.Status == TransferInTransit
+}
+
+// Valuation methods, set by CMMS_VALUATION
+const (
+	ValuationAverage = "Average"
+	ValuationFIFO    = "FIFO"
+)
+
+// A receipt of stock into a part's cost layers, with what is left of it
+type CostLayer struct {
+	ID       int       `db:"id"`
+	PartID   int       `db:"part_id"`
+	DateFrom time.Time `db:"datefrom"`
+	Qty      float64   `db:"qty"`
+	QtyLeft  float64   `db:"qty_left"`
+	Cost     float64   `db:"cost"`
+	Descr    string    `db:"descr"`
+}
+
+func (c *CostLayer) GetDateFrom() string {
+	return c.DateFrom.Format(datetimeDisplayFormat)
+}
+
+func (c *CostLayer) GetCost() string {
+	return fmt.Sprintf("$ %10.4f", c.Cost)
+}
+
+func (c *CostLayer) GetValue() string {
+	return fmt.Sprintf("$ %10.2f", c.Cost*c.QtyLeft)
+}
@@ -0,0 +1,134 @@
+package shared
+
+import (
+	"fmt"
+	"time"
+)
+
+// Stock-take statuses
+const (
+	StockTakeOpen      = "Open"
+	StockTakePosted    = "Posted"
+	StockTakeCancelled = "Cancelled"
+)
+
+// A count sheet for the parts in a category (and its subcategories) at a store.
+// Category 0 is all parts.
+type StockTake struct {
+	ID           int        `db:"id"`
+	SiteID       int        `db:"site_id"`
+	SiteName     *string    `db:"site_name"`
+	CategoryID   int        `db:"category_id"`
+	CategoryName *string    `db:"category_name"`
+	Status       string     `db:"status"`
+	Notes        string     `db:"notes"`
+	Created      time.Time  `db:"created"`
+	CreatedBy    int        `db:"created_by"`
+	CreatedName  *string    `db:"created_name"`
+	Posted       *time.Time `db:"posted"`
+	PostedBy     int        `db:"posted_by"`
+	NumLines     int        `db:"num_lines"`
+	NumCounted   int        `db:"num_counted"`
+	VarianceCost float64    `db:"variance_cost"`
+}
+
+type StockTakeRPCData struct {
+	Channel   int
+	ID        int
+	StockTake *StockTake
+}
+
+func (s *StockTake) GetSiteName() string {
+	return storeName(s.SiteID, s.SiteName)
+}
+
+func (s *StockTake) GetCategoryName() string {
+	if s.CategoryName == nil {
+		return "All Parts"
+	}
+	return *s.CategoryName
+}
+
+func (s *StockTake) GetCreated() string {
+	return s.Created.Format(datetimeDisplayFormat)
+}
+
+func (s *StockTake) GetCreatedBy() string {
+	if s.CreatedName == nil {
+		return ""
+	}
+	return *s.CreatedName
+}
+
+func (s *StockTake) GetPosted() string {
+	if s.Posted == nil {
+		return ""
+	}
+	return s.Posted.Format(datetimeDisplayFormat)
+}
+
+func (s *StockTake) GetProgress() string {
+	return fmt.Sprintf("%d / %d counted", s.NumCounted, s.NumLines)
+}
+
+func (s *StockTake) GetVarianceCost() string {
+	return fmt.Sprintf("$ %8.2f", s.VarianceCost)
+}
+
+func (s *StockTake) IsOpen() bool {
+	return s.Status == StockTakeOpen
+}
+
+// One part on a count sheet, with the stock expected when the sheet was made
+type StockTakeLine struct {
+	ID           int     `db:"id"`
+	StockTakeID  int     `db:"stock_take_id"`
+	PartID       int     `db:"part_id"`
+	PartName     string  `db:"part_name"`
+	StockCode    string  `db:"stock_code"`
+	QtyType      string  `db:"qty_type"`
+	Expected     float64 `db:"expected"`
+	Counted      float64 `db:"counted"`
+	IsCounted    bool    `db:"is_counted"`
+	VarianceCost float64 `db:"variance_cost"`
+	Notes        string  `db:"notes"`
+}
+
+type StockTakeLineRPCData struct {
+	Channel       int
+	ID            int
+	StockTakeLine *StockTakeLine
+}
+
+// The stock found over (or under, if negative) what was expected
+func (l *StockTakeLine) Variance() float64 {
+	if !l.IsCounted {
+		return 0
+	}
+	return l.Counted - l.Expected
+}
+
+func (l *StockTakeLine) GetCounted() string {
+	if !l.IsCounted {
+		return ""
+	}
+	return fmt.Sprintf("%g %s", l.Counted, l.QtyType)
+}
+
+func (l *StockTakeLine) GetExpected() string {
+	return fmt.Sprintf("%g %s", l.Expected, l.QtyType)
+}
+
+func (l *StockTakeLine) GetVariance() string {
+	if !l.IsCounted {
+		return ""
+	}
+	return fmt.Sprintf("%+g", l.Variance())
+}
+
+func (l *StockTakeLine) GetVarianceCost() string {
+	if l.VarianceCost == 0 {
+		return ""
+	}
+	return fmt.Sprintf("$ %8.2f", l.VarianceCost)
+}
@@ -23,8 +23,10 @@ type SchedTask struct {
 	Days          *int                `db:"days"`
 	Months        *int                `db:"months"`
 	Count         *int                `db:"count"`
+	Hours         *int                `db:"hours"`
 	Week          *int                `db:"week"`
 	WeekDay       *int                `db:"weekday"`
+	RRule         string              `db:"rrule"`
 	UserID        int                 `db:"user_id"`
 	DurationDays  int                 `db:"duration_days"`
 	LabourCost    float64             `db:"labour_cost"`
@@ -34,6 +36,8 @@ type SchedTask struct {
 	PartsRequired []PartReq           `db:"parts_required"`
 	Photos        []Photo             `db:"photos"`
 	NewPhoto      formulate.FileField `db:"new_photo"`
+	HoursRun      float64             `db:"hours_run"`
+	NextDue       *time.Time          `db:"next_due"`
 }
 
 type SchedTaskRPCData struct {
@@ -48,11 +52,32 @@ func (t *SchedTask) ShowFrequency() string {
 	// print("decoding freq", t.Freq)
 	switch t.Freq {
 	case "Monthly":
+		if t.Week == nil {
+			return "Monthly"
+		}
+		if *t.Week == 5 {
+			return "Monthly - Last Week"
+		}
 		return fmt.Sprintf("Monthly - Week %d", *t.Week)
+	case "Nth Weekday":
+		if t.Week == nil || t.WeekDay == nil || *t.WeekDay < 1 || *t.WeekDay > 7 {
+			return "Nth Weekday of the Month"
+		}
+		days := []string{"Mon", "Tue", "Wed", "Thur", "Fri", "Sat", "Sun"}
+		nths := []string{"", "1st", "2nd", "3rd", "4th", "Last"}
+		if *t.Week < 1 || *t.Week > 5 {
+			return "Nth Weekday of the Month"
+		}
+		return fmt.Sprintf("%s %s of the Month", nths[*t.Week], days[*t.WeekDay-1])
 	case "Yearly":
 		return fmt.Sprintf("Yearly - %s", t.StartDate.Format("Mon, Jan 2 2006"))
 	case "Every N Days":
+		if t.Days == nil {
+			return "Every few Days"
+		}
 		return fmt.Sprintf("Every %d Days", *t.Days)
+	case "Custom Rule":
+		return "Rule " + t.RRule
 	case "Every N Months":
 		if t.Months == nil {
 			return "Every few Months"
@@ -62,10 +87,26 @@ func (t *SchedTask) ShowFrequency() string {
 		return fmt.Sprintf("Once at - %s", t.OneOffDate.Format("Mon, Jan 2 2006"))
 	case "Job Count":
 		return fmt.Sprintf("Job Count > %d", *t.Count)
+	case "Every N Hours":
+		if t.Hours == nil {
+			return "Every few Hours"
+		}
+		return fmt.Sprintf("Every %d Running Hours", *t.Hours)
 	}
 	return fmt.Sprintf("%s %d", t.Freq, t.Week)
 }
 
+// Forecast of when the task is next due, for the running hours schedules
+func (t *SchedTask) ShowNextDue() string {
+	if t.Freq != "Every N Hours" {
+		return ""
+	}
+	if t.NextDue == nil {
+		return fmt.Sprintf("%.1f hrs run", t.HoursRun)
+	}
+	return fmt.Sprintf("%.1f hrs run, due %s", t.HoursRun, t.NextDue.Format("Mon, Jan 2 2006"))
+}
+
 func (t *SchedTask) ShowComponent(m Machine) string {
 	switch t.CompType {
 	case "A":
@@ -90,6 +131,42 @@ func (t *SchedTask) ShowPaused() string {
 	return "Running"
 }
 
+// Ask for the tasks that the scheduler is expected to generate between 2 dates,
+// optionally limited to a site or a machine
+type ForecastRequest struct {
+	Channel   int
+	From      time.Time
+	To        time.Time
+	SiteID    int
+	MachineID int
+}
+
+// A task that the scheduler is expected to generate, with the estimated costs
+type ForecastTask struct {
+	SchedID     int
+	MachineID   int
+	MachineName string
+	SiteID      int
+	SiteName    string
+	Component   string
+	Descr       string
+	Freq        string
+	UserID      int
+	Username    string
+	StartDate   time.Time
+	DueDate     time.Time
+	LabourEst   float64
+	MaterialEst float64
+}
+
+func (f *ForecastTask) GetStartDate() string {
+	return f.StartDate.Format("Mon, Jan 2 2006")
+}
+
+func (f *ForecastTask) TotalEst() float64 {
+	return f.LabourEst + f.MaterialEst
+}
+
 type SchedTaskPart struct {
 	TaskID int     `db:"task_id"`
 	PartID int     `db:"part_id"`
@@ -104,6 +181,7 @@ type TaskPart struct {
 	StockCode string  `db:"stock_code"`
 	Qty       float64 `db:"qty"`
 	QtyUsed   float64 `db:"qty_used"`
+	Cost      float64 `db:"cost"`
 	QtyType   string  `db:"qty_type"`
 	Notes     string  `db:"notes"`
 }
@@ -225,6 +303,13 @@ type Task struct {
 	Thumb3            string              `db:"thumb3"`
 	StoppagePreview   string              `db:"stoppage_preview"`
 	StoppageThumbnail string              `db:"stoppage_thumbnail"`
+	Overdue           bool                `db:"overdue"`
+	EscalationLevel   int                 `db:"escalation_level"`
+	EscalatedAt       *time.Time          `db:"escalated_at"`
+	Status            string              `db:"status"`
+	ClockedOn         bool                `db:"clocked_on"`
+	OnCrew            bool                `db:"on_crew"`
+	SignedOff         bool                `db:"signed_off"`
 }
 
 type TaskRPCData struct {
@@ -279,6 +364,14 @@ func (t *Task) GetSiteClass() string {
 	return ""
 }
 
+// Highlight tasks that the scheduler generated late, after missing their window
+func (t *Task) GetDateClass() string {
+	if t.Overdue {
+		return "overdue"
+	}
+	return ""
+}
+
 func (t *Task) GetComponent() string {
 	return t.MachineName + "     :      \n" + t.Component
 }
@@ -0,0 +1,52 @@
+package shared
+
+import (
+	"fmt"
+	"time"
+)
+
+// A stretch of work by one technician on a task, from clocking on to clocking off
+type TaskTime struct {
+	ID       int        `db:"id"`
+	TaskID   int        `db:"task_id"`
+	UserID   int        `db:"user_id"`
+	Username *string    `db:"username"`
+	Started  time.Time  `db:"started"`
+	Stopped  *time.Time `db:"stopped"`
+	Hours    float64    `db:"hours"`
+	Notes    string     `db:"notes"`
+}
+
+// A technician clocking on or off a task. Hold puts the task on hold waiting for
+// parts, which clocks everyone off it.
+type TaskTimeRPCData struct {
+	Channel int
+	ID      int
+	Notes   string
+	Hold    bool
+}
+
+func (t *TaskTime) GetUsername() string {
+	if t.Username == nil {
+		return ""
+	}
+	return *t.Username
+}
+
+func (t *TaskTime) GetStarted() string {
+	return t.Started.Format(datetimeDisplayFormat)
+}
+
+func (t *TaskTime) GetStopped() string {
+	if t.Stopped == nil {
+		return "On the job"
+	}
+	return t.Stopped.Format(datetimeDisplayFormat)
+}
+
+func (t *TaskTime) GetHours() string {
+	if t.Stopped == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.2f", t.Hours)
+}
@@ -17,6 +17,10 @@ type User struct {
 	Local       bool    `db:"local"`
 	IsTech      bool    `db:"is_tech"`
 	CanAllocate bool    `db:"can_allocate"`
+	OnSite      bool    `db:"on_site"`
+	OpenTasks   int     `db:"open_tasks"`
+	SkillMatch  int     `db:"skill_match"`
+	Ranking     string  `db:"ranking"`
 }
 
 type UserRPCData struct {
@@ -26,13 +30,14 @@ type UserRPCData struct {
 }
 
 type UserUpdate struct {
-	Channel  int    `db:"channel"`
-	ID       int    `db:"id"`
-	Username string `db:"username"`
-	Name     string `db:"name"`
-	Passwd   string `db:"passwd"`
-	Email    string `db:"email"`
-	SMS      string `db:"sms"`
+	Channel   int    `db:"channel"`
+	ID        int    `db:"id"`
+	Username  string `db:"username"`
+	Name      string `db:"name"`
+	Passwd    string `db:"passwd"`
+	OldPasswd string `db:"old_passwd"`
+	Email     string `db:"email"`
+	SMS       string `db:"sms"`
 }
 
 type UserSite struct {
@@ -0,0 +1,202 @@
+package shared
+
+import (
+	"fmt"
+	"time"
+)
+
+type Vendor struct {
+	ID           int    `db:"id"`
+	Name         string `db:"name"`
+	Descr        string `db:"descr"`
+	Address      string `db:"address"`
+	Phone        string `db:"phone"`
+	Fax          string `db:"fax"`
+	ContactName  string `db:"contact_name"`
+	ContactEmail string `db:"contact_email"`
+	OrdersEmail  string `db:"orders_email"`
+	Rating       string `db:"rating"`
+	Notes        string `db:"notes"`
+}
+
+type VendorRPCData struct {
+	Channel int
+	ID      int
+	Vendor  *Vendor
+}
+
+// A vendor's current price for a part, from part_vendor. The ID is the part ID.
+// StockCode picks the part when adding a new price.
+type VendorPrice struct {
+	ID          int        `db:"id"`
+	PartID      int        `db:"part_id"`
+	VendorID    int        `db:"vendor_id"`
+	PartName    string     `db:"part_name"`
+	StockCode   string     `db:"stock_code"`
+	QtyType     string     `db:"qty_type"`
+	VendorCode  string     `db:"vendor_code"`
+	LatestPrice float64    `db:"latest_price"`
+	MinQty      float64    `db:"min_qty"`
+	DateFrom    *time.Time `db:"datefrom"`
+	Notes       string     `db:"notes"`
+}
+
+type VendorPriceRPCData struct {
+	Channel     int
+	ID          int
+	VendorPrice *VendorPrice
+}
+
+func (v *VendorPrice) GetPrice() string {
+	return fmt.Sprintf("$ %8.2f", v.LatestPrice)
+}
+
+func (v *VendorPrice) GetDateFrom() string {
+	if v.DateFrom == nil {
+		return ""
+	}
+	return v.DateFrom.Format(datetimeDisplayFormat)
+}
+
+// Purchase order statuses, in the order that a PO normally moves through them
+const (
+	PODraft     = "Draft"
+	POSent      = "Sent"
+	POPartial   = "Partially Received"
+	POReceived  = "Received"
+	POCancelled = "Cancelled"
+)
+
+type PurchaseOrder struct {
+	ID           int                 `db:"id"`
+	VendorID     int                 `db:"vendor_id"`
+	VendorName   string              `db:"vendor_name"`
+	SiteID       int                 `db:"site_id"`
+	SiteName     *string             `db:"site_name"`
+	Status       string              `db:"status"`
+	Ref          string              `db:"ref"`
+	Notes        string              `db:"notes"`
+	Created      time.Time           `db:"created"`
+	CreatedBy    int                 `db:"created_by"`
+	Username     *string             `db:"username"`
+	SentDate     *time.Time          `db:"sent_date"`
+	ReceivedDate *time.Time          `db:"received_date"`
+	Total        float64             `db:"total"`
+	Lines        []PurchaseOrderLine `db:"lines"`
+}
+
+type PurchaseOrderRPCData struct {
+	Channel       int
+	ID            int
+	PurchaseOrder *PurchaseOrder
+}
+
+func (p *PurchaseOrder) GetID() string {
+	return fmt.Sprintf("%06d", p.ID)
+}
+
+func (p *PurchaseOrder) GetCreated() string {
+	return p.Created.Format(datetimeDisplayFormat)
+}
+
+func (p *PurchaseOrder) GetUsername() string {
+	if p.Username == nil {
+		return "System"
+	}
+	return *p.Username
+}
+
+func (p *PurchaseOrder) GetSentDate() string {
+	if p.SentDate == nil {
+		return ""
+	}
+	return p.SentDate.Format(datetimeDisplayFormat)
+}
+
+func (p *PurchaseOrder) GetReceivedDate() string {
+	if p.ReceivedDate == nil {
+		return ""
+	}
+	return p.ReceivedDate.Format(datetimeDisplayFormat)
+}
+
+// The store that the order is delivered to
+func (p *PurchaseOrder) GetSiteName() string {
+	if p.SiteID == 0 {
+		return ""
+	}
+	return storeName(p.SiteID, p.SiteName)
+}
+
+func (p *PurchaseOrder) GetTotal() string {
+	return fmt.Sprintf("$ %8.2f", p.Total)
+}
+
+// Lines can only be changed while the order is still a draft
+func (p *PurchaseOrder) IsDraft() bool {
+	return p.Status == PODraft
+}
+
+// Can stock be received against the order
+func (p *PurchaseOrder) IsOpen() bool {
+	return p.Status == POSent || p.Status == POPartial
+}
+
+// Only orders that have had nothing received can be cancelled
+func (p *PurchaseOrder) CanCancel() bool {
+	return p.Status == PODraft || p.Status == POSent
+}
+
+// One part on a purchase order. StockCode picks the part when adding a new line.
+type PurchaseOrderLine struct {
+	ID          int     `db:"id"`
+	POID        int     `db:"po_id"`
+	PartID      int     `db:"part_id"`
+	PartName    string  `db:"part_name"`
+	StockCode   string  `db:"stock_code"`
+	QtyType     string  `db:"qty_type"`
+	VendorCode  string  `db:"vendor_code"`
+	Qty         float64 `db:"qty"`
+	QtyReceived float64 `db:"qty_received"`
+	Price       float64 `db:"price"`
+	Notes       string  `db:"notes"`
+}
+
+type PurchaseOrderLineRPCData struct {
+	Channel int
+	ID      int
+	Line    *PurchaseOrderLine
+}
+
+// Receive stock against a purchase order. With LineID of 0, everything still
+// outstanding on the order is received, otherwise Qty is received on the one line.
+type POReceiveRPCData struct {
+	Channel int
+	ID      int
+	LineID  int
+	Qty     float64
+	Notes   string
+}
+
+func (l *PurchaseOrderLine) GetOutstanding() float64 {
+	if l.QtyReceived >= l.Qty {
+		return 0
+	}
+	return l.Qty - l.QtyReceived
+}
+
+func (l *PurchaseOrderLine) GetQty() string {
+	return fmt.Sprintf("%g %s", l.Qty, l.QtyType)
+}
+
+func (l *PurchaseOrderLine) GetReceived() string {
+	return fmt.Sprintf("%g / %g", l.QtyReceived, l.Qty)
+}
+
+func (l *PurchaseOrderLine) GetPrice() string {
+	return fmt.Sprintf("$ %8.2f", l.Price)
+}
+
+func (l *PurchaseOrderLine) GetTotal() string {
+	return fmt.Sprintf("$ %8.2f", l.Price*l.Qty)
+}